@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// API keys are issued and revoked through /v1/admin/api-keys and stored
+// hashed in api_keys; the key itself is shown once, at issue. Callers send it
+// as "Authorization: Bearer <key>" or "X-API-Key: <key>"; a bearer JWT is
+// verified instead when JWT_JWKS_URL is set (jwt.go). With API_AUTH=true
+// every request except probes, /metrics and the index needs a valid key;
+// otherwise keys are optional, but one that is sent must be valid. What a key
+// may do is decided by its scopes (authz.go).
+//
+// API_KEY_BOOTSTRAP is an admin key that isn't in the table, for issuing the
+// first real keys; with the memory, SQLite and DynamoDB backends, which have
+// no table, it and JWTs are the only credentials. Lookups are cached per instance for API_KEY_CACHE_TTL, so
+// a revoked key can keep working that long on other instances.
+
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeAdmin = "admin"
+
+	apiKeyPrefix       = "lbk_"
+	maxAPIKeyCacheSize = 10000
+)
+
+var apiKeyScopes = []string{scopeRead, scopeWrite, scopeAdmin}
+
+type apiKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"` // only in the issue response
+	KeyPrefix  string     `json:"keyPrefix"`
+	Scopes     []string   `json:"scopes"`
+	Tenant     string     `json:"tenant,omitempty"` // "" = not bound to a tenant
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// principal is who a request authenticated as: an API key, or a JWT's subject (see jwt.go).
+type principal struct {
+	keyID   int64 // 0 for the bootstrap key and tokens
+	name    string
+	subject string // the end user, for tokens
+	tenant  string // the tenant it is bound to, if any
+	scopes  []string
+}
+
+func (p *principal) has(scope string) bool { return slices.Contains(p.scopes, scope) }
+
+// ident is a stable id for per-caller state such as rate limit buckets.
+func (p *principal) ident() string {
+	if p.subject != "" {
+		return "sub:" + tenantSeason(p.tenant, p.subject)
+	}
+	return "key:" + strconv.FormatInt(p.keyID, 10)
+}
+
+type principalKey struct{}
+
+// requestPrincipal returns the caller's identity, or nil for an anonymous request.
+func requestPrincipal(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+func newAPIKeySecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func createAPIKey(ctx context.Context, db *sql.DB, k *apiKey) error {
+	k.Key = newAPIKeySecret()
+	k.KeyPrefix = k.Key[:len(apiKeyPrefix)+6]
+	return db.QueryRowContext(ctx, `
+	INSERT INTO api_keys (name, key_hash, key_prefix, scopes, tenant_id)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+	RETURNING id, created_at
+`, k.Name, hashAPIKey(k.Key), k.KeyPrefix, pq.Array(k.Scopes), k.Tenant).Scan(&k.ID, &k.CreatedAt)
+}
+
+func listAPIKeys(ctx context.Context, db *sql.DB) ([]apiKey, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT id, name, key_prefix, scopes, COALESCE(tenant_id, ''), created_at, last_used_at, revoked_at
+	FROM api_keys
+	ORDER BY id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []apiKey{}
+	for rows.Next() {
+		var k apiKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.KeyPrefix, pq.Array(&k.Scopes), &k.Tenant, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// revokeAPIKey marks the key revoked; the row stays for the audit trail.
+func revokeAPIKey(ctx context.Context, db *sql.DB, id int64) (bool, error) {
+	res, err := db.ExecContext(ctx, `UPDATE api_keys SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+type apiKeyCacheEntry struct {
+	p       *principal // nil: unknown or revoked
+	expires time.Time
+}
+
+type apiKeyAuth struct {
+	db        *sql.DB
+	required  bool
+	bootstrap string
+	ttl       tunableDuration // API_KEY_CACHE_TTL, reloadable
+	jwt       *jwtVerifier    // nil: bearer JWTs aren't accepted
+
+	mu    sync.Mutex
+	cache map[string]apiKeyCacheEntry // by key hash
+}
+
+func newAPIKeyAuth(db *sql.DB) *apiKeyAuth {
+	a := &apiKeyAuth{
+		db:        db,
+		required:  envBool("API_AUTH", false),
+		bootstrap: getenv("API_KEY_BOOTSTRAP"),
+		jwt:       newJWTVerifier(),
+		cache:     make(map[string]apiKeyCacheEntry),
+	}
+	a.ttl.set(envDuration("API_KEY_CACHE_TTL", 30*time.Second))
+	return a
+}
+
+// lookup resolves a key, from the cache or the table (stamping last_used_at).
+func (a *apiKeyAuth) lookup(ctx context.Context, key string) (*principal, error) {
+	if a.bootstrap != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.bootstrap)) == 1 {
+		return &principal{name: "bootstrap", scopes: apiKeyScopes}, nil
+	}
+	hash := hashAPIKey(key)
+	now := time.Now()
+	a.mu.Lock()
+	e, ok := a.cache[hash]
+	a.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.p, nil
+	}
+
+	if a.db == nil {
+		return nil, nil // the standalone backends keep no keys
+	}
+	p := &principal{}
+	err := a.db.QueryRowContext(ctx, `
+	UPDATE api_keys SET last_used_at=now()
+	WHERE key_hash=$1 AND revoked_at IS NULL
+	RETURNING id, name, scopes, COALESCE(tenant_id, '')
+`, hash).Scan(&p.keyID, &p.name, pq.Array(&p.scopes), &p.tenant)
+	if err == sql.ErrNoRows {
+		p = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	if len(a.cache) >= maxAPIKeyCacheSize {
+		clear(a.cache) // mostly junk keys; the real ones come back on the next request
+	}
+	a.cache[hash] = apiKeyCacheEntry{p: p, expires: now.Add(a.ttl.get())}
+	a.mu.Unlock()
+	return p, nil
+}
+
+// forget drops a revoked key from this instance's cache.
+func (a *apiKeyAuth) forget(id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for hash, e := range a.cache {
+		if e.p != nil && e.p.keyID == id {
+			delete(a.cache, hash)
+		}
+	}
+}
+
+// presentedAPIKey returns the key from Authorization: Bearer or X-API-Key.
+func presentedAPIKey(r *http.Request) string {
+	if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(v)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+func authExempt(path string) bool {
+	switch path {
+	case "/", "/favicon.ico", "/openapi.json", "/healthz", "/readyz", "/metrics":
+		return true
+	}
+	return false
+}
+
+func (a *apiKeyAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := presentedAPIKey(r)
+		if key == "" {
+			if a.required {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeProblem(w, http.StatusUnauthorized, "missing_api_key", "api key required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.jwt != nil && looksLikeJWT(key) {
+			// a JWKS fetch on first use or rotation may take a moment
+			ctx, cancel := context.WithTimeout(r.Context(), jwksFetchTimeout)
+			p, err := a.jwt.verify(ctx, key, time.Now())
+			cancel()
+			switch {
+			case errors.Is(err, errJWKSFetch):
+				writeProblem(w, http.StatusServiceUnavailable, "auth_unavailable", "token keys unavailable")
+				return
+			case err != nil:
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeProblem(w, http.StatusUnauthorized, "invalid_token", "invalid or expired token")
+				return
+			}
+			serveAs(w, r, next, p)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 300*time.Millisecond)
+		p, err := a.lookup(ctx, key)
+		cancel()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "API key lookup error", "err", err)
+			writeProblem(w, http.StatusServiceUnavailable, "auth_unavailable", "api key lookup failed")
+			return
+		}
+		if p == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeProblem(w, http.StatusUnauthorized, "invalid_api_key", "invalid or revoked api key")
+			return
+		}
+		serveAs(w, r, next, p)
+	})
+}
+
+// serveAs runs the request as p.
+func serveAs(w http.ResponseWriter, r *http.Request, next http.Handler, p *principal) {
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+}
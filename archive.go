@@ -0,0 +1,340 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+)
+
+// Archival: once a season is archived (its board is dropped, the ledger kept),
+// the final standings and the raw score_events are written as gzipped NDJSON
+// to object storage under {prefix}{seasonId}/. Standings come from the ledger,
+// since the ZSET may already be gone. Each file is staged in a temp file first
+// because a signed PUT needs the length and hash up front. With pruning on,
+// the season's score_events and snapshots are deleted after both uploads.
+
+const archivePruneBatch = 10000
+
+type seasonArchive struct {
+	SeasonID     string     `json:"seasonId"`
+	Status       string     `json:"status"` // done/failed
+	StandingsKey string     `json:"standingsKey,omitempty"`
+	EventsKey    string     `json:"eventsKey,omitempty"`
+	Members      int64      `json:"members"`
+	Events       int64      `json:"events"`
+	Pruned       bool       `json:"pruned"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"lastError,omitempty"`
+	ArchivedAt   *time.Time `json:"archivedAt,omitempty"`
+}
+
+type seasonArchiver struct {
+	db     *sql.DB
+	store  *s3Store
+	prefix string
+	prune  bool
+}
+
+func newSeasonArchiver(db *sql.DB, store *s3Store, prefix string, prune bool) *seasonArchiver {
+	return &seasonArchiver{db: db, store: store, prefix: prefix, prune: prune}
+}
+
+func (a *seasonArchiver) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.archiveAll(ctx); err != nil {
+				slog.Error("Archive error", "err", err)
+			}
+		}
+	}
+}
+
+// archiveAll archives every archived season that doesn't have a finished
+// archive yet (or, with pruning on, hasn't been pruned), one instance at a
+// time. Failed seasons are retried each pass.
+func (a *seasonArchiver) archiveAll(ctx context.Context) error {
+	conn, err := a.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_lock(hashtext('lb_archive'))`).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('lb_archive'))`)
+
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	rows, err := a.db.QueryContext(c, `
+	SELECT s.season_id, COALESCE(a.status='done', FALSE) FROM seasons s
+	LEFT JOIN season_archives a ON a.season_id=s.season_id
+	WHERE s.status='archived'
+	  AND (COALESCE(a.status, '') <> 'done' OR ($1 AND NOT a.pruned))
+	ORDER BY s.updated_at
+`, a.prune)
+	if err != nil {
+		cancel()
+		return err
+	}
+	type dueSeason struct {
+		id       string
+		uploaded bool
+	}
+	var due []dueSeason
+	for rows.Next() {
+		var d dueSeason
+		if err := rows.Scan(&d.id, &d.uploaded); err != nil {
+			rows.Close()
+			cancel()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	cancel()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.uploaded {
+			// uploaded on an earlier pass, but the prune didn't finish
+			if err := a.pruneSeason(ctx, d.id); err != nil {
+				slog.Error("Archive prune failed", "seasonId", d.id, "err", err)
+				if err := a.saveFailure(ctx, d.id, err); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		arc, err := a.archiveSeason(ctx, d.id)
+		if err != nil {
+			slog.Error("Archive failed", "seasonId", d.id, "err", err)
+			if err := a.saveFailure(ctx, d.id, err); err != nil {
+				return err
+			}
+			continue
+		}
+		slog.Info("Archived season", "seasonId", d.id, "members", arc.Members, "events", arc.Events, "pruned", arc.Pruned)
+	}
+	return nil
+}
+
+func (a *seasonArchiver) archiveSeason(ctx context.Context, seasonID string) (*seasonArchive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	arc := &seasonArchive{
+		SeasonID:     seasonID,
+		Status:       "done",
+		StandingsKey: a.prefix + seasonID + "/standings.ndjson.gz",
+		EventsKey:    a.prefix + seasonID + "/events.ndjson.gz",
+	}
+
+	var err error
+	arc.Members, err = a.upload(ctx, arc.StandingsKey, func(enc *json.Encoder) (int64, error) {
+		return writeLedgerStandings(ctx, a.db, seasonID, enc)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("standings: %w", err)
+	}
+	arc.Events, err = a.upload(ctx, arc.EventsKey, func(enc *json.Encoder) (int64, error) {
+		var n int64
+		err := streamEvents(ctx, a.db, seasonID, time.Time{}, time.Time{}, func(events []exportedEvent) error {
+			for _, e := range events {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+			}
+			n += int64(len(events))
+			return nil
+		})
+		return n, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("events: %w", err)
+	}
+
+	// Record the upload before pruning, so a failed prune never loses track of it.
+	if err := a.saveDone(ctx, arc); err != nil {
+		return nil, err
+	}
+	if a.prune {
+		if err := a.pruneSeason(ctx, seasonID); err != nil {
+			return nil, fmt.Errorf("prune: %w", err)
+		}
+		arc.Pruned = true
+	}
+	return arc, nil
+}
+
+// pruneSeason deletes an archived season's score_events, plus its snapshots,
+// once they're safely in object storage: its partition is dropped if it has
+// one (partitions.go), and any rows left are deleted in batches.
+func (a *seasonArchiver) pruneSeason(ctx context.Context, seasonID string) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// archived seasons take no new rows, so there's no cutoff
+	dropped, _, err := dropSeasonPartition(ctx, tx, seasonID, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	if dropped {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	for {
+		res, err := a.db.ExecContext(ctx, `
+	DELETE FROM score_events
+	WHERE season_id=$1 AND id IN (SELECT id FROM score_events WHERE season_id=$1 LIMIT $2)
+`, seasonID, archivePruneBatch)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n < archivePruneBatch {
+			break
+		}
+	}
+	if _, err := a.db.ExecContext(ctx, `DELETE FROM leaderboard_snapshots WHERE season_id=$1`, seasonID); err != nil {
+		return err
+	}
+	_, err = a.db.ExecContext(ctx,
+		`UPDATE season_archives SET pruned=TRUE, last_error=NULL WHERE season_id=$1`, seasonID)
+	return err
+}
+
+// upload stages gzipped NDJSON written by fn in a temp file, then PUTs it.
+func (a *seasonArchiver) upload(ctx context.Context, key string, fn func(enc *json.Encoder) (int64, error)) (int64, error) {
+	f, err := os.CreateTemp("", "lb-archive-*.ndjson.gz")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(f, h))
+	n, err := fn(json.NewEncoder(zw))
+	if err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := a.store.put(ctx, key, f, size, hex.EncodeToString(h.Sum(nil)), "application/gzip"); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (a *seasonArchiver) saveDone(ctx context.Context, arc *seasonArchive) error {
+	_, err := a.db.ExecContext(ctx, `
+	INSERT INTO season_archives (season_id, status, standings_key, events_key, members, events, attempts, archived_at)
+	VALUES ($1, 'done', $2, $3, $4, $5, 1, now())
+	ON CONFLICT (season_id) DO UPDATE SET
+	  status='done', standings_key=$2, events_key=$3, members=$4, events=$5,
+	  attempts=season_archives.attempts + 1, last_error=NULL, archived_at=now()
+`, arc.SeasonID, arc.StandingsKey, arc.EventsKey, arc.Members, arc.Events)
+	return err
+}
+
+func (a *seasonArchiver) saveFailure(ctx context.Context, seasonID string, cause error) error {
+	c, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	// A prune failure leaves the row done; only record the error.
+	_, err := a.db.ExecContext(c, `
+	INSERT INTO season_archives (season_id, status, attempts, last_error)
+	VALUES ($1, 'failed', 1, $2)
+	ON CONFLICT (season_id) DO UPDATE SET
+	  status=CASE WHEN season_archives.status='done' THEN 'done' ELSE 'failed' END,
+	  attempts=season_archives.attempts + CASE WHEN season_archives.status='done' THEN 0 ELSE 1 END,
+	  last_error=$2
+`, seasonID, cause.Error())
+	return err
+}
+
+// writeLedgerStandings writes the season's final ranking as computed from the
+// ledger (held users left out, ties like Redis) and returns the member count.
+func writeLedgerStandings(ctx context.Context, db *sql.DB, seasonID string, enc *json.Encoder) (int64, error) {
+	rows, err := db.QueryContext(ctx, `
+	WITH totals AS (`+ledgerTotalsSQL+`)
+	SELECT user_id, score FROM totals
+	ORDER BY score DESC, user_id DESC
+`, seasonID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var rank int64
+	for rows.Next() {
+		var uid string
+		var score int64
+		if err := rows.Scan(&uid, &score); err != nil {
+			return rank, err
+		}
+		rank++
+		if err := enc.Encode(exportedStanding{SeasonID: seasonID, Rank: rank, UserID: uid, Score: float64(score)}); err != nil {
+			return rank, err
+		}
+	}
+	return rank, rows.Err()
+}
+
+func getSeasonArchive(ctx context.Context, db *sql.DB, seasonID string) (*seasonArchive, error) {
+	arc := &seasonArchive{SeasonID: seasonID}
+	var standingsKey, eventsKey, lastError sql.NullString
+	var archivedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `
+	SELECT status, standings_key, events_key, members, events, pruned, attempts, last_error, archived_at
+	FROM season_archives WHERE season_id=$1
+`, seasonID).Scan(&arc.Status, &standingsKey, &eventsKey, &arc.Members, &arc.Events, &arc.Pruned,
+		&arc.Attempts, &lastError, &archivedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	arc.StandingsKey, arc.EventsKey, arc.LastError = standingsKey.String, eventsKey.String, lastError.String
+	if archivedAt.Valid {
+		arc.ArchivedAt = &archivedAt.Time
+	}
+	return arc, nil
+}
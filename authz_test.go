@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// specRoutes returns the mux pattern of every operation in openapi.yml.
+func specRoutes(t *testing.T) []string {
+	t.Helper()
+	var doc struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(openAPIYAML, &doc); err != nil {
+		t.Fatal(err)
+	}
+	methods := []string{"get", "put", "post", "patch", "delete"}
+	var patterns []string
+	for path, ops := range doc.Paths {
+		if path == "/" {
+			path = "/{$}"
+		}
+		for op := range ops {
+			if slices.Contains(methods, op) {
+				patterns = append(patterns, strings.ToUpper(op)+" "+path)
+			}
+		}
+	}
+	slices.Sort(patterns)
+	return patterns
+}
+
+var wildcardRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// routeRequest builds a request that matches pattern.
+func routeRequest(pattern string) *http.Request {
+	method, path, _ := strings.Cut(pattern, " ")
+	path = strings.ReplaceAll(path, "{$}", "")
+	path = wildcardRe.ReplaceAllStringFunc(path, func(w string) string { return "x" + strings.Trim(w, "{}") })
+	return httptest.NewRequest(method, path, nil)
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("problem body %q: %v", rec.Body.String(), err)
+	}
+	return body.Code
+}
+
+func TestRouteScopes(t *testing.T) {
+	routes := specRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("no routes in openapi.yml")
+	}
+	for _, pattern := range routes {
+		_, path, _ := strings.Cut(pattern, " ")
+		if strings.HasPrefix(path, "/v1/admin/") {
+			if got := requiredScope(pattern); got != scopeAdmin {
+				t.Errorf("%s needs scope %q, want admin", pattern, got)
+			}
+			continue
+		}
+		if _, ok := routeScopes[pattern]; !ok {
+			t.Errorf("%s is missing from routeScopes", pattern)
+		}
+	}
+	for pattern := range routeScopes {
+		if !slices.Contains(routes, pattern) {
+			t.Errorf("routeScopes lists %s, which isn't in openapi.yml", pattern)
+		}
+	}
+
+	for _, tc := range []struct {
+		pattern, want string
+	}{
+		{"GET /healthz", scopeNone},
+		{"GET /metrics", scopeNone},
+		{"GET /v1/seasons/{sid}/leaderboard/top", scopeRead},
+		{"GET /v1/ws/ranks", scopeRead},
+		{"POST /v1/seasons/{sid}/scores", scopeWrite},
+		{"DELETE /v1/seasons/{sid}", scopeAdmin},
+		{"GET /v1/admin/outbox/stats", scopeAdmin},
+		{"POST /v1/admin/outbox/dead:requeue", scopeAdmin},
+		{"GET /v1/seasons/{sid}/unlisted", scopeAdmin},
+	} {
+		if got := requiredScope(tc.pattern); got != tc.want {
+			t.Errorf("requiredScope(%s) = %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	mux := http.NewServeMux()
+	routes := specRoutes(t)
+	for _, pattern := range routes {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	handler := authorize(mux, mux)
+
+	readWrite := &principal{keyID: 1, scopes: []string{scopeRead, scopeWrite}}
+	admin := &principal{keyID: 2, scopes: []string{scopeAdmin}}
+	token := &principal{subject: "alice"}
+	for _, tc := range []struct {
+		name string
+		p    *principal
+		want func(pattern string) int
+	}{
+		{"anonymous", nil, func(string) int { return http.StatusOK }},
+		{"read-write key", readWrite, func(pattern string) int {
+			if requiredScope(pattern) == scopeAdmin {
+				return http.StatusForbidden
+			}
+			return http.StatusOK
+		}},
+		{"admin key", admin, func(pattern string) int {
+			if s := requiredScope(pattern); s != scopeNone && s != scopeAdmin {
+				return http.StatusForbidden
+			}
+			return http.StatusOK
+		}},
+		{"token without scopes", token, func(pattern string) int {
+			if requiredScope(pattern) == scopeNone || selfReadRoutes[pattern] {
+				return http.StatusOK
+			}
+			return http.StatusForbidden
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, pattern := range routes {
+				req := routeRequest(pattern)
+				if tc.p != nil {
+					req = req.WithContext(context.WithValue(req.Context(), principalKey{}, tc.p))
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				want := tc.want(pattern)
+				if rec.Code != want {
+					t.Errorf("%s = %d, want %d", pattern, rec.Code, want)
+					continue
+				}
+				if want == http.StatusForbidden && problemCode(t, rec) != "insufficient_scope" {
+					t.Errorf("%s: code = %q, want insufficient_scope", pattern, problemCode(t, rec))
+				}
+			}
+		})
+	}
+}
+
+func TestKeyAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		required bool
+		path     string
+		key      string
+		want     int
+		code     string
+		scopes   []string // of the principal the request went through as
+	}{
+		{"optional, no key", false, "/v1/seasons/s1/leaderboard/top", "", http.StatusOK, "", nil},
+		{"optional, unknown key", false, "/v1/seasons/s1/leaderboard/top", "lbk_unknown", http.StatusUnauthorized, "invalid_api_key", nil},
+		{"required, no key", true, "/v1/seasons/s1/leaderboard/top", "", http.StatusUnauthorized, "missing_api_key", nil},
+		{"required, unknown key", true, "/v1/admin/outbox/stats", "lbk_unknown", http.StatusUnauthorized, "invalid_api_key", nil},
+		{"required, bootstrap key", true, "/v1/admin/outbox/stats", "boot-key", http.StatusOK, "", apiKeyScopes},
+		{"required, exempt probe", true, "/healthz", "", http.StatusOK, "", nil},
+		{"required, exempt metrics", true, "/metrics", "lbk_unknown", http.StatusOK, "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("API_AUTH", fmt.Sprint(tc.required))
+			t.Setenv("API_KEY_BOOTSTRAP", "boot-key")
+			var got *principal
+			handler := newAPIKeyAuth(nil).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = requestPrincipal(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.want)
+			}
+			if tc.code != "" {
+				if code := problemCode(t, rec); code != tc.code {
+					t.Errorf("code = %q, want %q", code, tc.code)
+				}
+				if rec.Header().Get("WWW-Authenticate") == "" {
+					t.Error("no WWW-Authenticate header on a 401")
+				}
+			}
+			if tc.scopes != nil && (got == nil || !slices.Equal(got.scopes, tc.scopes)) {
+				t.Errorf("principal = %+v, want scopes %v", got, tc.scopes)
+			}
+		})
+	}
+}
+
+func TestRevokedKeyRejected(t *testing.T) {
+	db, _ := testStores(t)
+	ctx := context.Background()
+	t.Setenv("API_AUTH", "true")
+	t.Setenv("API_KEY_BOOTSTRAP", "")
+
+	k := &apiKey{Name: fmt.Sprintf("revoke-test-%d", time.Now().UnixNano()), Scopes: []string{scopeRead}}
+	if err := createAPIKey(ctx, db, k); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.ExecContext(ctx, `DELETE FROM api_keys WHERE id=$1`, k.ID) })
+
+	auth := newAPIKeyAuth(db)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := auth.middleware(ok)
+	status := func(h http.Handler) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/seasons/s1/leaderboard/top", nil)
+		req.Header.Set("X-API-Key", k.Key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if got := status(handler); got != http.StatusOK {
+		t.Fatalf("before revoking: %d, want 200", got)
+	}
+	if revoked, err := revokeAPIKey(ctx, db, k.ID); err != nil || !revoked {
+		t.Fatalf("revoke: %v, %v", revoked, err)
+	}
+	// what the revoke handler does for the instance that served it
+	auth.forget(k.ID)
+	if got := status(handler); got != http.StatusUnauthorized {
+		t.Errorf("after revoking: %d, want 401", got)
+	}
+	// an instance that never saw the key
+	if got := status(newAPIKeyAuth(db).middleware(ok)); got != http.StatusUnauthorized {
+		t.Errorf("after revoking, on a fresh instance: %d, want 401", got)
+	}
+}
+
+func TestTenantKeyOnAdminRoutes(t *testing.T) {
+	t.Setenv("TENANTS", "acme")
+	tenants := newTenancy()
+	mux := http.NewServeMux()
+	routes := specRoutes(t)
+	for _, pattern := range routes {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	handler := tenants.middleware(mux, mux)
+
+	for _, tc := range []struct {
+		name string
+		p    *principal
+	}{
+		{"default tenant", &principal{keyID: 1, scopes: apiKeyScopes}},
+		{"tenant key", &principal{keyID: 2, tenant: "acme", scopes: apiKeyScopes}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, pattern := range routes {
+				req := routeRequest(pattern)
+				req = req.WithContext(context.WithValue(req.Context(), principalKey{}, tc.p))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				// a tenant may manage its own seasons, but not the shared admin routes
+				want := http.StatusOK
+				if tc.p.tenant != "" && requiredScope(pattern) == scopeAdmin && !strings.Contains(pattern, "/seasons/{sid}") {
+					want = http.StatusForbidden
+				}
+				if rec.Code != want {
+					t.Errorf("%s = %d, want %d", pattern, rec.Code, want)
+					continue
+				}
+				if want == http.StatusForbidden && problemCode(t, rec) != "tenant_forbidden" {
+					t.Errorf("%s: code = %q, want tenant_forbidden", pattern, problemCode(t, rec))
+				}
+			}
+		})
+	}
+
+	// the outbox routes are the ones a tenant key must never reach
+	for _, pattern := range []string{
+		"GET /v1/admin/outbox/dead",
+		"POST /v1/admin/outbox/dead/{id}/requeue",
+		"POST /v1/admin/outbox/dead:requeue",
+		"GET /v1/admin/outbox/stats",
+	} {
+		if !slices.Contains(routes, pattern) {
+			t.Errorf("%s is missing from openapi.yml", pattern)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The bench command drives submissions and reads at fixed rates against a
+// running instance and reports latency percentiles per request kind, so
+// Redis and Postgres can be sized before a launch.
+//
+// Requests are sent open-loop: each kind is started on its own schedule
+// whether or not earlier ones have returned, up to -conns in flight; a request
+// that finds no free slot is counted as dropped rather than delayed, since
+// waiting would hide the very slowdowns being measured.
+//
+// Apply lag (submission to board) is measured from the outside by a probe
+// user: every -probe-interval it submits +1 and polls /rank until Redis shows
+// the new score. Degraded and trimmed answers come from the ledger and don't
+// count. With an admin key the server's own outbox latency is reported too.
+
+type benchOptions struct {
+	target        string
+	apiKey        string
+	seasonID      string
+	users         int
+	writeRPS      float64
+	readRPS       float64
+	duration      time.Duration
+	conns         int
+	probeInterval time.Duration
+	timeout       time.Duration
+}
+
+// benchStats collects one request kind's outcomes.
+type benchStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration // successful requests only
+	statuses  map[string]int64
+	dropped   int64
+}
+
+func (s *benchStats) record(status string, d time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[status]++
+	if ok {
+		s.latencies = append(s.latencies, d)
+	}
+}
+
+type benchSummary struct {
+	Requests int64            `json:"requests"`
+	Dropped  int64            `json:"dropped"`
+	Statuses map[string]int64 `json:"statuses"`
+	Rate     float64          `json:"ratePerSecond"` // successful, achieved
+	P50Ms    float64          `json:"p50Ms"`
+	P90Ms    float64          `json:"p90Ms"`
+	P99Ms    float64          `json:"p99Ms"`
+	MaxMs    float64          `json:"maxMs"`
+}
+
+func (s *benchStats) summary(elapsed time.Duration) benchSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := benchSummary{Dropped: s.dropped, Statuses: s.statuses}
+	for _, n := range s.statuses {
+		out.Requests += n
+	}
+	lat := s.latencies
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	if len(lat) > 0 {
+		pct := func(p float64) float64 { return ms(lat[min(len(lat)-1, int(p*float64(len(lat))))]) }
+		out.P50Ms, out.P90Ms, out.P99Ms, out.MaxMs = pct(0.5), pct(0.9), pct(0.99), ms(lat[len(lat)-1])
+		out.Rate = float64(len(lat)) / elapsed.Seconds()
+	}
+	return out
+}
+
+func ms(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+type benchReport struct {
+	Target          string                  `json:"target"`
+	SeasonID        string                  `json:"seasonId"`
+	DurationSeconds float64                 `json:"durationSeconds"`
+	Requests        map[string]benchSummary `json:"requests"` // submit, top, rank, around
+	ApplyLag        benchSummary            `json:"applyLag"` // probe submissions seen on the board; statuses: applied/timeout/error
+	ServerOutbox    *outboxStats            `json:"serverOutbox,omitempty"`
+}
+
+type benchClient struct {
+	opts benchOptions
+	hc   *http.Client
+	base *url.URL
+}
+
+func (bc *benchClient) do(ctx context.Context, method, path string, query url.Values, body any) (int, []byte, error) {
+	u := bc.base.JoinPath(path)
+	u.RawQuery = query.Encode()
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = strings.NewReader(string(b))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if bc.opts.apiKey != "" {
+		req.Header.Set("X-API-Key", bc.opts.apiKey)
+	}
+	resp, err := bc.hc.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
+
+func (bc *benchClient) seasonPath(rest string) string {
+	return "/v1/seasons/" + url.PathEscape(bc.opts.seasonID) + rest
+}
+
+// request sends one request and records it under stats; 2xx (and a 404 for
+// a user not on the board yet) count as successes.
+func (bc *benchClient) request(ctx context.Context, stats *benchStats, method, path string, query url.Values, body any) {
+	c, cancel := context.WithTimeout(ctx, bc.opts.timeout)
+	defer cancel()
+	start := time.Now()
+	code, _, err := bc.do(c, method, path, query, body)
+	d := time.Since(start)
+	if err != nil {
+		if ctx.Err() != nil {
+			return // run over, not a failure
+		}
+		status := "error"
+		if c.Err() != nil {
+			status = "timeout"
+		}
+		stats.record(status, d, false)
+		return
+	}
+	stats.record(strconv.Itoa(code), d, code/100 == 2 || code == http.StatusNotFound)
+}
+
+// probeScore reads the probe user's score as the board has it; ok is false
+// for an answer not read from the board.
+func (bc *benchClient) probeScore(ctx context.Context, userID string) (score float64, ok bool, err error) {
+	code, b, err := bc.do(ctx, http.MethodGet, bc.seasonPath("/leaderboard/rank"), url.Values{"userId": {userID}}, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	switch code {
+	case http.StatusOK:
+		var rr rankResponse
+		if err := json.Unmarshal(b, &rr); err != nil {
+			return 0, false, err
+		}
+		return rr.Score, !rr.Degraded && !rr.Trimmed, nil
+	case http.StatusNotFound:
+		return 0, true, nil
+	}
+	return 0, false, fmt.Errorf("rank: status %d", code)
+}
+
+// probe submits +1 for the probe user and waits until the board shows it.
+func (bc *benchClient) probe(ctx context.Context, stats *benchStats, userID string) {
+	c, cancel := context.WithTimeout(ctx, bc.opts.timeout)
+	before, ok, err := bc.probeScore(c, userID)
+	cancel()
+	if err != nil || !ok {
+		return // no baseline to compare against
+	}
+
+	start := time.Now()
+	c, cancel = context.WithTimeout(ctx, bc.opts.timeout)
+	code, _, err := bc.do(c, http.MethodPost, bc.seasonPath("/scores"), nil, map[string]any{"userId": userID, "delta": 1})
+	cancel()
+	if err != nil || code != http.StatusAccepted {
+		if ctx.Err() == nil {
+			stats.record("error", 0, false)
+		}
+		return
+	}
+
+	deadline := start.Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		c, cancel := context.WithTimeout(ctx, bc.opts.timeout)
+		score, ok, err := bc.probeScore(c, userID)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && ok && score > before {
+			stats.record("applied", time.Since(start), true)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats.record("timeout", 0, false)
+}
+
+// schedule starts a request rps times a second until ctx is done, each on
+// its own goroutine holding a slot of sem. next picks the request and the
+// stats it's counted under.
+func schedule(ctx context.Context, wg *sync.WaitGroup, sem chan struct{}, rps float64, next func() (*benchStats, func())) {
+	if rps <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stats, fn := next()
+		select {
+		case sem <- struct{}{}:
+		default:
+			stats.mu.Lock()
+			stats.dropped++
+			stats.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fn()
+		}()
+	}
+}
+
+func runBench(ctx context.Context, opts benchOptions) (*benchReport, error) {
+	base, err := url.Parse(opts.target)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid -target %q", opts.target)
+	}
+	bc := &benchClient{
+		opts: opts,
+		base: base,
+		hc: &http.Client{Transport: &http.Transport{
+			MaxIdleConns:        opts.conns,
+			MaxIdleConnsPerHost: opts.conns,
+		}},
+	}
+	newStats := func() *benchStats { return &benchStats{statuses: map[string]int64{}} }
+	stats := map[string]*benchStats{"submit": newStats(), "top": newStats(), "rank": newStats(), "around": newStats()}
+	lag := newStats()
+
+	run, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.conns)
+	user := func() string {
+		return "bench-user" + strconv.Itoa(1+rand.N(opts.users))
+	}
+
+	start := time.Now()
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		schedule(run, &wg, sem, opts.writeRPS, func() (*benchStats, func()) {
+			return stats["submit"], func() {
+				body := map[string]any{"userId": user(), "delta": 1 + rand.N(100)}
+				bc.request(run, stats["submit"], http.MethodPost, bc.seasonPath("/scores"), nil, body)
+			}
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		// top, rank and around in turn
+		var seq int
+		schedule(run, &wg, sem, opts.readRPS, func() (*benchStats, func()) {
+			seq++
+			switch seq % 3 {
+			case 0:
+				return stats["top"], func() {
+					bc.request(run, stats["top"], http.MethodGet, bc.seasonPath("/leaderboard/top"), url.Values{"limit": {"10"}}, nil)
+				}
+			case 1:
+				return stats["rank"], func() {
+					bc.request(run, stats["rank"], http.MethodGet, bc.seasonPath("/leaderboard/rank"), url.Values{"userId": {user()}}, nil)
+				}
+			default:
+				return stats["around"], func() {
+					bc.request(run, stats["around"], http.MethodGet, bc.seasonPath("/leaderboard/around"), url.Values{"userId": {user()}, "range": {"5"}}, nil)
+				}
+			}
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(opts.probeInterval)
+		defer ticker.Stop()
+		for {
+			bc.probe(run, lag, "bench-probe")
+			select {
+			case <-run.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	rep := &benchReport{
+		Target:          opts.target,
+		SeasonID:        opts.seasonID,
+		DurationSeconds: elapsed.Seconds(),
+		Requests:        map[string]benchSummary{},
+		ApplyLag:        lag.summary(elapsed),
+	}
+	for name, s := range stats {
+		rep.Requests[name] = s.summary(elapsed)
+	}
+	if opts.apiKey != "" {
+		c, cancel := context.WithTimeout(ctx, opts.timeout)
+		code, b, err := bc.do(c, http.MethodGet, "/v1/admin/outbox/stats", nil, nil)
+		cancel()
+		if err == nil && code == http.StatusOK {
+			var st outboxStats
+			if json.Unmarshal(b, &st) == nil {
+				rep.ServerOutbox = &st
+			}
+		}
+	}
+	return rep, nil
+}
+
+func (rep *benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "target %s  season %s  %.1fs\n\n", rep.Target, rep.SeasonID, rep.DurationSeconds)
+	fmt.Fprintf(w, "%-10s %9s %8s %9s %9s %9s %9s %9s  %s\n", "", "requests", "dropped", "ok/s", "p50 ms", "p90 ms", "p99 ms", "max ms", "statuses")
+	row := func(name string, s benchSummary) {
+		codes := make([]string, 0, len(s.Statuses))
+		for code, n := range s.Statuses {
+			codes = append(codes, fmt.Sprintf("%s=%d", code, n))
+		}
+		sort.Strings(codes)
+		fmt.Fprintf(w, "%-10s %9d %8d %9.1f %9.1f %9.1f %9.1f %9.1f  %s\n", name, s.Requests, s.Dropped, s.Rate,
+			s.P50Ms, s.P90Ms, s.P99Ms, s.MaxMs, strings.Join(codes, " "))
+	}
+	for _, name := range []string{"submit", "top", "rank", "around"} {
+		row(name, rep.Requests[name])
+	}
+	row("apply lag", rep.ApplyLag)
+	if st := rep.ServerOutbox; st != nil {
+		fmt.Fprintf(w, "\nserver outbox: p50 %.1f ms  p95 %.1f ms  p99 %.1f ms over %d rows (last 5m)  pending %d  oldest %.1fs\n",
+			st.ApplyLatency.P50Ms, st.ApplyLatency.P95Ms, st.ApplyLatency.P99Ms, st.ApplyLatency.Samples,
+			st.Counts["pending"], st.OldestPendingAge)
+	}
+}
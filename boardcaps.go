@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// A season's board can be capped (seasons.max_size, else LEADERBOARD_MAX_SIZE):
+// after each apply the lowest members past the cap are trimmed off. They stay
+// in the ledger, so a trimmed user whose score changes again is seeded with
+// their ledger total before the delta lands (seedTrimmedMembers) and competes
+// for the cap on their whole score. The trimming itself is the leaderboard
+// package's (leaderboard/batch.go); the binary supplies the caps and seeds.
+
+// newBoardRedisStore is the leaderboard.RedisStore the binary writes boards
+// through: the configured tie-breaking, the caps from boardCaps and a board
+// version bump after every change.
+func newBoardRedisStore(db *sql.DB, rdb redis.UniversalClient, defaultMaxSize int64, dedupWindow time.Duration) *leaderboard.RedisStore {
+	return leaderboard.NewRedisStore(rdb, leaderboard.RedisOptions{
+		DedupWindow: dedupWindow,
+		TieBreak:    tieBreakFirst(),
+		Caps: func(ctx context.Context, sids []string) (map[string]int64, error) {
+			return boardCaps(ctx, db, sids, defaultMaxSize)
+		},
+		Changed:     queueBoardBump,
+		OnTrimError: func(err error) { slog.Error("Trim error", "err", err) },
+	})
+}
+
+// trimLeaderboards caps each touched board at its max size, dropping the lowest scores.
+func trimLeaderboards(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, touched map[string]struct{}, defaultMaxSize int64) error {
+	sids := make([]string, 0, len(touched))
+	for sid := range touched {
+		sids = append(sids, sid)
+	}
+	return newBoardRedisStore(db, rdb, defaultMaxSize, 0).Trim(ctx, sids)
+}
+
+// boardCaps returns the effective cap of each season in sids (0 = unlimited).
+func boardCaps(ctx context.Context, db *sql.DB, sids []string, defaultMaxSize int64) (map[string]int64, error) {
+	caps := make(map[string]int64, len(sids))
+	for _, sid := range sids {
+		caps[sid] = defaultMaxSize
+	}
+	if len(sids) == 0 {
+		return caps, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+	SELECT season_id, max_size
+	FROM seasons
+	WHERE season_id = ANY($1) AND max_size IS NOT NULL
+`, pq.Array(sids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid string
+		var maxSize int64
+		if err := rows.Scan(&sid, &maxSize); err != nil {
+			return nil, err
+		}
+		caps[sid] = maxSize
+	}
+	return caps, rows.Err()
+}
+
+// seedTrimmedMembers returns the seed of each member missing from a capped
+// board that has a ledger total (a leaderboard.Seeder). Ledger rows whose
+// outbox row isn't applied yet are left out, as in rebuildUserScore; the
+// batch's own rows are still pending, so they are added on top by the apply
+// as usual.
+func seedTrimmedMembers(ctx context.Context, tx *sql.Tx, members []leaderboard.Member) (map[leaderboard.Member]leaderboard.Seed, error) {
+	sids := make([]string, len(members))
+	uids := make([]string, len(members))
+	for i, m := range members {
+		sids[i], uids[i] = m.SeasonID, m.UserID
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT e.season_id, e.user_id, SUM(e.delta), MAX(e.created_at)
+	FROM score_events e
+	JOIN unnest($1::text[], $2::text[]) AS m(season_id, user_id)
+	  ON e.season_id = m.season_id AND e.user_id = m.user_id
+	WHERE NOT EXISTS (
+	  SELECT 1 FROM outbox o
+	  WHERE o.event_type='score_delta' AND o.status IN ('pending', 'processing', 'failed')
+	    AND o.event_id = e.id
+	)
+	GROUP BY e.season_id, e.user_id
+`, pq.Array(sids), pq.Array(uids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seeds := make(map[leaderboard.Member]leaderboard.Seed)
+	for rows.Next() {
+		var m leaderboard.Member
+		var sd leaderboard.Seed
+		if err := rows.Scan(&m.SeasonID, &m.UserID, &sd.Points, &sd.At); err != nil {
+			return nil, err
+		}
+		seeds[m] = sd
+	}
+	return seeds, rows.Err()
+}
+
+// trimmedScore returns the ledger total of a user missing from a capped
+// board, and whether the user is one the cap trimmed off: someone with
+// applied ledger rows (the predicate seedTrimmedMembers uses) whose total is
+// at or below the lowest score still on the board. A user whose only rows are
+// waiting for the worker, or who should be on the board, is not.
+func trimmedScore(ctx context.Context, db *sql.DB, c redis.Cmdable, seasonID, userID string) (int64, bool, error) {
+	var sum sql.NullInt64
+	if err := db.QueryRowContext(ctx, `
+	SELECT SUM(e.delta)
+	FROM score_events e
+	WHERE e.season_id=$1 AND e.user_id=$2
+	  AND NOT EXISTS (
+	    SELECT 1 FROM outbox o
+	    WHERE o.event_type='score_delta' AND o.status IN ('pending', 'processing', 'failed')
+	      AND o.event_id = e.id
+	  )
+`, seasonID, userID).Scan(&sum); err != nil || !sum.Valid {
+		return 0, false, err
+	}
+
+	lowest, err := c.ZRangeWithScores(ctx, boardKey(seasonID), 0, 0).Result()
+	if err != nil || len(lowest) == 0 {
+		return 0, false, err
+	}
+	return sum.Int64, float64(sum.Int64) <= leaderboard.Points(lowest[0].Score), nil
+}
+
+// seasonMaxSize returns the effective board cap for a season (0 = unlimited).
+func seasonMaxSize(ctx context.Context, db *sql.DB, seasonID string, defaultMaxSize int64) (int64, error) {
+	var maxSize sql.NullInt64
+	err := db.QueryRowContext(ctx,
+		`SELECT max_size FROM seasons WHERE season_id=$1`, seasonID).Scan(&maxSize)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if maxSize.Valid {
+		return maxSize.Int64, nil
+	}
+	return defaultMaxSize, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/outbox"
+	"github.com/redis/go-redis/v9"
+)
+
+// The worker tests need a Postgres and a Redis to write to:
+// TEST_DATABASE_URL=postgres://... TEST_REDIS_ADDR=localhost:6379 go test.
+func testStores(t *testing.T) (*sql.DB, redis.UniversalClient) {
+	t.Helper()
+	dsn, addr := os.Getenv("TEST_DATABASE_URL"), os.Getenv("TEST_REDIS_ADDR")
+	if dsn == "" || addr == "" {
+		t.Skip("TEST_DATABASE_URL and TEST_REDIS_ADDR not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := migrateUp(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rdb.Close() })
+	return db, rdb
+}
+
+// queueDelta records a delta the way the submit path does: a ledger row and
+// its pending outbox row.
+func queueDelta(t *testing.T, db *sql.DB, seasonID, userID string, delta int64) {
+	t.Helper()
+	ctx := context.Background()
+	var id int64
+	if err := db.QueryRowContext(ctx,
+		`INSERT INTO score_events (season_id, user_id, delta) VALUES ($1, $2, $3) RETURNING id`,
+		seasonID, userID, delta).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	payload := fmt.Sprintf(`{"seasonId": %q, "userId": %q, "delta": %d, "eventId": %d}`, seasonID, userID, delta, id)
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO outbox (event_type, payload, status, event_id) VALUES ('score_delta', $1, 'pending', $2)`, payload, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func drainOutbox(t *testing.T, db *sql.DB, rdb redis.UniversalClient) {
+	t.Helper()
+	cfg := outboxConfig{batchSize: 100, retry: outbox.Retry{MaxAttempts: 3, Base: time.Second, Max: time.Second}}
+	w := newOutboxWorker(db, rdb, 0, cfg)
+	for {
+		n, err := w.ProcessBatch(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func TestTrimmedUserReturnsWithLedgerTotal(t *testing.T) {
+	db, rdb := testStores(t)
+	ctx := context.Background()
+	sid := fmt.Sprintf("trim-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		rdb.Del(ctx, boardKey(sid), appliedKey(sid))
+		db.ExecContext(ctx, `DELETE FROM outbox WHERE payload->>'seasonId'=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM score_events WHERE season_id=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM seasons WHERE season_id=$1`, sid)
+	})
+	if _, err := db.ExecContext(ctx, `INSERT INTO seasons (season_id, max_size) VALUES ($1, 1)`, sid); err != nil {
+		t.Fatal(err)
+	}
+
+	queueDelta(t, db, sid, "alice", 10)
+	queueDelta(t, db, sid, "bob", 6)
+	drainOutbox(t, db, rdb)
+	if _, err := rdb.ZScore(ctx, boardKey(sid), "bob").Result(); err != redis.Nil {
+		t.Fatalf("bob still on the capped board: %v", err)
+	}
+
+	// 6 + 5 passes alice's 10; 5 alone wouldn't
+	queueDelta(t, db, sid, "bob", 5)
+	drainOutbox(t, db, rdb)
+	score, err := rdb.ZScore(ctx, boardKey(sid), "bob").Result()
+	if err != nil {
+		t.Fatalf("bob not back on the board: %v", err)
+	}
+	if got := int64(score); got != 11 {
+		t.Fatalf("bob's score = %d, want 11", got)
+	}
+	if _, err := rdb.ZScore(ctx, boardKey(sid), "alice").Result(); err != redis.Nil {
+		t.Fatalf("alice not trimmed: %v", err)
+	}
+}
+
+func TestPendingUserIsNotTrimmed(t *testing.T) {
+	db, rdb := testStores(t)
+	ctx := context.Background()
+	sid := fmt.Sprintf("trim-pending-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		rdb.Del(ctx, boardKey(sid), appliedKey(sid))
+		db.ExecContext(ctx, `DELETE FROM outbox WHERE payload->>'seasonId'=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM score_events WHERE season_id=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM seasons WHERE season_id=$1`, sid)
+	})
+	if _, err := db.ExecContext(ctx, `INSERT INTO seasons (season_id, max_size) VALUES ($1, 1)`, sid); err != nil {
+		t.Fatal(err)
+	}
+
+	queueDelta(t, db, sid, "alice", 10)
+	drainOutbox(t, db, rdb)
+	// bob's first event is still waiting for the worker
+	queueDelta(t, db, sid, "bob", 20)
+	if _, trimmed, err := trimmedScore(ctx, db, rdb, sid, "bob"); err != nil || trimmed {
+		t.Fatalf("pending bob: trimmed=%v err=%v", trimmed, err)
+	}
+
+	queueDelta(t, db, sid, "carol", 5)
+	drainOutbox(t, db, rdb)
+	score, trimmed, err := trimmedScore(ctx, db, rdb, sid, "carol")
+	if err != nil || !trimmed || score != 5 {
+		t.Fatalf("carol: score=%d trimmed=%v err=%v, want 5 trimmed", score, trimmed, err)
+	}
+}
+
+func TestRedisStoreTrimsToCap(t *testing.T) {
+	_, rdb := testStores(t)
+	ctx := context.Background()
+	sid := fmt.Sprintf("cap-store-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { rdb.Del(ctx, boardKey(sid)) })
+	var changed []string
+	store := leaderboard.NewRedisStore(rdb, leaderboard.RedisOptions{
+		Caps: func(ctx context.Context, sids []string) (map[string]int64, error) {
+			return map[string]int64{sid: 2}, nil
+		},
+		Changed: func(ctx context.Context, pipe redis.Pipeliner, seasonID string, at time.Time) {
+			changed = append(changed, seasonID)
+		},
+	})
+
+	changes := []*leaderboard.Change{
+		{Kind: leaderboard.ChangeApply, SeasonID: sid, UserID: "alice", Deltas: []int64{10}},
+		{Kind: leaderboard.ChangeApply, SeasonID: sid, UserID: "bob", Deltas: []int64{30}},
+		{Kind: leaderboard.ChangeApply, SeasonID: sid, UserID: "carol", Deltas: []int64{20}},
+	}
+	if err := store.ApplyChanges(ctx, changes, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range changes {
+		if c.Err != nil || c.Score != float64(c.Deltas[0]) {
+			t.Fatalf("%s: score %v, err %v", c.UserID, c.Score, c.Err)
+		}
+	}
+	top, err := store.Top(ctx, sid, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].UserID != "bob" || top[1].UserID != "carol" {
+		t.Fatalf("top = %v, want bob and carol with alice trimmed", top)
+	}
+	// once for the apply, once for the trim
+	if len(changed) != 2 {
+		t.Fatalf("Changed ran %d times, want 2", len(changed))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/redis/go-redis/v9"
+)
+
+// Full-board exports read from a private COPY of the ZSET so the whole file is
+// one consistent cut, even though it is streamed out page by page while the
+// worker keeps applying deltas to the live board. The copy expires on its own
+// if the process dies mid-export.
+
+const (
+	boardExportPage = 1000
+	boardExportTTL  = 10 * time.Minute
+)
+
+type exportedStanding struct {
+	SeasonID string  `json:"seasonId"`
+	Rank     int64   `json:"rank"`
+	UserID   string  `json:"userId"`
+	Score    float64 `json:"score"`
+}
+
+// streamBoard calls fn with each page of the board in rank order; rank is the
+// 1-based rank of the page's first item. found is false when the board doesn't
+// exist in Redis.
+func streamBoard(ctx context.Context, rdb redis.UniversalClient, seasonID string, fn func(rank int64, items []leaderboardItem) error) (found bool, err error) {
+	key := boardKey(seasonID)
+	tmp := fmt.Sprintf("lbtmp:export:%s:%d", seasonTag(seasonID), time.Now().UnixNano())
+
+	pipe := rdb.TxPipeline()
+	copied := pipe.Copy(ctx, key, tmp, 0, false)
+	pipe.Expire(ctx, tmp, boardExportTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if copied.Val() == 0 {
+		return false, nil
+	}
+	defer rdb.Del(context.Background(), tmp)
+
+	items := make([]leaderboardItem, 0, boardExportPage)
+	for start := int64(0); ; start += boardExportPage {
+		zs, err := rdb.ZRevRangeWithScores(ctx, tmp, start, start+boardExportPage-1).Result()
+		if err != nil {
+			return true, err
+		}
+		if len(zs) == 0 {
+			return true, nil
+		}
+		items = items[:0]
+		for _, z := range zs {
+			uid, ok := z.Member.(string)
+			if !ok {
+				uid = fmt.Sprint(z.Member)
+			}
+			items = append(items, leaderboardItem{UserID: uid, Score: leaderboard.Points(z.Score)})
+		}
+		if err := fn(start+1, items); err != nil {
+			return true, err
+		}
+		if len(zs) < boardExportPage {
+			return true, nil
+		}
+	}
+}
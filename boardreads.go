@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/redis/go-redis/v9"
+)
+
+// Per-member board reads (rank and score of a user in a season) go through one
+// pipeline however many members and seasons they cover, so an endpoint
+// reading many users (ranks:export, the WebSocket rank subscriptions) or the
+// same user across seasons stays one round trip. Queue the reads alongside
+// other commands with queueMemberReads, or use readMembers on a bare
+// connection; either way run it inside reads.do to read from a replica.
+
+// boardMember is one user on one season's board.
+type boardMember struct {
+	seasonID, userID string
+}
+
+// memberRead is a member's 1-based rank and score; found is false for a
+// member not on the board.
+type memberRead struct {
+	rank  int64
+	score float64
+	found bool
+}
+
+type memberReads struct {
+	ranks  []*redis.IntCmd
+	scores []*redis.FloatCmd
+}
+
+func queueMemberReads(ctx context.Context, pipe redis.Pipeliner, members []boardMember) memberReads {
+	q := memberReads{
+		ranks:  make([]*redis.IntCmd, len(members)),
+		scores: make([]*redis.FloatCmd, len(members)),
+	}
+	for i, m := range members {
+		key := boardKey(m.seasonID)
+		q.ranks[i] = pipe.ZRevRank(ctx, key, m.userID)
+		q.scores[i] = pipe.ZScore(ctx, key, m.userID)
+	}
+	return q
+}
+
+// results reads the executed pipeline's replies, in members order.
+func (q memberReads) results() []memberRead {
+	out := make([]memberRead, len(q.ranks))
+	for i := range q.ranks {
+		rank, rerr := q.ranks[i].Result()
+		score, serr := q.scores[i].Result()
+		if rerr == nil && serr == nil {
+			out[i] = memberRead{rank: rank + 1, score: leaderboard.Points(score), found: true}
+		}
+	}
+	return out
+}
+
+// readMembers looks members up in one round trip on c. A missing member is
+// not an error.
+func readMembers(ctx context.Context, c redis.Cmdable, members []boardMember) ([]memberRead, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	pipe := c.Pipeline()
+	q := queueMemberReads(ctx, pipe, members)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return q.results(), nil
+}
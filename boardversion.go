@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Each board has a version counter, lbctl:version:{sid}, bumped after every
+// change to the ZSET (applies, trims, rebuilds, holds, restores), and the time
+// of that change in lbctl:modified:{sid}. Top and rank reads serve them as
+// ETag and Last-Modified so polling clients and CDNs revalidate with a 304
+// without the board being read. The keys are never deleted with the board:
+// starting over at 1 could match a tag a client still holds for the old
+// contents. Writers bump after the change and readers read the version before
+// the data, so a tag can only be older than what it was served with, never
+// newer.
+
+func boardVersionKey(seasonID string) string  { return "lbctl:version:" + seasonTag(seasonID) }
+func boardModifiedKey(seasonID string) string { return "lbctl:modified:" + seasonTag(seasonID) }
+
+// queueBoardBump adds the bump to a pipeline, after the commands that change the board.
+func queueBoardBump(ctx context.Context, pipe redis.Pipeliner, seasonID string, at time.Time) {
+	pipe.Incr(ctx, boardVersionKey(seasonID))
+	pipe.Set(ctx, boardModifiedKey(seasonID), at.Unix(), 0)
+}
+
+// bumpBoardVersion is called after a board change outside the worker pipeline.
+// A failed bump only means clients may keep a stale response until the next one.
+func bumpBoardVersion(ctx context.Context, rdb redis.UniversalClient, seasonID string) {
+	pipe := rdb.Pipeline()
+	queueBoardBump(ctx, pipe, seasonID, time.Now())
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Board version bump error", "err", err)
+	}
+}
+
+// boardStamp is a board's version and last change as read with the data.
+// The zero value (keys not written yet) gets no validators.
+type boardStamp struct {
+	version  int64
+	modified time.Time
+}
+
+// queueBoardStamp reads both keys in one command; put it before the data reads.
+func queueBoardStamp(ctx context.Context, c redis.Cmdable, seasonID string) *redis.SliceCmd {
+	return c.MGet(ctx, boardVersionKey(seasonID), boardModifiedKey(seasonID))
+}
+
+func parseBoardStamp(cmd *redis.SliceCmd) boardStamp {
+	var st boardStamp
+	vals := cmd.Val()
+	if len(vals) != 2 {
+		return st
+	}
+	if s, ok := vals[0].(string); ok {
+		st.version, _ = strconv.ParseInt(s, 10, 64)
+	}
+	if s, ok := vals[1].(string); ok {
+		if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+			st.modified = time.Unix(sec, 0).UTC()
+		}
+	}
+	return st
+}
+
+func readBoardStamp(ctx context.Context, c redis.Cmdable, seasonID string) (boardStamp, error) {
+	cmd := queueBoardStamp(ctx, c, seasonID)
+	if err := cmd.Err(); err != nil {
+		return boardStamp{}, err
+	}
+	return parseBoardStamp(cmd), nil
+}
+
+// etag is the tag for a read at this version. The same URL can be served in
+// several encodings (Vary: Accept), so the format is part of it.
+func (st boardStamp) etag(format string) string {
+	if st.version <= 0 {
+		return ""
+	}
+	_, sub, _ := strings.Cut(format, "/")
+	return fmt.Sprintf(`"%d-%s"`, st.version, strings.TrimPrefix(sub, "x-"))
+}
+
+// setValidators sets the cache headers for a fresh read of the board.
+func setValidators(w http.ResponseWriter, st boardStamp, format, cacheControl string) {
+	w.Header().Set("Cache-Control", cacheControl)
+	if etag := st.etag(format); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !st.modified.IsZero() {
+		w.Header().Set("Last-Modified", st.modified.Format(http.TimeFormat))
+	}
+}
+
+// notModified answers 304 when the request's validators still match: the
+// If-None-Match tags (weak comparison, as RFC 9110 asks for GET) or, only
+// without If-None-Match, If-Modified-Since.
+func notModified(w http.ResponseWriter, r *http.Request, st boardStamp, format, cacheControl string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := st.etag(format)
+		if etag == "" {
+			return false
+		}
+		match := false
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == etag {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !st.modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil || st.modified.After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+	w.Header().Add("Vary", "Accept")
+	setValidators(w, st, format, cacheControl)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// cachePolicy is the Cache-Control of one read endpoint, from
+// CACHE_{NAME}_MAX_AGE and CACHE_{NAME}_STALE_WHILE_REVALIDATE. With no
+// max-age, responses are no-cache: shared caches may keep them but must
+// revalidate, rather than guess a lifetime from Last-Modified.
+type cachePolicy struct {
+	maxAge               time.Duration
+	staleWhileRevalidate time.Duration
+}
+
+func envCachePolicy(name string) cachePolicy {
+	p := cachePolicy{
+		maxAge:               envDuration("CACHE_"+name+"_MAX_AGE", 0),
+		staleWhileRevalidate: envDuration("CACHE_"+name+"_STALE_WHILE_REVALIDATE", 0),
+	}
+	if p.maxAge < 0 || p.staleWhileRevalidate < 0 {
+		panic(fmt.Sprintf("invalid CACHE_%s_* duration", name))
+	}
+	return p
+}
+
+func (p cachePolicy) header() string {
+	if p.maxAge <= 0 {
+		return "no-cache"
+	}
+	h := fmt.Sprintf("public, max-age=%d", int64(p.maxAge/time.Second))
+	if p.staleWhileRevalidate > 0 {
+		h += fmt.Sprintf(", stale-while-revalidate=%d", int64(p.staleWhileRevalidate/time.Second))
+	}
+	return h
+}
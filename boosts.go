@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/outbox"
+	"github.com/lib/pq"
+)
+
+// Boost windows multiply positive score deltas for a season while active.
+// The worker decides at apply time and rewrites the ledger row (delta becomes
+// the boosted value, raw_delta keeps the submitted one), so SUM(delta) stays
+// equal to what was applied to Redis.
+
+type boost struct {
+	ID         int64     `json:"boostId"`
+	SeasonID   string    `json:"seasonId"`
+	Multiplier float64   `json:"multiplier"`
+	StartsAt   time.Time `json:"startsAt"`
+	EndsAt     time.Time `json:"endsAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type activeBoost struct {
+	id         int64
+	multiplier float64
+}
+
+func createBoost(ctx context.Context, db *sql.DB, b *boost) error {
+	return db.QueryRowContext(ctx, `
+	INSERT INTO boosts (season_id, multiplier, starts_at, ends_at)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+`, b.SeasonID, b.Multiplier, b.StartsAt, b.EndsAt).Scan(&b.ID, &b.CreatedAt)
+}
+
+// listBoosts returns boosts that haven't ended yet, soonest first.
+func listBoosts(ctx context.Context, db *sql.DB, seasonID string) ([]boost, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT id, season_id, multiplier, starts_at, ends_at, created_at
+	FROM boosts
+	WHERE season_id=$1 AND ends_at > now()
+	ORDER BY starts_at
+`, seasonID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []boost{}
+	for rows.Next() {
+		var b boost
+		if err := rows.Scan(&b.ID, &b.SeasonID, &b.Multiplier, &b.StartsAt, &b.EndsAt, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+func deleteBoost(ctx context.Context, db *sql.DB, seasonID string, boostID int64) (bool, error) {
+	res, err := db.ExecContext(ctx,
+		`DELETE FROM boosts WHERE id=$1 AND season_id=$2`, boostID, seasonID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// activeBoosts returns the strongest active boost per season. Overlapping
+// windows don't stack.
+func activeBoosts(ctx context.Context, tx *sql.Tx, seasons []string) (map[string]activeBoost, error) {
+	out := make(map[string]activeBoost)
+	if len(seasons) == 0 {
+		return out, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT DISTINCT ON (season_id) season_id, id, multiplier
+	FROM boosts
+	WHERE season_id = ANY($1) AND starts_at <= now() AND ends_at > now()
+	ORDER BY season_id, multiplier DESC
+`, pq.Array(seasons))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid string
+		var b activeBoost
+		if err := rows.Scan(&sid, &b.id, &b.multiplier); err != nil {
+			return nil, err
+		}
+		out[sid] = b
+	}
+	return out, rows.Err()
+}
+
+// boostedDelta applies a multiplier to positive deltas only; penalties are never amplified.
+func boostedDelta(delta int64, multiplier float64) int64 {
+	if delta <= 0 {
+		return delta
+	}
+	return int64(math.Round(float64(delta) * multiplier))
+}
+
+type boostedEvent struct {
+	eventID int64
+	raw     int64
+	boosted int64
+	boostID int64
+}
+
+// recordBoostedEvents queues the ledger row rewrites for boosted deltas that were applied.
+func recordBoostedEvents(b *outbox.Batch, evs []boostedEvent) {
+	if len(evs) == 0 {
+		return
+	}
+
+	ids := make([]int64, len(evs))
+	raws := make([]int64, len(evs))
+	boosted := make([]int64, len(evs))
+	boostIDs := make([]int64, len(evs))
+	for i, e := range evs {
+		ids[i], raws[i], boosted[i], boostIDs[i] = e.eventID, e.raw, e.boosted, e.boostID
+	}
+
+	b.Queue("boosted events update", `
+	UPDATE score_events s
+	SET raw_delta=v.raw, delta=v.boosted, boost_id=v.boost_id
+	FROM unnest($1::bigint[], $2::bigint[], $3::bigint[], $4::bigint[]) AS v(id, raw, boosted, boost_id)
+	WHERE s.id=v.id
+`, ids, raws, boosted, boostIDs)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis circuit breaker: after REDIS_BREAKER_FAILURES consecutive failed
+// calls (connection errors and timeouts; replies such as redis.Nil or
+// WRONGTYPE don't count) every Redis call fails at once with
+// errRedisUnavailable instead of waiting out its timeout. After
+// REDIS_BREAKER_COOLDOWN one call goes through as a probe: success closes the
+// breaker, failure keeps it open for another cooldown.
+//
+// While it is open, top and rank fall back to the ledger as on any Redis
+// error, other reads answer 503 redis_unavailable with Retry-After, score
+// submission skips its Redis checks (they fail open), and the outbox worker
+// stops claiming batches. REDIS_BREAKER_FAILURES=0 turns it off.
+
+var errRedisUnavailable = errors.New("redis unavailable (circuit open)")
+
+type redisBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	probing  bool
+}
+
+// newRedisBreaker reads REDIS_BREAKER_*; nil when turned off.
+func newRedisBreaker() *redisBreaker {
+	threshold := envInt64("REDIS_BREAKER_FAILURES", 5)
+	cooldown := envDuration("REDIS_BREAKER_COOLDOWN", 5*time.Second)
+	if threshold < 0 || cooldown <= 0 {
+		panic("invalid REDIS_BREAKER_FAILURES/REDIS_BREAKER_COOLDOWN")
+	}
+	if threshold == 0 {
+		return nil
+	}
+	return &redisBreaker{threshold: int(threshold), cooldown: cooldown}
+}
+
+// available reports whether a call would be let through now.
+func (b *redisBreaker) available() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openedAt.IsZero() || !b.probing && time.Since(b.openedAt) >= b.cooldown
+}
+
+// allow reports whether a call may go ahead; past the cooldown it lets one
+// probe through.
+func (b *redisBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *redisBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var reply redis.Error
+	switch {
+	case err == nil || err == redis.Nil || errors.As(err, &reply):
+		if !b.openedAt.IsZero() {
+			slog.Info("Redis circuit closed")
+		}
+		b.failures, b.openedAt, b.probing = 0, time.Time{}, false
+	case errors.Is(err, context.Canceled):
+		// The caller gave up; that says nothing about Redis.
+		b.probing = false
+	default:
+		b.failures++
+		if b.probing {
+			b.openedAt, b.probing = time.Now(), false
+		} else if b.openedAt.IsZero() && b.failures >= b.threshold {
+			b.openedAt = time.Now()
+			slog.Warn("Redis circuit opened", "failures", b.failures, "cooldown", b.cooldown.String(), "err", err)
+		}
+	}
+}
+
+func (b *redisBreaker) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (b *redisBreaker) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !b.allow() {
+			metrics.redisBreakerRejected.inc()
+			cmd.SetErr(errRedisUnavailable)
+			return errRedisUnavailable
+		}
+		err := next(ctx, cmd)
+		b.record(err)
+		return err
+	}
+}
+
+func (b *redisBreaker) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !b.allow() {
+			metrics.redisBreakerRejected.inc()
+			for _, cmd := range cmds {
+				cmd.SetErr(errRedisUnavailable)
+			}
+			return errRedisUnavailable
+		}
+		err := next(ctx, cmds)
+		b.record(err)
+		return err
+	}
+}
+
+// writeRedisError answers a failed Redis read: 503 while the breaker is
+// open, 500 otherwise.
+func writeRedisError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRedisUnavailable) {
+		w.Header().Set("Retry-After", "2")
+		writeProblem(w, http.StatusServiceUnavailable, "redis_unavailable", "redis is unavailable")
+		return
+	}
+	writeProblem(w, http.StatusInternalServerError, "redis_error", "redis error")
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The binary is a set of subcommands sharing the global flags, the config
+// file and the environment settings:
+//
+//	serve      HTTP API and worker (the default; -mode or RUN_MODE splits them)
+//	worker     outbox worker and background jobs only, same as serve -mode=worker
+//	migrate    up | down [n] | status
+//	rebuild    repopulate boards from the ledger
+//	reconcile  compare boards with the ledger, optionally healing
+//	seed       write generated submissions (staging and demo data)
+//	bench      load-test a running instance
+//
+// Global flags go before the command, its own flags after it.
+
+const cliUsage = `usage: leaderboard [flags] [command] [args]
+
+commands:
+  serve                               HTTP API and worker (default; see -mode)
+  worker                              outbox worker and background jobs only
+  migrate up | down [n] | status      schema migrations
+  rebuild [-all] [SEASON...]          repopulate boards from the ledger
+  reconcile [-full] [-heal] [SEASON...]
+                                      compare boards with the ledger (all boards when no season is given)
+  seed [-users n] [-events n] SEASON...
+                                      write generated submissions for the worker to apply
+  bench [-target url] [-write-rps n] [-read-rps n] [-duration d]
+                                      load-test a running instance
+
+flags:
+`
+
+func main() {
+	var stub stubOptions
+	flag.BoolVar(&stub.enabled, "stub", false, "serve deterministic fake leaderboards without Redis/Postgres")
+	flag.IntVar(&stub.size, "stub-size", 1000, "members per stub leaderboard")
+	flag.Int64Var(&stub.seed, "stub-seed", 1, "seed for stub leaderboard data")
+	mode := flag.String("mode", "", "api (HTTP only), worker (outbox and background jobs only) or all (default RUN_MODE, or all)")
+	configFile := flag.String("config", "", "YAML config file (default CONFIG_FILE); see config.go")
+	flag.Func("set", "override a setting, NAME=value (repeatable)", config.setFlag)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), cliUsage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := config.loadFile(*configFile); err != nil {
+		panic(fmt.Sprintf("invalid config file: %v", err))
+	}
+	setupLogging()
+	if _, err := loadMigrations(); err != nil {
+		panic(err)
+	}
+
+	cmd, args := "serve", flag.Args()
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve", "worker":
+		if len(args) > 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if cmd == "worker" {
+			if *mode != "" && *mode != "worker" {
+				panic("worker does not take -mode")
+			}
+			*mode = "worker"
+		}
+		if *mode == "" {
+			if *mode = getenv("RUN_MODE"); *mode == "" {
+				*mode = "all"
+			}
+		}
+		serve(*mode, stub)
+	case "migrate":
+		os.Exit(runMigrateCommand(args))
+	case "rebuild":
+		os.Exit(runRebuildCommand(args))
+	case "reconcile":
+		os.Exit(runReconcileCommand(args))
+	case "seed":
+		os.Exit(runSeedCommand(args))
+	case "bench":
+		os.Exit(runBenchCommand(args))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// commandContext is canceled on SIGINT/SIGTERM, so a long command stops
+// between seasons.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// runRebuildCommand implements `rebuild [-all] [SEASON...]` and returns the
+// process exit code.
+func runRebuildCommand(args []string) int {
+	fs := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	all := fs.Bool("all", false, "rebuild every season with ledger rows")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *all == (fs.NArg() > 0) {
+		fmt.Fprintln(os.Stderr, "usage: rebuild -all | SEASON...")
+		return 2
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	rdb, _ := newRedisClient()
+	defer rdb.Close()
+	db := newPostgresDB()
+	defer db.Close()
+	defaultMaxSize := envInt64("LEADERBOARD_MAX_SIZE", 0)
+
+	seasons := fs.Args()
+	if *all {
+		var err error
+		if seasons, err = boardSeasons(ctx, db); err != nil {
+			fmt.Fprintln(os.Stderr, "rebuild:", err)
+			return 1
+		}
+	}
+	for _, sid := range seasons {
+		start := time.Now()
+		n, err := rebuildLeaderboard(ctx, db, rdb, sid, defaultMaxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rebuild %s: %v\n", sid, err)
+			return 1
+		}
+		fmt.Printf("%s\t%d members\t%s\n", sid, n, time.Since(start).Round(time.Millisecond))
+	}
+	return 0
+}
+
+// runReconcileCommand implements `reconcile [-full] [-heal] [SEASON...]`,
+// printing each season's run as a line of JSON, and returns the process exit
+// code. Without seasons it checks every board.
+func runReconcileCommand(args []string) int {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	full := fs.Bool("full", false, "compare every member instead of a sample")
+	sample := fs.Int64("sample", envInt64("RECONCILE_SAMPLE_SIZE", 1000), "members sampled per season")
+	heal := fs.Bool("heal", envBool("RECONCILE_AUTO_HEAL", false), "queue corrections for drift found")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *sample < 0 {
+		fmt.Fprintln(os.Stderr, "reconcile: -sample must be >= 0")
+		return 2
+	}
+	sampleSize := int(*sample)
+	if *full {
+		sampleSize = 0
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	rdb, _ := newRedisClient()
+	defer rdb.Close()
+	db := newPostgresDB()
+	defer db.Close()
+	readDB := newPostgresReadDB(db)
+	if readDB != db {
+		defer readDB.Close()
+	}
+	defaultMaxSize := envInt64("LEADERBOARD_MAX_SIZE", 0)
+
+	seasons := fs.Args()
+	if len(seasons) == 0 {
+		var err error
+		if seasons, err = boardSeasons(ctx, db); err != nil {
+			fmt.Fprintln(os.Stderr, "reconcile:", err)
+			return 1
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, sid := range seasons {
+		run, err := reconcileSeason(ctx, db, readDB, rdb, sid, sampleSize, *heal, defaultMaxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "reconcile %s: %v\n", sid, err)
+			return 1
+		}
+		enc.Encode(run)
+	}
+	return 0
+}
+
+// runSeedCommand implements `seed [-users n] [-events n] [-span d] [-seed n]
+// [-append] SEASON...` (see seed.go) and returns the process exit code.
+func runSeedCommand(args []string) int {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	var opts seedOptions
+	fs.IntVar(&opts.users, "users", 1000, "users to generate")
+	fs.Float64Var(&opts.events, "events", 10, "mean submissions per user per season")
+	fs.DurationVar(&opts.span, "span", 30*24*time.Hour, "events are spread over this long before now")
+	fs.Int64Var(&opts.seed, "seed", 1, "seed for the generated data")
+	fs.BoolVar(&opts.allowExist, "append", false, "also seed seasons that already have events")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || opts.users < 1 || opts.events <= 0 || opts.span <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: seed [-users n] [-events n] [-span d] [-seed n] [-append] SEASON...")
+		return 2
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	db := newPostgresDB()
+	defer db.Close()
+
+	users := seedUsers(opts.users, opts.seed)
+	for _, sid := range fs.Args() {
+		start := time.Now()
+		res, err := seedSeason(ctx, db, sid, users, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "seed %s: %v\n", sid, err)
+			return 1
+		}
+		fmt.Printf("%s\t%d events\t%d users\t%s\n", sid, res.Events, res.Users, time.Since(start).Round(time.Millisecond))
+	}
+	return 0
+}
+
+// runBenchCommand implements `bench [flags]` (see bench.go) and returns the
+// process exit code.
+func runBenchCommand(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var opts benchOptions
+	fs.StringVar(&opts.target, "target", "http://localhost:8080", "base URL of the instance under test")
+	fs.StringVar(&opts.apiKey, "key", getenv("BENCH_API_KEY"), "API key sent as X-API-Key (default BENCH_API_KEY); admin scope adds server outbox stats")
+	fs.StringVar(&opts.seasonID, "season", "bench", "season to submit to and read from")
+	fs.IntVar(&opts.users, "users", 10000, "distinct users submitted for and read")
+	fs.Float64Var(&opts.writeRPS, "write-rps", 100, "score submissions per second")
+	fs.Float64Var(&opts.readRPS, "read-rps", 300, "reads per second, split evenly between top, rank and around")
+	fs.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to run")
+	fs.IntVar(&opts.conns, "conns", 256, "most requests in flight")
+	fs.DurationVar(&opts.probeInterval, "probe-interval", time.Second, "how often to measure apply lag")
+	fs.DurationVar(&opts.timeout, "timeout", 5*time.Second, "per-request timeout")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || opts.users < 1 || opts.writeRPS < 0 || opts.readRPS < 0 || opts.duration <= 0 ||
+		opts.conns < 1 || opts.probeInterval <= 0 || opts.timeout <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	rep, err := runBench(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	} else {
+		rep.print(os.Stdout)
+	}
+	return 0
+}
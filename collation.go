@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// A season's collation orders each run of equal scores by userId in its
+// locale, everywhere a board is read in order: top pages (collatePage), ranks
+// (collatedRank) and around pages. A run only partly on a page is read whole,
+// so the page gets the members the collated order puts there. Runs longer
+// than collationMaxRun keep Redis order, in pages and ranks alike.
+const collationMaxRun = 10000
+
+// seasonCollations caches each season's tie-ordering locale so the top endpoint
+// doesn't hit Postgres on every read. Entries expire after ttl; a season with no
+// collation configured keeps Redis' byte-wise member order.
+type seasonCollations struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]collationEntry
+}
+
+type collationEntry struct {
+	tag     language.Tag
+	ok      bool
+	expires time.Time
+}
+
+func newSeasonCollations(ttl time.Duration) *seasonCollations {
+	return &seasonCollations{ttl: ttl, entries: make(map[string]collationEntry)}
+}
+
+// lookup returns the season's collation locale, or ok=false when none is set.
+func (sc *seasonCollations) lookup(ctx context.Context, db *sql.DB, seasonID string) (language.Tag, bool, error) {
+	now := time.Now()
+
+	sc.mu.Lock()
+	e, hit := sc.entries[seasonID]
+	sc.mu.Unlock()
+	if hit && now.Before(e.expires) {
+		return e.tag, e.ok, nil
+	}
+
+	var locale sql.NullString
+	err := db.QueryRowContext(ctx,
+		`SELECT collation FROM seasons WHERE season_id=$1`, seasonID).Scan(&locale)
+	if err != nil && err != sql.ErrNoRows {
+		return language.Und, false, err
+	}
+
+	e = collationEntry{expires: now.Add(sc.ttl)}
+	if locale.Valid {
+		if tag, err := language.Parse(locale.String); err == nil {
+			e.tag, e.ok = tag, true
+		}
+	}
+
+	sc.mu.Lock()
+	sc.entries[seasonID] = e
+	sc.mu.Unlock()
+	return e.tag, e.ok, nil
+}
+
+func (sc *seasonCollations) invalidate(seasonID string) {
+	sc.mu.Lock()
+	delete(sc.entries, seasonID)
+	sc.mu.Unlock()
+}
+
+// sortTiesByLocale reorders runs of equal scores by userId using the locale's
+// collation. Items must already be in descending score order; only ties move.
+func sortTiesByLocale(items []leaderboardItem, tag language.Tag) {
+	c := collate.New(tag)
+	for i := 0; i < len(items); {
+		j := i + 1
+		for j < len(items) && items[j].Score == items[i].Score {
+			j++
+		}
+		if j-i > 1 {
+			run := items[i:j]
+			sort.SliceStable(run, func(a, b int) bool {
+				return c.CompareString(run[a].UserID, run[b].UserID) < 0
+			})
+		}
+		i = j
+	}
+}
+
+// sortRunByLocale orders one tie run, given in Redis order, by collation;
+// members the locale compares equal keep Redis order.
+func sortRunByLocale(c *collate.Collator, run []redis.Z) {
+	sort.SliceStable(run, func(a, b int) bool {
+		return c.CompareString(member(run[a]), member(run[b])) < 0
+	})
+}
+
+func member(z redis.Z) string {
+	s, _ := z.Member.(string)
+	return s
+}
+
+func scoreArg(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// collatePage puts a page of board key, read in Redis order from 0-based rank
+// offset, into collated order in place.
+func collatePage(ctx context.Context, rc redis.Cmdable, key string, zs []redis.Z, offset int64, tag language.Tag) error {
+	if len(zs) == 0 {
+		return nil
+	}
+	c := collate.New(tag)
+	end := offset + int64(len(zs))
+
+	// The first and last runs may go on past the page; count them.
+	type edge struct {
+		score        float64
+		above, count *redis.IntCmd
+	}
+	edges := []*edge{{score: zs[0].Score}}
+	if last := zs[len(zs)-1].Score; last != zs[0].Score {
+		edges = append(edges, &edge{score: last})
+	}
+	pipe := rc.Pipeline()
+	for _, e := range edges {
+		s := scoreArg(e.score)
+		e.above = pipe.ZCount(ctx, key, "("+s, "+inf")
+		e.count = pipe.ZCount(ctx, key, s, s)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(zs); {
+		j := i + 1
+		for j < len(zs) && zs[j].Score == zs[i].Score {
+			j++
+		}
+		run := zs[i:j]
+		i = j
+
+		var e *edge
+		for _, ed := range edges {
+			if ed.score == run[0].Score {
+				e = ed
+			}
+		}
+		if e == nil {
+			sortRunByLocale(c, run) // inside the page, so whole
+			continue
+		}
+		first, n := e.above.Val(), e.count.Val()
+		if n > collationMaxRun {
+			continue
+		}
+		if first >= offset && first+n <= end {
+			sortRunByLocale(c, run)
+			continue
+		}
+		s := scoreArg(e.score)
+		whole, err := rc.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: s, Max: s}).Result()
+		if err != nil {
+			return err
+		}
+		sortRunByLocale(c, whole)
+		// the page's share of the run, by rank
+		from := max(first, offset) - first
+		if from+int64(len(run)) > int64(len(whole)) {
+			// the run changed between the reads; the next read catches up
+			continue
+		}
+		copy(run, whole[from:from+int64(len(run))])
+	}
+	return nil
+}
+
+// collatedRank returns a member's 0-based rank in collated order, from its
+// 0-based Redis rank and score.
+func collatedRank(ctx context.Context, rc redis.Cmdable, key, userID string, rank int64, score float64, tag language.Tag) (int64, error) {
+	s := scoreArg(score)
+	pipe := rc.Pipeline()
+	above := pipe.ZCount(ctx, key, "("+s, "+inf")
+	count := pipe.ZCount(ctx, key, s, s)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	if n := count.Val(); n <= 1 || n > collationMaxRun {
+		return rank, nil
+	}
+	run, err := rc.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: s, Max: s}).Result()
+	if err != nil {
+		return 0, err
+	}
+	sortRunByLocale(collate.New(tag), run)
+	i := slices.IndexFunc(run, func(z redis.Z) bool { return member(z) == userID })
+	if i < 0 {
+		return rank, nil // moved off the score between the reads
+	}
+	return above.Val() + int64(i), nil
+}
+
+// collatedAround is leaderboard.ReadAround in collated order: up to n entries
+// either side of the member's collated rank; redis.Nil for a member not on
+// the board.
+func collatedAround(ctx context.Context, rc redis.Cmdable, key, userID string, n int64, tag language.Tag) ([]leaderboard.Entry, error) {
+	pipe := rc.Pipeline()
+	rankCmd := pipe.ZRevRank(ctx, key, userID)
+	scoreCmd := pipe.ZScore(ctx, key, userID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err // redis.Nil when the member is missing
+	}
+	rank, err := collatedRank(ctx, rc, key, userID, rankCmd.Val(), scoreCmd.Val(), tag)
+	if err != nil {
+		return nil, err
+	}
+	start := max(rank-n, 0)
+	zs, err := rc.ZRevRangeWithScores(ctx, key, start, rank+n).Result()
+	if err != nil {
+		return nil, err
+	}
+	if err := collatePage(ctx, rc, key, zs, start, tag); err != nil {
+		return nil, err
+	}
+	out := make([]leaderboard.Entry, len(zs))
+	for i, z := range zs {
+		out[i] = leaderboard.Entry{UserID: member(z), Score: leaderboard.Points(z.Score), Rank: start + int64(i) + 1}
+	}
+	return out, nil
+}
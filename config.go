@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settings come from, highest first: -set NAME=value flags, the environment,
+// and the YAML file named by -config (or CONFIG_FILE); an empty value counts
+// as unset. Every setting keeps its environment name. In the file, nested
+// keys are joined with "_" and upper-cased, so
+//
+//	http:
+//	  addr: ":8443"
+//	outbox:
+//	  batch_size: 500
+//	  poll_interval: 200ms
+//	tenants: [acme, beta]
+//
+// sets HTTP_ADDR, OUTBOX_BATCH_SIZE, OUTBOX_POLL_INTERVAL and TENANTS (lists
+// become comma-separated). Values are checked where they are read, as they
+// always were for the environment, and startup stops at an invalid one. Once
+// the process is set up it logs the effective config, secrets redacted, and
+// warns about file keys that nothing read.
+
+const (
+	fromDefault = "default"
+	fromFlag    = "flag"
+	fromEnv     = "env"
+	fromFile    = "file"
+)
+
+type settingRead struct {
+	value  string
+	source string
+}
+
+type configStore struct {
+	mu    sync.Mutex
+	path  string
+	flags map[string]string
+	file  map[string]string
+	reads map[string]settingRead
+}
+
+var config = &configStore{
+	flags: make(map[string]string),
+	file:  make(map[string]string),
+	reads: make(map[string]settingRead),
+}
+
+// setFlag handles one -set NAME=value.
+func (c *configStore) setFlag(s string) error {
+	name, v, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return errors.New("expected NAME=value")
+	}
+	c.flags[name] = v
+	return nil
+}
+
+// loadFile reads the YAML config file; path "" falls back to CONFIG_FILE.
+func (c *configStore) loadFile(path string) error {
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	file := make(map[string]string)
+	if len(doc.Content) > 0 {
+		if doc.Content[0].Kind != yaml.MappingNode {
+			return fmt.Errorf("%s: expected a mapping at the top level", path)
+		}
+		if err := flattenConfig("", doc.Content[0], file); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	c.mu.Lock()
+	c.path, c.file = path, file
+	c.mu.Unlock()
+	return nil
+}
+
+func flattenConfig(name string, n *yaml.Node, out map[string]string) error {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return flattenConfig(name, n.Alias, out)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := strings.ToUpper(strings.ReplaceAll(n.Content[i].Value, "-", "_"))
+			if name != "" {
+				key = name + "_" + key
+			}
+			if err := flattenConfig(key, n.Content[i+1], out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, dup := out[name]; dup {
+		return fmt.Errorf("%s is set twice", name)
+	}
+	switch n.Kind {
+	case yaml.SequenceNode:
+		parts := make([]string, len(n.Content))
+		for i, e := range n.Content {
+			if e.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s: lists may only hold plain values", name)
+			}
+			parts[i] = e.Value
+		}
+		out[name] = strings.Join(parts, ",")
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			out[name] = ""
+		} else {
+			out[name] = n.Value
+		}
+	}
+	return nil
+}
+
+// lookup returns a setting's value and whether it is set; def is only
+// recorded, for the effective config.
+func (c *configStore) lookup(name, def string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := settingRead{value: def, source: fromDefault}
+	if v := c.flags[name]; v != "" {
+		r = settingRead{v, fromFlag}
+	} else if v := os.Getenv(name); v != "" {
+		r = settingRead{v, fromEnv}
+	} else if v := c.file[name]; v != "" {
+		r = settingRead{v, fromFile}
+	}
+	c.reads[name] = r
+	if r.source == fromDefault {
+		return "", false
+	}
+	return r.value, true
+}
+
+// getenv is os.Getenv for settings: flags and the config file count too.
+func getenv(name string) string {
+	v, _ := config.lookup(name, "")
+	return v
+}
+
+func secretSetting(name string) bool {
+	for _, s := range []string{"TOKEN", "PASSWORD", "SECRET", "BOOTSTRAP", "HEADERS"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// logEffective logs every setting read so far and where its value came from.
+func (c *configStore) logEffective() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.reads))
+	for name := range c.reads {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		r := c.reads[name]
+		v := r.value
+		if u, err := url.Parse(v); err == nil && u.User != nil {
+			v = u.Redacted()
+		}
+		if secretSetting(name) && r.source != fromDefault {
+			v = "xxxxx"
+		}
+		attrs = append(attrs, slog.String(name, v+" ("+r.source+")"))
+	}
+	slog.Info("Effective config", "file", c.path, slog.Group("settings", attrs...))
+
+	for name := range c.file {
+		if _, ok := c.reads[name]; !ok {
+			slog.Warn("Config file setting not used (unknown, or not read in this mode)", "name", name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Auto-heal: reconciliation can queue a score_correction outbox event for a
+// drifted user. The correction is a delta (ledger - board, both read under the
+// rebuild lock) rather than an absolute ZADD, so it stays correct no matter how
+// it interleaves with that user's pending score_delta events. Every correction
+// is kept in score_corrections for audit.
+
+type scoreCorrection struct {
+	ID          int64     `json:"correctionId"`
+	SeasonID    string    `json:"seasonId"`
+	UserID      string    `json:"userId"`
+	RedisScore  float64   `json:"redisScore"` // 0 when the user was missing from the board
+	LedgerScore int64     `json:"ledgerScore"`
+	Delta       int64     `json:"delta"`
+	OutboxID    int64     `json:"outboxId"`
+	Status      string    `json:"status"` // outbox status: pending/done/failed
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func newScoreCorrection(seasonID, userID string, redisScore float64, ledgerScore int64) scoreCorrection {
+	return scoreCorrection{
+		SeasonID:    seasonID,
+		UserID:      userID,
+		RedisScore:  redisScore,
+		LedgerScore: ledgerScore,
+		Delta:       ledgerScore - int64(math.Round(redisScore)),
+	}
+}
+
+// queueCorrections writes the outbox events and audit rows in the caller's tx
+// and returns how many were queued.
+func queueCorrections(ctx context.Context, tx *sql.Tx, fixes []scoreCorrection) (int64, error) {
+	var n int64
+	for _, f := range fixes {
+		if f.Delta == 0 {
+			continue
+		}
+		if err := tx.QueryRowContext(ctx, `
+	INSERT INTO score_corrections (season_id, user_id, redis_score, ledger_score, delta)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id
+`, f.SeasonID, f.UserID, f.RedisScore, f.LedgerScore, f.Delta).Scan(&f.ID); err != nil {
+			return n, err
+		}
+
+		payload, _ := json.Marshal(map[string]any{
+			"seasonId":     f.SeasonID,
+			"userId":       f.UserID,
+			"delta":        f.Delta,
+			"correctionId": f.ID,
+		})
+		if _, err := tx.ExecContext(ctx, `
+	WITH ob AS (
+	  INSERT INTO outbox (event_type, payload, status, request_id)
+	  VALUES ('score_correction', $1, 'pending', NULLIF($3,''))
+	  RETURNING id
+	)
+	UPDATE score_corrections SET outbox_id=(SELECT id FROM ob) WHERE id=$2
+`, payload, f.ID, requestID(ctx)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// markRebuild records that a season's board (userID "") or one user's score
+// was just rebuilt, so corrections queued before it are retired by the worker
+// (outbox.SupersededCorrectionsSQL) rather than applied on top of the rebuilt
+// scores.
+// Must run under the rebuild lock. It doesn't touch the corrections' outbox
+// rows: a worker may hold those FOR UPDATE while it waits for this lock.
+//
+// Corrections are only queued under the rebuild lock, so every correction
+// committed before it has an id at or below the outbox sequence's last value.
+func markRebuild(ctx context.Context, tx *sql.Tx, seasonID, userID string) error {
+	_, err := tx.ExecContext(ctx, `
+	INSERT INTO board_rebuilds (season_id, user_id, outbox_id)
+	VALUES ($1, $2, COALESCE(pg_sequence_last_value(pg_get_serial_sequence('outbox', 'id')::regclass), 0))
+	ON CONFLICT (season_id, user_id) DO UPDATE SET outbox_id=EXCLUDED.outbox_id, rebuilt_at=now()
+`, seasonID, userID)
+	return err
+}
+
+func listCorrections(ctx context.Context, db *sql.DB, seasonID string, limit int) ([]scoreCorrection, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT c.id, c.season_id, c.user_id, c.redis_score, c.ledger_score, c.delta,
+	  COALESCE(c.outbox_id, 0), COALESCE(o.status, 'done'), c.created_at
+	FROM score_corrections c
+	LEFT JOIN outbox o ON o.id=c.outbox_id
+	WHERE c.season_id=$1
+	ORDER BY c.id DESC
+	LIMIT $2
+`, seasonID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []scoreCorrection{}
+	for rows.Next() {
+		var f scoreCorrection
+		if err := rows.Scan(&f.ID, &f.SeasonID, &f.UserID, &f.RedisScore, &f.LedgerScore, &f.Delta,
+			&f.OutboxID, &f.Status, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
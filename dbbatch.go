@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// writeBatch collects writes (seed's per-season inserts) and sends them to
+// Postgres as a single pgx pipeline on the connection that holds the
+// transaction, so they land inside it in one round trip; outbox.Batch does
+// the same for the worker. Statements
+// run in the order they were queued; the first one to fail aborts the
+// transaction and is reported by send. Arguments are passed as native Go
+// values (slices for arrays), not pq.Array.
+type writeBatch struct {
+	b     pgx.Batch
+	names []string // per statement, for errors
+}
+
+func (wb *writeBatch) queue(name, query string, args ...any) {
+	wb.b.Queue(query, args...)
+	wb.names = append(wb.names, name)
+}
+
+func (wb *writeBatch) len() int { return wb.b.Len() }
+
+// send runs the queued statements on conn, which must be the one the
+// transaction was started on.
+func (wb *writeBatch) send(ctx context.Context, conn *sql.Conn) (err error) {
+	if wb.len() == 0 {
+		return nil
+	}
+	_, sp := startChildSpan(ctx, "sql batch", spanKindClient)
+	sp.setAttr("db.system", "postgresql")
+	sp.setAttr("db.statements", wb.len())
+	defer func() { sp.finish(err) }()
+
+	return conn.Raw(func(dc any) error {
+		br := pgxConn(dc).Conn().SendBatch(ctx, &wb.b)
+		for _, name := range wb.names {
+			if _, err := br.Exec(); err != nil {
+				br.Close()
+				return fmt.Errorf("db %s failed: %w", name, err)
+			}
+		}
+		return br.Close()
+	})
+}
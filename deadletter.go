@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dead letters are outbox rows parked as failed: out of retry attempts, or
+// unprocessable (bad payload, unknown event type). Requeueing puts them back
+// to pending with a fresh attempt budget once the cause is fixed. Failed
+// score_delta rows are left out of rebuilds and reconciliation, so requeueing
+// one applies it exactly once.
+//
+// Two kinds are never requeued: score_correction rows (the next reconciliation
+// recomputes drift, and replaying a stale delta would overshoot) and score
+// deltas of archived seasons (their board is gone for good).
+
+const maxRequeueIDs = 1000
+
+type deadOutboxItem struct {
+	ID          int64           `json:"id"`
+	EventType   string          `json:"eventType"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"lastError"`
+	RequestID   *string         `json:"requestId"` // API call that queued the row
+	CreatedAt   time.Time       `json:"createdAt"`
+	Requeueable bool            `json:"requeueable"`
+}
+
+// requeueableSQL is true for failed rows that may go back to pending (alias o).
+const requeueableSQL = `(
+	o.event_type <> 'score_correction'
+	AND NOT EXISTS (
+	  SELECT 1 FROM seasons s
+	  WHERE s.season_id = o.payload->>'seasonId' AND s.status='archived'
+	    AND o.event_type='score_delta'
+	)
+)`
+
+// listDeadOutbox pages through failed rows newest first; before=0 starts at the newest.
+func listDeadOutbox(ctx context.Context, db *sql.DB, eventType string, tenant sql.NullString, before int64, limit int) ([]deadOutboxItem, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT o.id, o.event_type, o.payload, o.attempts, COALESCE(o.last_error, ''), o.request_id, o.created_at, `+requeueableSQL+`
+	FROM outbox o
+	WHERE o.status='failed'
+	  AND ($1 = '' OR o.event_type=$1)
+	  AND ($2 = 0 OR o.id < $2)
+	  AND ($4::text IS NULL OR o.tenant_id=$4)
+	ORDER BY o.id DESC
+	LIMIT $3
+`, eventType, before, limit, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []deadOutboxItem{}
+	for rows.Next() {
+		var it deadOutboxItem
+		var payload []byte
+		if err := rows.Scan(&it.ID, &it.EventType, &payload, &it.Attempts, &it.LastError, &it.RequestID, &it.CreatedAt, &it.Requeueable); err != nil {
+			return nil, err
+		}
+		it.Payload = payload
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// requeueDeadOutbox moves the given failed rows (or, with ids empty, every
+// failed row of seasonID) back to pending and returns how many moved.
+func requeueDeadOutbox(ctx context.Context, db *sql.DB, ids []int64, seasonID string) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+	UPDATE outbox o
+	SET status='pending', attempts=0, next_attempt_at=NULL
+	WHERE o.status='failed'
+	  AND (COALESCE(cardinality($1::bigint[]), 0) = 0 OR o.id = ANY($1))
+	  AND ($2 = '' OR o.payload->>'seasonId'=$2)
+	  AND `+requeueableSQL+`
+`, pq.Array(ids), seasonID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// deadOutboxStatus reports whether row id exists as a dead letter and whether it can be requeued.
+func deadOutboxStatus(ctx context.Context, db *sql.DB, id int64) (found, requeueable bool, err error) {
+	err = db.QueryRowContext(ctx, `
+	SELECT `+requeueableSQL+` FROM outbox o WHERE o.id=$1 AND o.status='failed'
+`, id).Scan(&requeueable)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	return err == nil, requeueable, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// The pprof endpoints are served on their own listener, DEBUG_ADDR (off by
+// default), never on :8080: bind it to localhost or an internal interface and
+// reach it with a port-forward when the worker falls behind, e.g.
+//
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//	go tool pprof http://localhost:6060/debug/pprof/heap
+//
+// With DEBUG_TOKEN set, requests also need "Authorization: Bearer <token>";
+// with DEBUG_ALLOWED_CIDRS, they must come from those networks (ipallow.go).
+
+func newDebugMux(token string, allow ipAllowlist) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	var h http.Handler = mux
+	if token != "" {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeProblem(w, http.StatusUnauthorized, "unauthorized", "debug token required")
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+	if allow != nil {
+		h = peerAllowlist(allow, h)
+	}
+	return h
+}
+
+// serveDebug runs the pprof listener until ctx is cancelled. There is no
+// write timeout: CPU profiles and traces stream for as long as ?seconds= asks.
+func serveDebug(ctx context.Context, addr, token string, allow ipAllowlist) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newDebugMux(token, allow),
+		ReadHeaderTimeout: 3 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("Debug server is starting", "addr", addr, "token", token != "", "allowlist", allow != nil)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err := <-errCh:
+		return err
+	}
+	// profiles in flight are cut off rather than waited for
+	_ = srv.Close()
+	return nil
+}
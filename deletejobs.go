@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+type seasonDeleteJob struct {
+	ID            int64      `json:"jobId"`
+	SeasonID      string     `json:"seasonId"`
+	Status        string     `json:"status"` // pending/running/done/failed
+	RedisMembers  int64      `json:"redisMembers"`
+	DeletedEvents int64      `json:"deletedEvents"`
+	DeletedOutbox int64      `json:"deletedOutbox"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+	eventCutoff   int64
+	outboxCutoff  int64
+}
+
+// seasonDeletePreview is what a season delete would remove right now.
+type seasonDeletePreview struct {
+	RedisMembers int64 `json:"redisMembers"`
+	ScoreEvents  int64 `json:"scoreEvents"`
+	OutboxRows   int64 `json:"outboxRows"`
+}
+
+const (
+	deleteJobBatchSize    = 10000
+	maxBatchDeleteSeasons = 1000
+	deleteJobMaxAttempts  = 5
+	// A running job whose updated_at is older than this is assumed orphaned by a dead instance.
+	deleteJobLease = 2 * time.Minute
+)
+
+// enqueueSeasonDelete records a delete job plus the season_deleted outbox event
+// that drops the Redis key. Only rows that exist at this point are cleaned up:
+// scores submitted after the delete belong to the fresh season.
+func enqueueSeasonDelete(ctx context.Context, tx *sql.Tx, seasonID string, payload []byte, redisMembers int64) (int64, error) {
+	var eventCutoff int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(id), 0) FROM score_events`).Scan(&eventCutoff); err != nil {
+		return 0, err
+	}
+
+	var outboxCutoff int64
+	if err := tx.QueryRowContext(ctx, `
+  INSERT INTO outbox (event_type, payload, status, request_id)
+  VALUES ('season_deleted', $1, 'pending', NULLIF($2,''))
+  RETURNING id
+`, payload, requestID(ctx)).Scan(&outboxCutoff); err != nil {
+		return 0, err
+	}
+
+	var jobID int64
+	if err := tx.QueryRowContext(ctx, `
+  INSERT INTO season_delete_jobs (season_id, event_cutoff, outbox_cutoff, redis_members)
+  VALUES ($1, $2, $3, $4)
+  RETURNING id
+`, seasonID, eventCutoff, outboxCutoff, redisMembers).Scan(&jobID); err != nil {
+		return 0, err
+	}
+	return jobID, nil
+}
+
+// startSeasonDelete queues the deletion of one season in its own transaction.
+func startSeasonDelete(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID string) (jobID, redisMembers int64, err error) {
+	// ZCARD is O(1); the ledger counts are reported by the job as it deletes.
+	redisMembers, err = rdb.ZCard(ctx, boardKey(seasonID)).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	payload, _ := json.Marshal(map[string]any{"seasonId": seasonID})
+	jobID, err = enqueueSeasonDelete(ctx, tx, seasonID, payload, redisMembers)
+	if err != nil {
+		return 0, 0, err
+	}
+	return jobID, redisMembers, tx.Commit()
+}
+
+// validateSeasonPattern accepts a glob with * and ? only, so the same pattern
+// means the same thing to Redis MATCH and SQL LIKE.
+func validateSeasonPattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("pattern is required")
+	}
+	if strings.ContainsAny(pattern, `[]\`) {
+		return errors.New("pattern may only use * and ? wildcards")
+	}
+	if strings.Trim(pattern, "*?") == "" {
+		return errors.New("pattern must contain a literal part")
+	}
+	return nil
+}
+
+// matchSeasons finds seasons matching a glob in both Redis (SCAN) and the
+// ledger, so boards whose ZSET is already gone are still cleaned up. Seasons
+// whose delete is already under way (a pending or running job, or its
+// season_deleted event not applied yet) still match until it finishes, so
+// they are left out: calling again after a truncated batch moves on to the
+// next seasons instead of queueing the same ones twice. With tenancy on
+// (tenants), the pattern runs over stored ids, where "*-test" also matches
+// "acme:foo-test": only the default tenant's seasons, the ids without ':',
+// are kept.
+func matchSeasons(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, pattern string, limit int, tenants bool) ([]string, error) {
+	like := strings.NewReplacer("%", `\%`, "_", `\_`, "*", "%", "?", "_").Replace(pattern)
+	deleting, err := deletingSeasons(ctx, db, like)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	for _, sid := range deleting {
+		seen[sid] = struct{}{}
+	}
+	var out []string
+	add := func(sid string) {
+		if tenants && strings.Contains(sid, ":") {
+			return
+		}
+		if _, ok := seen[sid]; !ok && len(out) < limit {
+			seen[sid] = struct{}{}
+			out = append(out, sid)
+		}
+	}
+
+	err = scanKeys(ctx, rdb, boardKey(pattern), func(key string) bool {
+		if sid := boardSeason(key); sid != "" {
+			add(sid)
+		}
+		return len(out) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Loose index scan over score_events(season_id, ...) as in boardSeasons,
+	// from the pattern's literal prefix and stopping past it, instead of a
+	// DISTINCT over the whole ledger on every call. No ORDER BY: the CTE
+	// already yields ids in order, and sorting would make it run to the end.
+	prefix, _, _ := strings.Cut(strings.ReplaceAll(pattern, "?", "*"), "*")
+	rows, err := db.QueryContext(ctx, `
+	WITH RECURSIVE s AS (
+	  (SELECT season_id FROM score_events WHERE season_id >= $4 ORDER BY season_id LIMIT 1)
+	  UNION ALL
+	  SELECT (SELECT e.season_id FROM score_events e WHERE e.season_id > s.season_id ORDER BY e.season_id LIMIT 1)
+	  FROM s WHERE s.season_id IS NOT NULL AND starts_with(s.season_id, $4)
+	)
+	SELECT season_id FROM s
+	WHERE season_id LIKE $1 AND season_id <> ALL($3)
+	  AND NOT ($5 AND strpos(season_id, ':') > 0)
+	LIMIT $2
+`, like, limit, pq.Array(deleting), prefix, tenants)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		add(sid)
+	}
+	return out, rows.Err()
+}
+
+// deletingSeasons lists the seasons matching a LIKE pattern that have a
+// delete under way.
+func deletingSeasons(ctx context.Context, db *sql.DB, like string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT season_id FROM season_delete_jobs
+	WHERE status IN ('pending', 'running') AND season_id LIKE $1
+	UNION
+	SELECT payload->>'seasonId' FROM outbox
+	WHERE event_type='season_deleted' AND status IN ('pending', 'processing')
+	  AND payload->>'seasonId' LIKE $1
+`, like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{} // not nil: <> ALL(NULL) would match nothing
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		out = append(out, sid)
+	}
+	return out, rows.Err()
+}
+
+// previewSeasonDelete counts rows the delete job would remove. The counts scan
+// the whole season, so callers should give it a generous timeout.
+func previewSeasonDelete(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID string) (*seasonDeletePreview, error) {
+	var p seasonDeletePreview
+
+	n, err := rdb.ZCard(ctx, boardKey(seasonID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	p.RedisMembers = n
+
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM score_events WHERE season_id=$1`, seasonID).Scan(&p.ScoreEvents); err != nil {
+		return nil, err
+	}
+
+	// same filter as the job: pending rows are applied, not deleted
+	if err := db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM outbox
+	WHERE payload->>'seasonId'=$1 AND status IN ('done', 'failed')
+`, seasonID).Scan(&p.OutboxRows); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func getSeasonDeleteJob(ctx context.Context, db *sql.DB, seasonID string, jobID int64) (*seasonDeleteJob, error) {
+	var j seasonDeleteJob
+	var lastError sql.NullString
+	var finishedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `
+	SELECT id, season_id, status, redis_members, deleted_events, deleted_outbox, attempts, last_error, created_at, finished_at
+	FROM season_delete_jobs
+	WHERE id=$1 AND season_id=$2
+`, jobID, seasonID).Scan(&j.ID, &j.SeasonID, &j.Status, &j.RedisMembers, &j.DeletedEvents, &j.DeletedOutbox,
+		&j.Attempts, &lastError, &j.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.LastError = lastError.String
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+func runSeasonDeleteJobs(ctx context.Context, db *sql.DB) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				ok, err := processSeasonDeleteJob(ctx, db)
+				if err != nil {
+					slog.Error("Delete job error", "err", err)
+				}
+				if !ok || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// processSeasonDeleteJob claims one job and deletes its rows in batches, each
+// batch in its own short transaction. It reports whether a job was claimed.
+func processSeasonDeleteJob(ctx context.Context, db *sql.DB) (bool, error) {
+	j, err := claimSeasonDeleteJob(ctx, db)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := runSeasonDeleteJob(ctx, db, j); err != nil {
+		status := "pending"
+		if j.Attempts >= deleteJobMaxAttempts {
+			status = "failed"
+		}
+		c, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, _ = db.ExecContext(c, `
+	UPDATE season_delete_jobs
+	SET status=$2, last_error=$3, updated_at=now(), finished_at=CASE WHEN $2='failed' THEN now() END
+	WHERE id=$1
+`, j.ID, status, err.Error())
+		return true, fmt.Errorf("season delete job %d: %w", j.ID, err)
+	}
+	return true, nil
+}
+
+func claimSeasonDeleteJob(ctx context.Context, db *sql.DB) (*seasonDeleteJob, error) {
+	c, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var j seasonDeleteJob
+	err := db.QueryRowContext(c, `
+	UPDATE season_delete_jobs
+	SET status='running', attempts=attempts+1, started_at=COALESCE(started_at, now()), updated_at=now()
+	WHERE id = (
+	  SELECT id FROM season_delete_jobs
+	  WHERE status='pending'
+	     OR (status='running' AND updated_at < now() - make_interval(secs => $1))
+	  ORDER BY id
+	  FOR UPDATE SKIP LOCKED
+	  LIMIT 1
+	)
+	RETURNING id, season_id, attempts, deleted_events, deleted_outbox, event_cutoff, outbox_cutoff, created_at
+`, deleteJobLease.Seconds()).Scan(&j.ID, &j.SeasonID, &j.Attempts, &j.DeletedEvents, &j.DeletedOutbox,
+		&j.eventCutoff, &j.outboxCutoff, &j.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func runSeasonDeleteJob(ctx context.Context, db *sql.DB, j *seasonDeleteJob) error {
+	// A season with a partition of its own (partitions.go) loses it in one go;
+	// whatever is left (the default partition's rows, or the whole season on
+	// an unpartitioned table or when the ID is already in use again) is
+	// deleted in batches.
+	if err := dropDeletedSeasonPartition(ctx, db, j); err != nil {
+		return fmt.Errorf("score_events partition drop failed: %w", err)
+	}
+	for {
+		n, err := deleteBatch(ctx, db, j.ID, "deleted_events", `
+	DELETE FROM score_events
+	WHERE season_id=$1 AND id IN (
+	  SELECT id FROM score_events
+	  WHERE season_id=$1 AND id <= $2
+	  LIMIT $3
+	)
+`, j.SeasonID, j.eventCutoff)
+		if err != nil {
+			return fmt.Errorf("score_events delete failed: %w", err)
+		}
+		if n < deleteJobBatchSize {
+			break
+		}
+	}
+
+	// Pending rows are left alone: the worker applies them before the season_deleted
+	// event (id order), and everything after the cutoff belongs to the new season.
+	for {
+		n, err := deleteBatch(ctx, db, j.ID, "deleted_outbox", `
+	DELETE FROM outbox
+	WHERE id IN (
+	  SELECT id FROM outbox
+	  WHERE payload->>'seasonId'=$1 AND id < $2 AND status IN ('done', 'failed')
+	  LIMIT $3
+	)
+`, j.SeasonID, j.outboxCutoff)
+		if err != nil {
+			return fmt.Errorf("outbox delete failed: %w", err)
+		}
+		if n < deleteJobBatchSize {
+			break
+		}
+	}
+
+	if err := deleteSeasonRows(ctx, db, j); err != nil {
+		return fmt.Errorf("season rows delete failed: %w", err)
+	}
+	return nil
+}
+
+// seasonRowDeletes remove what a season leaves outside the ledger: its
+// settings and status, cheat holds, boosts and the rest. Each runs with the
+// season ($1) and the job's creation time ($2); rows written after the delete
+// was requested belong to a season recreated under the same ID and stay.
+// webhook_deliveries and stream_events are left to their own cleanup: they
+// carry the season.deleted notifications themselves.
+var seasonRowDeletes = []string{
+	`DELETE FROM seasons WHERE season_id=$1 AND updated_at <= $2`,
+	`DELETE FROM report_targets WHERE season_id=$1 AND last_reported_at <= $2`,
+	`DELETE FROM reports WHERE season_id=$1 AND created_at <= $2`,
+	`DELETE FROM boosts WHERE season_id=$1 AND created_at <= $2`,
+	`DELETE FROM season_webhooks WHERE season_id=$1 AND updated_at <= $2`,
+	`DELETE FROM leaderboard_snapshots WHERE season_id=$1 AND taken_at <= $2`,
+	`DELETE FROM reconcile_runs WHERE season_id=$1 AND started_at <= $2`,
+	`DELETE FROM score_corrections WHERE season_id=$1 AND created_at <= $2`,
+	`DELETE FROM board_rebuilds WHERE season_id=$1 AND rebuilt_at <= $2`,
+	`DELETE FROM season_imports WHERE season_id=$1 AND created_at <= $2`,
+	`DELETE FROM season_archives WHERE season_id=$1 AND (archived_at IS NULL OR archived_at <= $2)`,
+	// earlier jobs; this one stays for the job-status endpoint
+	`DELETE FROM season_delete_jobs WHERE season_id=$1 AND created_at < $2 AND status IN ('done', 'failed')`,
+}
+
+// deleteSeasonRows is the job's last step: it clears the season's other rows
+// and marks the job done in one transaction, so a reused season ID starts
+// active, with default settings and no holds.
+func deleteSeasonRows(ctx context.Context, db *sql.DB, j *seasonDeleteJob) error {
+	c, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range seasonRowDeletes {
+		if _, err := tx.ExecContext(c, q, j.SeasonID, j.CreatedAt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(c, `
+	UPDATE season_delete_jobs
+	SET status='done', last_error=NULL, finished_at=now(), updated_at=now()
+	WHERE id=$1
+`, j.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func dropDeletedSeasonPartition(ctx context.Context, db *sql.DB, j *seasonDeleteJob) error {
+	c, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	dropped, n, err := dropSeasonPartition(c, tx, j.SeasonID, j.eventCutoff)
+	if err != nil || !dropped {
+		return err
+	}
+	if _, err := tx.ExecContext(c, `
+	UPDATE season_delete_jobs SET deleted_events=deleted_events+$2, updated_at=now() WHERE id=$1
+`, j.ID, n); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteBatch runs one bounded delete and bumps the job's progress counter in
+// the same transaction, which also renews the job's lease.
+func deleteBatch(ctx context.Context, db *sql.DB, jobID int64, counter, query string, seasonID string, cutoff int64) (int64, error) {
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(c, query, seasonID, cutoff, deleteJobBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// counter is one of two fixed column names, never user input
+	if _, err := tx.ExecContext(c,
+		`UPDATE season_delete_jobs SET `+counter+`=`+counter+`+$2, updated_at=now() WHERE id=$1`,
+		jobID, n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/ledger"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestDeletedFrozenSeasonIDIsReusable(t *testing.T) {
+	db, rdb := testStores(t)
+	ctx := context.Background()
+	sid := fmt.Sprintf("delete-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		rdb.Del(ctx, boardKey(sid), appliedKey(sid))
+		db.ExecContext(ctx, `DELETE FROM outbox WHERE payload->>'seasonId'=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM score_events WHERE season_id=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM seasons WHERE season_id=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM report_targets WHERE season_id=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM season_delete_jobs WHERE season_id=$1`, sid)
+	})
+
+	queueDelta(t, db, sid, "alice", 10)
+	drainOutbox(t, db, rdb)
+	if _, err := db.ExecContext(ctx, `
+	INSERT INTO seasons (season_id, status, frozen_at, max_size) VALUES ($1, 'frozen', now(), 5)
+	ON CONFLICT (season_id) DO UPDATE SET status='frozen', frozen_at=now(), max_size=5, updated_at=now()
+`, sid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO report_targets (season_id, user_id, report_count, status) VALUES ($1, 'alice', 3, 'held')`, sid); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID, _, err := startSeasonDelete(ctx, db, rdb, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other tests' jobs may be queued too; run jobs until this one is done
+	for i := 0; ; i++ {
+		j, err := getSeasonDeleteJob(ctx, db, sid, jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status == "done" {
+			break
+		}
+		if j.Status == "failed" || i == 100 {
+			t.Fatalf("delete job %d: status %s, last error %q", jobID, j.Status, j.LastError)
+		}
+		if _, err := processSeasonDeleteJob(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var seasons, holds int
+	if err := db.QueryRowContext(ctx, `
+	SELECT (SELECT COUNT(*) FROM seasons WHERE season_id=$1), (SELECT COUNT(*) FROM report_targets WHERE season_id=$1)
+`, sid).Scan(&seasons, &holds); err != nil {
+		t.Fatal(err)
+	}
+	if seasons != 0 || holds != 0 {
+		t.Fatalf("left %d seasons rows and %d report_targets rows", seasons, holds)
+	}
+
+	pool, err := pgxpool.New(ctx, os.Getenv("TEST_DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	scores := ledger.NewPostgres(pool)
+	season, err := scores.Season(ctx, sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ledger.Closed(season.Status) {
+		t.Fatalf("reused season id is %s", season.Status)
+	}
+	rec, err := scores.Record(ctx, ledger.Submission{SeasonID: sid, UserID: "bob", Delta: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.EventID == 0 {
+		t.Fatalf("submission to the reused season id rejected: season %q", rec.SeasonStatus)
+	}
+}
+
+func TestBatchDeleteMatchesOwnTenantOnly(t *testing.T) {
+	db, rdb := testStores(t)
+	ctx := context.Background()
+	prefix := fmt.Sprintf("match-test-%d", time.Now().UnixNano())
+	own, other := prefix+"-a", "acme:"+prefix+"-a"
+	t.Cleanup(func() {
+		for _, sid := range []string{own, other} {
+			db.ExecContext(ctx, `DELETE FROM outbox WHERE payload->>'seasonId'=$1`, sid)
+			db.ExecContext(ctx, `DELETE FROM score_events WHERE season_id=$1`, sid)
+		}
+	})
+	queueDelta(t, db, own, "alice", 1)
+	queueDelta(t, db, other, "alice", 1)
+
+	sids, err := matchSeasons(ctx, db, rdb, "*"+prefix+"-*", 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sids) != 1 || sids[0] != own {
+		t.Fatalf("matched %q, want only %q", sids, own)
+	}
+}
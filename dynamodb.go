@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/ledger"
+	"github.com/disfordave/leaderboard-go/outbox"
+)
+
+// STORAGE_BACKEND=dynamodb keeps the ledger and outbox in DynamoDB
+// (ledger.DynamoDB) instead of Postgres, for AWS serverless stacks, and the
+// boards in Redis as usual. The API is the core one newEngineMux serves
+// (submit, top, rank, around, percentiles); the worker is an
+// outbox.DynamoDBWorker, which applies deltas only. RUN_MODE splits the two
+// like the Postgres setup, but run a single worker: items aren't claimed, and
+// only OUTBOX_DEDUP_WINDOW keeps a second one from applying them twice.
+//
+// The API is served by serveStandalone, behind the same auth, scope, tenant,
+// allowlist and rate limit checks as the Postgres setup. Keys are issued into
+// Postgres' api_keys, which this backend doesn't have, so API_KEY_BOOTSTRAP
+// and bearer JWTs are the only credentials; set API_AUTH=true to require one.
+
+func newDynamoDBLedger() *ledger.DynamoDB {
+	c, err := ledger.NewDynamoDBClient(getenv("DYNAMODB_ENDPOINT"), getenv("AWS_REGION"),
+		getenv("AWS_ACCESS_KEY_ID"), getenv("AWS_SECRET_ACCESS_KEY"), getenv("AWS_SESSION_TOKEN"))
+	if err != nil {
+		panic(err)
+	}
+	prefix, ok := config.lookup("DYNAMODB_TABLE_PREFIX", "leaderboard_")
+	if !ok {
+		prefix = "leaderboard_"
+	}
+	l := ledger.NewDynamoDB(c, ledger.DynamoDBTables{
+		Seasons: prefix + "seasons",
+		Events:  prefix + "score_events",
+		Outbox:  prefix + "outbox",
+	})
+	// Changing the shard count strands the items queued in shards no longer read.
+	shards := envInt64("DYNAMODB_OUTBOX_SHARDS", 4)
+	if shards < 1 || shards > 256 {
+		panic("invalid DYNAMODB_OUTBOX_SHARDS")
+	}
+	l.Shards = int(shards)
+	return l
+}
+
+func serveDynamoDB(runAPI, runWorker bool) {
+	scores := newDynamoDBLedger()
+	if envBool("DYNAMODB_CREATE_TABLES", false) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := scores.CreateTables(ctx)
+		cancel()
+		if err != nil {
+			panic(err)
+		}
+	}
+	rdb, _ := newRedisClient()
+	defer rdb.Close()
+	store := leaderboard.NewRedisStore(rdb, leaderboard.RedisOptions{
+		DedupWindow: envDuration("OUTBOX_DEDUP_WINDOW", 10*time.Minute),
+		TieBreak:    tieBreakFirst(),
+	})
+
+	lc := newLifecycle()
+	var mux *http.ServeMux
+	if runAPI {
+		mux = newEngineMux("dynamodb", leaderboard.NewEngine(scores, store))
+	} else {
+		mux = http.NewServeMux()
+		mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+		})
+		mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), 200*time.Millisecond)
+			defer cancel()
+			if err := rdb.Ping(ctx).Err(); err != nil {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "not_ready", "redis": "down"})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"status": "ready", "redis": "ok"})
+		})
+	}
+	if runWorker {
+		w := outbox.NewDynamoDBWorker(scores, store)
+		n := envInt64("OUTBOX_BATCH_SIZE", 500)
+		if n < 1 || n > 10000 {
+			panic("invalid OUTBOX_BATCH_SIZE")
+		}
+		w.BatchSize = int(n)
+		w.Retry = outbox.Retry{
+			MaxAttempts: int(envInt64("OUTBOX_MAX_ATTEMPTS", 10)),
+			Base:        envDuration("OUTBOX_RETRY_BASE", time.Second),
+			Max:         envDuration("OUTBOX_RETRY_MAX", 5*time.Minute),
+		}
+		if w.Retry.MaxAttempts < 1 {
+			panic("invalid OUTBOX_MAX_ATTEMPTS")
+		}
+		// Every poll is a Query per shard, so the default is slower than the
+		// Postgres worker's.
+		interval := envDuration("OUTBOX_POLL_INTERVAL", time.Second)
+		if interval <= 0 {
+			panic("invalid OUTBOX_POLL_INTERVAL")
+		}
+		lc.add("outbox", 10*time.Second, loop(func(ctx context.Context) {
+			w.Run(ctx, interval, func(err error) { slog.Error("DynamoDB outbox batch failed", "err", err) })
+		}))
+	}
+	slog.Info("DynamoDB backend", "tables", scores.Tables, "shards", scores.Shards, "api", runAPI, "worker", runWorker)
+	serveStandalone(lc, mux)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Read endpoints (top, rank, around, percentiles) can answer in a binary
+// encoding picked from the Accept header: Protocol Buffers with the messages
+// in proto/leaderboard.proto, or MessagePack with the same field names as the
+// JSON. Both encoders are hand-written for these few shapes to stay on the
+// standard library. Errors are always JSON.
+
+const (
+	mimeProtobuf = "application/x-protobuf"
+	mimeMsgpack  = "application/msgpack"
+)
+
+// protoMessage is implemented by responses that have a protobuf form.
+type protoMessage interface {
+	appendProto(b []byte) []byte
+}
+
+// negotiateFormat returns the preferred supported media type, honoring q
+// values; anything else (including no Accept header) gets JSON.
+func negotiateFormat(r *http.Request, proto bool) string {
+	best, bestQ := "application/json", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, p := range fields[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		var format string
+		switch mt {
+		case mimeProtobuf, "application/protobuf", "application/vnd.google.protobuf":
+			if proto {
+				format = mimeProtobuf
+			}
+		case mimeMsgpack, "application/x-msgpack", "application/vnd.msgpack":
+			format = mimeMsgpack
+		case "application/json", "application/*", "*/*":
+			format = "application/json"
+		}
+		if format != "" && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// writeRead writes a read endpoint's response in the negotiated encoding.
+func writeRead(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Add("Vary", "Accept")
+	pm, isProto := v.(protoMessage)
+	switch negotiateFormat(r, isProto) {
+	case mimeProtobuf:
+		w.Header().Set("Content-Type", mimeProtobuf)
+		w.WriteHeader(status)
+		_, _ = w.Write(pm.appendProto(nil))
+	case mimeMsgpack:
+		w.Header().Set("Content-Type", mimeMsgpack)
+		w.WriteHeader(status)
+		_, _ = w.Write(appendMsgpack(nil, reflect.ValueOf(v)))
+	default:
+		writeJSON(w, status, v)
+	}
+}
+
+// --- MessagePack ---
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// appendMsgpack encodes v the way encoding/json would lay it out: struct
+// fields by json tag (omitempty honored), times as RFC 3339 strings.
+func appendMsgpack(b []byte, v reflect.Value) []byte {
+	if !v.IsValid() {
+		return append(b, 0xc0)
+	}
+	if v.Type() == timeType {
+		return msgpackString(b, v.Interface().(time.Time).Format(time.RFC3339Nano))
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0)
+		}
+		return appendMsgpack(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return msgpackInt(b, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return msgpackUint(b, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float()))
+	case reflect.String:
+		return msgpackString(b, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(b, 0xc0)
+		}
+		b = msgpackHeader(b, v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			b = appendMsgpack(b, v.Index(i))
+		}
+		return b
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, 0xc0)
+		}
+		b = msgpackHeader(b, v.Len(), 0x80, 0xde, 0xdf)
+		iter := v.MapRange()
+		for iter.Next() {
+			b = msgpackString(b, iter.Key().String())
+			b = appendMsgpack(b, iter.Value())
+		}
+		return b
+	case reflect.Struct:
+		type field struct {
+			name string
+			v    reflect.Value
+		}
+		var fields []field
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if name == "" {
+				name = sf.Name
+			}
+			fv := v.Field(i)
+			if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+				continue
+			}
+			fields = append(fields, field{name, fv})
+		}
+		b = msgpackHeader(b, len(fields), 0x80, 0xde, 0xdf)
+		for _, f := range fields {
+			b = msgpackString(b, f.name)
+			b = appendMsgpack(b, f.v)
+		}
+		return b
+	default:
+		// not used by the read endpoints; keep the JSON form rather than fail
+		j, _ := json.Marshal(v.Interface())
+		return msgpackString(b, string(j))
+	}
+}
+
+// isEmptyValue mirrors encoding/json's omitempty rule.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
+
+func msgpackHeader(b []byte, n int, fix, m16, m32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, m16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, m32), uint32(n))
+	}
+}
+
+func msgpackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func msgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return msgpackUint(b, uint64(n))
+	case n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+	}
+}
+
+func msgpackUint(b []byte, n uint64) []byte {
+	switch {
+	case n < 128:
+		return append(b, byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+	}
+}
+
+// --- Protocol Buffers (proto3; zero values are left out) ---
+
+func protoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func protoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protoTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func protoInt(b []byte, field int, n int64) []byte {
+	if n == 0 {
+		return b
+	}
+	return binary.AppendUvarint(protoTag(b, field, 0), uint64(n))
+}
+
+func protoBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return append(protoTag(b, field, 0), 1)
+}
+
+func protoDouble(b []byte, field int, f float64) []byte {
+	if f == 0 && !math.Signbit(f) {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(protoTag(b, field, 1), math.Float64bits(f))
+}
+
+// protoMessageField appends an embedded message; empty messages are still
+// written when they are list elements.
+func protoMessageField(b []byte, field int, msg []byte) []byte {
+	b = protoTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
+
+func (it leaderboardItem) appendProto(b []byte) []byte {
+	b = protoString(b, 1, it.UserID)
+	return protoDouble(b, 2, it.Score)
+}
+
+func (resp topResponse) appendProto(b []byte) []byte {
+	b = protoString(b, 1, resp.SeasonID)
+	for _, it := range resp.Items {
+		b = protoMessageField(b, 2, it.appendProto(nil))
+	}
+	return protoBool(b, 3, resp.Degraded)
+}
+
+func (resp rankResponse) appendProto(b []byte) []byte {
+	b = protoString(b, 1, resp.SeasonID)
+	b = protoString(b, 2, resp.UserID)
+	b = protoInt(b, 3, resp.Rank)
+	b = protoDouble(b, 4, resp.Score)
+	b = protoBool(b, 5, resp.Trimmed)
+	return protoBool(b, 6, resp.Degraded)
+}
+
+func (it aroundItem) appendProto(b []byte) []byte {
+	b = protoInt(b, 1, it.Rank)
+	b = protoString(b, 2, it.UserID)
+	return protoDouble(b, 3, it.Score)
+}
+
+func (resp aroundResponse) appendProto(b []byte) []byte {
+	b = protoString(b, 1, resp.SeasonID)
+	b = protoString(b, 2, resp.UserID)
+	b = protoInt(b, 3, resp.Range)
+	for _, it := range resp.Items {
+		b = protoMessageField(b, 4, it.appendProto(nil))
+	}
+	return b
+}
+
+func (pb percentileBucket) appendProto(b []byte) []byte {
+	b = protoInt(b, 1, int64(pb.Bucket))
+	b = protoDouble(b, 2, pb.Percentile)
+	b = protoInt(b, 3, pb.CutoffRank)
+	return protoDouble(b, 4, pb.MinScore)
+}
+
+func (resp percentilesResponse) appendProto(b []byte) []byte {
+	b = protoString(b, 1, resp.SeasonID)
+	b = protoInt(b, 2, int64(resp.Buckets))
+	b = protoInt(b, 3, resp.Total)
+	if !resp.ComputedAt.IsZero() {
+		// google.protobuf.Timestamp
+		var ts []byte
+		ts = protoInt(ts, 1, resp.ComputedAt.Unix())
+		ts = protoInt(ts, 2, int64(resp.ComputedAt.Nanosecond()))
+		b = protoMessageField(b, 4, ts)
+	}
+	for _, it := range resp.Items {
+		b = protoMessageField(b, 5, it.appendProto(nil))
+	}
+	return b
+}
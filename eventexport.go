@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Ledger export for the warehouse loader. Pages are keyset-paginated on
+// (created_at, id) so a long export never holds one query (and its snapshot)
+// open for minutes. created_at is the inserting transaction's start time, so
+// a window whose end is "now" can miss rows that are still committing; loaders
+// should keep `to` a little in the past.
+
+const eventExportPage = 5000
+
+type exportedEvent struct {
+	EventID   int64     `json:"eventId"`
+	SeasonID  string    `json:"seasonId"`
+	UserID    string    `json:"userId"`
+	Delta     int64     `json:"delta"`
+	RawDelta  *int64    `json:"rawDelta,omitempty"` // submitted delta when a boost changed it
+	BoostID   *int64    `json:"boostId,omitempty"`
+	Compacted bool      `json:"compacted,omitempty"` // per-user total written by retention
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// streamEvents calls fn with each page of the season's events created in
+// [from, to), oldest first. A zero from or to leaves that side open.
+func streamEvents(ctx context.Context, db *sql.DB, seasonID string, from, to time.Time, fn func(events []exportedEvent) error) error {
+	var (
+		afterTS  = from
+		afterID  int64
+		first    = true
+		nullFrom = from.IsZero()
+		nullTo   = to.IsZero()
+	)
+	page := make([]exportedEvent, 0, eventExportPage)
+	for {
+		rows, err := db.QueryContext(ctx, `
+	SELECT id, season_id, user_id, delta, raw_delta, boost_id, compacted, created_at
+	FROM score_events
+	WHERE season_id=$1
+	  AND ($2 OR (created_at, id) > ($3, $4))
+	  AND ($5 OR created_at < $6)
+	ORDER BY created_at, id
+	LIMIT $7
+`, seasonID, first && nullFrom, afterTS, afterID, nullTo, to, eventExportPage)
+		if err != nil {
+			return err
+		}
+
+		page = page[:0]
+		for rows.Next() {
+			var e exportedEvent
+			var raw, boost sql.NullInt64
+			if err := rows.Scan(&e.EventID, &e.SeasonID, &e.UserID, &e.Delta, &raw, &boost, &e.Compacted, &e.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			if raw.Valid {
+				e.RawDelta = &raw.Int64
+			}
+			if boost.Valid {
+				e.BoostID = &boost.Int64
+			}
+			page = append(page, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if len(page) < eventExportPage {
+			return nil
+		}
+
+		last := page[len(page)-1]
+		afterTS, afterID, first = last.CreatedAt, last.EventID, false
+	}
+}
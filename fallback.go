@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Ledger fallback: when Redis errors out, top and rank are answered from an
+// aggregation over score_events instead of a 500. It is best-effort — a full
+// GROUP BY per request, it includes events the worker hasn't applied yet, and
+// ties are ordered like Redis (member descending) — so responses carry
+// degraded=true. Held users are left out, as they are on the board.
+
+const ledgerTotalsSQL = `
+	SELECT e.user_id, SUM(e.delta) AS score
+	FROM score_events e
+	WHERE e.season_id=$1
+	  AND NOT EXISTS (
+	    SELECT 1 FROM report_targets t
+	    WHERE t.season_id=e.season_id AND t.user_id=e.user_id AND t.status='held'
+	  )
+	GROUP BY e.user_id
+`
+
+func ledgerTop(ctx context.Context, db *sql.DB, seasonID string, limit int) ([]leaderboardItem, error) {
+	rows, err := db.QueryContext(ctx, `
+	WITH totals AS (`+ledgerTotalsSQL+`)
+	SELECT user_id, score FROM totals
+	ORDER BY score DESC, user_id DESC
+	LIMIT $2
+`, seasonID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]leaderboardItem, 0, limit)
+	for rows.Next() {
+		var it leaderboardItem
+		var score int64
+		if err := rows.Scan(&it.UserID, &score); err != nil {
+			return nil, err
+		}
+		it.Score = float64(score)
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// ledgerRank returns the 1-based rank and total of one user; found is false
+// when the user has no events (or is held).
+func ledgerRank(ctx context.Context, db *sql.DB, seasonID, userID string) (rank int64, score float64, found bool, err error) {
+	var total int64
+	err = db.QueryRowContext(ctx, `
+	WITH totals AS (`+ledgerTotalsSQL+`),
+	me AS (SELECT score FROM totals WHERE user_id=$2)
+	SELECT me.score,
+	  1 + (SELECT COUNT(*) FROM totals t
+	       WHERE t.score > me.score OR (t.score = me.score AND t.user_id > $2))
+	FROM me
+`, seasonID, userID).Scan(&total, &rank)
+	if err == sql.ErrNoRows {
+		return 0, 0, false, nil
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return rank, float64(total), true, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Bulk import for seeding a season from another system. Rows are written to
+// score_events directly (no outbox) in one transaction, then the board is
+// rebuilt from the ledger. In "standings" mode each row is a user's final
+// score and is stored as the delta from the user's current ledger total; in
+// "deltas" mode rows are historical events and are stored as-is. Each batch
+// of rows goes in with COPY FROM on the transaction's connection.
+
+const (
+	maxImportRows  = 1000000
+	importBatch    = 5000
+	importModeSet  = "standings"
+	importModeHist = "deltas"
+)
+
+var errSeasonArchived = errors.New("season is archived")
+
+type importRow struct {
+	UserID    string
+	Value     int64     // score in standings mode, delta in deltas mode
+	CreatedAt time.Time // deltas mode only; zero means now
+}
+
+type importResult struct {
+	ImportID int64  `json:"importId"`
+	SeasonID string `json:"seasonId"`
+	Mode     string `json:"mode"`
+	Rows     int64  `json:"rows"`
+	Events   int64  `json:"events"`  // rows that changed the ledger
+	Members  int64  `json:"members"` // board size after the rebuild
+}
+
+// importInputError marks problems with the uploaded file (as opposed to the database).
+type importInputError struct{ msg string }
+
+func (e *importInputError) Error() string { return e.msg }
+
+func badImport(line int64, format string, args ...any) error {
+	return &importInputError{msg: fmt.Sprintf("line %d: ", line) + fmt.Sprintf(format, args...)}
+}
+
+// csvImportError reports malformed csv as bad input and passes read errors
+// (e.g. the body size limit) through untouched.
+func csvImportError(line int64, err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return badImport(line, "%v", pe.Err)
+	}
+	return err
+}
+
+// readImportRows parses a CSV (with a header row) or NDJSON body and hands the
+// rows to fn in batches.
+func readImportRows(r io.Reader, format, mode string, fn func(rows []importRow) error) error {
+	valueField := "score"
+	if mode == importModeHist {
+		valueField = "delta"
+	}
+
+	batch := make([]importRow, 0, importBatch)
+	var total int64
+	add := func(line int64, row importRow) error {
+		if row.UserID == "" {
+			return badImport(line, "userId is required")
+		}
+		if mode == importModeHist && row.Value == 0 {
+			return badImport(line, "delta must be non-zero")
+		}
+		if total++; total > maxImportRows {
+			return &importInputError{msg: fmt.Sprintf("too many rows (max %d)", maxImportRows)}
+		}
+		batch = append(batch, row)
+		if len(batch) == importBatch {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+		return nil
+	}
+
+	switch format {
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		header, err := cr.Read()
+		if err == io.EOF {
+			return &importInputError{msg: "missing csv header"}
+		}
+		if err != nil {
+			return csvImportError(1, err)
+		}
+		cols := map[string]int{}
+		for i, h := range header {
+			cols[strings.TrimSpace(h)] = i
+		}
+		uidCol, ok1 := cols["userId"]
+		valCol, ok2 := cols[valueField]
+		if !ok1 || !ok2 {
+			return &importInputError{msg: fmt.Sprintf("csv header must include userId and %s", valueField)}
+		}
+		tsCol, hasTS := cols["createdAt"]
+		hasTS = hasTS && mode == importModeHist
+
+		for line := int64(2); ; line++ {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return csvImportError(line, err)
+			}
+			row := importRow{UserID: rec[uidCol]}
+			if row.Value, err = strconv.ParseInt(strings.TrimSpace(rec[valCol]), 10, 64); err != nil {
+				return badImport(line, "%s must be an integer", valueField)
+			}
+			if hasTS && rec[tsCol] != "" {
+				if row.CreatedAt, err = time.Parse(time.RFC3339Nano, rec[tsCol]); err != nil {
+					return badImport(line, "createdAt must be an RFC 3339 timestamp")
+				}
+			}
+			if err := add(line, row); err != nil {
+				return err
+			}
+		}
+
+	case "ndjson":
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64<<10), 1<<20)
+		for line := int64(1); sc.Scan(); line++ {
+			b := sc.Bytes()
+			if len(strings.TrimSpace(string(b))) == 0 {
+				continue
+			}
+			var rec struct {
+				UserID    string    `json:"userId"`
+				Score     *int64    `json:"score"`
+				Delta     *int64    `json:"delta"`
+				CreatedAt time.Time `json:"createdAt"`
+			}
+			if err := json.Unmarshal(b, &rec); err != nil {
+				return badImport(line, "invalid json")
+			}
+			v := rec.Score
+			if mode == importModeHist {
+				v = rec.Delta
+			}
+			if v == nil {
+				return badImport(line, "%s is required", valueField)
+			}
+			row := importRow{UserID: rec.UserID, Value: *v}
+			if mode == importModeHist {
+				row.CreatedAt = rec.CreatedAt
+			}
+			if err := add(line, row); err != nil {
+				return err
+			}
+		}
+		if err := sc.Err(); err == bufio.ErrTooLong {
+			return &importInputError{msg: "line too long"}
+		} else if err != nil {
+			return err
+		}
+
+	default:
+		return &importInputError{msg: "format must be csv or ndjson"}
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// importScores loads the body into the ledger in one transaction and rebuilds
+// the board. Archived seasons can't be imported into.
+func importScores(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID, format, mode string, body io.Reader, defaultMaxSize int64) (*importResult, error) {
+	res := &importResult{SeasonID: seasonID, Mode: mode}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// FOR SHARE only holds off a freeze if the row exists; inserting it here
+	// makes a freeze's upsert wait for this transaction instead.
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO seasons (season_id) VALUES ($1) ON CONFLICT (season_id) DO NOTHING`, seasonID); err != nil {
+		return nil, err
+	}
+	var status string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT status FROM seasons WHERE season_id=$1 FOR SHARE`, seasonID).Scan(&status); err != nil {
+		return nil, err
+	}
+	if status == "archived" {
+		return nil, errSeasonArchived
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+	INSERT INTO season_imports (season_id, mode, format) VALUES ($1, $2, $3)
+	RETURNING id
+`, seasonID, mode, format).Scan(&res.ImportID); err != nil {
+		return nil, err
+	}
+
+	err = readImportRows(body, format, mode, func(rows []importRow) error {
+		res.Rows += int64(len(rows))
+		var copyRows [][]any
+
+		if mode == importModeSet {
+			// last row wins when a user appears twice in the same batch; later
+			// batches see earlier ones through the ledger
+			last := make(map[string]int, len(rows))
+			users := make([]string, 0, len(rows))
+			for i, r := range rows {
+				if _, ok := last[r.UserID]; !ok {
+					users = append(users, r.UserID)
+				}
+				last[r.UserID] = i
+			}
+			totals, err := ledgerTotals(ctx, tx, seasonID, users)
+			if err != nil {
+				return err
+			}
+			for i, r := range rows {
+				if last[r.UserID] != i {
+					continue
+				}
+				if d := r.Value - totals[r.UserID]; d != 0 {
+					copyRows = append(copyRows, []any{seasonID, r.UserID, d, res.ImportID})
+				}
+			}
+			n, err := copyScoreEvents(ctx, conn, []string{"season_id", "user_id", "delta", "import_id"}, copyRows)
+			res.Events += n
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, r := range rows {
+			if r.CreatedAt.IsZero() {
+				r.CreatedAt = now
+			}
+			copyRows = append(copyRows, []any{seasonID, r.UserID, r.Value, r.CreatedAt, res.ImportID})
+		}
+		n, err := copyScoreEvents(ctx, conn, []string{"season_id", "user_id", "delta", "created_at", "import_id"}, copyRows)
+		res.Events += n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE season_imports SET rows=$2, events=$3 WHERE id=$1
+`, res.ImportID, res.Rows, res.Events); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	res.Members, err = rebuildLeaderboard(ctx, db, rdb, seasonID, defaultMaxSize)
+	if err != nil {
+		return res, fmt.Errorf("rebuild: %w", err)
+	}
+	return res, nil
+}
+
+// ledgerTotals sums the users' ledger rows in the season; users without rows are left out.
+func ledgerTotals(ctx context.Context, tx *sql.Tx, seasonID string, users []string) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT user_id, SUM(delta) FROM score_events
+	WHERE season_id=$1 AND user_id = ANY($2)
+	GROUP BY user_id
+`, seasonID, pq.Array(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int64, len(users))
+	for rows.Next() {
+		var uid string
+		var total int64
+		if err := rows.Scan(&uid, &total); err != nil {
+			return nil, err
+		}
+		totals[uid] = total
+	}
+	return totals, rows.Err()
+}
+
+// copyScoreEvents COPYs rows into score_events on conn, inside whatever
+// transaction is open on it.
+func copyScoreEvents(ctx context.Context, conn *sql.Conn, cols []string, rows [][]any) (n int64, err error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	sp := startSQLSpan(ctx, "sql copy", "COPY score_events ("+strings.Join(cols, ", ")+") FROM STDIN")
+	defer func() { sp.finish(err) }()
+
+	err = conn.Raw(func(dc any) error {
+		var err error
+		n, err = pgxConn(dc).Conn().CopyFrom(ctx, pgx.Identifier{"score_events"}, cols, pgx.CopyFromRows(rows))
+		return err
+	})
+	return n, err
+}
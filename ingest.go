@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Score submission is the hottest path, so it skips database/sql and talks to
+// Postgres through its own pgx pool (POSTGRES_INGEST_MAX_CONNS connections, on
+// top of POSTGRES_MAX_OPEN_CONNS), in the binary protocol with cached
+// prepared statements; ledger.Postgres runs the two round trips on it. The
+// rest of the code, the outbox worker included, shares its transactions across
+// many helpers and stays on database/sql.
+
+func newIngestPool() *pgxpool.Pool {
+	cfg, err := pgxpool.ParseConfig(postgresDSN())
+	if err != nil {
+		panic(fmt.Sprintf("invalid POSTGRES_DSN: %v", err))
+	}
+	maxConns := envInt64("POSTGRES_INGEST_MAX_CONNS", 20)
+	if maxConns < 1 {
+		panic("invalid POSTGRES_INGEST_MAX_CONNS")
+	}
+	cfg.MaxConns = int32(maxConns)
+	cfg.MaxConnLifetime = envDuration("POSTGRES_CONN_MAX_LIFETIME", 5*time.Minute)
+	cfg.ConnConfig.Tracer = pgxTracer{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("POSTGRES_CONNECT_TIMEOUT", 700*time.Millisecond))
+	defer cancel()
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		panic(err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		panic(err)
+	}
+	return pool
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Admin routes (everything that needs the admin scope, see authz.go, which
+// includes the outbox endpoints) and the pprof listener can be restricted to
+// source networks: ADMIN_ALLOWED_CIDRS and DEBUG_ALLOWED_CIDRS are
+// comma-separated CIDRs or addresses, e.g. "10.8.0.0/16,fd00::/8". The check
+// runs before authentication, so a leaked key is useless from elsewhere.
+// Unset means no restriction.
+//
+// On the API port the client address is taken as for rate limiting: with
+// TRUSTED_PROXIES=n, n entries from the right of X-Forwarded-For. The debug
+// listener is internal and always uses the peer address.
+
+type ipAllowlist []netip.Prefix
+
+// envIPAllowlist parses a CIDR list from the environment; nil when unset.
+func envIPAllowlist(name string) ipAllowlist {
+	v := strings.TrimSpace(getenv(name))
+	if v == "" {
+		return nil
+	}
+	var l ipAllowlist
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			a, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				panic(fmt.Sprintf("invalid %s entry %q", name, s))
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		l = append(l, p.Masked())
+	}
+	if len(l) == 0 {
+		panic(fmt.Sprintf("invalid %s", name))
+	}
+	return l
+}
+
+func (l ipAllowlist) allows(ip string) bool {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range l {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies is TRUSTED_PROXIES, or the older RATE_LIMIT_TRUSTED_PROXIES.
+func trustedProxies() int {
+	n := envInt64("TRUSTED_PROXIES", envInt64("RATE_LIMIT_TRUSTED_PROXIES", 0))
+	if n < 0 {
+		panic("invalid TRUSTED_PROXIES")
+	}
+	return int(n)
+}
+
+// clientIP is the peer address, or with trusted > 0 the address trusted
+// entries from the right of X-Forwarded-For, which is the one the outermost
+// trusted proxy saw.
+func clientIP(r *http.Request, trusted int) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if trusted > 0 {
+		var hops []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, a := range strings.Split(h, ",") {
+				hops = append(hops, strings.TrimSpace(a))
+			}
+		}
+		if n := len(hops) - trusted; n >= 0 && n < len(hops) && net.ParseIP(hops[n]) != nil {
+			ip = hops[n]
+		}
+	}
+	return ip
+}
+
+func denyIP(w http.ResponseWriter, r *http.Request, ip string) {
+	slog.WarnContext(r.Context(), "Request from outside the allowlist", "ip", ip, "path", r.URL.Path)
+	writeProblem(w, http.StatusForbidden, "ip_not_allowed", "not allowed from this address")
+}
+
+// adminAllowlist answers 403 ip_not_allowed to admin routes from outside l.
+// Like authorize, it asks mux which pattern the request will match.
+func adminAllowlist(mux *http.ServeMux, l ipAllowlist, trusted int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" && requiredScope(pattern) == scopeAdmin {
+			if ip := clientIP(r, trusted); !l.allows(ip) {
+				denyIP(w, r, ip)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// peerAllowlist answers 403 ip_not_allowed to any peer outside l.
+func peerAllowlist(l ipAllowlist, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r, 0); !l.allows(ip) {
+			denyIP(w, r, ip)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
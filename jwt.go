@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bearer tokens that are JWTs (OIDC access or ID tokens from the platform)
+// are verified against the JWKS at JWT_JWKS_URL, with JWT_ISSUER and
+// JWT_AUDIENCE checked when set. The subject becomes the caller's identity:
+// without the read scope, a token may still read the rank and around view of
+// its own userId (its sub, or JWT_USER_CLAIM). Scopes come from the "scope"
+// (space-separated) or "scp" claim, keeping read/write/admin after stripping
+// JWT_SCOPE_PREFIX. With JWT_TENANT_CLAIM set, every token must carry that
+// claim and is bound to the tenant it names (tenant.go).
+//
+// The key set is fetched on first use and refreshed every JWKS_CACHE_TTL, or
+// sooner (at most every 30s) when a token names a kid it doesn't have, so key
+// rotation needs no restart. If a refresh fails the old set is kept.
+
+const (
+	jwtLeeway           = time.Minute
+	jwksMinRefetch      = 30 * time.Second
+	maxJWKSBytes        = 1 << 20
+	jwksFetchTimeout    = 5 * time.Second
+	jwtMaxTokenBytes    = 16 << 10
+	defaultJWTUserClaim = "sub"
+)
+
+var (
+	errJWTInvalid = errors.New("invalid token")
+	errJWKSFetch  = errors.New("jwks unavailable")
+)
+
+type jwtVerifier struct {
+	jwksURL     string
+	issuer      string
+	audience    string
+	userClaim   string
+	scopePrefix string
+	tenantClaim string
+	ttl         tunableDuration // JWKS_CACHE_TTL, reloadable
+	client      *http.Client
+
+	mu         sync.Mutex
+	keys       map[string]crypto.PublicKey // by kid
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// newJWTVerifier reads the JWT_* settings; it returns nil when JWT_JWKS_URL is unset.
+func newJWTVerifier() *jwtVerifier {
+	u := getenv("JWT_JWKS_URL")
+	if u == "" {
+		return nil
+	}
+	v := &jwtVerifier{
+		jwksURL:     u,
+		issuer:      getenv("JWT_ISSUER"),
+		audience:    getenv("JWT_AUDIENCE"),
+		userClaim:   getenv("JWT_USER_CLAIM"),
+		scopePrefix: getenv("JWT_SCOPE_PREFIX"),
+		tenantClaim: getenv("JWT_TENANT_CLAIM"),
+		client:      &http.Client{Timeout: jwksFetchTimeout},
+	}
+	v.ttl.set(envDuration("JWKS_CACHE_TTL", time.Hour))
+	if v.userClaim == "" {
+		v.userClaim = defaultJWTUserClaim
+	}
+	if v.issuer == "" || v.audience == "" {
+		slog.Warn("JWT_ISSUER or JWT_AUDIENCE unset: tokens for other issuers or audiences signed by the same keys are accepted")
+	}
+	return v
+}
+
+// looksLikeJWT tells a JWT from an API key without verifying anything.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && !strings.HasPrefix(token, apiKeyPrefix)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and claims and returns the caller. Errors are
+// errJWTInvalid (401) or errJWKSFetch (503).
+func (v *jwtVerifier) verify(ctx context.Context, token string, now time.Time) (*principal, error) {
+	if len(token) > jwtMaxTokenBytes {
+		return nil, errJWTInvalid
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTInvalid
+	}
+	var hdr jwtHeader
+	if err := decodeJWTPart(parts[0], &hdr); err != nil {
+		return nil, errJWTInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errJWTInvalid
+	}
+
+	key, err := v.key(ctx, hdr.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyJWTSignature(hdr.Alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, errJWTInvalid
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errJWTInvalid
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errJWTInvalid
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errJWTInvalid
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return nil, errJWTInvalid
+	}
+	if v.audience != "" && !slices.Contains(claimStrings(claims["aud"]), v.audience) {
+		return nil, errJWTInvalid
+	}
+	subject, _ := claims[v.userClaim].(string)
+	if subject == "" {
+		return nil, errJWTInvalid
+	}
+
+	p := &principal{name: "jwt", subject: subject}
+	if v.tenantClaim != "" {
+		if p.tenant, _ = claims[v.tenantClaim].(string); p.tenant == "" {
+			return nil, errJWTInvalid
+		}
+	}
+	raw := claimStrings(claims["scp"])
+	if s, ok := claims["scope"].(string); ok {
+		raw = append(raw, strings.Fields(s)...)
+	}
+	for _, sc := range raw {
+		sc = strings.TrimPrefix(sc, v.scopePrefix)
+		if slices.Contains(apiKeyScopes, sc) && !p.has(sc) {
+			p.scopes = append(p.scopes, sc)
+		}
+	}
+	return p, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// claimStrings reads a claim that may be a string or an array of strings (aud, scp).
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// verifyJWTSignature supports RS*, PS*, ES* and EdDSA; the key type must match the alg.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if len(alg) < 5 {
+		return false
+	}
+	var h crypto.Hash
+	switch alg[len(alg)-3:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	}
+	digest := func() []byte {
+		switch h {
+		case crypto.SHA256:
+			d := sha256.Sum256(signed)
+			return d[:]
+		case crypto.SHA384:
+			d := sha512.Sum384(signed)
+			return d[:]
+		default:
+			d := sha512.Sum512(signed)
+			return d[:]
+		}
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512":
+			return rsa.VerifyPKCS1v15(k, h, digest(), sig) == nil
+		case "PS256", "PS384", "PS512":
+			return rsa.VerifyPSS(k, h, digest(), sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		want := map[string]int{"ES256": 32, "ES384": 48, "ES512": 66}[alg]
+		if want == 0 || want != size || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest(), r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, sig)
+	}
+	return false
+}
+
+// key returns the key for kid. A stale set is refreshed in the background
+// while its keys keep working; an unknown kid fetches the set right away.
+func (v *jwtVerifier) key(ctx context.Context, kid string, now time.Time) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if k, ok := v.lookupKey(kid); ok {
+		if now.Sub(v.fetchedAt) >= v.ttl.get() && !v.refreshing {
+			v.refreshing = true
+			go v.refresh()
+		}
+		return k, nil
+	}
+	if now.Sub(v.fetchedAt) < jwksMinRefetch {
+		if v.keys == nil {
+			return nil, errJWKSFetch // the last attempt failed; don't hammer the issuer
+		}
+		return nil, errJWTInvalid
+	}
+	keys, err := v.fetch(ctx)
+	v.fetchedAt = now
+	if err != nil {
+		slog.ErrorContext(ctx, "JWKS fetch error", "err", err, "url", v.jwksURL)
+		if v.keys == nil {
+			return nil, errJWKSFetch
+		}
+		return nil, errJWTInvalid
+	}
+	v.keys = keys
+	if k, ok := v.lookupKey(kid); ok {
+		return k, nil
+	}
+	return nil, errJWTInvalid
+}
+
+// lookupKey finds kid in the current set. A token without kid is accepted
+// against a set of one key. Called with mu held.
+func (v *jwtVerifier) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if k, ok := v.keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+func (v *jwtVerifier) refresh() {
+	keys, err := v.fetch(context.Background())
+	if err != nil {
+		slog.Error("JWKS refresh error", "err", err, "url", v.jwksURL)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		v.keys = keys
+	}
+	v.fetchedAt = time.Now() // on error, the old set is kept for another ttl
+	v.refreshing = false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *jwtVerifier) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			slog.Warn("JWKS key skipped", "kid", k.Kid, "err", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks: no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64.DecodeString(k.N)
+		e, err2 := b64.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := b64.DecodeString(k.X)
+		y, err2 := b64.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("bad EC key")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		x, err := b64.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+}
+
+// mayReadUser reports whether the caller may read userID's rank: anyone
+// without a token identity, read scope, or the user themself.
+func mayReadUser(ctx context.Context, userID string) bool {
+	p := requestPrincipal(ctx)
+	return p == nil || p.subject == "" || p.has(scopeRead) || p.subject == userID
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kafkaRESTPublisher produces stream messages to a Kafka topic through a Kafka
+// REST Proxy (Confluent REST Proxy v2 API, also served by Redpanda's HTTP
+// proxy), which keeps the service on the standard library. Messages are keyed
+// by season, so one season's events stay in one partition and in order.
+type kafkaRESTPublisher struct {
+	endpoint string // {proxy}/topics/{topic}
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newKafkaRESTPublisher(proxyURL, topic, user, password string) (*kafkaRESTPublisher, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid kafka rest proxy url %q", proxyURL)
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("kafka topic is required")
+	}
+	return &kafkaRESTPublisher{
+		endpoint: strings.TrimSuffix(proxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (k *kafkaRESTPublisher) name() string { return "kafka" }
+
+func (k *kafkaRESTPublisher) publish(ctx context.Context, msgs []streamMessage) error {
+	type record struct {
+		Key   string        `json:"key"`
+		Value streamMessage `json:"value"`
+	}
+	records := make([]record, len(msgs))
+	for i, m := range msgs {
+		records[i] = record{Key: tenantSeason(m.TenantID, m.SeasonID), Value: m}
+	}
+	body, _ := json.Marshal(map[string]any{"records": records})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.user != "" {
+		req.SetBasicAuth(k.user, k.password)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("kafka rest proxy responded %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+
+	// The proxy answers 200 even when single records fail; check each offset.
+	var out struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return fmt.Errorf("kafka rest proxy: bad response: %w", err)
+	}
+	if len(out.Offsets) != len(msgs) {
+		return fmt.Errorf("kafka rest proxy: %d offsets for %d records", len(out.Offsets), len(msgs))
+	}
+	for i, o := range out.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("kafka record %d (id %d): %s", i, msgs[i].ID, o.Error)
+		}
+	}
+	return nil
+}
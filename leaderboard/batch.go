@@ -0,0 +1,252 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Batched writes. RedisStore.ApplyChanges sends a batch of board changes to
+// Redis as one pipeline, for a worker applying many outbox rows at once, and
+// caps the boards it touched afterwards: with RedisOptions.Caps, the lowest
+// members past a season's cap are trimmed off. They stay in the ledger, so a
+// trimmed user whose score changes again is seeded with their ledger total
+// (a Seeder) before the delta lands and competes for the cap on their whole
+// score.
+
+type ChangeKind int
+
+const (
+	ChangeApply  ChangeKind = iota // add Deltas to the user's score
+	ChangeRemove                   // take the user off the board
+	ChangeDrop                     // drop the season's board
+)
+
+// Change is one write of an ApplyChanges call. ApplyChanges sets Err, or for
+// ChangeApply the user's Score right after it, in points.
+type Change struct {
+	Kind     ChangeKind
+	SeasonID string
+	UserID   string    // not for ChangeDrop
+	IDs      []int64   // ChangeApply: the outbox rows carrying Deltas
+	Deltas   []int64   // ChangeApply
+	At       time.Time // ChangeApply: when the latest of them was recorded
+
+	Score float64
+	Err   error
+}
+
+// Member is one user on one season's board.
+type Member struct {
+	SeasonID, UserID string
+}
+
+// Seed is what a trimmed member starts from: their ledger total and when it
+// last changed.
+type Seed struct {
+	Points int64
+	At     time.Time
+}
+
+// Seeder returns the seeds of members missing from capped boards. A member
+// it leaves out was never on the board and starts from its deltas alone.
+type Seeder func(ctx context.Context, members []Member) (map[Member]Seed, error)
+
+// ApplyChanges applies changes in one pipeline, in order, then trims the
+// capped boards it added to; seed may be nil on uncapped boards. It returns
+// an error, with nothing worth keeping applied, only when Redis couldn't be
+// reached or the caps or seeds couldn't be read; error replies go to the
+// changes they belong to.
+func (s *RedisStore) ApplyChanges(ctx context.Context, changes []*Change, seed Seeder) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	// Seasons dropped in this batch start from an empty board.
+	var touched []string
+	seen := make(map[string]bool)
+	dropped := make(map[string]bool)
+	for _, c := range changes {
+		switch c.Kind {
+		case ChangeApply:
+			if !seen[c.SeasonID] {
+				seen[c.SeasonID] = true
+				touched = append(touched, c.SeasonID)
+			}
+		case ChangeDrop:
+			dropped[c.SeasonID] = true
+		}
+	}
+	var caps map[string]int64
+	if s.opts.Caps != nil && len(touched) > 0 {
+		var err error
+		if caps, err = s.opts.Caps(ctx, touched); err != nil {
+			return fmt.Errorf("board caps lookup failed: %w", err)
+		}
+	}
+	var seeds map[Member]Seed
+	if seed != nil {
+		var candidates []Member
+		for _, c := range changes {
+			if c.Kind == ChangeApply && caps[c.SeasonID] > 0 && !dropped[c.SeasonID] {
+				candidates = append(candidates, Member{c.SeasonID, c.UserID})
+			}
+		}
+		missing, err := s.missing(ctx, candidates)
+		if err != nil {
+			return fmt.Errorf("trimmed member lookup failed: %w", err)
+		}
+		if len(missing) > 0 {
+			if seeds, err = seed(ctx, missing); err != nil {
+				return fmt.Errorf("trimmed member seed failed: %w", err)
+			}
+		}
+	}
+
+	cmds, err := s.send(ctx, changes, seeds)
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		// Script cache flushed (restart, failover): no script call ran, and
+		// the plain commands are safe to repeat (with scripts in use, applies
+		// all go through them), so load the scripts and send it again.
+		if err := s.LoadScripts(ctx); err != nil {
+			return fmt.Errorf("redis script load failed: %w", err)
+		}
+		cmds, err = s.send(ctx, changes, seeds)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Trimming is best-effort: the deltas are already applied, and trimmed users stay in the ledger.
+	if err := s.trim(ctx, caps); err != nil && s.opts.OnTrimError != nil {
+		s.opts.OnTrimError(err)
+	}
+
+	for i, c := range changes {
+		if c.Err = cmds[i].Err(); c.Err != nil || c.Kind != ChangeApply {
+			continue
+		}
+		c.Score, _ = applyScore(cmds[i])
+	}
+	return nil
+}
+
+// send runs the changes' pipeline and returns a command per change. Its error
+// is a connection-level failure, or a NOSCRIPT reply from any command; other
+// error replies are left on their commands (Exec only reports the first one).
+func (s *RedisStore) send(ctx context.Context, changes []*Change, seeds map[Member]Seed) ([]redis.Cmder, error) {
+	pipe := s.rdb.Pipeline()
+	now := time.Now()
+	cmds := make([]redis.Cmder, len(changes))
+	for i, c := range changes {
+		key := BoardKey(c.SeasonID)
+		switch c.Kind {
+		case ChangeApply:
+			if sd, ok := seeds[Member{c.SeasonID, c.UserID}]; ok {
+				pipe.ZAddNX(ctx, key, redis.Z{Score: float64(sd.Points) + s.tie(sd.At), Member: c.UserID})
+			}
+			cmds[i] = s.queueApply(ctx, pipe, c.SeasonID, c.UserID, c.IDs, c.Deltas, c.At, now)
+		case ChangeRemove:
+			cmds[i] = pipe.ZRem(ctx, key, c.UserID)
+		case ChangeDrop:
+			cmds[i] = pipe.Del(ctx, key, AppliedKey(c.SeasonID))
+		}
+	}
+	// queued after the board commands, so a hook never runs before its change
+	if s.opts.Changed != nil {
+		seen := make(map[string]bool)
+		for _, c := range changes {
+			if !seen[c.SeasonID] {
+				seen[c.SeasonID] = true
+				s.opts.Changed(ctx, pipe, c.SeasonID, now)
+			}
+		}
+	}
+
+	_, err := pipe.Exec(ctx)
+	var reply redis.Error
+	if err != nil && !errors.As(err, &reply) {
+		return nil, fmt.Errorf("redis pipeline failed: %w", err)
+	}
+	for _, cmd := range cmds {
+		if redis.HasErrorPrefix(cmd.Err(), "NOSCRIPT") {
+			return nil, cmd.Err()
+		}
+	}
+	return cmds, nil
+}
+
+// missing returns the members that aren't on their boards.
+func (s *RedisStore) missing(ctx context.Context, members []Member) ([]Member, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	pipe := s.rdb.Pipeline()
+	scores := make([]*redis.FloatCmd, len(members))
+	for i, m := range members {
+		scores[i] = pipe.ZScore(ctx, BoardKey(m.SeasonID), m.UserID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	var out []Member
+	for i, m := range members {
+		if scores[i].Err() == redis.Nil {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Trim caps the seasons' boards at their RedisOptions.Caps, e.g. after a
+// rebuild wrote a board wholesale.
+func (s *RedisStore) Trim(ctx context.Context, seasonIDs []string) error {
+	if s.opts.Caps == nil || len(seasonIDs) == 0 {
+		return nil
+	}
+	caps, err := s.opts.Caps(ctx, seasonIDs)
+	if err != nil {
+		return err
+	}
+	return s.trim(ctx, caps)
+}
+
+// trim drops the lowest members past each board's cap.
+func (s *RedisStore) trim(ctx context.Context, caps map[string]int64) error {
+	pipe := s.rdb.Pipeline()
+	removed := make(map[string]*redis.IntCmd)
+	for sid, maxSize := range caps {
+		if maxSize <= 0 {
+			continue
+		}
+		// ZSET ranks are ascending, so 0..-(max+1) are everything below the top max members.
+		removed[sid] = pipe.ZRemRangeByRank(ctx, BoardKey(sid), 0, -(maxSize + 1))
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if s.opts.Changed == nil {
+		return nil
+	}
+
+	// the top N is unchanged, but ranks of trimmed users now read as not found
+	pipe = s.rdb.Pipeline()
+	n := 0
+	now := time.Now()
+	for sid, cmd := range removed {
+		if cmd.Val() > 0 {
+			s.opts.Changed(ctx, pipe, sid, now)
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
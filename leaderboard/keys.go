@@ -0,0 +1,84 @@
+package leaderboard
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Season keys put the season ID in a hash tag: the board is lb:{sid}, its
+// apply markers lbctl:applied:{sid}, and so on. On Redis Cluster only the
+// part in braces picks the slot, so all of a season's keys live on one node
+// and the apply script, a rebuild's RENAME and MULTI blocks that touch
+// several of them keep working.
+
+func SeasonTag(seasonID string) string { return "{" + seasonID + "}" }
+
+func BoardKey(seasonID string) string { return "lb:" + SeasonTag(seasonID) }
+
+// BoardSeason is the season ID of a board key, or "" for any other key.
+func BoardSeason(key string) string {
+	sid, ok := strings.CutPrefix(key, "lb:{")
+	if !ok {
+		return ""
+	}
+	sid, ok = strings.CutSuffix(sid, "}")
+	if !ok {
+		return ""
+	}
+	return sid
+}
+
+// Exactly-once apply: a worker applies a batch to Redis and then commits the
+// outbox rows as done. If it dies in between, the rows are still pending and
+// the next batch would add their deltas a second time. To make that replay
+// harmless, each ZINCRBY runs as a script that first records the outbox ids it
+// carries in lbctl:applied:{sid} (a ZSET scored by apply time) and only adds
+// the deltas of ids it hadn't seen. Markers older than the dedup window are
+// pruned as the season is written, which bounds the set to the window's rows.
+//
+// Anything that replaces the board wholesale (season drop, rebuild, snapshot
+// restore) clears the markers with it: a row whose commit was lost is pending
+// again, so it isn't in the new board and has to be applied once more.
+
+func AppliedKey(seasonID string) string {
+	return "lbctl:applied:" + SeasonTag(seasonID)
+}
+
+// KEYS[1] board, KEYS[2] markers; ARGV: member, now, prune cutoff, ttl (s), tie
+// fraction (0 for a plain board, see tiebreak.go), then id/delta pairs.
+// Returns the member's score after the call.
+var ApplyDeltasScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[3])
+local total, fresh = 0, 0
+for i = 6, #ARGV, 2 do
+  if redis.call('ZADD', KEYS[2], 'NX', ARGV[2], ARGV[i]) == 1 then
+    total = total + tonumber(ARGV[i + 1])
+    fresh = fresh + 1
+  end
+end
+redis.call('EXPIRE', KEYS[2], ARGV[4])
+if fresh == 0 then
+  return redis.call('ZSCORE', KEYS[1], ARGV[1]) or '0'
+end
+local tie = tonumber(ARGV[5])
+if tie == 0 then
+  return redis.call('ZINCRBY', KEYS[1], total, ARGV[1])
+end
+local v = math.floor(tonumber(redis.call('ZSCORE', KEYS[1], ARGV[1]) or '0')) + total + tie
+redis.call('ZADD', KEYS[1], v, ARGV[1])
+return string.format('%.17g', v)
+`)
+
+// ApplyDeltasArgs builds the script arguments for one coalesced (season, user)
+// op; tie is its TieFraction, or 0.
+func ApplyDeltasArgs(userID string, ids, deltas []int64, now time.Time, window time.Duration, tie float64) []any {
+	args := make([]any, 0, 5+2*len(ids))
+	args = append(args, userID, now.UnixMilli(), now.Add(-window).UnixMilli(), int64(window/time.Second)+1, tie)
+	for i, id := range ids {
+		args = append(args, strconv.FormatInt(id, 10), deltas[i])
+	}
+	return args
+}
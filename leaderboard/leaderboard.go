@@ -0,0 +1,103 @@
+// Package leaderboard is the season ranking engine without the HTTP server:
+// scores are recorded in an event ledger (package ledger), applied to a rank
+// store by an outbox worker (package outbox), and read back from the store.
+// The leaderboard-go binary builds its API on the same pieces; another Go
+// service can embed an Engine to submit and read scores without running HTTP.
+//
+// With the Postgres ledger, outbox.Worker applies the submissions; the
+// binary runs the same worker with its own features (boosts, cheat holds,
+// webhooks, streams) added through outbox.Hooks, and a RedisStore with board
+// size caps.
+package leaderboard
+
+import (
+	"context"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// Entry is one board member.
+type Entry struct {
+	UserID string  `json:"userId"`
+	Score  float64 `json:"score"`
+	Rank   int64   `json:"rank"` // 1-based
+}
+
+// RankStore holds the boards: one sorted set of users by score per season,
+// ties ordered by user ID descending.
+type RankStore interface {
+	// Apply adds deltas to a user's score and returns the new score. ids are
+	// the outbox rows carrying them; a store may use them to skip rows it
+	// has already applied. at is when the latest of them was recorded, which
+	// a store breaking ties by who got there first ranks by.
+	Apply(ctx context.Context, seasonID, userID string, ids, deltas []int64, at time.Time) (float64, error)
+	// Remove takes a user off a season's board.
+	Remove(ctx context.Context, seasonID, userID string) error
+	// Drop removes a season's board.
+	Drop(ctx context.Context, seasonID string) error
+	// Top returns limit entries from 0-based offset, highest score first.
+	Top(ctx context.Context, seasonID string, offset, limit int64) ([]Entry, error)
+	// Rank returns a user's entry; ok is false for a user not on the board.
+	Rank(ctx context.Context, seasonID, userID string) (e Entry, ok bool, err error)
+	// Around returns up to n entries either side of a user and the user.
+	Around(ctx context.Context, seasonID, userID string, n int64) ([]Entry, error)
+	// Size returns the number of members on a season's board.
+	Size(ctx context.Context, seasonID string) (int64, error)
+}
+
+// SeasonClosedError is returned by Engine.Submit for a frozen or archived
+// season.
+type SeasonClosedError struct{ Status string }
+
+func (e *SeasonClosedError) Error() string { return "season is " + e.Status }
+
+// Engine records submissions in a ledger and reads boards from a rank store.
+// With ledger.Memory and ledger.SQLite a submission is on the board when
+// Submit returns; with Postgres and DynamoDB it gets there through the
+// outbox worker.
+type Engine struct {
+	ledger ledger.Ledger
+	store  RankStore
+}
+
+func NewEngine(l ledger.Ledger, store RankStore) *Engine {
+	return &Engine{ledger: l, store: store}
+}
+
+// Submit records a score change and returns its ledger event id. It reads
+// the season first like the binary does, which also creates a missing
+// season row on ledgers that lock it in Record (ledger.Postgres).
+func (e *Engine) Submit(ctx context.Context, s ledger.Submission) (int64, error) {
+	season, err := e.ledger.Season(ctx, s.SeasonID)
+	if err != nil {
+		return 0, err
+	}
+	if ledger.Closed(season.Status) {
+		return 0, &SeasonClosedError{Status: season.Status}
+	}
+	r, err := e.ledger.Record(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	if r.EventID == 0 {
+		return 0, &SeasonClosedError{Status: r.SeasonStatus}
+	}
+	return r.EventID, nil
+}
+
+func (e *Engine) Top(ctx context.Context, seasonID string, offset, limit int64) ([]Entry, error) {
+	return e.store.Top(ctx, seasonID, offset, limit)
+}
+
+func (e *Engine) Rank(ctx context.Context, seasonID, userID string) (Entry, bool, error) {
+	return e.store.Rank(ctx, seasonID, userID)
+}
+
+func (e *Engine) Around(ctx context.Context, seasonID, userID string, n int64) ([]Entry, error) {
+	return e.store.Around(ctx, seasonID, userID, n)
+}
+
+func (e *Engine) Size(ctx context.Context, seasonID string) (int64, error) {
+	return e.store.Size(ctx, seasonID)
+}
@@ -0,0 +1,167 @@
+package leaderboard
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// MemoryStore is a RankStore kept in process memory, for tests, demos and CI
+// runs without Redis. Each board is a slice sorted like a Redis ZREVRANGE
+// (score descending, then user ID descending) plus a score per user; an
+// update moves the user's entry by binary search, so writes are O(n) in the
+// board size and reads are O(log n) plus the page. Ties stay in user ID
+// order, so Apply doesn't use at.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	boards map[string]*memoryBoard
+}
+
+type memoryBoard struct {
+	sorted []Entry // Rank unset; it's the index + 1
+	scores map[string]float64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{boards: make(map[string]*memoryBoard)}
+}
+
+// NewMemoryEngine returns an Engine on a Memory ledger and a MemoryStore, with
+// every submission applied before Submit returns.
+func NewMemoryEngine() (*Engine, *ledger.Memory, *MemoryStore) {
+	store := NewMemoryStore()
+	l := ledger.NewMemory(func(ctx context.Context, ev ledger.Event) error {
+		_, err := store.Apply(ctx, ev.SeasonID, ev.UserID, []int64{ev.ID}, []int64{ev.Delta}, ev.CreatedAt)
+		return err
+	})
+	return NewEngine(l, store), l, store
+}
+
+// before reports whether a ranks above b.
+func before(a, b Entry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.UserID > b.UserID
+}
+
+// find is the index of e's position in the board.
+func (b *memoryBoard) find(e Entry) int {
+	return sort.Search(len(b.sorted), func(i int) bool { return !before(b.sorted[i], e) })
+}
+
+// Apply doesn't track ids: nothing is replayed into a memory store.
+func (s *MemoryStore) Apply(ctx context.Context, seasonID, userID string, ids, deltas []int64, at time.Time) (float64, error) {
+	var total int64
+	for _, d := range deltas {
+		total += d
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.boards[seasonID]
+	if b == nil {
+		b = &memoryBoard{scores: make(map[string]float64)}
+		s.boards[seasonID] = b
+	}
+	old, ok := b.scores[userID]
+	if ok {
+		i := b.find(Entry{UserID: userID, Score: old})
+		b.sorted = append(b.sorted[:i], b.sorted[i+1:]...)
+	}
+	e := Entry{UserID: userID, Score: old + float64(total)}
+	i := b.find(e)
+	b.sorted = append(b.sorted, Entry{})
+	copy(b.sorted[i+1:], b.sorted[i:])
+	b.sorted[i] = e
+	b.scores[userID] = e.Score
+	return e.Score, nil
+}
+
+func (s *MemoryStore) Remove(ctx context.Context, seasonID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.boards[seasonID]
+	if b == nil {
+		return nil
+	}
+	if old, ok := b.scores[userID]; ok {
+		i := b.find(Entry{UserID: userID, Score: old})
+		b.sorted = append(b.sorted[:i], b.sorted[i+1:]...)
+		delete(b.scores, userID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Drop(ctx context.Context, seasonID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.boards, seasonID)
+	return nil
+}
+
+func (s *MemoryStore) Top(ctx context.Context, seasonID string, offset, limit int64) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := s.boards[seasonID]
+	if b == nil || offset < 0 || limit <= 0 {
+		return []Entry{}, nil
+	}
+	return b.page(offset, offset+limit), nil
+}
+
+func (s *MemoryStore) Rank(ctx context.Context, seasonID, userID string) (Entry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := s.boards[seasonID]
+	if b == nil {
+		return Entry{}, false, nil
+	}
+	score, ok := b.scores[userID]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	i := b.find(Entry{UserID: userID, Score: score})
+	return Entry{UserID: userID, Score: score, Rank: int64(i) + 1}, true, nil
+}
+
+func (s *MemoryStore) Around(ctx context.Context, seasonID, userID string, n int64) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := s.boards[seasonID]
+	if b == nil {
+		return []Entry{}, nil
+	}
+	score, ok := b.scores[userID]
+	if !ok {
+		return []Entry{}, nil
+	}
+	i := int64(b.find(Entry{UserID: userID, Score: score}))
+	return b.page(max(i-n, 0), i+n+1), nil
+}
+
+func (s *MemoryStore) Size(ctx context.Context, seasonID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b := s.boards[seasonID]; b != nil {
+		return int64(len(b.sorted)), nil
+	}
+	return 0, nil
+}
+
+// page copies entries [from, to) with their ranks.
+func (b *memoryBoard) page(from, to int64) []Entry {
+	to = min(to, int64(len(b.sorted)))
+	if from >= to {
+		return []Entry{}
+	}
+	out := make([]Entry, to-from)
+	for i := range out {
+		out[i] = b.sorted[from+int64(i)]
+		out[i].Rank = from + int64(i) + 1
+	}
+	return out
+}
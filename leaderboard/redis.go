@@ -0,0 +1,196 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore is a RankStore on the binary's boards: a sorted set per season
+// under BoardKey. With RedisOptions.TieBreak, Apply adds the tie fraction of
+// the change's time (see tiebreak.go); scores read back are points (see
+// Points) either way. With a non-zero dedup window, Apply goes through
+// ApplyDeltasScript so a batch replayed after a lost commit isn't applied
+// twice. Apply is a one-change ApplyChanges (batch.go), so boards with Caps
+// are trimmed after it too.
+type RedisStore struct {
+	rdb  redis.UniversalClient
+	opts RedisOptions
+}
+
+// RedisOptions configures a RedisStore. A board must be written with one
+// TieBreak setting throughout; switching means rebuilding it.
+type RedisOptions struct {
+	DedupWindow time.Duration // 0 = apply without markers (see keys.go)
+	TieBreak    bool          // rank equal points by who reached them first
+
+	// Caps returns the board size cap of each season (0 or absent: none).
+	// Nil leaves every board uncapped.
+	Caps func(ctx context.Context, seasonIDs []string) (map[string]int64, error)
+	// Changed is queued once per season after the writes that change its
+	// board, e.g. to bump a version clients revalidate against.
+	Changed func(ctx context.Context, pipe redis.Pipeliner, seasonID string, at time.Time)
+	// OnTrimError gets a failed trim; the changes before it stay applied.
+	OnTrimError func(err error)
+}
+
+func NewRedisStore(rdb redis.UniversalClient, opts RedisOptions) *RedisStore {
+	return &RedisStore{rdb: rdb, opts: opts}
+}
+
+// tie is the tie fraction of a change at t, 0 without TieBreak.
+func (s *RedisStore) tie(t time.Time) float64 {
+	if !s.opts.TieBreak {
+		return 0
+	}
+	return TieFraction(t)
+}
+
+func (s *RedisStore) Apply(ctx context.Context, seasonID, userID string, ids, deltas []int64, at time.Time) (float64, error) {
+	c := &Change{Kind: ChangeApply, SeasonID: seasonID, UserID: userID, IDs: ids, Deltas: deltas, At: at}
+	if err := s.ApplyChanges(ctx, []*Change{c}, nil); err != nil {
+		return 0, err
+	}
+	return c.Score, c.Err
+}
+
+// queueApply queues one ChangeApply on pipe; now is the apply time the dedup
+// markers get. Scripts go as EVALSHA: on a NOSCRIPT reply nothing of the
+// call ran, so LoadScripts and send it again. applyScore reads the result.
+func (s *RedisStore) queueApply(ctx context.Context, pipe redis.Pipeliner, seasonID, userID string, ids, deltas []int64, at, now time.Time) redis.Cmder {
+	key := BoardKey(seasonID)
+	tie := s.tie(at)
+	if s.opts.DedupWindow > 0 {
+		return ApplyDeltasScript.EvalSha(ctx, pipe, []string{key, AppliedKey(seasonID)},
+			ApplyDeltasArgs(userID, ids, deltas, now, s.opts.DedupWindow, tie)...)
+	}
+	var total int64
+	for _, d := range deltas {
+		total += d
+	}
+	if tie > 0 {
+		return TieIncrScript.EvalSha(ctx, pipe, []string{key}, userID, total, tie)
+	}
+	return pipe.ZIncrBy(ctx, key, float64(total), userID)
+}
+
+// applyScore is the new score, in points, of a change queueApply queued.
+func applyScore(cmd redis.Cmder) (float64, error) {
+	var score float64
+	var err error
+	switch cmd := cmd.(type) {
+	case *redis.FloatCmd:
+		score, err = cmd.Result()
+	case *redis.Cmd:
+		score, err = cmd.Float64()
+	default:
+		err = fmt.Errorf("unexpected apply command %T", cmd)
+	}
+	return Points(score), err
+}
+
+// LoadScripts loads the scripts queueApply may call into the script cache,
+// e.g. after a restart or failover flushed it.
+func (s *RedisStore) LoadScripts(ctx context.Context) error {
+	for _, script := range []*redis.Script{ApplyDeltasScript, TieIncrScript} {
+		if err := script.Load(ctx, s.rdb).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) Remove(ctx context.Context, seasonID, userID string) error {
+	return s.rdb.ZRem(ctx, BoardKey(seasonID), userID).Err()
+}
+
+func (s *RedisStore) Drop(ctx context.Context, seasonID string) error {
+	return s.rdb.Del(ctx, BoardKey(seasonID), AppliedKey(seasonID)).Err()
+}
+
+func (s *RedisStore) Top(ctx context.Context, seasonID string, offset, limit int64) ([]Entry, error) {
+	if limit <= 0 {
+		return []Entry{}, nil
+	}
+	zs, err := s.rdb.ZRevRangeWithScores(ctx, BoardKey(seasonID), offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return entries(zs, offset+1), nil
+}
+
+func (s *RedisStore) Rank(ctx context.Context, seasonID, userID string) (Entry, bool, error) {
+	pipe := s.rdb.Pipeline()
+	rank := pipe.ZRevRank(ctx, BoardKey(seasonID), userID)
+	score := pipe.ZScore(ctx, BoardKey(seasonID), userID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return Entry{}, false, nil
+		}
+		return Entry{}, false, err
+	}
+	return Entry{UserID: userID, Score: Points(score.Val()), Rank: rank.Val() + 1}, true, nil
+}
+
+func (s *RedisStore) Around(ctx context.Context, seasonID, userID string, n int64) ([]Entry, error) {
+	entries, err := ReadAround(ctx, s.rdb, BoardKey(seasonID), userID, n)
+	if errors.Is(err, redis.Nil) {
+		return []Entry{}, nil
+	}
+	return entries, err
+}
+
+// AroundScript reads a member's rank and the page around it in one call, so
+// the page can't come from a board that moved after the rank was read.
+// KEYS[1] board; ARGV: member, n. Returns nil for a member not on the board,
+// else {0-based rank of the first entry, {member, score, ...}}.
+var AroundScript = redis.NewScript(`
+local r = redis.call('ZREVRANK', KEYS[1], ARGV[1])
+if not r then
+  return false
+end
+local start = math.max(r - tonumber(ARGV[2]), 0)
+return {start, redis.call('ZREVRANGE', KEYS[1], start, r + tonumber(ARGV[2]), 'WITHSCORES')}
+`)
+
+// ReadAround returns up to n entries either side of a member of board key and
+// the member, with AroundScript; redis.Nil for a member not on the board.
+// Scores are points. It only reads, so c may be a replica.
+func ReadAround(ctx context.Context, c redis.Scripter, key, userID string, n int64) ([]Entry, error) {
+	v, err := AroundScript.Run(ctx, c, []string{key}, userID, n).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != 2 {
+		return nil, fmt.Errorf("around script: unexpected reply %v", v)
+	}
+	start, _ := v[0].(int64)
+	flat, _ := v[1].([]any)
+	out := make([]Entry, 0, len(flat)/2)
+	for i := 0; i+1 < len(flat); i += 2 {
+		uid, _ := flat[i].(string)
+		score, err := strconv.ParseFloat(fmt.Sprint(flat[i+1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("around script: score of %q: %w", uid, err)
+		}
+		out = append(out, Entry{UserID: uid, Score: Points(score), Rank: start + int64(len(out)) + 1})
+	}
+	return out, nil
+}
+
+func (s *RedisStore) Size(ctx context.Context, seasonID string) (int64, error) {
+	return s.rdb.ZCard(ctx, BoardKey(seasonID)).Result()
+}
+
+func entries(zs []redis.Z, firstRank int64) []Entry {
+	out := make([]Entry, len(zs))
+	for i, z := range zs {
+		uid, _ := z.Member.(string)
+		out[i] = Entry{UserID: uid, Score: Points(z.Score), Rank: firstRank + int64(i)}
+	}
+	return out
+}
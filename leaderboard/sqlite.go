@@ -0,0 +1,144 @@
+package leaderboard
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// SQLiteStore is a RankStore in a SQLite table, for single-node deployments
+// of a few thousand players per season. The board is a table indexed by
+// (season, score, user), so a rank is an index range count: fine at that
+// size, linear in the rank beyond it. Ties stay in user ID order, so Apply
+// doesn't use at.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore returns a store on db, creating its table if needed.
+func NewSQLiteStore(ctx context.Context, db *sql.DB) (*SQLiteStore, error) {
+	if _, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS board (
+	  season_id TEXT NOT NULL,
+	  user_id   TEXT NOT NULL,
+	  score     INTEGER NOT NULL,
+	  PRIMARY KEY (season_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_board_rank ON board (season_id, score DESC, user_id DESC);
+`); err != nil {
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// NewSQLiteEngine returns an Engine on a SQLite ledger and a SQLiteStore
+// sharing db, each submission applied in the transaction that records it.
+func NewSQLiteEngine(ctx context.Context, db *sql.DB) (*Engine, *ledger.SQLite, *SQLiteStore, error) {
+	store, err := NewSQLiteStore(ctx, db)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	l, err := ledger.NewSQLite(ctx, db, func(ctx context.Context, tx *sql.Tx, ev ledger.Event) error {
+		_, err := applySQLite(ctx, tx, ev.SeasonID, ev.UserID, ev.Delta)
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return NewEngine(l, store), l, store, nil
+}
+
+type sqliteQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func applySQLite(ctx context.Context, q sqliteQuerier, seasonID, userID string, delta int64) (float64, error) {
+	var score int64
+	err := q.QueryRowContext(ctx, `
+	INSERT INTO board (season_id, user_id, score) VALUES (?, ?, ?)
+	ON CONFLICT (season_id, user_id) DO UPDATE SET score = score + excluded.score
+	RETURNING score
+`, seasonID, userID, delta).Scan(&score)
+	return float64(score), err
+}
+
+// Apply doesn't track ids: the ledger applies each event in its own
+// transaction, so nothing is replayed.
+func (s *SQLiteStore) Apply(ctx context.Context, seasonID, userID string, ids, deltas []int64, at time.Time) (float64, error) {
+	var total int64
+	for _, d := range deltas {
+		total += d
+	}
+	return applySQLite(ctx, s.db, seasonID, userID, total)
+}
+
+func (s *SQLiteStore) Remove(ctx context.Context, seasonID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM board WHERE season_id=? AND user_id=?`, seasonID, userID)
+	return err
+}
+
+func (s *SQLiteStore) Drop(ctx context.Context, seasonID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM board WHERE season_id=?`, seasonID)
+	return err
+}
+
+func (s *SQLiteStore) Top(ctx context.Context, seasonID string, offset, limit int64) ([]Entry, error) {
+	if offset < 0 || limit <= 0 {
+		return []Entry{}, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT user_id, score FROM board WHERE season_id=?
+	ORDER BY score DESC, user_id DESC
+	LIMIT ? OFFSET ?
+`, seasonID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var score int64
+		if err := rows.Scan(&e.UserID, &score); err != nil {
+			return nil, err
+		}
+		e.Score = float64(score)
+		e.Rank = offset + int64(len(out)) + 1
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) Rank(ctx context.Context, seasonID, userID string) (Entry, bool, error) {
+	var score, above int64
+	err := s.db.QueryRowContext(ctx, `
+	SELECT b.score, (SELECT COUNT(*) FROM board o
+	                 WHERE o.season_id = b.season_id
+	                   AND (o.score > b.score OR (o.score = b.score AND o.user_id > b.user_id)))
+	FROM board b WHERE b.season_id=? AND b.user_id=?
+`, seasonID, userID).Scan(&score, &above)
+	if err == sql.ErrNoRows {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, err
+	}
+	return Entry{UserID: userID, Score: float64(score), Rank: above + 1}, true, nil
+}
+
+func (s *SQLiteStore) Around(ctx context.Context, seasonID, userID string, n int64) ([]Entry, error) {
+	e, ok, err := s.Rank(ctx, seasonID, userID)
+	if err != nil || !ok {
+		return []Entry{}, err
+	}
+	start := max(e.Rank-1-n, 0)
+	return s.Top(ctx, seasonID, start, e.Rank+n-start)
+}
+
+func (s *SQLiteStore) Size(ctx context.Context, seasonID string) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM board WHERE season_id=?`, seasonID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,48 @@
+package leaderboard
+
+import (
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// First-reached tie-breaking. A board normally stores a user's points as the
+// score, and Redis orders equal scores by member. With tie-breaking on, the
+// score is points + TieFraction(t), t being when the user's points last
+// changed: the fraction is in (0, 0.5] and shrinks as t grows, so among equal
+// points whoever got there first ranks higher. Points reads the points back;
+// points are integers, so it's a no-op on a plain board.
+//
+// The fraction counts seconds from 2024-01-01 UTC over 2^32 s (~136 years). A
+// float64 keeps second resolution for points below 2^19 in magnitude and a
+// coarser one above, where users reaching the same points within one step
+// fall back to member order.
+
+var tieEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+// TieFraction is the fraction added to points last changed at t.
+func TieFraction(t time.Time) float64 {
+	s := min(max(t.Unix()-tieEpoch, 0), 1<<32-1)
+	return float64(1<<32-s) / (1 << 33)
+}
+
+// TieScore is the board score of points last changed at t.
+func TieScore(points int64, t time.Time) float64 {
+	return float64(points) + TieFraction(t)
+}
+
+// Points is the points of a board score, with or without a tie fraction.
+func Points(score float64) float64 {
+	return math.Floor(score)
+}
+
+// TieIncrScript adds a delta to a member's points and sets its tie fraction,
+// where a plain board would ZINCRBY. KEYS[1] board; ARGV: member, delta,
+// fraction. Returns the new score.
+var TieIncrScript = redis.NewScript(`
+local old = redis.call('ZSCORE', KEYS[1], ARGV[1])
+local v = math.floor(tonumber(old or '0')) + tonumber(ARGV[2]) + tonumber(ARGV[3])
+redis.call('ZADD', KEYS[1], v, ARGV[1])
+return string.format('%.17g', v)
+`)
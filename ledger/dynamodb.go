@@ -0,0 +1,393 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DynamoDB is a Ledger on Amazon DynamoDB, for deployments on AWS serverless
+// stacks that don't run Postgres. It keeps three tables (see CreateTables):
+//
+//   - Seasons, keyed by season_id: status, submit_limit_per_minute and the
+//     season's event id counter.
+//   - Events, keyed by (season_id, id): the score_events rows.
+//   - Outbox, keyed by (shard, sk): the score_delta items an
+//     outbox.DynamoDBWorker applies to the rank store. Items are spread over
+//     Shards partitions by user; sk is the season and event id.
+//
+// Record takes the next event id from the season's counter in a conditional
+// update that also refuses a frozen or archived season, then writes the event
+// and its outbox item in one transaction. Event ids are per season, and an id
+// is skipped when that transaction fails after the counter moved.
+type DynamoDB struct {
+	c      *DynamoDBClient
+	Tables DynamoDBTables
+	Shards int
+}
+
+// DynamoDBTables names the ledger's tables.
+type DynamoDBTables struct {
+	Seasons string
+	Events  string
+	Outbox  string
+}
+
+// NewDynamoDB returns a ledger on tables with 4 outbox shards. The tables must
+// exist.
+func NewDynamoDB(c *DynamoDBClient, tables DynamoDBTables) *DynamoDB {
+	return &DynamoDB{c: c, Tables: tables, Shards: 4}
+}
+
+func (l *DynamoDB) Client() *DynamoDBClient { return l.c }
+
+// Shard is the outbox shard of a user's submissions in a season.
+func (l *DynamoDB) Shard(seasonID, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(seasonID + "\x00" + userID))
+	return int(h.Sum32() % uint32(l.Shards))
+}
+
+// OutboxSK is the outbox sort key of an event: the season, then the id padded
+// so a shard reads in id order within a season.
+func OutboxSK(seasonID string, eventID int64) string {
+	return fmt.Sprintf("%s#%020d", seasonID, eventID)
+}
+
+func (l *DynamoDB) Season(ctx context.Context, seasonID string) (Season, error) {
+	var out struct{ Item DynamoDBItem }
+	if err := l.c.Do(ctx, "GetItem", map[string]any{
+		"TableName":            l.Tables.Seasons,
+		"Key":                  DynamoDBItem{"season_id": DynamoDBString(seasonID)},
+		"ProjectionExpression": "#status, submit_limit_per_minute",
+		"ExpressionAttributeNames": map[string]string{
+			"#status": "status",
+		},
+	}, &out); err != nil {
+		return Season{}, err
+	}
+	return seasonFromItem(out.Item), nil
+}
+
+func seasonFromItem(item DynamoDBItem) Season {
+	s := Season{Status: item["status"].S}
+	if v, ok := item["submit_limit_per_minute"]; ok && v.N != "" {
+		n := v.Int()
+		s.SubmitLimit = &n
+	}
+	return s
+}
+
+// SetSeason sets a season's status and submit limit, e.g. to freeze it.
+func (l *DynamoDB) SetSeason(ctx context.Context, seasonID string, s Season) error {
+	status := s.Status
+	if status == "" {
+		status = "active"
+	}
+	in := map[string]any{
+		"TableName":                l.Tables.Seasons,
+		"Key":                      DynamoDBItem{"season_id": DynamoDBString(seasonID)},
+		"UpdateExpression":         "SET #status = :status REMOVE submit_limit_per_minute",
+		"ExpressionAttributeNames": map[string]string{"#status": "status"},
+		"ExpressionAttributeValues": DynamoDBItem{
+			":status": DynamoDBString(status),
+		},
+	}
+	if s.SubmitLimit != nil {
+		in["UpdateExpression"] = "SET #status = :status, submit_limit_per_minute = :limit"
+		in["ExpressionAttributeValues"].(DynamoDBItem)[":limit"] = DynamoDBNumber(*s.SubmitLimit)
+	}
+	return l.c.Do(ctx, "UpdateItem", in, nil)
+}
+
+func (l *DynamoDB) Record(ctx context.Context, s Submission) (Recorded, error) {
+	var next struct{ Attributes DynamoDBItem }
+	err := l.c.Do(ctx, "UpdateItem", map[string]any{
+		"TableName":                           l.Tables.Seasons,
+		"Key":                                 DynamoDBItem{"season_id": DynamoDBString(s.SeasonID)},
+		"UpdateExpression":                    "SET next_event_id = if_not_exists(next_event_id, :zero) + :one",
+		"ConditionExpression":                 "attribute_not_exists(#status) OR NOT (#status IN (:frozen, :archived))",
+		"ExpressionAttributeNames":            map[string]string{"#status": "status"},
+		"ReturnValues":                        "ALL_NEW",
+		"ReturnValuesOnConditionCheckFailure": "ALL_OLD",
+		"ExpressionAttributeValues": DynamoDBItem{
+			":zero":     DynamoDBNumber(0),
+			":one":      DynamoDBNumber(1),
+			":frozen":   DynamoDBString("frozen"),
+			":archived": DynamoDBString("archived"),
+		},
+	}, &next)
+	var derr *DynamoDBError
+	if errors.As(err, &derr) && derr.Type == "ConditionalCheckFailedException" {
+		return Recorded{SeasonStatus: derr.Item["status"].S}, nil
+	}
+	if err != nil {
+		return Recorded{}, err
+	}
+	id := next.Attributes["next_event_id"].Int()
+	status := next.Attributes["status"].S
+
+	now := time.Now().UTC()
+	event := DynamoDBItem{
+		"season_id":  DynamoDBString(s.SeasonID),
+		"id":         DynamoDBNumber(id),
+		"user_id":    DynamoDBString(s.UserID),
+		"delta":      DynamoDBNumber(s.Delta),
+		"created_at": DynamoDBString(now.Format(time.RFC3339Nano)),
+	}
+	if s.RequestID != "" {
+		event["request_id"] = DynamoDBString(s.RequestID)
+	}
+	// The same shape as the Postgres ledger's payload (outbox.Payload).
+	payload, err := json.Marshal(map[string]any{
+		"seasonId":    s.SeasonID,
+		"userId":      s.UserID,
+		"delta":       s.Delta,
+		"eventId":     id,
+		"traceparent": s.Traceparent,
+	})
+	if err != nil {
+		return Recorded{}, err
+	}
+	if err := l.c.Do(ctx, "TransactWriteItems", map[string]any{
+		"TransactItems": []map[string]any{
+			{"Put": map[string]any{
+				"TableName":           l.Tables.Events,
+				"Item":                event,
+				"ConditionExpression": "attribute_not_exists(season_id)",
+			}},
+			{"Put": map[string]any{
+				"TableName": l.Tables.Outbox,
+				"Item": DynamoDBItem{
+					"shard":      DynamoDBNumber(int64(l.Shard(s.SeasonID, s.UserID))),
+					"sk":         DynamoDBString(OutboxSK(s.SeasonID, id)),
+					"event_type": DynamoDBString("score_delta"),
+					"payload":    DynamoDBString(string(payload)),
+					"attempts":   DynamoDBNumber(0),
+					"created_at": DynamoDBNumber(now.UnixMilli()),
+				},
+			}},
+		},
+	}, nil); err != nil {
+		return Recorded{}, err
+	}
+	return Recorded{EventID: id, SeasonStatus: status}, nil
+}
+
+// CreateTables creates the ledger's tables with on-demand capacity, leaving
+// any that already exist. Deployments that manage tables elsewhere (e.g.
+// CloudFormation) don't need it.
+func (l *DynamoDB) CreateTables(ctx context.Context) error {
+	type key struct{ name, kind, typ string }
+	tables := []struct {
+		name string
+		keys []key
+	}{
+		{l.Tables.Seasons, []key{{"season_id", "HASH", "S"}}},
+		{l.Tables.Events, []key{{"season_id", "HASH", "S"}, {"id", "RANGE", "N"}}},
+		{l.Tables.Outbox, []key{{"shard", "HASH", "N"}, {"sk", "RANGE", "S"}}},
+	}
+	for _, t := range tables {
+		var schema, attrs []map[string]string
+		for _, k := range t.keys {
+			schema = append(schema, map[string]string{"AttributeName": k.name, "KeyType": k.kind})
+			attrs = append(attrs, map[string]string{"AttributeName": k.name, "AttributeType": k.typ})
+		}
+		err := l.c.Do(ctx, "CreateTable", map[string]any{
+			"TableName":            t.name,
+			"KeySchema":            schema,
+			"AttributeDefinitions": attrs,
+			"BillingMode":          "PAY_PER_REQUEST",
+		}, nil)
+		var derr *DynamoDBError
+		if errors.As(err, &derr) && derr.Type == "ResourceInUseException" {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("create table %s: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+// DynamoDBClient calls the DynamoDB JSON API with SigV4-signed requests. Like
+// the binary's other AWS client (S3 archival) it only needs the standard
+// library; credentials are static keys, plus a session token for temporary
+// ones (Lambda, ECS task roles).
+type DynamoDBClient struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// NewDynamoDBClient returns a client for region, on endpoint if set (e.g.
+// DynamoDB Local) or the regional AWS endpoint.
+func NewDynamoDBClient(endpoint, region, accessKey, secretKey, sessionToken string) (*DynamoDBClient, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid dynamodb endpoint %q", endpoint)
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("dynamodb credentials are required")
+	}
+	return &DynamoDBClient{
+		endpoint:     u,
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// DynamoDBValue is an attribute value; only the types the ledger uses.
+type DynamoDBValue struct {
+	S string `json:"S,omitempty"`
+	N string `json:"N,omitempty"`
+}
+
+// DynamoDBItem is an item, or a key or expression values.
+type DynamoDBItem map[string]DynamoDBValue
+
+func DynamoDBString(s string) DynamoDBValue { return DynamoDBValue{S: s} }
+
+func DynamoDBNumber(n int64) DynamoDBValue { return DynamoDBValue{N: strconv.FormatInt(n, 10)} }
+
+// Int is a number value, or 0.
+func (v DynamoDBValue) Int() int64 {
+	n, _ := strconv.ParseInt(v.N, 10, 64)
+	return n
+}
+
+// DynamoDBError is an error response. Type is the exception name, e.g.
+// ConditionalCheckFailedException; Item is the old item a failed condition
+// returns with ReturnValuesOnConditionCheckFailure.
+type DynamoDBError struct {
+	Status  int
+	Type    string
+	Message string
+	Item    DynamoDBItem
+}
+
+func (e *DynamoDBError) Error() string {
+	return fmt.Sprintf("dynamodb: %s: %s (%d)", e.Type, e.Message, e.Status)
+}
+
+// Do calls operation op (e.g. "PutItem") with request in and decodes the
+// response into out, which may be nil.
+func (c *DynamoDBClient) Do(ctx context.Context, op string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	c.sign(req, body, time.Now().UTC())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var e struct {
+			Type     string `json:"__type"`
+			Message  string `json:"message"`
+			MessageU string `json:"Message"`
+			Item     DynamoDBItem
+		}
+		_ = json.Unmarshal(msg, &e)
+		derr := &DynamoDBError{Status: resp.StatusCode, Type: e.Type, Message: e.Message, Item: e.Item}
+		if i := strings.LastIndexByte(derr.Type, '#'); i >= 0 {
+			derr.Type = derr.Type[i+1:]
+		}
+		if derr.Message == "" {
+			derr.Message = e.MessageU
+		}
+		if derr.Type == "" {
+			derr.Message = strings.TrimSpace(string(msg))
+		}
+		return derr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *DynamoDBClient) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-date;x-amz-target"
+	headers := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + req.URL.Host + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+		signedHeaders = "content-type;host;x-amz-date;x-amz-security-token;x-amz-target"
+		headers += "x-amz-security-token:" + c.sessionToken + "\n"
+	}
+	headers += "x-amz-target:" + req.Header.Get("X-Amz-Target") + "\n"
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		"", // no query string
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + c.region + "/dynamodb/aws4_request"
+	csum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(csum[:])
+
+	k := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	k = hmacSHA256(k, c.region)
+	k = hmacSHA256(k, "dynamodb")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,50 @@
+// Package ledger records score submissions. The ledger (score_events) is the
+// source of truth for every board: each submission is appended to it together
+// with the outbox row that later applies it to the rank store, in one
+// statement, so a score is never recorded without being queued or queued
+// without being recorded.
+package ledger
+
+import "context"
+
+// Submission is one score change for a user in a season.
+type Submission struct {
+	SeasonID  string
+	UserID    string
+	Delta     int64
+	RequestID string // stored with the rows for tracing a call; may be empty
+
+	// Traceparent is the submitting request's span, carried in the outbox
+	// payload so the worker continues its trace; may be empty.
+	Traceparent string
+}
+
+// Recorded is the outcome of Record. EventID is the score_events id, or 0
+// when the season is frozen or archived; SeasonStatus is the season's status
+// ("" for a season with no row).
+type Recorded struct {
+	EventID      int64
+	SeasonStatus string
+}
+
+// Season is what a submission needs to know about its season up front.
+// SubmitLimit is the per-user submissions per minute override, nil for the
+// default.
+type Season struct {
+	Status      string
+	SubmitLimit *int64
+}
+
+// Ledger is the event ledger a leaderboard.Engine writes to.
+type Ledger interface {
+	// Season reads a season without locking it; an unknown season is active
+	// with no override. Backends that lock the season in Record may create
+	// it here so there is something to lock.
+	Season(ctx context.Context, seasonID string) (Season, error)
+	// Record appends a submission and queues it for the worker, unless the
+	// season is frozen or archived by then.
+	Record(ctx context.Context, s Submission) (Recorded, error)
+}
+
+// Closed reports whether a season status refuses submissions.
+func Closed(status string) bool { return status == "frozen" || status == "archived" }
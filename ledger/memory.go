@@ -0,0 +1,88 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Event is a recorded submission.
+type Event struct {
+	ID        int64
+	SeasonID  string
+	UserID    string
+	Delta     int64
+	RequestID string
+	CreatedAt time.Time
+}
+
+// Memory is a Ledger kept in a slice, for tests, demos and CI runs without
+// Postgres. It has no outbox: each recorded event is handed to apply
+// straight away, so a board is up to date when Record returns. Nothing
+// survives the process.
+type Memory struct {
+	apply func(context.Context, Event) error
+
+	mu      sync.Mutex
+	events  []Event
+	seasons map[string]Season
+}
+
+// NewMemory returns an empty ledger that passes each recorded event to
+// apply, which may be nil.
+func NewMemory(apply func(context.Context, Event) error) *Memory {
+	return &Memory{apply: apply, seasons: make(map[string]Season)}
+}
+
+func (m *Memory) Season(ctx context.Context, seasonID string) (Season, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.seasons[seasonID], nil
+}
+
+// SetSeason sets a season's status and submit limit, e.g. to freeze it.
+func (m *Memory) SetSeason(seasonID string, s Season) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seasons[seasonID] = s
+}
+
+// Record appends the event and applies it. The lock is held through the
+// apply, so events reach the store in ledger order; an apply error is
+// returned with the event left recorded, as a failed outbox row would be.
+func (m *Memory) Record(ctx context.Context, s Submission) (Recorded, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := m.seasons[s.SeasonID].Status
+	if Closed(status) {
+		return Recorded{SeasonStatus: status}, nil
+	}
+	ev := Event{
+		ID:        int64(len(m.events)) + 1,
+		SeasonID:  s.SeasonID,
+		UserID:    s.UserID,
+		Delta:     s.Delta,
+		RequestID: s.RequestID,
+		CreatedAt: time.Now().UTC(),
+	}
+	m.events = append(m.events, ev)
+	if m.apply != nil {
+		if err := m.apply(ctx, ev); err != nil {
+			return Recorded{}, err
+		}
+	}
+	return Recorded{EventID: ev.ID, SeasonStatus: status}, nil
+}
+
+// Events returns a season's events in the order they were recorded.
+func (m *Memory) Events(seasonID string) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Event
+	for _, ev := range m.events {
+		if ev.SeasonID == seasonID {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
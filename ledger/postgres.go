@@ -0,0 +1,81 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres is the Ledger on the schema in migrations/, talking to Postgres
+// through a native pgx pool: submission is the hottest path, and the binary
+// protocol with cached prepared statements keeps it to two round trips, a
+// plain read of the season and one statement that locks the season row,
+// checks it again and, unless the season was frozen or archived in between,
+// writes the ledger row and its outbox row. The lock only works on a row
+// that exists, so Season creates a missing one with the defaults: a freeze
+// that would otherwise insert it then has to wait for in-flight writes.
+type Postgres struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgres(pool *pgxpool.Pool) *Postgres { return &Postgres{pool: pool} }
+
+const seasonSQL = `SELECT status, submit_limit_per_minute FROM seasons WHERE season_id=$1`
+
+// committed before recordSQL runs, so its FOR SHARE always has a row to lock
+const ensureSeasonSQL = `INSERT INTO seasons (season_id) VALUES ($1) ON CONFLICT (season_id) DO NOTHING`
+
+// $5 is the outbox payload without eventId, which comes from the insert.
+const recordSQL = `
+	WITH season AS (
+	  SELECT status FROM seasons WHERE season_id=$1::text FOR SHARE
+	), ev AS (
+	  INSERT INTO score_events (season_id, user_id, delta, request_id)
+	  SELECT $1::text, $2::text, $3::bigint, NULLIF($4::text, '')
+	  WHERE NOT EXISTS (SELECT 1 FROM season WHERE status IN ('frozen', 'archived'))
+	  RETURNING id
+	), queued AS (
+	  INSERT INTO outbox (event_type, payload, status, request_id, event_id)
+	  SELECT 'score_delta', $5::jsonb || jsonb_build_object('eventId', ev.id), 'pending', NULLIF($4, ''), ev.id
+	  FROM ev
+	)
+	SELECT COALESCE((SELECT status FROM season), ''), (SELECT id FROM ev)
+`
+
+func (p *Postgres) Season(ctx context.Context, seasonID string) (Season, error) {
+	var s Season
+	err := p.pool.QueryRow(ctx, seasonSQL, seasonID).Scan(&s.Status, &s.SubmitLimit)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := p.pool.Exec(ctx, ensureSeasonSQL, seasonID); err != nil {
+			return Season{}, err
+		}
+		return Season{}, nil
+	}
+	return s, err
+}
+
+func (p *Postgres) Record(ctx context.Context, s Submission) (Recorded, error) {
+	// the same payload outbox.Payload decodes
+	body := map[string]any{
+		"seasonId": s.SeasonID,
+		"userId":   s.UserID,
+		"delta":    s.Delta,
+	}
+	if s.Traceparent != "" {
+		body["traceparent"] = s.Traceparent
+	}
+	payload, _ := json.Marshal(body)
+
+	var r Recorded
+	var id *int64
+	if err := p.pool.QueryRow(ctx, recordSQL, s.SeasonID, s.UserID, s.Delta, s.RequestID, payload).Scan(&r.SeasonStatus, &id); err != nil {
+		return Recorded{}, err
+	}
+	if id != nil {
+		r.EventID = *id
+	}
+	return r, nil
+}
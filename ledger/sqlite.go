@@ -0,0 +1,104 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SQLite is a Ledger in a SQLite database, for single-node deployments small
+// enough not to need Postgres and Redis. Like Memory it has no outbox: each
+// event is handed to apply inside the transaction that records it, so the
+// ledger and a board kept in the same database (leaderboard.SQLiteStore)
+// commit together.
+type SQLite struct {
+	db    *sql.DB
+	apply func(context.Context, *sql.Tx, Event) error
+}
+
+// NewSQLite returns a ledger on db, creating its tables if needed. apply, which
+// may be nil, runs in each recording transaction.
+func NewSQLite(ctx context.Context, db *sql.DB, apply func(context.Context, *sql.Tx, Event) error) (*SQLite, error) {
+	if _, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS seasons (
+	  season_id               TEXT PRIMARY KEY,
+	  status                  TEXT NOT NULL DEFAULT 'active',
+	  submit_limit_per_minute INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS score_events (
+	  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	  season_id  TEXT NOT NULL,
+	  user_id    TEXT NOT NULL,
+	  delta      INTEGER NOT NULL,
+	  request_id TEXT,
+	  created_at TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_score_events_season_user ON score_events (season_id, user_id);
+`); err != nil {
+		return nil, err
+	}
+	return &SQLite{db: db, apply: apply}, nil
+}
+
+func (l *SQLite) Season(ctx context.Context, seasonID string) (Season, error) {
+	var s Season
+	err := l.db.QueryRowContext(ctx,
+		`SELECT status, submit_limit_per_minute FROM seasons WHERE season_id=?`, seasonID).Scan(&s.Status, &s.SubmitLimit)
+	if err == sql.ErrNoRows {
+		return Season{}, nil
+	}
+	return s, err
+}
+
+// SetSeason sets a season's status and submit limit, e.g. to freeze it.
+func (l *SQLite) SetSeason(ctx context.Context, seasonID string, s Season) error {
+	status := s.Status
+	if status == "" {
+		status = "active"
+	}
+	_, err := l.db.ExecContext(ctx, `
+	INSERT INTO seasons (season_id, status, submit_limit_per_minute) VALUES (?, ?, ?)
+	ON CONFLICT (season_id) DO UPDATE SET status=excluded.status, submit_limit_per_minute=excluded.submit_limit_per_minute
+`, seasonID, status, s.SubmitLimit)
+	return err
+}
+
+// Record checks the season, appends the event and applies it in one
+// transaction, which the database must start as a write (BEGIN IMMEDIATE) so
+// concurrent submissions queue on the lock instead of failing to upgrade.
+func (l *SQLite) Record(ctx context.Context, s Submission) (Recorded, error) {
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Recorded{}, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE((SELECT status FROM seasons WHERE season_id=?), '')`, s.SeasonID).Scan(&status); err != nil {
+		return Recorded{}, err
+	}
+	if Closed(status) {
+		return Recorded{SeasonStatus: status}, nil
+	}
+	ev := Event{
+		SeasonID:  s.SeasonID,
+		UserID:    s.UserID,
+		Delta:     s.Delta,
+		RequestID: s.RequestID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := tx.QueryRowContext(ctx, `
+	INSERT INTO score_events (season_id, user_id, delta, request_id, created_at)
+	VALUES (?, ?, ?, NULLIF(?, ''), ?)
+	RETURNING id
+`, ev.SeasonID, ev.UserID, ev.Delta, ev.RequestID, ev.CreatedAt.Format(time.RFC3339Nano)).Scan(&ev.ID); err != nil {
+		return Recorded{}, err
+	}
+	if l.apply != nil {
+		if err := l.apply(ctx, tx, ev); err != nil {
+			return Recorded{}, err
+		}
+	}
+	return Recorded{EventID: ev.ID, SeasonStatus: status}, tx.Commit()
+}
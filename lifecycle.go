@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// lifecycle starts components in the order they were added and stops them in
+// reverse, so the HTTP server (added last) stops taking requests before the
+// schedulers and the outbox worker it feeds are shut down. Each component gets
+// its own context; stopping cancels it and waits up to the component's stop
+// timeout before moving on.
+
+type componentState string
+
+const (
+	componentPending  componentState = "pending"
+	componentRunning  componentState = "running"
+	componentStopping componentState = "stopping"
+	componentStopped  componentState = "stopped"
+	componentFailed   componentState = "failed"
+)
+
+type componentStatus struct {
+	Name  string         `json:"name"`
+	State componentState `json:"state"`
+	Error string         `json:"error,omitempty"`
+}
+
+type component struct {
+	name        string
+	stopTimeout time.Duration
+	run         func(ctx context.Context) error // blocks until ctx is cancelled
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu    sync.Mutex
+	state componentState
+	err   error
+}
+
+type lifecycle struct {
+	components []*component
+	failed     chan struct{}
+	failOnce   sync.Once
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{failed: make(chan struct{})}
+}
+
+// add registers a component. run must return once ctx is cancelled; returning
+// early with an error marks the component failed and triggers a shutdown.
+func (lc *lifecycle) add(name string, stopTimeout time.Duration, run func(ctx context.Context) error) {
+	lc.components = append(lc.components, &component{
+		name:        name,
+		stopTimeout: stopTimeout,
+		run:         run,
+		state:       componentPending,
+	})
+}
+
+// loop adapts a background loop that only returns once ctx is cancelled.
+func loop(fn func(ctx context.Context)) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		fn(ctx)
+		return nil
+	}
+}
+
+func (lc *lifecycle) start() {
+	for _, c := range lc.components {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.cancel = cancel
+		c.done = make(chan struct{})
+		c.setState(componentRunning, nil)
+
+		go func(c *component) {
+			defer close(c.done)
+			err := c.run(ctx)
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			switch {
+			case c.state == componentStopping:
+				c.state = componentStopped
+			case err != nil:
+				c.state, c.err = componentFailed, err
+				slog.Error("Component failed", "component", c.name, "err", err)
+				lc.failOnce.Do(func() { close(lc.failed) })
+			default:
+				// a one-shot component (e.g. cache warming) that finished its work
+				c.state = componentStopped
+			}
+		}(c)
+	}
+}
+
+// wait blocks until ctx is done or a component fails.
+func (lc *lifecycle) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutdown signal received")
+	case <-lc.failed:
+	}
+}
+
+// stop shuts components down in reverse start order.
+func (lc *lifecycle) stop() {
+	for i := len(lc.components) - 1; i >= 0; i-- {
+		c := lc.components[i]
+		if c.cancel == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		if c.state == componentRunning {
+			c.state = componentStopping
+		}
+		c.mu.Unlock()
+		c.cancel()
+
+		select {
+		case <-c.done:
+		case <-time.After(c.stopTimeout):
+			slog.Warn("Component did not stop in time", "component", c.name, "timeout", c.stopTimeout.String())
+		}
+	}
+}
+
+func (c *component) setState(s componentState, err error) {
+	c.mu.Lock()
+	c.state, c.err = s, err
+	c.mu.Unlock()
+}
+
+func (lc *lifecycle) status() []componentStatus {
+	out := make([]componentStatus, 0, len(lc.components))
+	for _, c := range lc.components {
+		c.mu.Lock()
+		st := componentStatus{Name: c.name, State: c.state}
+		if c.err != nil {
+			st.Error = c.err.Error()
+		}
+		c.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+// healthy reports whether no component has failed.
+func (lc *lifecycle) healthy() bool {
+	for _, c := range lc.components {
+		c.mu.Lock()
+		failed := c.state == componentFailed
+		c.mu.Unlock()
+		if failed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Live updates: after each committed batch the worker publishes one message
+// per touched season on lbctl:updates:{sid}, and admin changes to a season's
+// settings publish one too. Every API instance subscribes to the whole prefix
+// and uses it to drop in-process caches and to wake SSE feeds and WebSocket
+// sessions watching the season. What changed is read back from the board or
+// Postgres, so a lost message only delays an update until the next one or
+// the cache's own TTL.
+
+const seasonUpdatesPrefix = "lbctl:updates:"
+
+func seasonUpdatesChannel(seasonID string) string { return seasonUpdatesPrefix + seasonID }
+
+// seasonUpdate is the message body.
+type seasonUpdate struct {
+	SeasonID string   `json:"seasonId"`
+	Users    []string `json:"users,omitempty"` // users whose score changed
+	Dropped  bool     `json:"dropped,omitempty"`
+	Settings bool     `json:"settings,omitempty"` // season config (e.g. collation) changed
+}
+
+// publishSeasonUpdates is best-effort: readers fall back to polling and TTLs.
+func publishSeasonUpdates(ctx context.Context, rdb redis.UniversalClient, updates map[string]*seasonUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	pipe := rdb.Pipeline()
+	for sid, u := range updates {
+		b, _ := json.Marshal(u)
+		pipe.Publish(ctx, seasonUpdatesChannel(sid), b)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// publishSettingsChanged tells every instance to drop what it cached about the season's settings.
+func publishSettingsChanged(ctx context.Context, rdb redis.UniversalClient, seasonID string) {
+	u := &seasonUpdate{SeasonID: seasonID, Settings: true}
+	if err := publishSeasonUpdates(ctx, rdb, map[string]*seasonUpdate{seasonID: u}); err != nil {
+		slog.Error("Season update publish error", "err", err)
+	}
+}
+
+// seasonUpdates holds one pattern subscription for the process. Handlers see
+// every message; listeners are called for their season only.
+type seasonUpdates struct {
+	ps *redis.PubSub
+
+	mu        sync.Mutex
+	handlers  []func(seasonUpdate)
+	listeners map[string]map[*func()]struct{}
+}
+
+func newSeasonUpdates(rdb redis.UniversalClient) *seasonUpdates {
+	return &seasonUpdates{
+		ps:        rdb.PSubscribe(context.Background(), seasonUpdatesPrefix+"*"),
+		listeners: make(map[string]map[*func()]struct{}),
+	}
+}
+
+func (su *seasonUpdates) run(ctx context.Context) {
+	defer su.ps.Close()
+	msgs := su.ps.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-msgs:
+			if !ok {
+				return
+			}
+			u := seasonUpdate{SeasonID: strings.TrimPrefix(m.Channel, seasonUpdatesPrefix)}
+			_ = json.Unmarshal([]byte(m.Payload), &u)
+			su.mu.Lock()
+			for _, h := range su.handlers {
+				h(u)
+			}
+			for fn := range su.listeners[u.SeasonID] {
+				(*fn)()
+			}
+			su.mu.Unlock()
+		}
+	}
+}
+
+// onUpdate registers a handler for every message. Like listeners it runs on
+// the dispatch goroutine and must not block.
+func (su *seasonUpdates) onUpdate(h func(seasonUpdate)) {
+	su.mu.Lock()
+	su.handlers = append(su.handlers, h)
+	su.mu.Unlock()
+}
+
+// listen calls fn on every update for the season until the returned stop is
+// called. fn runs on the dispatch goroutine and must not block.
+func (su *seasonUpdates) listen(seasonID string, fn func()) func() {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+
+	ls := su.listeners[seasonID]
+	if ls == nil {
+		ls = make(map[*func()]struct{})
+		su.listeners[seasonID] = ls
+	}
+	key := &fn
+	ls[key] = struct{}{}
+
+	return func() {
+		su.mu.Lock()
+		defer su.mu.Unlock()
+		ls := su.listeners[seasonID]
+		delete(ls, key)
+		if len(ls) == 0 {
+			delete(su.listeners, seasonID)
+		}
+	}
+}
+
+// Rank sessions: a WebSocket client subscribes to (season, user) pairs and is
+// pushed the user's rank and score whenever either changes. Any update to the
+// season can move the user's rank, so every update marks the season dirty and
+// dirty seasons are re-read at most once per interval.
+
+const (
+	rankSessionMaxSubs = 20
+	rankSessionPing    = 30 * time.Second
+)
+
+type rankSubKey struct {
+	seasonID, userID string
+}
+
+type rankSub struct {
+	rank  *int64 // 1-based; nil while not on the board
+	score *float64
+	sent  bool
+}
+
+// rankClientMessage is what clients send.
+type rankClientMessage struct {
+	Action   string `json:"action"` // subscribe/unsubscribe
+	SeasonID string `json:"seasonId"`
+	UserID   string `json:"userId"`
+}
+
+// rankPush is what the server sends for a subscription.
+type rankPush struct {
+	Type     string   `json:"type"` // rank
+	SeasonID string   `json:"seasonId"`
+	UserID   string   `json:"userId"`
+	Rank     *int64   `json:"rank"`
+	Score    *float64 `json:"score"`
+}
+
+func serveRankSession(ctx context.Context, ws *wsConn, updates *seasonUpdates, reads *redisReads, interval time.Duration) {
+	defer ws.close(1001)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			m, err := ws.readMessage(2 * rankSessionPing)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	subs := make(map[rankSubKey]*rankSub)
+	stops := make(map[string]func()) // per season
+	defer func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}()
+
+	var dmu sync.Mutex
+	dirty := make(map[string]struct{})
+	wake := make(chan struct{}, 1)
+	markDirty := func(seasonID string) {
+		dmu.Lock()
+		dirty[seasonID] = struct{}{}
+		dmu.Unlock()
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+
+	sendError := func(msg string) bool {
+		b, _ := json.Marshal(map[string]any{"type": "error", "error": msg})
+		return ws.writeText(b) == nil
+	}
+
+	ping := time.NewTicker(rankSessionPing)
+	defer ping.Stop()
+	var refresh <-chan time.Time // armed while seasons are dirty
+	var next time.Time           // earliest time for the next refresh
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-readErr:
+			return
+		case <-ping.C:
+			if err := ws.ping(); err != nil {
+				return
+			}
+		case <-wake:
+			if refresh == nil {
+				refresh = time.After(max(time.Until(next), 0))
+			}
+		case <-refresh:
+			refresh = nil
+			next = time.Now().Add(interval)
+			dmu.Lock()
+			seasons := dirty
+			dirty = make(map[string]struct{})
+			dmu.Unlock()
+			if err := refreshRankSubs(ctx, ws, reads, subs, seasons); err != nil {
+				return
+			}
+		case raw := <-msgs:
+			var m rankClientMessage
+			if err := json.Unmarshal(raw, &m); err != nil || m.SeasonID == "" || m.UserID == "" {
+				if !sendError("expected {\"action\", \"seasonId\", \"userId\"}") {
+					return
+				}
+				continue
+			}
+			sid, ok := qualifySeason(ctx, m.SeasonID)
+			if !ok {
+				if !sendError("seasonId must not contain ':'") {
+					return
+				}
+				continue
+			}
+			m.SeasonID = sid
+			key := rankSubKey{m.SeasonID, m.UserID}
+			switch m.Action {
+			case "subscribe":
+				if _, ok := subs[key]; ok {
+					continue
+				}
+				if len(subs) >= rankSessionMaxSubs {
+					if !sendError(fmt.Sprintf("at most %d subscriptions per connection", rankSessionMaxSubs)) {
+						return
+					}
+					continue
+				}
+				if _, ok := stops[m.SeasonID]; !ok {
+					sid := m.SeasonID
+					stops[sid] = updates.listen(sid, func() { markDirty(sid) })
+				}
+				subs[key] = &rankSub{}
+				markDirty(m.SeasonID) // initial state, subject to the same throttle
+			case "unsubscribe":
+				delete(subs, key)
+				still := false
+				for k := range subs {
+					if k.seasonID == m.SeasonID {
+						still = true
+						break
+					}
+				}
+				if stop, ok := stops[m.SeasonID]; ok && !still {
+					stop()
+					delete(stops, m.SeasonID)
+				}
+			default:
+				if !sendError("unknown action: " + m.Action) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// refreshRankSubs re-reads the subscriptions in the given seasons and pushes
+// the ones that changed. Only a write error ends the session; a read error
+// skips this round, and the season is read again on its next update.
+func refreshRankSubs(ctx context.Context, ws *wsConn, reads *redisReads, subs map[rankSubKey]*rankSub, seasons map[string]struct{}) error {
+	var keys []rankSubKey
+	for k := range subs {
+		if _, ok := seasons[k.seasonID]; ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	c, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+
+	members := make([]boardMember, len(keys))
+	for i, k := range keys {
+		members[i] = boardMember{seasonID: k.seasonID, userID: k.userID}
+	}
+	var found []memberRead
+	err := reads.do(c, func(rc redis.Cmdable) error {
+		var err error
+		found, err = readMembers(c, rc, members)
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+
+	for i, k := range keys {
+		var rank *int64
+		var score *float64
+		if found[i].found {
+			rank, score = &found[i].rank, &found[i].score
+		}
+		sub := subs[k]
+		if sub.sent && equalPtr(sub.rank, rank) && equalPtr(sub.score, score) {
+			continue
+		}
+		sub.rank, sub.score, sub.sent = rank, score, true
+		b, _ := json.Marshal(rankPush{Type: "rank", SeasonID: unqualifySeason(ctx, k.seasonID), UserID: k.userID, Rank: rank, Score: score})
+		if err := ws.writeText(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
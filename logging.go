@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Logs are JSON lines on stdout (slog), at LOG_LEVEL (debug/info/warn/error,
+// default info). Records logged with a request's context carry its
+// request_id (and trace_id/span_id when the request is traced); each request also gets one access line with method, path,
+// status, latency and seasonId.
+
+func setupLogging() {
+	level := slog.LevelInfo
+	if v := getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			panic(fmt.Sprintf("invalid LOG_LEVEL: %v", err))
+		}
+	}
+	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(requestIDLogHandler{h}))
+}
+
+// requestIDLogHandler adds request_id, and the current span when traced, to
+// records logged with a request context.
+type requestIDLogHandler struct {
+	slog.Handler
+}
+
+func (h requestIDLogHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		rec.AddAttrs(slog.String("request_id", id))
+	}
+	if tc, ok := ctx.Value(traceKey{}).(traceContext); ok && tc.sampled {
+		rec.AddAttrs(slog.String("trace_id", hex.EncodeToString(tc.traceID[:])), slog.String("span_id", hex.EncodeToString(tc.spanID[:])))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h requestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDLogHandler) WithGroup(name string) slog.Handler {
+	return requestIDLogHandler{h.Handler.WithGroup(name)}
+}
+
+// routeInfo is what the mux matched. recordRoute copies it out of the
+// request, so middleware further out (access log, metrics, tracing) sees it
+// even when a layer in between passed on a request of its own (WithContext).
+type routeInfo struct {
+	pattern  string
+	seasonID string
+}
+
+type routeInfoKey struct{}
+
+// withRouteInfo returns r with an empty routeInfo to be filled, or the one it already has.
+func withRouteInfo(r *http.Request) (*http.Request, *routeInfo) {
+	if ri, ok := r.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+		return r, ri
+	}
+	ri := &routeInfo{}
+	return r.WithContext(context.WithValue(r.Context(), routeInfoKey{}, ri)), ri
+}
+
+// recordRoute wraps the mux itself.
+func recordRoute(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ri, ok := r.Context().Value(routeInfoKey{}).(*routeInfo); ok {
+			defer func() {
+				ri.pattern = r.Pattern
+				ri.seasonID = r.PathValue("sid")
+			}()
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// accessLog writes one line per request once the handler returns and records
+// it in the HTTP metrics. The route comes from recordRoute. Probes log at debug.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, ri := withRouteInfo(r)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		latency := time.Since(start)
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		status := sw.status
+		switch {
+		case status == 0 && r.Header.Get("Upgrade") != "":
+			status = http.StatusSwitchingProtocols // hijacked by the WebSocket upgrade
+		case status == 0:
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(latency.Microseconds())/1000),
+		}
+		if ri.seasonID != "" {
+			attrs = append(attrs, slog.String("seasonId", ri.seasonID))
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+		observeRequest(r.Method, ri.pattern, status, latency)
+	})
+}
+
+// statusWriter records the status code; Unwrap keeps flushing, deadlines and
+// Hijack reachable through http.ResponseController.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
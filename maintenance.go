@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenanceMode is the global maintenance switch. The source of truth is the
+// maintenance row in Postgres (Redis may be the thing being failed over), and
+// every instance keeps an in-memory copy refreshed by run so the request path
+// never blocks on a lookup.
+type maintenanceMode struct {
+	mu           sync.RWMutex
+	enabled      bool
+	readsEnabled bool
+	retryAfter   int
+	message      string
+}
+
+type maintenanceStatus struct {
+	Enabled           bool   `json:"enabled"`
+	ReadsEnabled      bool   `json:"readsEnabled"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds"`
+	Message           string `json:"message,omitempty"`
+}
+
+func newMaintenanceMode() *maintenanceMode {
+	return &maintenanceMode{readsEnabled: true, retryAfter: 60}
+}
+
+func (m *maintenanceMode) status() maintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maintenanceStatus{
+		Enabled:           m.enabled,
+		ReadsEnabled:      m.readsEnabled,
+		RetryAfterSeconds: m.retryAfter,
+		Message:           m.message,
+	}
+}
+
+func (m *maintenanceMode) set(st maintenanceStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = st.Enabled
+	m.readsEnabled = st.ReadsEnabled
+	m.retryAfter = st.RetryAfterSeconds
+	m.message = st.Message
+}
+
+func (m *maintenanceMode) load(ctx context.Context, db *sql.DB) error {
+	var st maintenanceStatus
+	var msg sql.NullString
+	err := db.QueryRowContext(ctx, `
+	SELECT enabled, reads_enabled, retry_after_seconds, message
+	FROM maintenance
+	WHERE id
+`).Scan(&st.Enabled, &st.ReadsEnabled, &st.RetryAfterSeconds, &msg)
+	if err == sql.ErrNoRows {
+		st = maintenanceStatus{ReadsEnabled: true, RetryAfterSeconds: 60}
+	} else if err != nil {
+		return err
+	}
+	st.Message = msg.String
+	m.set(st)
+	return nil
+}
+
+func (m *maintenanceMode) save(ctx context.Context, db *sql.DB, st maintenanceStatus) error {
+	if _, err := db.ExecContext(ctx, `
+	INSERT INTO maintenance (id, enabled, reads_enabled, retry_after_seconds, message)
+	VALUES (TRUE, $1, $2, $3, NULLIF($4, ''))
+	ON CONFLICT (id) DO UPDATE SET
+	  enabled=EXCLUDED.enabled,
+	  reads_enabled=EXCLUDED.reads_enabled,
+	  retry_after_seconds=EXCLUDED.retry_after_seconds,
+	  message=EXCLUDED.message,
+	  updated_at=now()
+`, st.Enabled, st.ReadsEnabled, st.RetryAfterSeconds, st.Message); err != nil {
+		return err
+	}
+	m.set(st)
+	return nil
+}
+
+// run keeps the local copy in sync with other instances' toggles.
+func (m *maintenanceMode) run(ctx context.Context, db *sql.DB) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			if err := m.load(c, db); err != nil {
+				slog.Error("Maintenance refresh error", "err", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// middleware rejects writes (and reads, unless allowed) while maintenance is on.
+// Probes, the index and admin routes always pass so operators can turn it off again.
+func (m *maintenanceMode) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := m.status()
+		if !st.Enabled || maintenanceExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if isRead && st.ReadsEnabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		msg := st.Message
+		if msg == "" {
+			msg = "service under maintenance"
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfterSeconds))
+		writeProblem(w, http.StatusServiceUnavailable, "maintenance", msg)
+	})
+}
+
+func maintenanceExempt(path string) bool {
+	switch path {
+	case "/", "/favicon.ico", "/healthz", "/readyz", "/metrics":
+		return true
+	}
+	return strings.HasPrefix(path, "/v1/admin/")
+}
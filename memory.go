@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// STORAGE_BACKEND=memory serves the core API from leaderboard.NewMemoryEngine:
+// the ledger is a slice and the boards sorted slices, so a demo or a CI run
+// needs neither Redis nor Postgres. Unlike stub mode, submissions change the
+// boards, and at once (there is no outbox to wait for). Admin, export,
+// streaming and the other Postgres-backed endpoints aren't served, and
+// nothing survives a restart. STORAGE_BACKEND=sqlite serves the same API from
+// leaderboard.NewSQLiteEngine, which keeps the ledger and boards in a file.
+//
+// Requests are authenticated and authorized as in the Redis + Postgres setup
+// (serveStandalone), except that API keys can't be issued without the
+// api_keys table: API_KEY_BOOTSTRAP and bearer JWTs are the credentials.
+
+func newMemoryMux() *http.ServeMux {
+	engine, _, _ := leaderboard.NewMemoryEngine()
+	return newEngineMux("memory", engine)
+}
+
+func newEngineMux(backend string, engine *leaderboard.Engine) *http.ServeMux {
+	mux := http.NewServeMux()
+	maxBody := envBytes("SCORES_MAX_BODY_BYTES", 1<<20)
+
+	storeError := func(w http.ResponseWriter, r *http.Request, err error) {
+		slog.ErrorContext(r.Context(), "Store error", "backend", backend, "err", err)
+		writeProblem(w, http.StatusInternalServerError, "store_error", "store error")
+	}
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"service": "leaderboard-go",
+			"backend": backend,
+			"versions": []map[string]any{
+				{"version": "v1", "path": "/v1", "status": "stable"},
+			},
+		})
+	})
+
+	mux.HandleFunc("GET /favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":   "ready",
+			"redis":    backend,
+			"postgres": backend,
+			"schema":   backend,
+		})
+	})
+
+	mux.HandleFunc("GET /openapi.json", serveOpenAPI)
+	mux.HandleFunc("GET /metrics", metricsHandler(nil, nil))
+
+	mux.HandleFunc("GET /v1/capabilities", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, capabilitiesResponse{
+			Versions: []string{"v1"},
+			Features: map[string]bool{backend: true},
+		})
+	})
+
+	mux.HandleFunc("POST /v1/seasons/{sid}/scores", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+		dec.DisallowUnknownFields()
+		var req scoreUpdateRequest
+		if err := dec.Decode(&req); err != nil {
+			writeProblem(w, http.StatusBadRequest, "invalid_json", "invalid json")
+			return
+		}
+		if req.UserID == "" {
+			writeProblem(w, http.StatusBadRequest, "missing_user_id", "userId is required")
+			return
+		}
+		if req.Delta == 0 {
+			writeProblem(w, http.StatusBadRequest, "invalid_delta", "delta must be non-zero")
+			return
+		}
+		_, err := engine.Submit(r.Context(), ledger.Submission{
+			SeasonID:  seasonID,
+			UserID:    req.UserID,
+			Delta:     req.Delta,
+			RequestID: requestID(r.Context()),
+		})
+		var closed *leaderboard.SeasonClosedError
+		if errors.As(err, &closed) {
+			writeProblem(w, http.StatusLocked, "season_"+closed.Status, "season is "+closed.Status)
+			return
+		}
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"seasonId": unqualifySeason(r.Context(), seasonID),
+			"userId":   req.UserID,
+			"queued":   true,
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/top", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		limit := int64(10)
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var parsed int64
+			if _, err := fmt.Sscanf(v, "%d", &parsed); err != nil || parsed <= 0 || parsed > 1000 {
+				writeProblem(w, http.StatusBadRequest, "invalid_limit", "limit must be 1..1000")
+				return
+			}
+			limit = parsed
+		}
+
+		entries, err := engine.Top(r.Context(), seasonID, 0, limit)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		items := make([]leaderboardItem, len(entries))
+		for i, e := range entries {
+			items[i] = leaderboardItem{UserID: e.UserID, Score: e.Score}
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeRead(w, r, http.StatusOK, topResponse{SeasonID: unqualifySeason(r.Context(), seasonID), Items: items})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/rank", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, http.StatusBadRequest, "missing_user_id", "userId is required")
+			return
+		}
+		if !mayReadUser(r.Context(), userID) {
+			writeProblem(w, http.StatusForbidden, "self_only", "token may only read its own userId")
+			return
+		}
+
+		e, ok, err := engine.Rank(r.Context(), seasonID, userID)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		if !ok {
+			writeProblem(w, http.StatusNotFound, "user_not_found", "user not found in leaderboard")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeRead(w, r, http.StatusOK, rankResponse{
+			SeasonID: unqualifySeason(r.Context(), seasonID),
+			UserID:   userID,
+			Rank:     e.Rank,
+			Score:    e.Score,
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/around", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, http.StatusBadRequest, "missing_user_id", "userId is required")
+			return
+		}
+		if !mayReadUser(r.Context(), userID) {
+			writeProblem(w, http.StatusForbidden, "self_only", "token may only read its own userId")
+			return
+		}
+		rng := int64(5)
+		if v := r.URL.Query().Get("range"); v != "" {
+			var parsed int64
+			if _, err := fmt.Sscanf(v, "%d", &parsed); err != nil || parsed < 0 || parsed > 100 {
+				writeProblem(w, http.StatusBadRequest, "invalid_range", "range must be 0..100")
+				return
+			}
+			rng = parsed
+		}
+
+		entries, err := engine.Around(r.Context(), seasonID, userID, rng)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		if len(entries) == 0 {
+			writeProblem(w, http.StatusNotFound, "user_not_found", "user not found in leaderboard")
+			return
+		}
+		items := make([]aroundItem, len(entries))
+		for i, e := range entries {
+			items[i] = aroundItem{Rank: e.Rank, UserID: e.UserID, Score: e.Score}
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeRead(w, r, http.StatusOK, aroundResponse{
+			SeasonID: unqualifySeason(r.Context(), seasonID),
+			UserID:   userID,
+			Range:    rng,
+			Items:    items,
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/percentiles", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		buckets := 10
+		if v := r.URL.Query().Get("buckets"); v != "" {
+			var parsed int
+			if _, err := fmt.Sscanf(v, "%d", &parsed); err != nil || parsed <= 0 || parsed > 100 {
+				writeProblem(w, http.StatusBadRequest, "invalid_buckets", "buckets must be 1..100")
+				return
+			}
+			buckets = parsed
+		}
+
+		total, err := engine.Size(r.Context(), seasonID)
+		if err != nil {
+			storeError(w, r, err)
+			return
+		}
+		resp := percentilesResponse{
+			SeasonID:   unqualifySeason(r.Context(), seasonID),
+			Buckets:    buckets,
+			Total:      total,
+			ComputedAt: time.Now().UTC(),
+			Items:      []percentileBucket{},
+		}
+		for i := 1; i <= buckets && total > 0; i++ {
+			cutoff := (total*int64(i) + int64(buckets) - 1) / int64(buckets)
+			at, err := engine.Top(r.Context(), seasonID, cutoff-1, 1)
+			if err != nil {
+				storeError(w, r, err)
+				return
+			}
+			if len(at) == 0 {
+				break // the board shrank meanwhile
+			}
+			resp.Items = append(resp.Items, percentileBucket{
+				Bucket:     i,
+				Percentile: 100 * float64(i) / float64(buckets),
+				CutoffRank: cutoff,
+				MinScore:   at[0].Score,
+			})
+		}
+		writeRead(w, r, http.StatusOK, resp)
+	})
+
+	return mux
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// testEngine runs the core engine behavior against one backend; setSeason
+// changes a season's status through that backend's ledger.
+func testEngine(t *testing.T, engine *leaderboard.Engine, setSeason func(sid, status string) error) {
+	ctx := context.Background()
+	const sid = "engine-test"
+	submit := func(user string, delta int64) {
+		t.Helper()
+		if _, err := engine.Submit(ctx, ledger.Submission{SeasonID: sid, UserID: user, Delta: delta}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submit("alice", 10)
+	submit("bob", 20)
+	submit("carol", 10)
+	submit("bob", -5)
+
+	want := []leaderboard.Entry{
+		{UserID: "bob", Score: 15, Rank: 1},
+		{UserID: "carol", Score: 10, Rank: 2}, // ties go by user ID descending
+		{UserID: "alice", Score: 10, Rank: 3},
+	}
+	equal := func(got, want []leaderboard.Entry) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	t.Run("Top", func(t *testing.T) {
+		for _, tc := range []struct {
+			offset, limit int64
+			want          []leaderboard.Entry
+		}{
+			{0, 10, want},
+			{1, 1, want[1:2]},
+			{3, 10, nil},
+		} {
+			got, err := engine.Top(ctx, sid, tc.offset, tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(got, tc.want) {
+				t.Errorf("Top(%d, %d) = %v, want %v", tc.offset, tc.limit, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("Rank", func(t *testing.T) {
+		for _, w := range want {
+			got, ok, err := engine.Rank(ctx, sid, w.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok || got != w {
+				t.Errorf("Rank(%s) = %v, %v, want %v", w.UserID, got, ok, w)
+			}
+		}
+		if _, ok, err := engine.Rank(ctx, sid, "nobody"); err != nil || ok {
+			t.Errorf("Rank(nobody) found = %v, err = %v", ok, err)
+		}
+	})
+
+	t.Run("Around", func(t *testing.T) {
+		for _, tc := range []struct {
+			user string
+			n    int64
+			want []leaderboard.Entry
+		}{
+			{"carol", 1, want},
+			{"bob", 1, want[:2]},
+			{"alice", 0, want[2:]},
+			{"nobody", 1, nil},
+		} {
+			got, err := engine.Around(ctx, sid, tc.user, tc.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(got, tc.want) {
+				t.Errorf("Around(%s, %d) = %v, want %v", tc.user, tc.n, got, tc.want)
+			}
+		}
+		if n, err := engine.Size(ctx, sid); err != nil || n != 3 {
+			t.Errorf("Size = %d, %v, want 3", n, err)
+		}
+	})
+
+	t.Run("SeasonClose", func(t *testing.T) {
+		for _, status := range []string{"frozen", "archived"} {
+			if err := setSeason(sid, status); err != nil {
+				t.Fatal(err)
+			}
+			_, err := engine.Submit(ctx, ledger.Submission{SeasonID: sid, UserID: "alice", Delta: 100})
+			var closed *leaderboard.SeasonClosedError
+			if !errors.As(err, &closed) || closed.Status != status {
+				t.Fatalf("submit to a %s season: %v", status, err)
+			}
+		}
+		got, err := engine.Top(ctx, sid, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(got, want) {
+			t.Errorf("closed season's board changed: %v", got)
+		}
+
+		if err := setSeason(sid, "active"); err != nil {
+			t.Fatal(err)
+		}
+		submit("alice", 100)
+		if e, _, err := engine.Rank(ctx, sid, "alice"); err != nil || e.Rank != 1 || e.Score != 110 {
+			t.Errorf("after reopening: alice = %v, %v", e, err)
+		}
+	})
+}
+
+func TestMemoryEngine(t *testing.T) {
+	engine, scores, _ := leaderboard.NewMemoryEngine()
+	testEngine(t, engine, func(sid, status string) error {
+		scores.SetSeason(sid, ledger.Season{Status: status})
+		return nil
+	})
+}
+
+func TestSQLiteEngine(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", "file:"+t.TempDir()+"/lb.db?_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	engine, scores, _, err := leaderboard.NewSQLiteEngine(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEngine(t, engine, func(sid, status string) error {
+		return scores.SetSeason(ctx, sid, ledger.Season{Status: status})
+	})
+}
+
+// brokenStore fails every read, like a store that lost its connection.
+type brokenStore struct{ *leaderboard.MemoryStore }
+
+var errBrokenStore = errors.New("store unavailable")
+
+func (brokenStore) Top(context.Context, string, int64, int64) ([]leaderboard.Entry, error) {
+	return nil, errBrokenStore
+}
+
+func (brokenStore) Rank(context.Context, string, string) (leaderboard.Entry, bool, error) {
+	return leaderboard.Entry{}, false, errBrokenStore
+}
+
+func (brokenStore) Around(context.Context, string, string, int64) ([]leaderboard.Entry, error) {
+	return nil, errBrokenStore
+}
+
+func TestEngineMuxStoreErrors(t *testing.T) {
+	store := brokenStore{leaderboard.NewMemoryStore()}
+	scores := ledger.NewMemory(func(ctx context.Context, ev ledger.Event) error {
+		_, err := store.Apply(ctx, ev.SeasonID, ev.UserID, []int64{ev.ID}, []int64{ev.Delta}, ev.CreatedAt)
+		return err
+	})
+	mux := newEngineMux("memory", leaderboard.NewEngine(scores, store))
+	if _, err := scores.Record(context.Background(), ledger.Submission{SeasonID: "s1", UserID: "alice", Delta: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{
+		"/v1/seasons/s1/leaderboard/top",
+		"/v1/seasons/s1/leaderboard/rank?userId=alice",
+		"/v1/seasons/s1/leaderboard/around?userId=alice",
+		"/v1/seasons/s1/leaderboard/percentiles",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("GET %s = %d, want 500", path, rec.Code)
+		}
+	}
+}
+
+func TestStandaloneRequiresAPIKey(t *testing.T) {
+	t.Setenv("API_AUTH", "true")
+	t.Setenv("API_KEY_BOOTSTRAP", "boot-key")
+	mux := newMemoryMux()
+	handler := apiMiddleware(mux, recordRoute(mux), newAPIKeyAuth(nil), nil, nil, &configReloader{})
+
+	for _, tc := range []struct {
+		method, path, key string
+		want              int
+	}{
+		{"GET", "/healthz", "", http.StatusOK},
+		{"GET", "/v1/seasons/s1/leaderboard/top", "", http.StatusUnauthorized},
+		{"GET", "/v1/seasons/s1/leaderboard/top", "lbk_unknown", http.StatusUnauthorized},
+		{"GET", "/v1/seasons/s1/leaderboard/top", "boot-key", http.StatusOK},
+		{"POST", "/v1/seasons/s1/scores", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"userId":"alice","delta":1}`))
+		if tc.key != "" {
+			req.Header.Set("X-API-Key", tc.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s with key %q = %d, want %d", tc.method, tc.path, tc.key, rec.Code, tc.want)
+		}
+	}
+}
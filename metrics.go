@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// GET /metrics serves the Prometheus text format (version 0.0.4). Like the
+// NATS and WebSocket code it is written against the standard library: a few
+// counter and histogram vectors updated in place, plus gauges read at scrape
+// time (outbox depth, DB pool, goroutines).
+
+// metricVec is a counter or gauge with labels.
+type metricVec struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values []string
+	v      float64
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: "counter", labels: labels, series: make(map[string]*metricSeries)}
+}
+
+func (m *metricVec) add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	m.mu.Lock()
+	s := m.series[key]
+	if s == nil {
+		s = &metricSeries{values: values}
+		m.series[key] = s
+	}
+	s.v += delta
+	m.mu.Unlock()
+}
+
+func (m *metricVec) inc(values ...string) { m.add(1, values...) }
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeMetricHeader(w, m.name, m.help, m.kind)
+	for _, key := range sortedKeys(m.series) {
+		s := m.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.values, "", ""), formatFloat(s.v))
+	}
+}
+
+// histogramVec is a histogram with labels and fixed upper bounds.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+var (
+	latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	sizeBuckets    = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+)
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	i := sort.SearchFloat64s(h.buckets, v) // first bound >= v; len(buckets) is +Inf
+	h.mu.Lock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{values: values, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cum uint64
+		for i, bound := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, "le", formatFloat(bound)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.values, "", ""), s.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	writeMetricHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func writeCounter(w io.Writer, name, help string, v float64) {
+	writeMetricHeader(w, name, help, "counter")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// metrics is process-wide, like the script and pool globals: the worker and
+// the Redis hook update it without having it threaded through.
+var metrics = struct {
+	httpRequests         *metricVec
+	httpDuration         *histogramVec
+	outboxProcessed      *metricVec
+	outboxBatchDuration  *histogramVec
+	outboxApplyLatency   *histogramVec
+	redisPipelineSize    *histogramVec
+	redisPipelineErrors  *metricVec
+	redisBreakerRejected *metricVec
+	rateLimited          *metricVec
+}{
+	httpRequests: newCounterVec("leaderboard_http_requests_total",
+		"HTTP requests by route pattern, method and status.", "route", "method", "status"),
+	httpDuration: newHistogramVec("leaderboard_http_request_duration_seconds",
+		"HTTP request latency by route pattern and method.", latencyBuckets, "route", "method"),
+	outboxProcessed: newCounterVec("leaderboard_outbox_processed_total",
+		"Outbox rows settled by the worker: applied, or errored (retried or failed).", "event_type", "result"),
+	outboxBatchDuration: newHistogramVec("leaderboard_outbox_batch_duration_seconds",
+		"Time to claim, apply and commit one non-empty outbox batch.", latencyBuckets),
+	outboxApplyLatency: newHistogramVec("leaderboard_outbox_apply_latency_seconds",
+		"Time from outbox insert to Redis apply for score_delta rows.", latencyBuckets),
+	redisPipelineSize: newHistogramVec("leaderboard_redis_pipeline_commands",
+		"Commands per Redis pipeline.", sizeBuckets),
+	redisPipelineErrors: newCounterVec("leaderboard_redis_pipeline_errors_total",
+		"Redis pipelines that returned an error (other than a nil reply)."),
+	redisBreakerRejected: newCounterVec("leaderboard_redis_breaker_rejected_total",
+		"Redis calls failed at once because the circuit breaker was open."),
+	rateLimited: newCounterVec("leaderboard_http_rate_limited_total",
+		"Requests rejected with 429 by the per-client rate limiter, by route class.", "class"),
+}
+
+// observeRequest records one HTTP request; route is the mux pattern without
+// its method, so path values don't blow up the label set.
+func observeRequest(method, pattern string, status int, d time.Duration) {
+	route := pattern
+	if _, path, ok := strings.Cut(route, " "); ok {
+		route = path
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER" // clients pick the method; keep the label set bounded
+	}
+	metrics.httpRequests.inc(route, method, strconv.Itoa(status))
+	metrics.httpDuration.observe(d.Seconds(), route, method)
+}
+
+// redisMetricsHook counts pipeline sizes and errors for a client.
+type redisMetricsHook struct{}
+
+func (redisMetricsHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (redisMetricsHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (redisMetricsHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		metrics.redisPipelineSize.observe(float64(len(cmds)))
+		err := next(ctx, cmds)
+		if err != nil && err != redis.Nil {
+			metrics.redisPipelineErrors.inc()
+		}
+		return err
+	}
+}
+
+// metricsHandler serves /metrics; db may be nil (stub mode), and ingest is
+// nil without the API.
+func metricsHandler(db *sql.DB, ingest *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+
+		metrics.httpRequests.write(bw)
+		metrics.httpDuration.write(bw)
+		metrics.outboxProcessed.write(bw)
+		metrics.outboxBatchDuration.write(bw)
+		metrics.outboxApplyLatency.write(bw)
+		metrics.redisPipelineSize.write(bw)
+		metrics.redisPipelineErrors.write(bw)
+		metrics.redisBreakerRejected.write(bw)
+		metrics.rateLimited.write(bw)
+		writeGauge(bw, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+		if db == nil {
+			return
+		}
+
+		st := db.Stats()
+		writeGauge(bw, "leaderboard_db_open_connections", "Open Postgres connections, in use or idle.", float64(st.OpenConnections))
+		writeGauge(bw, "leaderboard_db_in_use_connections", "Postgres connections in use.", float64(st.InUse))
+		writeGauge(bw, "leaderboard_db_idle_connections", "Idle Postgres connections.", float64(st.Idle))
+		writeGauge(bw, "leaderboard_db_max_open_connections", "Configured Postgres connection limit (0 = unlimited).", float64(st.MaxOpenConnections))
+		writeCounter(bw, "leaderboard_db_wait_count_total", "Connections waited for.", float64(st.WaitCount))
+		writeCounter(bw, "leaderboard_db_wait_duration_seconds_total", "Total time blocked waiting for a connection.", st.WaitDuration.Seconds())
+		if ingest != nil {
+			ps := ingest.Stat()
+			writeGauge(bw, "leaderboard_db_ingest_open_connections", "Open connections in the score submission pool.", float64(ps.TotalConns()))
+			writeGauge(bw, "leaderboard_db_ingest_in_use_connections", "Score submission pool connections in use.", float64(ps.AcquiredConns()))
+			writeCounter(bw, "leaderboard_db_ingest_wait_count_total", "Score submission pool connections waited for.", float64(ps.EmptyAcquireCount()))
+			writeCounter(bw, "leaderboard_db_ingest_wait_duration_seconds_total", "Total time blocked waiting for a score submission pool connection.", ps.EmptyAcquireWaitTime().Seconds())
+		}
+
+		// The queue depth is read per scrape; a slow or failing query leaves it out
+		// rather than failing the scrape.
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+		depth, oldest, err := outboxDepth(ctx, db)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Metrics outbox depth error", "err", err)
+			return
+		}
+		writeMetricHeader(bw, "leaderboard_outbox_rows", "Unfinished outbox rows by status.", "gauge")
+		for _, status := range []string{"pending", "processing", "failed"} {
+			fmt.Fprintf(bw, "leaderboard_outbox_rows{status=%q} %d\n", status, depth[status])
+		}
+		writeGauge(bw, "leaderboard_outbox_oldest_pending_age_seconds", "Age of the oldest pending outbox row (0 when none).", oldest)
+	}
+}
+
+// outboxDepth counts the unfinished rows; done rows are left out so the count
+// stays on the partial indexes instead of the whole retention window.
+func outboxDepth(ctx context.Context, db *sql.DB) (map[string]int64, float64, error) {
+	depth := map[string]int64{}
+	rows, err := db.QueryContext(ctx, `
+	SELECT status, COUNT(*) FROM outbox WHERE status IN ('pending', 'processing', 'failed') GROUP BY status
+`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, 0, err
+		}
+		depth[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var age sql.NullFloat64
+	if err := db.QueryRowContext(ctx, `
+	SELECT EXTRACT(EPOCH FROM now() - MIN(created_at))::float8 FROM outbox WHERE status='pending'
+`).Scan(&age); err != nil {
+		return nil, 0, err
+	}
+	return depth, age.Float64, nil
+}
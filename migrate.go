@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The schema ships in the binary as numbered migrations
+// (migrations/NNNN_name.up.sql, with a matching .down.sql), applied in order
+// by `migrate up` or at startup with MIGRATE_ON_START. Applied versions are
+// recorded in schema_migrations; each migration runs in one transaction with
+// its record, and a session advisory lock keeps instances starting together
+// from racing.
+//
+// Databases created from schema.sql before migrations existed have the tables
+// but no schema_migrations. Migration 1 is that schema, so it is recorded as
+// applied without being run.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version  int
+	name     string
+	up, down string
+}
+
+type migrationStatus struct {
+	migration
+	applied   bool
+	appliedAt *time.Time // nil for a pre-migrations schema
+}
+
+// loadMigrations reads the embedded migrations in version order, once. main
+// calls it at startup, so a badly named file stops the process.
+var loadMigrations = sync.OnceValues(func() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+	var out []migration
+	for _, p := range paths {
+		base := strings.TrimSuffix(path.Base(p), ".up.sql")
+		num, name, ok := strings.Cut(base, "_")
+		v, err := strconv.Atoi(num)
+		if !ok || err != nil || v < 1 {
+			return nil, fmt.Errorf("%s: name must be NNNN_name.up.sql", p)
+		}
+		up, err := migrationFiles.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		down, err := migrationFiles.ReadFile(strings.TrimSuffix(p, ".up.sql") + ".down.sql")
+		if err != nil {
+			return nil, fmt.Errorf("%s: missing down migration", p)
+		}
+		out = append(out, migration{version: v, name: name, up: string(up), down: string(down)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	for i := 1; i < len(out); i++ {
+		if out[i].version == out[i-1].version {
+			return nil, fmt.Errorf("duplicate migration version %d", out[i].version)
+		}
+	}
+	return out, nil
+})
+
+// withMigrationLock runs fn on a connection holding the migration lock,
+// waiting for another instance's run to finish. schema_migrations is created
+// (and a pre-migrations schema baselined) first.
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext('lb_migrate'))`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('lb_migrate'))`)
+
+	if _, err := conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+	  version    INT PRIMARY KEY,
+	  name       TEXT NOT NULL,
+	  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+`); err != nil {
+		return err
+	}
+	if err := baselineMigrations(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies every pending migration and returns those it applied.
+func migrateUp(ctx context.Context, db *sql.DB) ([]migration, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []migration
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m.up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+			}
+			slog.Info("Migration applied", "version", m.version, "name", m.name)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// baselineMigrations records migration 1 as applied on a database that
+// already has the pre-migrations schema.
+func baselineMigrations(ctx context.Context, conn *sql.Conn) error {
+	res, err := conn.ExecContext(ctx, `
+	INSERT INTO schema_migrations (version, name)
+	SELECT 1, 'initial'
+	WHERE to_regclass('outbox') IS NOT NULL AND NOT EXISTS (SELECT 1 FROM schema_migrations)
+`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("Existing schema recorded as migration 1")
+	}
+	return nil
+}
+
+// migrateDown reverts the latest steps applied migrations and returns them.
+func migrateDown(ctx context.Context, db *sql.DB, steps int) ([]migration, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []migration
+	err = withMigrationLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(all) - 1; i >= 0 && len(done) < steps; i-- {
+			m := all[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, conn, m.down, `DELETE FROM schema_migrations WHERE version=$1`, m.version); err != nil {
+				return fmt.Errorf("migration %04d_%s down: %w", m.version, m.name, err)
+			}
+			slog.Info("Migration reverted", "version", m.version, "name", m.name)
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// runMigration runs a migration's SQL and its bookkeeping statement in one transaction.
+func runMigration(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// no arguments, so the script may hold several statements
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrationStatuses lists every embedded migration and when it was applied,
+// without creating or changing anything.
+func migrationStatuses(ctx context.Context, db *sql.DB) ([]migrationStatus, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var tracked bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&tracked); err != nil {
+		return nil, err
+	}
+	query := `SELECT version, applied_at FROM schema_migrations`
+	if !tracked {
+		// a pre-migrations schema counts as version 1
+		query = `SELECT 1, NULL::timestamptz WHERE to_regclass('outbox') IS NOT NULL`
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]*time.Time)
+	for rows.Next() {
+		var v int
+		var at *time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]migrationStatus, len(all))
+	for i, m := range all {
+		out[i] = migrationStatus{migration: m}
+		if at, ok := applied[m.version]; ok {
+			out[i].applied = true
+			out[i].appliedAt = at
+		}
+	}
+	return out, nil
+}
+
+// schemaState is the readiness view of the schema: "ok", "missing" (nothing
+// applied and no pre-migrations tables) or "outdated" (migrations pending),
+// with the highest applied and the latest embedded version.
+func schemaState(ctx context.Context, db *sql.DB) (state string, version, latest int, err error) {
+	statuses, err := migrationStatuses(ctx, db)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	state = "ok"
+	for _, s := range statuses {
+		latest = s.version
+		if s.applied {
+			version = s.version
+		} else {
+			state = "outdated"
+		}
+	}
+	if version == 0 {
+		state = "missing"
+	}
+	return state, version, latest, nil
+}
+
+// runMigrateCommand implements `migrate up|down [n]|status` and returns the
+// process exit code.
+func runMigrateCommand(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: migrate up | down [n] | status")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	db := newPostgresDB()
+	defer db.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return usage()
+		}
+		done, err := migrateUp(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate up:", err)
+			return 1
+		}
+		for _, m := range done {
+			fmt.Printf("applied  %04d_%s\n", m.version, m.name)
+		}
+		if len(done) == 0 {
+			fmt.Println("up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return usage()
+			}
+			steps = n
+		} else if len(args) > 2 {
+			return usage()
+		}
+		done, err := migrateDown(ctx, db, steps)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate down:", err)
+			return 1
+		}
+		for _, m := range done {
+			fmt.Printf("reverted %04d_%s\n", m.version, m.name)
+		}
+		if len(done) == 0 {
+			fmt.Println("nothing to revert")
+		}
+	case "status":
+		if len(args) != 1 {
+			return usage()
+		}
+		statuses, err := migrationStatuses(ctx, db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate status:", err)
+			return 1
+		}
+		for _, s := range statuses {
+			state := "pending"
+			switch {
+			case s.appliedAt != nil:
+				state = "applied " + s.appliedAt.UTC().Format(time.RFC3339)
+			case s.applied:
+				state = "applied (schema predates migrations)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.version, s.name, state)
+		}
+	default:
+		return usage()
+	}
+	return 0
+}
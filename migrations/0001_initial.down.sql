@@ -0,0 +1,2 @@
+-- Drops everything 0001_initial.up.sql creates, data included.
+DROP TABLE IF EXISTS outbox, score_events;
@@ -0,0 +1,8 @@
+-- Per-season settings, starting with the board size cap (boardcaps.go).
+
+CREATE TABLE seasons (
+  season_id  TEXT PRIMARY KEY,
+  max_size   BIGINT, -- NULL = use LEADERBOARD_MAX_SIZE (0 = unlimited)
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE seasons
+  DROP COLUMN IF EXISTS status,
+  DROP COLUMN IF EXISTS frozen_at;
@@ -0,0 +1,3 @@
+ALTER TABLE seasons
+  ADD COLUMN status    TEXT NOT NULL DEFAULT 'active', -- active/frozen
+  ADD COLUMN frozen_at TIMESTAMPTZ;
@@ -0,0 +1,9 @@
+-- single-row global maintenance switch
+CREATE TABLE maintenance (
+  id                  BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+  enabled             BOOLEAN NOT NULL DEFAULT FALSE,
+  reads_enabled       BOOLEAN NOT NULL DEFAULT TRUE,
+  retry_after_seconds INT NOT NULL DEFAULT 60,
+  message             TEXT,
+  updated_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
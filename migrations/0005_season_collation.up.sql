@@ -0,0 +1,2 @@
+ALTER TABLE seasons
+  ADD COLUMN collation TEXT; -- BCP 47 tag for ordering tied entries (NULL = byte-wise)
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS season_delete_jobs;
@@ -0,0 +1,20 @@
+-- Background season deletes (deletejobs.go).
+
+CREATE TABLE season_delete_jobs (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id      TEXT NOT NULL,
+  status         TEXT NOT NULL DEFAULT 'pending', -- pending/running/done/failed
+  event_cutoff   BIGINT NOT NULL, -- delete score_events with id <= cutoff
+  outbox_cutoff  BIGINT NOT NULL, -- delete outbox rows with id < cutoff (the season_deleted event)
+  deleted_events BIGINT NOT NULL DEFAULT 0,
+  deleted_outbox BIGINT NOT NULL DEFAULT 0,
+  attempts       INT NOT NULL DEFAULT 0,
+  last_error     TEXT,
+  created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+  started_at     TIMESTAMPTZ,
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+  finished_at    TIMESTAMPTZ
+);
+
+CREATE INDEX idx_season_delete_jobs_status
+  ON season_delete_jobs (status, id);
@@ -0,0 +1,5 @@
+ALTER TABLE seasons
+  DROP COLUMN IF EXISTS ends_at,
+  DROP COLUMN IF EXISTS event_retention_days;
+
+ALTER TABLE score_events DROP COLUMN IF EXISTS compacted;
@@ -0,0 +1,9 @@
+-- Per-season event retention (retention.go): raw events are folded into
+-- per-user totals some days after the season ends.
+
+ALTER TABLE score_events
+  ADD COLUMN compacted BOOLEAN NOT NULL DEFAULT FALSE; -- per-user total written by event retention
+
+ALTER TABLE seasons
+  ADD COLUMN ends_at              TIMESTAMPTZ,
+  ADD COLUMN event_retention_days INT; -- compact raw score_events this many days after ends_at (NULL = keep)
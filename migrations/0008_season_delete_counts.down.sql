@@ -0,0 +1 @@
+ALTER TABLE season_delete_jobs DROP COLUMN IF EXISTS redis_members;
@@ -0,0 +1,2 @@
+ALTER TABLE season_delete_jobs
+  ADD COLUMN redis_members BIGINT NOT NULL DEFAULT 0; -- ZCARD when the delete was requested
@@ -0,0 +1,27 @@
+-- Cheat reports and the per-user review queue with board holds.
+
+CREATE TABLE reports (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id       TEXT NOT NULL,
+  target_user_id  TEXT NOT NULL,
+  reporter_id     TEXT NOT NULL,
+  reason          TEXT,
+  score_at_report DOUBLE PRECISION,
+  rank_at_report  BIGINT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+  UNIQUE (season_id, target_user_id, reporter_id)
+);
+
+CREATE TABLE report_targets (
+  season_id         TEXT NOT NULL,
+  user_id           TEXT NOT NULL,
+  report_count      BIGINT NOT NULL DEFAULT 0,
+  status            TEXT NOT NULL DEFAULT 'open', -- open/held/dismissed
+  first_reported_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_reported_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  held_at           TIMESTAMPTZ,
+  PRIMARY KEY (season_id, user_id)
+);
+
+CREATE INDEX idx_report_targets_queue
+  ON report_targets (season_id, status, report_count DESC);
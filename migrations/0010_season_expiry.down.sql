@@ -0,0 +1,4 @@
+ALTER TABLE seasons
+  DROP COLUMN IF EXISTS expire_after_days,
+  DROP COLUMN IF EXISTS expire_action,
+  DROP COLUMN IF EXISTS expired_at;
@@ -0,0 +1,6 @@
+-- Seasons archived or deleted some days after they end; status gains 'archived'.
+
+ALTER TABLE seasons
+  ADD COLUMN expire_after_days INT, -- archive/delete the season this many days after ends_at (NULL = never)
+  ADD COLUMN expire_action     TEXT NOT NULL DEFAULT 'archive', -- archive/delete
+  ADD COLUMN expired_at        TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS boosts;
+
+ALTER TABLE score_events
+  DROP COLUMN IF EXISTS raw_delta,
+  DROP COLUMN IF EXISTS boost_id;
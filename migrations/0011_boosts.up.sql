@@ -0,0 +1,17 @@
+-- Scheduled score multipliers, applied by the outbox worker (boosts.go).
+
+ALTER TABLE score_events
+  ADD COLUMN raw_delta BIGINT, -- submitted delta when a boost changed it
+  ADD COLUMN boost_id  BIGINT; -- boosts.id applied to this event
+
+CREATE TABLE boosts (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id  TEXT NOT NULL,
+  multiplier DOUBLE PRECISION NOT NULL,
+  starts_at  TIMESTAMPTZ NOT NULL,
+  ends_at    TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_boosts_season_window
+  ON boosts (season_id, ends_at);
@@ -0,0 +1,27 @@
+-- Redis-vs-ledger reconciliation runs and the drift each one found (reconcile.go).
+
+CREATE TABLE reconcile_runs (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id    TEXT NOT NULL,
+  mode         TEXT NOT NULL, -- sample/full
+  checked      BIGINT NOT NULL,
+  mismatched   BIGINT NOT NULL,
+  missing      BIGINT NOT NULL,
+  extra        BIGINT NOT NULL,
+  held         BIGINT NOT NULL,
+  max_abs_diff DOUBLE PRECISION NOT NULL,
+  started_at   TIMESTAMPTZ NOT NULL,
+  finished_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_reconcile_runs_season
+  ON reconcile_runs (season_id, id DESC);
+
+CREATE TABLE reconcile_drift (
+  run_id       BIGINT NOT NULL REFERENCES reconcile_runs (id) ON DELETE CASCADE,
+  user_id      TEXT NOT NULL,
+  kind         TEXT NOT NULL, -- mismatch/missing/extra/held
+  redis_score  DOUBLE PRECISION,
+  ledger_score BIGINT,
+  PRIMARY KEY (run_id, user_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS score_corrections;
+
+ALTER TABLE reconcile_runs DROP COLUMN IF EXISTS healed;
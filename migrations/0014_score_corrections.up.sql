@@ -0,0 +1,18 @@
+-- Drift healed by reconciliation through score_correction outbox events (corrections.go).
+
+ALTER TABLE reconcile_runs
+  ADD COLUMN healed BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE score_corrections (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id    TEXT NOT NULL,
+  user_id      TEXT NOT NULL,
+  redis_score  DOUBLE PRECISION NOT NULL, -- 0 when missing from the board
+  ledger_score BIGINT NOT NULL,
+  delta        BIGINT NOT NULL, -- applied via a score_correction outbox event
+  outbox_id    BIGINT,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_score_corrections_season
+  ON score_corrections (season_id, id DESC);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS leaderboard_snapshot_members, leaderboard_snapshots;
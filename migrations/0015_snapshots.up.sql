@@ -0,0 +1,18 @@
+-- Periodic copies of each board (snapshots.go).
+
+CREATE TABLE leaderboard_snapshots (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id TEXT NOT NULL,
+  members   BIGINT NOT NULL DEFAULT 0,
+  taken_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_leaderboard_snapshots_season
+  ON leaderboard_snapshots (season_id, id DESC);
+
+CREATE TABLE leaderboard_snapshot_members (
+  snapshot_id BIGINT NOT NULL REFERENCES leaderboard_snapshots (id) ON DELETE CASCADE,
+  user_id     TEXT NOT NULL,
+  score       DOUBLE PRECISION NOT NULL,
+  PRIMARY KEY (snapshot_id, user_id)
+);
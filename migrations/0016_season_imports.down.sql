@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS season_imports;
+
+ALTER TABLE score_events DROP COLUMN IF EXISTS import_id;
@@ -0,0 +1,14 @@
+-- Bulk imports of standings and historical deltas (import.go).
+
+ALTER TABLE score_events
+  ADD COLUMN import_id BIGINT; -- season_imports.id for backfilled events
+
+CREATE TABLE season_imports (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id  TEXT NOT NULL,
+  mode       TEXT NOT NULL, -- standings/deltas
+  format     TEXT NOT NULL, -- csv/ndjson
+  rows       BIGINT NOT NULL DEFAULT 0,
+  events     BIGINT NOT NULL DEFAULT 0, -- score_events written (standings rows already matching the ledger are skipped)
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,14 @@
+-- Closed seasons exported to object storage (archive.go).
+
+CREATE TABLE season_archives (
+  season_id     TEXT PRIMARY KEY,
+  status        TEXT NOT NULL, -- done/failed
+  standings_key TEXT, -- object keys of the gzipped NDJSON exports
+  events_key    TEXT,
+  members       BIGINT NOT NULL DEFAULT 0,
+  events        BIGINT NOT NULL DEFAULT 0,
+  pruned        BOOLEAN NOT NULL DEFAULT FALSE, -- score_events and snapshots deleted after upload
+  attempts      INT NOT NULL DEFAULT 0,
+  last_error    TEXT,
+  archived_at   TIMESTAMPTZ
+);
@@ -0,0 +1,2 @@
+ALTER TABLE outbox
+  ADD COLUMN next_attempt_at TIMESTAMPTZ; -- retry backoff after a failed apply (NULL = due now)
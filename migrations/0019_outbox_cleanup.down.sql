@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS outbox_archive;
+DROP INDEX IF EXISTS idx_outbox_done_processed;
@@ -0,0 +1,14 @@
+-- Old done rows are deleted, or moved to outbox_archive (outboxcleanup.go).
+
+CREATE INDEX idx_outbox_done_processed
+  ON outbox (processed_at) WHERE status='done';
+
+CREATE TABLE outbox_archive (
+  id           BIGINT PRIMARY KEY, -- original outbox id
+  event_type   TEXT NOT NULL,
+  payload      JSONB NOT NULL,
+  attempts     INT NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL,
+  processed_at TIMESTAMPTZ,
+  archived_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS trg_outbox_notify ON outbox;
+DROP FUNCTION IF EXISTS lb_outbox_notify();
@@ -0,0 +1,11 @@
+-- Wakes the outbox worker (LISTEN lb_outbox) as soon as the inserting transaction commits.
+CREATE OR REPLACE FUNCTION lb_outbox_notify() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('lb_outbox', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER trg_outbox_notify
+  AFTER INSERT ON outbox
+  FOR EACH STATEMENT EXECUTE FUNCTION lb_outbox_notify();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_outbox_partition_pending;
+ALTER TABLE outbox DROP COLUMN IF EXISTS outbox_partition;
@@ -0,0 +1,8 @@
+-- Season buckets that partitioned workers claim with advisory locks (outboxpartition.go).
+
+ALTER TABLE outbox
+  -- the mask must match outboxPartitions (16)
+  ADD COLUMN outbox_partition INT GENERATED ALWAYS AS (hashtext(COALESCE(payload->>'seasonId', '')) & 15) STORED;
+
+CREATE INDEX idx_outbox_partition_pending
+  ON outbox (outbox_partition, id) WHERE status='pending';
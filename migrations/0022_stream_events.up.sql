@@ -0,0 +1,20 @@
+-- Applied score and season events waiting to be published to Kafka or NATS (stream.go).
+
+CREATE TABLE stream_events (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  event_type      TEXT NOT NULL, -- score_applied/season_deleted/season_archived
+  season_id       TEXT NOT NULL,
+  payload         JSONB NOT NULL,
+  status          TEXT NOT NULL DEFAULT 'pending', -- pending/published
+  attempts        INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_error      TEXT,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+  published_at    TIMESTAMPTZ
+);
+
+CREATE INDEX idx_stream_events_pending
+  ON stream_events (id) WHERE status='pending';
+
+CREATE INDEX idx_stream_events_published
+  ON stream_events (published_at) WHERE status='published';
@@ -0,0 +1,3 @@
+DELETE FROM webhook_deliveries WHERE subscription_id IS NOT NULL;
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS subscription_id;
+DROP TABLE IF EXISTS webhook_subscriptions;
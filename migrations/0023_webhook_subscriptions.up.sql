@@ -0,0 +1,17 @@
+-- Webhook subscriptions with event filters, delivered through webhook_deliveries (webhooksubs.go).
+
+CREATE TABLE webhook_subscriptions (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  url        TEXT NOT NULL,
+  secret     TEXT NOT NULL,
+  events     TEXT[] NOT NULL, -- score.applied/rank.top_entered/season.archived/season.deleted
+  season_id  TEXT, -- NULL: every season
+  top_n      BIGINT NOT NULL DEFAULT 10, -- rank.top_entered threshold
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE webhook_deliveries
+  ADD COLUMN subscription_id BIGINT REFERENCES webhook_subscriptions(id) ON DELETE CASCADE; -- NULL: season webhook
+
+CREATE INDEX idx_webhook_deliveries_subscription
+  ON webhook_deliveries (subscription_id, status, id) WHERE subscription_id IS NOT NULL;
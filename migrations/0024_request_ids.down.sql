@@ -0,0 +1,3 @@
+ALTER TABLE outbox_archive DROP COLUMN IF EXISTS request_id;
+ALTER TABLE outbox DROP COLUMN IF EXISTS request_id;
+ALTER TABLE score_events DROP COLUMN IF EXISTS request_id;
@@ -0,0 +1,10 @@
+-- X-Request-ID of the API call behind each ledger and outbox row (requestid.go).
+
+ALTER TABLE score_events
+  ADD COLUMN request_id TEXT; -- X-Request-ID of the API call that recorded it
+
+ALTER TABLE outbox
+  ADD COLUMN request_id TEXT; -- X-Request-ID of the API call that queued it (NULL for jobs)
+
+ALTER TABLE outbox_archive
+  ADD COLUMN request_id TEXT;
@@ -0,0 +1 @@
+ALTER TABLE seasons DROP COLUMN IF EXISTS submit_limit_per_minute;
@@ -0,0 +1,2 @@
+ALTER TABLE seasons
+  ADD COLUMN submit_limit_per_minute INT; -- score submissions per user per minute (NULL = use SCORE_SUBMIT_LIMIT_PER_MINUTE, 0 = no cap)
@@ -0,0 +1,12 @@
+-- API keys, stored as hashes (apikeys.go).
+
+CREATE TABLE api_keys (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  name         TEXT NOT NULL,
+  key_hash     TEXT NOT NULL UNIQUE, -- sha256 hex; the key itself is only shown when issued
+  key_prefix   TEXT NOT NULL, -- first characters, to tell keys apart in listings
+  scopes       TEXT[] NOT NULL, -- read/write/admin
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_used_at TIMESTAMPTZ, -- stamped when an instance looks the key up (at most once per API_KEY_CACHE_TTL)
+  revoked_at   TIMESTAMPTZ
+);
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS idx_outbox_tenant_failed;
+ALTER TABLE outbox DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE score_events DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,15 @@
+-- Tenants: seasons are namespaced "{tenant}:{sid}" and keys can be bound to one (tenant.go).
+
+ALTER TABLE score_events
+  -- '' = default tenant
+  ADD COLUMN tenant_id TEXT NOT NULL GENERATED ALWAYS AS (CASE WHEN strpos(season_id, ':') > 0 THEN split_part(season_id, ':', 1) ELSE '' END) STORED;
+
+ALTER TABLE outbox
+  -- tenant of payload.seasonId, as in score_events
+  ADD COLUMN tenant_id TEXT NOT NULL GENERATED ALWAYS AS (CASE WHEN strpos(payload->>'seasonId', ':') > 0 THEN split_part(payload->>'seasonId', ':', 1) ELSE '' END) STORED;
+
+CREATE INDEX idx_outbox_tenant_failed
+  ON outbox (tenant_id, id) WHERE status='failed';
+
+ALTER TABLE api_keys
+  ADD COLUMN tenant_id TEXT; -- tenant the key is bound to (NULL = default tenant, may name any)
@@ -0,0 +1,36 @@
+-- Moves score_events back to a plain table, keeping ids; the season
+-- partitions are dropped with the partitioned table.
+
+ALTER TABLE score_events RENAME TO score_events_partitioned;
+ALTER TABLE score_events_partitioned RENAME CONSTRAINT score_events_pkey TO score_events_partitioned_pkey;
+ALTER SEQUENCE score_events_id_seq RENAME TO score_events_partitioned_id_seq;
+DROP INDEX idx_score_events_season_created, idx_score_events_season_user_created;
+
+CREATE TABLE score_events (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  season_id  TEXT NOT NULL,
+  user_id    TEXT NOT NULL,
+  delta      BIGINT NOT NULL,
+  raw_delta  BIGINT,
+  boost_id   BIGINT,
+  compacted  BOOLEAN NOT NULL DEFAULT FALSE,
+  import_id  BIGINT,
+  request_id TEXT,
+  tenant_id  TEXT NOT NULL GENERATED ALWAYS AS (CASE WHEN strpos(season_id, ':') > 0 THEN split_part(season_id, ':', 1) ELSE '' END) STORED,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO score_events (id, season_id, user_id, delta, raw_delta, boost_id, compacted, import_id, request_id, created_at)
+OVERRIDING SYSTEM VALUE
+SELECT id, season_id, user_id, delta, raw_delta, boost_id, compacted, import_id, request_id, created_at FROM score_events_partitioned;
+
+SELECT setval(pg_get_serial_sequence('score_events', 'id'),
+  COALESCE((SELECT max(id) FROM score_events), 0) + 1, false);
+
+DROP TABLE score_events_partitioned;
+
+CREATE INDEX idx_score_events_season_created
+  ON score_events (season_id, created_at DESC);
+
+CREATE INDEX idx_score_events_season_user_created
+  ON score_events (season_id, user_id, created_at DESC);
@@ -0,0 +1,43 @@
+-- Rebuilds score_events LIST-partitioned by season (see partitions.go),
+-- moving the existing rows over with their ids. Seasons created through
+-- PUT /v1/admin/seasons/{sid} get a partition of their own; everything else,
+-- including every season moved here, goes to score_events_default. The table
+-- is rewritten, so expect it to take a while on a large ledger.
+
+ALTER TABLE score_events RENAME TO score_events_unpartitioned;
+ALTER TABLE score_events_unpartitioned RENAME CONSTRAINT score_events_pkey TO score_events_unpartitioned_pkey;
+ALTER SEQUENCE score_events_id_seq RENAME TO score_events_unpartitioned_id_seq;
+DROP INDEX idx_score_events_season_created, idx_score_events_season_user_created;
+
+CREATE TABLE score_events (
+  id BIGINT GENERATED ALWAYS AS IDENTITY,
+  season_id  TEXT NOT NULL,
+  user_id    TEXT NOT NULL,
+  delta      BIGINT NOT NULL,
+  raw_delta  BIGINT, -- submitted delta when a boost changed it
+  boost_id   BIGINT, -- boosts.id applied to this event
+  compacted  BOOLEAN NOT NULL DEFAULT FALSE, -- per-user total written by event retention
+  import_id  BIGINT, -- season_imports.id for backfilled events
+  request_id TEXT, -- X-Request-ID of the API call that recorded it
+  -- tenant of the season ("{tenant}:{sid}"; '' = default tenant), see tenant.go
+  tenant_id  TEXT NOT NULL GENERATED ALWAYS AS (CASE WHEN strpos(season_id, ':') > 0 THEN split_part(season_id, ':', 1) ELSE '' END) STORED,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (id, season_id) -- must include the partition key; id is still unique
+) PARTITION BY LIST (season_id);
+
+CREATE TABLE score_events_default PARTITION OF score_events DEFAULT;
+
+INSERT INTO score_events (id, season_id, user_id, delta, raw_delta, boost_id, compacted, import_id, request_id, created_at)
+OVERRIDING SYSTEM VALUE
+SELECT id, season_id, user_id, delta, raw_delta, boost_id, compacted, import_id, request_id, created_at FROM score_events_unpartitioned;
+
+SELECT setval(pg_get_serial_sequence('score_events', 'id'),
+  COALESCE((SELECT max(id) FROM score_events), 0) + 1, false);
+
+DROP TABLE score_events_unpartitioned;
+
+CREATE INDEX idx_score_events_season_created
+  ON score_events (season_id, created_at DESC);
+
+CREATE INDEX idx_score_events_season_user_created
+  ON score_events (season_id, user_id, created_at DESC);
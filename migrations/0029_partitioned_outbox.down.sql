@@ -0,0 +1,46 @@
+-- Moves outbox back to a plain table, keeping ids; the status and day
+-- partitions are dropped with the partitioned table.
+
+ALTER TABLE outbox RENAME TO outbox_partitioned;
+ALTER SEQUENCE outbox_id_seq RENAME TO outbox_partitioned_id_seq;
+DROP INDEX idx_outbox_pending, idx_outbox_done_processed, idx_outbox_tenant_failed, idx_outbox_partition_pending;
+
+CREATE TABLE outbox (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  event_type   TEXT NOT NULL,
+  payload      JSONB NOT NULL,
+  status       TEXT NOT NULL DEFAULT 'pending', -- pending/processing/done/failed
+  attempts     INT NOT NULL DEFAULT 0,
+  last_error   TEXT,
+  next_attempt_at TIMESTAMPTZ,
+  request_id   TEXT,
+  outbox_partition INT GENERATED ALWAYS AS (hashtext(COALESCE(payload->>'seasonId', '')) & 15) STORED,
+  tenant_id    TEXT NOT NULL GENERATED ALWAYS AS (CASE WHEN strpos(payload->>'seasonId', ':') > 0 THEN split_part(payload->>'seasonId', ':', 1) ELSE '' END) STORED,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  processed_at TIMESTAMPTZ
+);
+
+INSERT INTO outbox (id, event_type, payload, status, attempts, last_error, next_attempt_at, request_id, created_at, processed_at)
+OVERRIDING SYSTEM VALUE
+SELECT id, event_type, payload, status, attempts, last_error, next_attempt_at, request_id, created_at, processed_at FROM outbox_partitioned;
+
+SELECT setval(pg_get_serial_sequence('outbox', 'id'),
+  COALESCE((SELECT max(id) FROM outbox), 0) + 1, false);
+
+DROP TABLE outbox_partitioned;
+
+CREATE INDEX idx_outbox_pending
+  ON outbox (status, id);
+
+CREATE INDEX idx_outbox_done_processed
+  ON outbox (processed_at) WHERE status='done';
+
+CREATE INDEX idx_outbox_tenant_failed
+  ON outbox (tenant_id, id) WHERE status='failed';
+
+CREATE INDEX idx_outbox_partition_pending
+  ON outbox (outbox_partition, id) WHERE status='pending';
+
+CREATE OR REPLACE TRIGGER trg_outbox_notify
+  AFTER INSERT ON outbox
+  FOR EACH STATEMENT EXECUTE FUNCTION lb_outbox_notify();
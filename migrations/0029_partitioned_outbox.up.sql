@@ -0,0 +1,66 @@
+-- Rebuilds outbox partitioned by status (see outboxcleanup.go), moving the
+-- existing rows over with their ids: the worker's scans only touch
+-- outbox_live, and done rows move to outbox_done, split by the day they were
+-- processed so cleanup can drop whole days. The table is rewritten, so
+-- expect it to take a while on a large outbox.
+
+ALTER TABLE outbox RENAME TO outbox_unpartitioned;
+ALTER TABLE outbox_unpartitioned RENAME CONSTRAINT outbox_pkey TO outbox_unpartitioned_pkey;
+ALTER SEQUENCE outbox_id_seq RENAME TO outbox_unpartitioned_id_seq;
+DROP INDEX idx_outbox_pending, idx_outbox_done_processed, idx_outbox_tenant_failed, idx_outbox_partition_pending;
+
+CREATE TABLE outbox (
+  id BIGINT GENERATED ALWAYS AS IDENTITY,
+  event_type   TEXT NOT NULL,
+  payload      JSONB NOT NULL,
+  status       TEXT NOT NULL DEFAULT 'pending', -- pending/processing/done/failed
+  attempts     INT NOT NULL DEFAULT 0,
+  last_error   TEXT,
+  next_attempt_at TIMESTAMPTZ, -- retry backoff after a failed apply (NULL = due now)
+  request_id   TEXT, -- X-Request-ID of the API call that queued it (NULL for jobs)
+  -- season bucket for partitioned workers; the mask must match outboxPartitions (16)
+  outbox_partition INT GENERATED ALWAYS AS (hashtext(COALESCE(payload->>'seasonId', '')) & 15) STORED,
+  -- tenant of payload.seasonId, as in score_events
+  tenant_id    TEXT NOT NULL GENERATED ALWAYS AS (CASE WHEN strpos(payload->>'seasonId', ':') > 0 THEN split_part(payload->>'seasonId', ':', 1) ELSE '' END) STORED,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+  processed_at TIMESTAMPTZ
+) PARTITION BY LIST (status);
+
+-- A key on outbox itself would have to include processed_at, outbox_done's
+-- partition key, which is NULL until a row is done. Each leaf gets one on id
+-- instead (outboxcleanup.go adds it to the day partitions it creates), so an
+-- id is unique among the live rows and within each day of done rows.
+CREATE TABLE outbox_live PARTITION OF outbox (PRIMARY KEY (id))
+  FOR VALUES IN ('pending', 'processing', 'failed');
+
+CREATE TABLE outbox_done PARTITION OF outbox
+  FOR VALUES IN ('done') PARTITION BY RANGE (processed_at);
+
+-- days without a partition yet (outbox_done_YYYYMMDD are created ahead)
+CREATE TABLE outbox_done_default PARTITION OF outbox_done (PRIMARY KEY (id)) DEFAULT;
+
+-- the eventId in score_delta payloads still points at the same ledger rows
+INSERT INTO outbox (id, event_type, payload, status, attempts, last_error, next_attempt_at, request_id, created_at, processed_at)
+OVERRIDING SYSTEM VALUE
+SELECT id, event_type, payload, status, attempts, last_error, next_attempt_at, request_id, created_at, processed_at FROM outbox_unpartitioned;
+
+SELECT setval(pg_get_serial_sequence('outbox', 'id'),
+  COALESCE((SELECT max(id) FROM outbox), 0) + 1, false);
+
+DROP TABLE outbox_unpartitioned;
+
+CREATE INDEX idx_outbox_pending
+  ON outbox (status, id);
+
+CREATE INDEX idx_outbox_done_processed
+  ON outbox (processed_at) WHERE status='done';
+
+CREATE INDEX idx_outbox_tenant_failed
+  ON outbox (tenant_id, id) WHERE status='failed';
+
+CREATE INDEX idx_outbox_partition_pending
+  ON outbox (outbox_partition, id) WHERE status='pending';
+
+CREATE OR REPLACE TRIGGER trg_outbox_notify
+  AFTER INSERT ON outbox
+  FOR EACH STATEMENT EXECUTE FUNCTION lb_outbox_notify();
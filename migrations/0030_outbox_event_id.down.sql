@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_outbox_live_event_id;
+ALTER TABLE outbox DROP COLUMN IF EXISTS event_id;
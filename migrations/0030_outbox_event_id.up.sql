@@ -0,0 +1,13 @@
+-- score_events.id of a score_delta row as a column, so the "still waiting for
+-- the worker" checks (rebuild, reconcile, capped-board seeding) join through
+-- an index instead of reading every live row's payload.
+
+ALTER TABLE outbox ADD COLUMN event_id BIGINT;
+
+-- only rows those checks look at; done rows keep event_id NULL
+UPDATE outbox SET event_id = (payload->>'eventId')::bigint
+WHERE event_type='score_delta' AND status IN ('pending', 'processing', 'failed')
+  AND payload ? 'eventId';
+
+CREATE INDEX idx_outbox_live_event_id
+  ON outbox (event_id) WHERE status IN ('pending', 'processing', 'failed');
@@ -0,0 +1,12 @@
+-- Where each board (user_id '') or single user was last rebuilt from the
+-- ledger, as the outbox id high-water mark at the time. score_correction rows
+-- at or below it were computed against the replaced scores, so the worker
+-- retires them instead of applying them (corrections.go).
+
+CREATE TABLE board_rebuilds (
+  season_id  TEXT NOT NULL,
+  user_id    TEXT NOT NULL DEFAULT '', -- '' = the whole board
+  outbox_id  BIGINT NOT NULL,
+  rebuilt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (season_id, user_id)
+);
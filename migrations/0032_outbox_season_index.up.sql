@@ -0,0 +1,5 @@
+-- The season delete job and its preview find a season's outbox rows by
+-- payload->>'seasonId'; without this they scan every done row.
+
+CREATE INDEX idx_outbox_season
+  ON outbox ((payload->>'seasonId'), id);
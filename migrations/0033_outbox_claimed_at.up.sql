@@ -0,0 +1,4 @@
+-- When a worker last committed a row as processing; the reaper returns rows
+-- stuck there past OUTBOX_PROCESSING_TIMEOUT (reaper.go).
+
+ALTER TABLE outbox ADD COLUMN claimed_at TIMESTAMPTZ;
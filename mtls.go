@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// With TLS_CERT_FILE and TLS_KEY_FILE the API port serves HTTPS. Adding
+// MTLS_CLIENT_CA_FILE (a PEM bundle) turns on client certificates for
+// service-to-service callers such as game servers:
+//
+//   - MTLS_REQUIRE=scores (default): any client may connect, but score
+//     submission needs a certificate from the bundle, so only trusted game
+//     servers can write scores; everything else is unchanged.
+//   - MTLS_REQUIRE=all: the handshake fails without one. Probes and
+//     /metrics need a certificate too.
+//
+// MTLS_ALLOWED_NAMES (comma-separated) further limits certificates to those
+// whose subject CN or a DNS or URI SAN is listed. Certificates are read once,
+// at startup.
+
+const mtlsScoresRoute = "POST /v1/seasons/{sid}/scores"
+
+type clientCertPolicy struct {
+	requireAll bool
+	names      []string // empty: any certificate from the bundle
+}
+
+// newServerTLS reads the TLS_* and MTLS_* settings; the config is nil for
+// plain HTTP and the policy nil without client certificates.
+func newServerTLS() (*tls.Config, *clientCertPolicy) {
+	certFile, keyFile := getenv("TLS_CERT_FILE"), getenv("TLS_KEY_FILE")
+	caFile := getenv("MTLS_CLIENT_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			panic("MTLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		panic(fmt.Sprintf("invalid TLS_CERT_FILE/TLS_KEY_FILE: %v", err))
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		panic(fmt.Sprintf("invalid MTLS_CLIENT_CA_FILE: %v", err))
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic("invalid MTLS_CLIENT_CA_FILE: no certificates")
+	}
+	cfg.ClientCAs = pool
+
+	p := &clientCertPolicy{}
+	for _, n := range strings.Split(getenv("MTLS_ALLOWED_NAMES"), ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			p.names = append(p.names, n)
+		}
+	}
+	switch v := getenv("MTLS_REQUIRE"); v {
+	case "", "scores":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "all":
+		p.requireAll = true
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 || !p.allows(cs.VerifiedChains[0][0]) {
+				return errors.New("client certificate not allowed")
+			}
+			return nil
+		}
+	default:
+		panic("invalid MTLS_REQUIRE (scores or all)")
+	}
+	return cfg, p
+}
+
+// allows reports whether a verified leaf certificate has an allowed name.
+func (p *clientCertPolicy) allows(cert *x509.Certificate) bool {
+	if len(p.names) == 0 {
+		return true
+	}
+	if slices.Contains(p.names, cert.Subject.CommonName) {
+		return true
+	}
+	for _, n := range cert.DNSNames {
+		if slices.Contains(p.names, n) {
+			return true
+		}
+	}
+	for _, u := range cert.URIs {
+		if slices.Contains(p.names, u.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware answers 403 client_cert_required to score submissions without
+// an allowed, verified client certificate. With MTLS_REQUIRE=all the
+// handshake has already checked every connection.
+func (p *clientCertPolicy) middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	if p.requireAll {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == mtlsScoresRoute {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || !p.allows(r.TLS.VerifiedChains[0][0]) {
+				slog.WarnContext(r.Context(), "Score submission without an allowed client certificate", "remote", r.RemoteAddr)
+				writeProblem(w, http.StatusForbidden, "client_cert_required", "a trusted client certificate is required")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natsPublisher publishes stream messages to NATS JetStream over the plain
+// NATS client protocol (the subset needed to publish and read acks), so the
+// service stays on the standard library. Each message goes to
+// {subject}.{type} with a Nats-Msg-Id header set to its id, so JetStream's
+// duplicate window drops redeliveries; a batch counts as published once every
+// message has a PubAck. The connection is kept open between batches and
+// redialled after any error. Only the stream job calls publish, so it isn't
+// safe for concurrent use.
+type natsPublisher struct {
+	addr      string
+	tls       bool
+	subject   string
+	connectOp []byte
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newNATSPublisher(rawURL, subject string) (*natsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("invalid nats url %q", rawURL)
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("nats subject is required")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	// no_responders turns a subject without a stream into an immediate 503 instead of a timeout
+	opts := map[string]any{"verbose": false, "pedantic": false, "headers": true, "no_responders": true, "name": "leaderboard-go"}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), pass
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	b, _ := json.Marshal(opts)
+
+	return &natsPublisher{
+		addr:      addr,
+		tls:       u.Scheme == "tls",
+		subject:   subject,
+		connectOp: []byte("CONNECT " + string(b) + "\r\nPING\r\n"),
+	}, nil
+}
+
+func (n *natsPublisher) name() string { return "nats" }
+
+func (n *natsPublisher) publish(ctx context.Context, msgs []streamMessage) error {
+	if n.conn == nil {
+		if err := n.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if err := n.publishBatch(ctx, msgs); err != nil {
+		n.close()
+		return err
+	}
+	return nil
+}
+
+func (n *natsPublisher) dial(ctx context.Context) error {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	if n.tls {
+		host, _, _ := net.SplitHostPort(n.addr)
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+	// INFO, then CONNECT + PING answered by PONG (or -ERR on bad credentials)
+	line, err := n.readLine()
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+	if err == nil {
+		_, err = conn.Write(n.connectOp)
+	}
+	for err == nil {
+		if line, err = n.readLine(); err != nil {
+			break
+		}
+		if line == "PONG" {
+			return nil
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			err = fmt.Errorf("nats: %s", line)
+		}
+	}
+	n.close()
+	return err
+}
+
+func (n *natsPublisher) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.r = nil, nil
+	}
+}
+
+func (n *natsPublisher) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (n *natsPublisher) publishBatch(ctx context.Context, msgs []streamMessage) error {
+	deadline := time.Now().Add(10 * time.Second)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = n.conn.SetDeadline(deadline)
+
+	// Acks come back on a fresh inbox per batch, one reply subject per message.
+	tok := make([]byte, 8)
+	_, _ = rand.Read(tok)
+	inbox := "_INBOX.lb." + hex.EncodeToString(tok)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "SUB %s.* 1\r\n", inbox)
+	for i, m := range msgs {
+		body, _ := json.Marshal(m)
+		hdr := "NATS/1.0\r\nNats-Msg-Id: " + strconv.FormatInt(m.ID, 10) + "\r\n\r\n"
+		fmt.Fprintf(&buf, "HPUB %s.%s %s.%d %d %d\r\n%s%s\r\n",
+			n.subject, m.Type, inbox, i, len(hdr), len(hdr)+len(body), hdr, body)
+	}
+	if _, err := n.conn.Write([]byte(buf.String())); err != nil {
+		return err
+	}
+
+	acked := 0
+	for acked < len(msgs) {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case line == "PONG", line == "+OK", strings.HasPrefix(line, "INFO "):
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", line)
+		case strings.HasPrefix(line, "MSG "), strings.HasPrefix(line, "HMSG "):
+			if err := n.readAck(line, msgs); err != nil {
+				return err
+			}
+			acked++
+		default:
+			return fmt.Errorf("nats: unexpected %q", line)
+		}
+	}
+	_, err := n.conn.Write([]byte("UNSUB 1\r\n"))
+	return err
+}
+
+// readAck consumes one reply (MSG subject sid [reply] size, or HMSG with a
+// header size before the total) and checks that it's a PubAck.
+func (n *natsPublisher) readAck(line string, msgs []streamMessage) error {
+	f := strings.Fields(line)
+	size, err := strconv.Atoi(f[len(f)-1])
+	if err != nil || size < 0 {
+		return fmt.Errorf("nats: bad reply %q", line)
+	}
+	payload := make([]byte, size+2) // with trailing CRLF
+	if _, err := io.ReadFull(n.r, payload); err != nil {
+		return err
+	}
+	payload = payload[:size]
+	if f[0] == "HMSG" {
+		hdrSize, err := strconv.Atoi(f[len(f)-2])
+		if err != nil || hdrSize > size {
+			return fmt.Errorf("nats: bad reply %q", line)
+		}
+		// a header-only reply is a status, e.g. 503 when no stream matches the subject
+		if hdrSize == size {
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.SplitN(string(payload), "\r\n", 2)[0]))
+		}
+		payload = payload[hdrSize:]
+	}
+
+	var ack struct {
+		Stream string `json:"stream"`
+		Error  *struct {
+			Code        int    `json:"code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return fmt.Errorf("nats: bad ack: %w", err)
+	}
+	if ack.Error != nil {
+		// the reply subject ends in the message's index in the batch
+		i, err := strconv.Atoi(f[1][strings.LastIndexByte(f[1], '.')+1:])
+		if err == nil && i >= 0 && i < len(msgs) {
+			return fmt.Errorf("nats: id %d: %s", msgs[i].ID, ack.Error.Description)
+		}
+		return fmt.Errorf("nats: %s", ack.Error.Description)
+	}
+	if ack.Stream == "" {
+		return errors.New("nats: reply is not a JetStream ack (is a stream bound to the subject?)")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openapi.yml is the hand-maintained spec; it is compiled into the binary and
+// served as JSON so SDK generators can fetch it from a running instance.
+//
+//go:embed openapi.yml
+var openAPIYAML []byte
+
+// openAPIJSON converts the spec once. main calls it at startup, so a spec
+// that doesn't parse stops the process instead of failing a client request.
+var openAPIJSON = sync.OnceValues(func() ([]byte, error) {
+	var doc any
+	if err := yaml.Unmarshal(openAPIYAML, &doc); err != nil {
+		return nil, fmt.Errorf("openapi.yml: %w", err)
+	}
+	return json.Marshal(doc)
+})
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	b, err := openAPIJSON()
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "spec_unavailable", "spec unavailable")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,252 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// DynamoDBWorker applies the outbox items a ledger.DynamoDB queues to a
+// leaderboard.RankStore. Each batch reads due items from every shard, applies
+// each (season, user) through the store and deletes the applied items; an
+// item whose apply failed backs off like a Postgres row and is parked in
+// shard -1-s (which no worker reads) with failed_at once it has had
+// Retry.MaxAttempts.
+//
+// Items aren't claimed, so two workers on the same shards apply an item
+// twice unless the store skips replayed ids (leaderboard.RedisStore with a
+// dedup window does). Run one.
+type DynamoDBWorker struct {
+	c      *ledger.DynamoDBClient
+	table  string
+	shards int
+	store  leaderboard.RankStore
+
+	BatchSize int
+	Retry     Retry
+}
+
+// NewDynamoDBWorker returns a worker on l's outbox with the binary's default
+// batch size and backoff.
+func NewDynamoDBWorker(l *ledger.DynamoDB, store leaderboard.RankStore) *DynamoDBWorker {
+	return &DynamoDBWorker{
+		c:         l.Client(),
+		table:     l.Tables.Outbox,
+		shards:    l.Shards,
+		store:     store,
+		BatchSize: 500,
+		Retry:     Retry{MaxAttempts: 10, Base: time.Second, Max: 5 * time.Minute},
+	}
+}
+
+// Run processes batches until ctx is done, waiting interval whenever the
+// outbox has been drained. Batch errors are passed to onError, which may be
+// nil.
+func (w *DynamoDBWorker) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		n, err := w.ProcessBatch(ctx)
+		if err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		if err == nil && n == w.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+type dynamoItem struct {
+	shard    int64
+	sk       string
+	attempts int
+}
+
+// applyOp is one store call: a (season, user)'s deltas, or a board drop.
+type applyOp struct {
+	seasonID, userID string
+	drop             bool
+	ids, deltas      []int64
+	at               time.Time // the latest item's created_at
+}
+
+// ProcessBatch applies up to BatchSize due items and returns how many it
+// read.
+func (w *DynamoDBWorker) ProcessBatch(ctx context.Context) (int, error) {
+	now := time.Now()
+	var items []dynamoItem
+	var ops []*applyOp
+	opItems := make(map[*applyOp][]int) // indexes into items
+	open := make(map[string]*applyOp)   // season\x00user -> op
+	var bad []int
+	var badErrs []string
+	for shard := 0; shard < w.shards && len(items) < w.BatchSize; shard++ {
+		var start ledger.DynamoDBItem
+		for len(items) < w.BatchSize {
+			in := map[string]any{
+				"TableName":                w.table,
+				"KeyConditionExpression":   "#shard = :shard",
+				"FilterExpression":         "attribute_not_exists(next_attempt_at) OR next_attempt_at <= :now",
+				"ConsistentRead":           true,
+				"Limit":                    w.BatchSize - len(items),
+				"ExpressionAttributeNames": map[string]string{"#shard": "shard"}, // a reserved word
+				"ExpressionAttributeValues": ledger.DynamoDBItem{
+					":shard": ledger.DynamoDBNumber(int64(shard)),
+					":now":   ledger.DynamoDBNumber(now.UnixMilli()),
+				},
+			}
+			if start != nil {
+				in["ExclusiveStartKey"] = start
+			}
+			var out struct {
+				Items            []ledger.DynamoDBItem
+				LastEvaluatedKey ledger.DynamoDBItem
+			}
+			if err := w.c.Do(ctx, "Query", in, &out); err != nil {
+				return 0, err
+			}
+			for _, it := range out.Items {
+				i := len(items)
+				items = append(items, dynamoItem{
+					shard:    it["shard"].Int(),
+					sk:       it["sk"].S,
+					attempts: int(it["attempts"].Int()),
+				})
+				var p Payload
+				if err := json.Unmarshal([]byte(it["payload"].S), &p); err != nil {
+					bad, badErrs = append(bad, i), append(badErrs, "json error: "+err.Error())
+					continue
+				}
+				if t := it["event_type"].S; t != EventScoreDelta && t != EventScoreCorrection {
+					bad, badErrs = append(bad, i), append(badErrs, "unknown event_type: "+t)
+					continue
+				}
+				k := p.SeasonID + "\x00" + p.UserID
+				op := open[k]
+				if op == nil {
+					op = &applyOp{seasonID: p.SeasonID, userID: p.UserID}
+					open[k] = op
+					ops = append(ops, op)
+				}
+				op.ids = append(op.ids, p.EventID)
+				op.deltas = append(op.deltas, p.Delta)
+				if at := time.UnixMilli(it["created_at"].Int()); at.After(op.at) {
+					op.at = at
+				}
+				opItems[op] = append(opItems[op], i)
+			}
+			if out.LastEvaluatedKey == nil {
+				break
+			}
+			start = out.LastEvaluatedKey
+		}
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	var done []int
+	for _, op := range ops {
+		if _, err := w.store.Apply(ctx, op.seasonID, op.userID, op.ids, op.deltas, op.at); err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return 0, err
+			}
+			for _, i := range opItems[op] {
+				if err := w.retry(ctx, items[i], "apply error: "+err.Error(), now); err != nil {
+					return 0, err
+				}
+			}
+			continue
+		}
+		done = append(done, opItems[op]...)
+	}
+	for j, i := range bad {
+		if err := w.park(ctx, items[i], badErrs[j], now); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), w.delete(ctx, items, done)
+}
+
+func dynamoKey(it dynamoItem) ledger.DynamoDBItem {
+	return ledger.DynamoDBItem{"shard": ledger.DynamoDBNumber(it.shard), "sk": ledger.DynamoDBString(it.sk)}
+}
+
+// delete removes the applied items, 25 (the BatchWriteItem limit) at a time.
+func (w *DynamoDBWorker) delete(ctx context.Context, items []dynamoItem, idx []int) error {
+	for len(idx) > 0 {
+		n := min(len(idx), 25)
+		reqs := make([]map[string]any, n)
+		for j, i := range idx[:n] {
+			reqs[j] = map[string]any{"DeleteRequest": map[string]any{"Key": dynamoKey(items[i])}}
+		}
+		idx = idx[n:]
+		for len(reqs) > 0 {
+			var out struct {
+				UnprocessedItems map[string][]map[string]any
+			}
+			if err := w.c.Do(ctx, "BatchWriteItem", map[string]any{
+				"RequestItems": map[string]any{w.table: reqs},
+			}, &out); err != nil {
+				return err
+			}
+			reqs = out.UnprocessedItems[w.table]
+			if len(reqs) > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// retry backs an item off, or parks it once it has had MaxAttempts.
+func (w *DynamoDBWorker) retry(ctx context.Context, it dynamoItem, msg string, now time.Time) error {
+	attempts := it.attempts + 1
+	if attempts >= w.Retry.MaxAttempts {
+		return w.park(ctx, it, msg, now)
+	}
+	return w.c.Do(ctx, "UpdateItem", map[string]any{
+		"TableName":        w.table,
+		"Key":              dynamoKey(it),
+		"UpdateExpression": "SET attempts = :attempts, next_attempt_at = :next, last_error = :err",
+		"ExpressionAttributeValues": ledger.DynamoDBItem{
+			":attempts": ledger.DynamoDBNumber(int64(attempts)),
+			":next":     ledger.DynamoDBNumber(now.Add(w.Retry.Delay(attempts)).UnixMilli()),
+			":err":      ledger.DynamoDBString(msg),
+		},
+	}, nil)
+}
+
+// park moves an item to its shard's failed partition, -1-shard.
+func (w *DynamoDBWorker) park(ctx context.Context, it dynamoItem, msg string, now time.Time) error {
+	var old struct{ Item ledger.DynamoDBItem }
+	if err := w.c.Do(ctx, "GetItem", map[string]any{
+		"TableName":      w.table,
+		"Key":            dynamoKey(it),
+		"ConsistentRead": true,
+	}, &old); err != nil || old.Item == nil {
+		return err
+	}
+	parked := old.Item
+	parked["shard"] = ledger.DynamoDBNumber(-1 - it.shard)
+	parked["attempts"] = ledger.DynamoDBNumber(int64(it.attempts + 1))
+	parked["last_error"] = ledger.DynamoDBString(msg)
+	parked["failed_at"] = ledger.DynamoDBString(now.UTC().Format(time.RFC3339Nano))
+	return w.c.Do(ctx, "TransactWriteItems", map[string]any{
+		"TransactItems": []map[string]any{
+			{"Put": map[string]any{"TableName": w.table, "Item": parked}},
+			{"Delete": map[string]any{"TableName": w.table, "Key": dynamoKey(it)}},
+		},
+	}, nil)
+}
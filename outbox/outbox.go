@@ -0,0 +1,165 @@
+// Package outbox is the queue between the ledger and the rank store. Every
+// change to a board is first written as an outbox row in the same
+// transaction as its ledger row; workers claim pending rows in batches
+// (FOR UPDATE SKIP LOCKED, so several can run at once), apply them and mark
+// them done, or back off and retry rows whose apply failed.
+//
+// Worker applies the Postgres outbox to a leaderboard.RankStore; the binary
+// runs it too, with Hooks for the features it adds (boosts, cheat holds,
+// webhooks, streams). DynamoDBWorker applies the DynamoDB ledger's outbox.
+package outbox
+
+import "time"
+
+// Event types. score_correction rows are queued by reconciliation auto-heal;
+// season_deleted and season_archived drop the board once the season's earlier
+// rows are applied.
+const (
+	EventScoreDelta      = "score_delta"
+	EventScoreCorrection = "score_correction"
+	EventSeasonDeleted   = "season_deleted"
+	EventSeasonArchived  = "season_archived"
+)
+
+// Payload is the union of all outbox event payloads.
+type Payload struct {
+	SeasonID string `json:"seasonId"`
+	UserID   string `json:"userId"`
+	Delta    int64  `json:"delta"`
+	EventID  int64  `json:"eventId"` // score_events.id; absent in rows queued by older versions
+
+	Traceparent string `json:"traceparent,omitempty"` // the submitting request's span, when traced
+}
+
+// Retry is the backoff for rows whose apply failed: retried after
+// Base*2^(attempts-1) (capped at Max), then parked as failed after
+// MaxAttempts.
+type Retry struct {
+	MaxAttempts int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+// ClaimSQL selects and locks up to $1 pending rows due now, from outbox
+// partition $2 or (-1) all of them: id, event_type, payload, created_at. It
+// runs in a short transaction of its own with ProcessingSQL.
+const ClaimSQL = `
+	SELECT id, event_type, payload, created_at
+	FROM outbox
+	WHERE status='pending' AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+	  AND ($2 < 0 OR outbox_partition = $2)
+	  -- a board drop waits for the season's earlier rows, which may be in
+	  -- another worker's batch or backing off
+	  AND NOT (event_type IN ('season_deleted', 'season_archived') AND EXISTS (
+	    SELECT 1 FROM outbox e
+	    WHERE e.id < outbox.id AND e.status IN ('pending', 'processing')
+	      AND e.payload->>'seasonId' = outbox.payload->>'seasonId'
+	  ))
+	ORDER BY id
+	FOR UPDATE SKIP LOCKED
+	LIMIT $1
+`
+
+// ClaimPartitionSQL tries to take outbox partition $1 (see the
+// outbox_partition column) for the rest of the transaction.
+const ClaimPartitionSQL = `SELECT pg_try_advisory_xact_lock(hashtext('lb_outbox_partition'), $1)`
+
+// ProcessingSQL marks the claimed rows $1 as in progress and counts the
+// attempt. It commits with ClaimSQL, before the batch applies anything, so a
+// worker killed mid-batch leaves its rows processing with the attempt counted
+// (the binary's reaper returns them) rather than rolling the attempt back:
+// a batch that crashes the worker every time still ends up failed.
+const ProcessingSQL = `
+	UPDATE outbox
+	SET status='processing', attempts=attempts+1, claimed_at=now()
+	WHERE id = ANY($1)
+`
+
+// LockClaimedSQL locks the batch's claimed rows $1 in the transaction that
+// applies and settles them, so a reaper skips them while the batch runs.
+const LockClaimedSQL = `
+	SELECT id FROM outbox
+	WHERE id = ANY($1) AND status='processing'
+	FOR UPDATE
+`
+
+// ReleaseSQL returns rows $1 of a batch that failed before settling them to
+// pending, taking back the attempt: like a rollback, but the claim had
+// already committed.
+const ReleaseSQL = `
+	UPDATE outbox
+	SET status='pending', attempts=attempts-1
+	WHERE id = ANY($1) AND status='processing'
+`
+
+// LockSeasonsSQL takes the shared rebuild lock for each season in $1, which
+// must be sorted so two batches can't deadlock each other. While a rebuild
+// holds a season's exclusive lock, no worker applies deltas for it.
+const LockSeasonsSQL = `
+	SELECT pg_advisory_xact_lock_shared(hashtext('lb_rebuild'), hashtext(s))
+	FROM unnest($1::text[]) AS s
+`
+
+// DoneSQL marks rows $1 applied at $2.
+const DoneSQL = `
+	UPDATE outbox
+	SET status='done', processed_at=$2, last_error=NULL
+	WHERE id = ANY($1)
+`
+
+// SupersededCorrectionsSQL returns which of the score_correction rows $1,
+// for seasons $2 and users $3, were queued before the last rebuild of their
+// board or user (board_rebuilds).
+const SupersededCorrectionsSQL = `
+	SELECT c.id
+	FROM unnest($1::bigint[], $2::text[], $3::text[]) AS c(id, season_id, user_id)
+	WHERE EXISTS (
+	  SELECT 1 FROM board_rebuilds r
+	  WHERE r.season_id=c.season_id AND r.user_id IN ('', c.user_id) AND r.outbox_id >= c.id
+	)
+`
+
+// SupersededSQL settles score_correction rows $1 that a rebuild made
+// redundant without applying them.
+const SupersededSQL = `
+	UPDATE outbox
+	SET status='done', processed_at=now(), last_error='superseded by rebuild'
+	WHERE id = ANY($1)
+`
+
+// FailedSQL parks rows $1 that can't be applied at all with errors $2.
+const FailedSQL = `
+	UPDATE outbox o
+	SET status='failed', last_error=f.err
+	FROM unnest($1::bigint[], $2::text[]) AS f(id, err)
+	WHERE o.id=f.id
+`
+
+// RetrySQL backs off rows $1 with errors $2, or parks them as failed once
+// they've had $3 attempts; $4 and $5 are the base and maximum delay in
+// seconds. attempts was already bumped by ProcessingSQL.
+const RetrySQL = `
+	UPDATE outbox o
+	SET status=CASE WHEN o.attempts >= $3 THEN 'failed' ELSE 'pending' END,
+	    next_attempt_at=now() + LEAST(
+	      make_interval(secs => $4 * power(2, GREATEST(o.attempts-1, 0))),
+	      make_interval(secs => $5)),
+	    last_error=f.err
+	FROM unnest($1::bigint[], $2::text[]) AS f(id, err)
+	WHERE o.id=f.id
+`
+
+// RetryArgs are the arguments of RetrySQL after the ids and errors.
+func (r Retry) RetryArgs() []any {
+	return []any{r.MaxAttempts, r.Base.Seconds(), r.Max.Seconds()}
+}
+
+// Delay is the backoff before a row's next attempt after attempts so far, the
+// same as RetrySQL computes.
+func (r Retry) Delay(attempts int) time.Duration {
+	d := r.Base
+	for i := 1; i < attempts && d < r.Max; i++ {
+		d *= 2
+	}
+	return min(d, r.Max)
+}
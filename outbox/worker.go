@@ -0,0 +1,547 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// Worker applies the Postgres outbox to a leaderboard.RankStore; the binary
+// runs the same one. A batch claims due rows (from one outbox partition or
+// all of them) and commits them as processing with the attempt counted, then
+// in its own transaction locks them, takes the shared rebuild lock of their
+// seasons, coalesces them into one store call per (season, user) and settles
+// every row: done, backed off for a retry, or failed. A batch that fails
+// with an error hands its rows back uncounted (ReleaseSQL); one whose worker
+// dies leaves them processing until something reaps them.
+//
+// db must use pgx's database/sql driver (github.com/jackc/pgx/v5/stdlib),
+// directly or through a wrapper with an Unwrap() driver.Conn method: the
+// batch's writes go out as one pgx pipeline on the connection holding its
+// transaction. Several workers may share a database; SKIP LOCKED keeps their
+// batches apart.
+type Worker struct {
+	db    *sql.DB
+	store leaderboard.RankStore
+
+	BatchSize int
+	Retry     Retry
+	Timeout   time.Duration // for a whole batch, from claim to commit
+	Hooks     Hooks         // nil applies the rows as they are
+}
+
+// NewWorker returns a worker with the binary's default batch size, backoff
+// and timeout.
+func NewWorker(db *sql.DB, store leaderboard.RankStore) *Worker {
+	return &Worker{
+		db:        db,
+		store:     store,
+		BatchSize: 500,
+		Retry:     Retry{MaxAttempts: 10, Base: time.Second, Max: 5 * time.Minute},
+		Timeout:   5 * time.Second,
+	}
+}
+
+// Hooks add to a Worker's batches. The binary uses them for boosts, cheat
+// holds, webhooks, streams, tracing and metrics.
+type Hooks interface {
+	// Claimed runs once the rows are claimed and their seasons locked,
+	// before they're coalesced; it may set a score_delta row's Delta or
+	// Hold. The context it returns is used for the rest of the batch.
+	Claimed(ctx context.Context, b *Batch) (context.Context, error)
+	// Applied runs after the store calls, with each op's outcome, before the
+	// rows are settled.
+	Applied(ctx context.Context, b *Batch) error
+	// Done ends every batch Claimed ran for, with the batch's error (nil
+	// once it committed).
+	Done(ctx context.Context, b *Batch, err error)
+}
+
+// BatchStore is a RankStore that takes a batch's ops in one call (the
+// binary's pipelines them to Redis). ApplyBatch sets each op's Score or Err;
+// an error it returns rolls the batch back, to be retried without using up
+// its rows' attempts.
+type BatchStore interface {
+	leaderboard.RankStore
+	ApplyBatch(ctx context.Context, b *Batch) error
+}
+
+// Row is a claimed outbox row.
+type Row struct {
+	ID        int64
+	EventType string
+	CreatedAt time.Time
+	Payload   Payload
+	Err       error // the payload didn't decode; the row is failed
+
+	// Delta is what a score row adds to the board, Payload.Delta unless
+	// Hooks.Claimed changes it. Hold takes a score_delta row's user off the
+	// board instead.
+	Delta int64
+	Hold  bool
+}
+
+type OpKind int
+
+const (
+	OpApply  OpKind = iota // add the rows' deltas to the user's score
+	OpRemove               // take a held user off the board
+	OpDrop                 // drop the season's board
+)
+
+// Op is one store call of a batch. Rows are coalesced per (season, user); a
+// season drop closes the season's open ops, so rows after it are applied
+// after the drop.
+type Op struct {
+	Kind      OpKind
+	EventType string // OpDrop: season_deleted or season_archived
+	SeasonID  string
+	UserID    string // not for OpDrop
+	Rows      []*Row
+
+	Score float64 // OpApply: the user's score right after it, in points
+	Err   error   // the store's error; the op's rows are retried
+}
+
+func (op *Op) IDs() []int64 {
+	ids := make([]int64, len(op.Rows))
+	for i, r := range op.Rows {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+func (op *Op) Deltas() []int64 {
+	deltas := make([]int64, len(op.Rows))
+	for i, r := range op.Rows {
+		deltas[i] = r.Delta
+	}
+	return deltas
+}
+
+// RecordedAt is when the op's latest row was queued.
+func (op *Op) RecordedAt() time.Time {
+	var at time.Time
+	for _, r := range op.Rows {
+		if r.CreatedAt.After(at) {
+			at = r.CreatedAt
+		}
+	}
+	return at
+}
+
+func (op *Op) Total() int64 {
+	var total int64
+	for _, r := range op.Rows {
+		total += r.Delta
+	}
+	return total
+}
+
+// Batch is one round of a Worker, as its Hooks and a BatchStore see it.
+type Batch struct {
+	Tx        *sql.Tx
+	Partition int // -1 for all of them
+	Rows      []*Row
+	Ops       []*Op // once the rows are coalesced
+
+	StartedAt, ClaimedAt, AppliedAt, CommittedAt time.Time
+
+	Hook any // what the Hooks keep for the batch
+
+	writes pgx.Batch
+	names  []string // per statement, for errors
+}
+
+// Queue adds a statement to the batch's writes, which run in the order
+// queued right before the commit, together with the rows' settling updates.
+// Arguments are Go values (slices for arrays), not pq.Array.
+func (b *Batch) Queue(name, query string, args ...any) {
+	b.writes.Queue(query, args...)
+	b.names = append(b.names, name)
+}
+
+// Run processes batches until ctx is done, waiting interval whenever the
+// outbox has been drained. Batch errors are passed to onError, which may be
+// nil.
+func (w *Worker) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	for {
+		n, err := w.ProcessBatch(ctx)
+		if err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		if err == nil && n == w.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ProcessBatch applies up to BatchSize due rows and returns how many it
+// claimed.
+func (w *Worker) ProcessBatch(ctx context.Context) (int, error) {
+	return w.ProcessPartition(ctx, -1)
+}
+
+// ProcessPartition is ProcessBatch on one outbox partition, which the batch
+// holds until it commits; it returns 0 when another worker is on it.
+func (w *Worker) ProcessPartition(ctx context.Context, partition int) (n int, err error) {
+	b := &Batch{Partition: partition, StartedAt: time.Now()}
+	c, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	conn, err := w.db.Conn(c)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(c, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	b.Tx = tx
+
+	if partition >= 0 {
+		var claimed bool
+		if err := tx.QueryRowContext(c, ClaimPartitionSQL, partition).Scan(&claimed); err != nil {
+			return 0, fmt.Errorf("db partition claim failed: %w", err)
+		}
+		if !claimed {
+			return 0, nil
+		}
+	}
+	// on another connection: the claim commits while tx goes on
+	ids, err := b.claim(c, w.db, w.BatchSize)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	b.ClaimedAt = time.Now()
+	// A returned error hands the rows back; a panic or a killed process
+	// leaves them processing with the attempt counted.
+	defer func() {
+		if err == nil {
+			return
+		}
+		tx.Rollback() // its row locks would block the release
+		rc, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if _, rerr := w.db.ExecContext(rc, ReleaseSQL, pq.Array(ids)); rerr != nil {
+			err = fmt.Errorf("%w (and db release failed: %v)", err, rerr)
+		}
+	}()
+	if err := b.lockClaimed(c, ids); err != nil {
+		return 0, fmt.Errorf("db claimed rows lock failed: %w", err)
+	}
+	if len(b.Rows) == 0 {
+		return 0, nil
+	}
+	ids = ids[:0] // only what the batch holds is its to release
+	for _, r := range b.Rows {
+		ids = append(ids, r.ID)
+	}
+
+	// Waits out any rebuild running for these seasons.
+	if err := b.lockSeasons(c); err != nil {
+		return 0, fmt.Errorf("db rebuild lock failed: %w", err)
+	}
+	superseded, err := b.supersededCorrections(c)
+	if err != nil {
+		return 0, fmt.Errorf("db rebuild marks lookup failed: %w", err)
+	}
+
+	if w.Hooks != nil {
+		var hc context.Context
+		hc, err = w.Hooks.Claimed(c, b)
+		if hc != nil {
+			c = hc
+		}
+		defer func() { w.Hooks.Done(c, b, err) }()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	bad, badErrs, retired := b.coalesce(superseded)
+	if err := w.apply(c, b); err != nil {
+		return 0, err
+	}
+	b.AppliedAt = time.Now().UTC()
+	if w.Hooks != nil {
+		if err := w.Hooks.Applied(c, b); err != nil {
+			return 0, err
+		}
+	}
+
+	okIDs := make([]int64, 0, len(b.Rows))
+	var failIDs []int64
+	var failErrs []string
+	for _, op := range b.Ops {
+		if op.Err != nil {
+			for _, r := range op.Rows {
+				failIDs = append(failIDs, r.ID)
+				failErrs = append(failErrs, "apply error: "+op.Err.Error())
+			}
+			continue
+		}
+		okIDs = append(okIDs, op.IDs()...)
+	}
+	if len(okIDs) > 0 {
+		b.Queue("bulk done update", DoneSQL, okIDs, b.AppliedAt)
+	}
+	if len(retired) > 0 {
+		b.Queue("superseded update", SupersededSQL, retired)
+	}
+	if len(bad) > 0 {
+		b.Queue("bulk failed update", FailedSQL, bad, badErrs)
+	}
+	if len(failIDs) > 0 {
+		b.Queue("bulk retry update", RetrySQL, append([]any{failIDs, failErrs}, w.Retry.RetryArgs()...)...)
+	}
+
+	if err := b.send(c, conn); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	b.CommittedAt = time.Now()
+	return len(b.Rows), nil
+}
+
+// claim takes up to limit due rows in a transaction of its own on db and
+// commits them as processing, returning their ids.
+func (b *Batch) claim(ctx context.Context, db *sql.DB, limit int) ([]int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, ClaimSQL, limit, b.Partition)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		r := &Row{}
+		var payload []byte
+		if err := rows.Scan(&r.ID, &r.EventType, &payload, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Err = json.Unmarshal(payload, &r.Payload)
+		r.Delta = r.Payload.Delta
+		b.Rows = append(b.Rows, r)
+		ids = append(ids, r.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if _, err := tx.ExecContext(ctx, ProcessingSQL, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("db processing update failed: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// lockClaimed locks the claimed rows ids in the batch's transaction and drops
+// any that are no longer processing (reaped between the claim and the lock).
+func (b *Batch) lockClaimed(ctx context.Context, ids []int64) error {
+	rows, err := b.Tx.QueryContext(ctx, LockClaimedSQL, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	locked := make(map[int64]bool, len(ids))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		locked[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	b.Rows = slices.DeleteFunc(b.Rows, func(r *Row) bool { return !locked[r.ID] })
+	return nil
+}
+
+// lockSeasons takes the shared rebuild lock of every season in the batch,
+// sorted so two batches can't deadlock each other.
+func (b *Batch) lockSeasons(ctx context.Context) error {
+	seen := make(map[string]bool)
+	var seasons []string
+	for _, r := range b.Rows {
+		if r.Err == nil && !seen[r.Payload.SeasonID] {
+			seen[r.Payload.SeasonID] = true
+			seasons = append(seasons, r.Payload.SeasonID)
+		}
+	}
+	if len(seasons) == 0 {
+		return nil
+	}
+	sort.Strings(seasons)
+	_, err := b.Tx.ExecContext(ctx, LockSeasonsSQL, pq.Array(seasons))
+	return err
+}
+
+// supersededCorrections returns the batch's score_correction rows that were
+// queued before a rebuild of their board or user, and so were computed
+// against the scores it replaced. They are settled without being applied.
+func (b *Batch) supersededCorrections(ctx context.Context) (map[int64]bool, error) {
+	var ids []int64
+	var seasons, users []string
+	for _, r := range b.Rows {
+		if r.Err == nil && r.EventType == EventScoreCorrection {
+			ids = append(ids, r.ID)
+			seasons = append(seasons, r.Payload.SeasonID)
+			users = append(users, r.Payload.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	rows, err := b.Tx.QueryContext(ctx, SupersededCorrectionsSQL, pq.Array(ids), pq.Array(seasons), pq.Array(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out[id] = true
+	}
+	return out, rows.Err()
+}
+
+// coalesce turns the rows into b.Ops and returns the rows that can't be
+// applied at all, with their errors, and the superseded corrections.
+func (b *Batch) coalesce(superseded map[int64]bool) (bad []int64, badErrs []string, retired []int64) {
+	open := make(map[string]map[string]*Op) // season -> user -> op still taking rows
+	merge := func(kind OpKind, r *Row) {
+		users := open[r.Payload.SeasonID]
+		if users == nil {
+			users = make(map[string]*Op)
+			open[r.Payload.SeasonID] = users
+		}
+		op := users[r.Payload.UserID]
+		if op == nil || op.Kind != kind {
+			op = &Op{Kind: kind, SeasonID: r.Payload.SeasonID, UserID: r.Payload.UserID}
+			users[r.Payload.UserID] = op
+			b.Ops = append(b.Ops, op)
+		}
+		op.Rows = append(op.Rows, r)
+	}
+
+	for _, r := range b.Rows {
+		if r.Err != nil {
+			bad, badErrs = append(bad, r.ID), append(badErrs, "json error: "+r.Err.Error())
+			continue
+		}
+		switch r.EventType {
+		case EventScoreDelta:
+			if r.Hold {
+				// also clears a member re-added by a delta applied just before the hold
+				merge(OpRemove, r)
+			} else {
+				merge(OpApply, r)
+			}
+		case EventScoreCorrection:
+			if superseded[r.ID] {
+				retired = append(retired, r.ID)
+				continue
+			}
+			// a delta, so ordering against score_delta doesn't matter
+			merge(OpApply, r)
+		case EventSeasonDeleted, EventSeasonArchived:
+			delete(open, r.Payload.SeasonID)
+			b.Ops = append(b.Ops, &Op{Kind: OpDrop, EventType: r.EventType, SeasonID: r.Payload.SeasonID, Rows: []*Row{r}})
+		default:
+			bad, badErrs = append(bad, r.ID), append(badErrs, "unknown event_type: "+r.EventType)
+		}
+	}
+	return bad, badErrs, retired
+}
+
+// apply makes the batch's store calls: all at once on a BatchStore, else one
+// op at a time.
+func (w *Worker) apply(ctx context.Context, b *Batch) error {
+	if len(b.Ops) == 0 {
+		return nil
+	}
+	if bs, ok := w.store.(BatchStore); ok {
+		return bs.ApplyBatch(ctx, b)
+	}
+	for _, op := range b.Ops {
+		switch op.Kind {
+		case OpApply:
+			op.Score, op.Err = w.store.Apply(ctx, op.SeasonID, op.UserID, op.IDs(), op.Deltas(), op.RecordedAt())
+		case OpRemove:
+			op.Err = w.store.Remove(ctx, op.SeasonID, op.UserID)
+		case OpDrop:
+			op.Err = w.store.Drop(ctx, op.SeasonID)
+		}
+		if errors.Is(op.Err, context.Canceled) || errors.Is(op.Err, context.DeadlineExceeded) {
+			return op.Err
+		}
+	}
+	return nil
+}
+
+// send runs the queued writes on conn, which holds the batch's transaction.
+func (b *Batch) send(ctx context.Context, conn *sql.Conn) error {
+	return conn.Raw(func(dc any) error {
+		pc, ok := pgxConn(dc)
+		if !ok {
+			return fmt.Errorf("outbox: database/sql driver connection %T is not pgx's", dc)
+		}
+		br := pc.Conn().SendBatch(ctx, &b.writes)
+		for _, name := range b.names {
+			if _, err := br.Exec(); err != nil {
+				br.Close()
+				return fmt.Errorf("db %s failed: %w", name, err)
+			}
+		}
+		return br.Close()
+	})
+}
+
+// pgxConn unwraps the driver connection handed to (*sql.Conn).Raw.
+func pgxConn(dc any) (*stdlib.Conn, bool) {
+	for {
+		switch c := dc.(type) {
+		case *stdlib.Conn:
+			return c, true
+		case interface{ Unwrap() driver.Conn }:
+			dc = c.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Outbox cleanup: done rows are only history once applied, but they stay in
+// the table the worker scans. Rows processed more than OUTBOX_RETENTION_DAYS
+// ago are deleted in batches, or moved to outbox_archive when archiving is on.
+// Failed rows are kept for the dead-letter endpoints. Each batch is a single
+// DELETE ... RETURNING, so concurrent runs on other instances never copy a
+// row twice.
+//
+// The outbox is partitioned by status (migrations/), so the done tail never
+// sits in the pending scans: applying a row moves it from outbox_live to
+// outbox_done, which is split by processing day (a worker that then tries to
+// lock the moved row gets a serialization failure and retries its batch, see
+// retry.go). Each run creates the day
+// partitions for the next few days, and a day that is entirely past the
+// retention is dropped as a whole (copied to outbox_archive first when
+// archiving is on) before the batched delete handles the rest. Day
+// partitions are only created for days that haven't started, since rows
+// already in outbox_done_default would block them; those rows and any on an
+// unpartitioned outbox are removed in batches.
+
+const (
+	outboxCleanupBatch = 5000
+	outboxDaysAhead    = 3
+	outboxDayLayout    = "20060102"
+)
+
+func runOutboxCleanup(ctx context.Context, db *sql.DB, interval time.Duration, retentionDays int, archive bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := maintainOutboxDays(ctx, db, retentionDays, archive); err != nil {
+				slog.Error("Outbox partition maintenance error", "err", err)
+			}
+			n, err := cleanupOutbox(ctx, db, retentionDays, archive)
+			if err != nil {
+				slog.Error("Outbox cleanup error", "err", err)
+			}
+			if n > 0 {
+				slog.Info("Outbox cleanup removed done rows", "rows", n, "archived", archive)
+			}
+		}
+	}
+}
+
+func cleanupOutbox(ctx context.Context, db *sql.DB, retentionDays int, archive bool) (int64, error) {
+	query := `
+	DELETE FROM outbox
+	WHERE id IN (
+	  SELECT id FROM outbox
+	  WHERE status='done' AND processed_at < now() - make_interval(days => $1)
+	  LIMIT $2
+	)
+`
+	if archive {
+		query = `
+	WITH moved AS (
+	  DELETE FROM outbox
+	  WHERE id IN (
+	    SELECT id FROM outbox
+	    WHERE status='done' AND processed_at < now() - make_interval(days => $1)
+	    LIMIT $2
+	  )
+	  RETURNING id, event_type, payload, attempts, request_id, created_at, processed_at
+	)
+	INSERT INTO outbox_archive (id, event_type, payload, attempts, request_id, created_at, processed_at)
+	SELECT id, event_type, payload, attempts, request_id, created_at, processed_at FROM moved
+`
+	}
+
+	var total int64
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		c, cancel := context.WithTimeout(ctx, 30*time.Second)
+		res, err := db.ExecContext(c, query, retentionDays, outboxCleanupBatch)
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < outboxCleanupBatch {
+			return total, nil
+		}
+	}
+}
+
+// maintainOutboxDays creates the coming days' outbox_done partitions and
+// drops the expired ones. One instance runs it at a time.
+func maintainOutboxDays(ctx context.Context, db *sql.DB, retentionDays int, archive bool) error {
+	var partitioned bool
+	if err := db.QueryRowContext(ctx, `
+	SELECT EXISTS (SELECT 1 FROM pg_partitioned_table WHERE partrelid = to_regclass('outbox_done'))
+`).Scan(&partitioned); err != nil || !partitioned {
+		return err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_lock(hashtext('lb_outbox_days'))`).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('lb_outbox_days'))`)
+
+	days, err := outboxDays(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for i := 1; i <= outboxDaysAhead; i++ {
+		day := today.AddDate(0, 0, i)
+		if days[day] {
+			continue
+		}
+		if err := createOutboxDay(ctx, db, day); err != nil {
+			return fmt.Errorf("create %s: %w", day.Format(time.DateOnly), err)
+		}
+	}
+
+	// a day is dropped once all of it is older than the retention
+	expired := today.AddDate(0, 0, -retentionDays)
+	for day := range days {
+		if !day.Before(expired) {
+			continue
+		}
+		n, err := dropOutboxDay(ctx, db, day, archive)
+		if err != nil {
+			return fmt.Errorf("drop %s: %w", day.Format(time.DateOnly), err)
+		}
+		slog.Info("Outbox cleanup dropped day partition", "day", day.Format(time.DateOnly), "rows", n, "archived", archive)
+	}
+	return nil
+}
+
+// outboxDays lists the days that have an outbox_done partition.
+func outboxDays(ctx context.Context, db *sql.DB) (map[time.Time]bool, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = to_regclass('outbox_done')
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := make(map[time.Time]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if day, err := time.Parse(outboxDayLayout, strings.TrimPrefix(name, "outbox_done_")); err == nil {
+			days[day] = true
+		}
+	}
+	return days, rows.Err()
+}
+
+func outboxDayTable(day time.Time) string {
+	return pq.QuoteIdentifier("outbox_done_" + day.Format(outboxDayLayout))
+}
+
+func createOutboxDay(ctx context.Context, db *sql.DB, day time.Time) error {
+	c, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Creating a partition locks outbox_done against the worker's done updates.
+	// Its key on id is the one outbox can't have (migrations/0029).
+	if _, err := tx.ExecContext(c, `SET LOCAL lock_timeout = '`+partitionLockTimeout+`'`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(c, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF outbox_done (PRIMARY KEY (id)) FOR VALUES FROM (%s) TO (%s)`,
+		outboxDayTable(day), pq.QuoteLiteral(day.Format(time.RFC3339)), pq.QuoteLiteral(day.AddDate(0, 0, 1).Format(time.RFC3339)))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// dropOutboxDay drops one day of done rows, copying them to outbox_archive
+// first when archive is set; it returns how many rows the day held.
+func dropOutboxDay(ctx context.Context, db *sql.DB, day time.Time, archive bool) (int64, error) {
+	c, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// A past day takes no new rows, so it's read without blocking the worker;
+	// only the DROP locks outbox_done, briefly.
+	table := outboxDayTable(day)
+	var n int64
+	if archive {
+		res, err := tx.ExecContext(c, `
+	INSERT INTO outbox_archive (id, event_type, payload, attempts, request_id, created_at, processed_at)
+	SELECT id, event_type, payload, attempts, request_id, created_at, processed_at FROM `+table)
+		if err != nil {
+			return 0, err
+		}
+		n, _ = res.RowsAffected()
+	} else if err := tx.QueryRowContext(c, `SELECT COUNT(*) FROM `+table).Scan(&n); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(c, `SET LOCAL lock_timeout = '`+partitionLockTimeout+`'`); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(c, `DROP TABLE `+table); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
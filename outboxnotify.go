@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+)
+
+// An AFTER INSERT trigger on outbox (see migrations/) runs pg_notify on the
+// lb_outbox channel, which Postgres delivers when the writing transaction
+// commits. The listener holds one dedicated connection and turns each
+// notification into a non-blocking wake-up for each worker, so a burst of
+// writes coalesces into a single drain. While the connection is down the
+// worker's slow poll keeps things moving.
+
+const outboxNotifyChannel = "lb_outbox"
+
+func runOutboxListener(ctx context.Context, db *sql.DB, wake []chan struct{}) {
+	for ctx.Err() == nil {
+		err := listenOutbox(ctx, db, wake)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("Outbox listener error", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func listenOutbox(ctx context.Context, db *sql.DB, wake []chan struct{}) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(dc any) error {
+		pc := pgxConn(dc).Conn()
+		if _, listenErr = pc.Exec(ctx, "LISTEN "+outboxNotifyChannel); listenErr == nil {
+			// Anything committed while we weren't listening would otherwise wait for the poll.
+			signalWake(wake)
+			for {
+				if _, listenErr = pc.WaitForNotification(ctx); listenErr != nil {
+					break
+				}
+				signalWake(wake)
+			}
+		}
+		// The connection is still subscribed; drop it rather than return it to the pool.
+		return driver.ErrBadConn
+	})
+	return listenErr
+}
+
+func signalWake(wake []chan struct{}) {
+	for _, ch := range wake {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+)
+
+// Partitioned workers: every outbox row carries outbox_partition, a generated
+// column hashing its season into one of outboxPartitions buckets (migrations/).
+// With OUTBOX_PARTITIONED on, each batch claims a single partition with a
+// transaction-level advisory lock and only scans that partition's rows, so
+// many replicas spread over the partitions instead of all racing on the head
+// of one SKIP LOCKED scan. A season always maps to the same partition, so its
+// rows keep being applied in id order by one worker at a time.
+
+// outboxPartitions must match the mask in the outbox_partition column.
+const outboxPartitions = 16
+
+// pendingOutboxPartitions lists the partitions that have pending rows, in a
+// random order so workers start on different ones.
+func pendingOutboxPartitions(ctx context.Context, db *sql.DB) ([]int, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT DISTINCT outbox_partition FROM outbox WHERE status='pending'
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []int
+	for rows.Next() {
+		var p int
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	rand.Shuffle(len(parts), func(i, j int) { parts[i], parts[j] = parts[j], parts[i] })
+	return parts, rows.Err()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// outboxTuning is the part of the worker's settings a config reload can
+// change (reload.go); every worker reads it before each poll and batch.
+type outboxTuning struct {
+	batchSize atomic.Int64
+	pollMin   tunableDuration
+	pollMax   tunableDuration
+}
+
+func (t *outboxTuning) set(batchSize int, pollMin, pollMax time.Duration) {
+	t.batchSize.Store(int64(batchSize))
+	t.pollMin.set(pollMin)
+	t.pollMax.set(max(pollMax, pollMin))
+}
+
+// outboxPoll is the worker's poll schedule: every idle poll doubles the delay
+// up to max, and finding work (or a NOTIFY wake-up) drops it back to min.
+// Each delay is jittered by ±20% so instances started together drift apart
+// instead of hitting Postgres in lockstep.
+type outboxPoll struct {
+	tuning *outboxTuning
+	cur    time.Duration
+}
+
+func newOutboxPoll(t *outboxTuning) *outboxPoll {
+	return &outboxPoll{tuning: t, cur: t.pollMin.get()}
+}
+
+func (p *outboxPoll) busy() { p.cur = p.tuning.pollMin.get() }
+
+func (p *outboxPoll) idle() {
+	p.cur = max(min(p.cur*2, p.tuning.pollMax.get()), p.tuning.pollMin.get())
+}
+
+// next returns the jittered delay until the next poll.
+func (p *outboxPoll) next() time.Duration {
+	spread := int64(p.cur) / 5
+	if spread <= 0 {
+		return p.cur
+	}
+	return p.cur + time.Duration(rand.Int64N(2*spread+1)-spread)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// outboxStats is the backlog overview behind GET /v1/admin/outbox/stats.
+type outboxStats struct {
+	Counts           map[string]int64 `json:"counts"` // rows by status
+	OldestPendingAt  *time.Time       `json:"oldestPendingAt,omitempty"`
+	OldestPendingAge float64          `json:"oldestPendingAgeSeconds"`
+	Attempts         []outboxAttempts `json:"attempts"` // unfinished rows by attempt count
+	Throughput       outboxThroughput `json:"throughput"`
+	ApplyLatency     outboxLatency    `json:"applyLatency"`
+}
+
+type outboxAttempts struct {
+	Attempts int   `json:"attempts"`
+	Rows     int64 `json:"rows"`
+}
+
+// outboxThroughput counts rows marked done in the trailing windows.
+type outboxThroughput struct {
+	Last1m    int64   `json:"last1m"`
+	Last5m    int64   `json:"last5m"`
+	Last1h    int64   `json:"last1h"`
+	PerSecond float64 `json:"perSecond"` // over the last minute
+}
+
+// outboxLatency is the time from outbox insert to Redis apply (processed_at is
+// stamped right after the pipeline returns) for score events applied in the
+// last five minutes; retries count toward it.
+type outboxLatency struct {
+	Samples int64   `json:"samples"`
+	P50Ms   float64 `json:"p50Ms"`
+	P95Ms   float64 `json:"p95Ms"`
+	P99Ms   float64 `json:"p99Ms"`
+}
+
+func getOutboxStats(ctx context.Context, db *sql.DB) (*outboxStats, error) {
+	st := &outboxStats{
+		Counts:   map[string]int64{"pending": 0, "processing": 0, "done": 0, "failed": 0},
+		Attempts: []outboxAttempts{},
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT status, COUNT(*) FROM outbox GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		st.Counts[status] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var oldest sql.NullTime
+	var age sql.NullFloat64
+	if err := db.QueryRowContext(ctx, `
+	SELECT MIN(created_at), EXTRACT(EPOCH FROM now() - MIN(created_at))::float8
+	FROM outbox WHERE status='pending'
+`).Scan(&oldest, &age); err != nil {
+		return nil, err
+	}
+	if oldest.Valid {
+		st.OldestPendingAt = &oldest.Time
+		st.OldestPendingAge = age.Float64
+	}
+
+	rows, err = db.QueryContext(ctx, `
+	SELECT attempts, COUNT(*) FROM outbox
+	WHERE status IN ('pending', 'processing', 'failed')
+	GROUP BY attempts
+	ORDER BY attempts
+`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a outboxAttempts
+		if err := rows.Scan(&a.Attempts, &a.Rows); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		st.Attempts = append(st.Attempts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	t := &st.Throughput
+	if err := db.QueryRowContext(ctx, `
+	SELECT
+	  COUNT(*) FILTER (WHERE processed_at > now() - interval '1 minute'),
+	  COUNT(*) FILTER (WHERE processed_at > now() - interval '5 minutes'),
+	  COUNT(*)
+	FROM outbox
+	WHERE status='done' AND processed_at > now() - interval '1 hour'
+`).Scan(&t.Last1m, &t.Last5m, &t.Last1h); err != nil {
+		return nil, err
+	}
+	t.PerSecond = float64(t.Last1m) / 60
+
+	l := &st.ApplyLatency
+	var p50, p95, p99 sql.NullFloat64
+	if err := db.QueryRowContext(ctx, `
+	SELECT n, q[1], q[2], q[3]
+	FROM (
+	  SELECT COUNT(*),
+	    percentile_cont(ARRAY[0.5, 0.95, 0.99]) WITHIN GROUP (
+	      ORDER BY EXTRACT(EPOCH FROM processed_at - created_at)::float8 * 1000)
+	  FROM outbox
+	  WHERE status='done' AND event_type='score_delta' AND processed_at > now() - interval '5 minutes'
+	) t(n, q)
+`).Scan(&l.Samples, &p50, &p95, &p99); err != nil {
+		return nil, err
+	}
+	l.P50Ms, l.P95Ms, l.P99Ms = p50.Float64, p95.Float64, p99.Float64
+	return st, nil
+}
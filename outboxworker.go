@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/outbox"
+	"github.com/redis/go-redis/v9"
+)
+
+// The binary runs outbox.Worker, which claims, locks, coalesces and settles
+// the rows. What it adds goes in through the worker's extension points:
+// boardStore applies a batch to Redis in one pipeline through the
+// leaderboard package's store (board size caps, tie fractions, board
+// versions), and outboxHooks adds boosts, cheat holds,
+// webhooks, streams, subscriptions, tracing and metrics.
+
+// outboxConfig is how the worker batches and applies rows.
+type outboxConfig struct {
+	batchSize   int // from outboxTuning at the start of each round
+	retry       outbox.Retry
+	transient   transientRetry // for a whole batch that failed on a dropped connection or failover
+	dedupWindow time.Duration  // 0 = apply without markers (see leaderboard/keys.go)
+	partitioned bool           // claim one partition per batch (see outboxpartition.go)
+	stream      bool           // queue stream_events for a publisher (see stream.go)
+	tenants     *tenancy       // events show a tenant's season by the id it uses
+}
+
+// newOutboxWorker wires the binary's store and hooks into an outbox.Worker.
+func newOutboxWorker(db *sql.DB, rdb redis.UniversalClient, defaultMaxSize int64, cfg outboxConfig) *outbox.Worker {
+	w := outbox.NewWorker(db, boardStore{newBoardRedisStore(db, rdb, defaultMaxSize, cfg.dedupWindow)})
+	w.BatchSize = cfg.batchSize
+	w.Retry = cfg.retry
+	w.Hooks = &outboxHooks{rdb: rdb, cfg: cfg}
+	return w
+}
+
+// runOutboxWorker drains the outbox whenever wake fires (a NOTIFY from the
+// write path) and otherwise polls on an adaptive schedule as a fallback. Full
+// batches are followed immediately by the next one.
+func runOutboxWorker(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, breaker *redisBreaker, defaultMaxSize int64, cfg outboxConfig, tuning *outboxTuning, wake <-chan struct{}) {
+	poll := newOutboxPoll(tuning)
+	if cfg.dedupWindow > 0 {
+		if err := leaderboard.ApplyDeltasScript.Load(ctx, rdb).Err(); err != nil {
+			slog.Error("Worker script load error", "err", err)
+		}
+	} else if tieBreakFirst() {
+		if err := leaderboard.TieIncrScript.Load(ctx, rdb).Err(); err != nil {
+			slog.Error("Worker script load error", "err", err)
+		}
+	}
+	w := newOutboxWorker(db, rdb, defaultMaxSize, cfg)
+
+	timer := time.NewTimer(poll.next())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			poll.busy()
+		case <-timer.C:
+		}
+		if !breaker.available() {
+			// Redis is down: leave the rows pending rather than claim and roll back batch after batch.
+			poll.idle()
+			timer.Reset(poll.next())
+			continue
+		}
+
+		w.BatchSize = int(tuning.batchSize.Load())
+		parts := []int{-1}
+		if cfg.partitioned {
+			var err error
+			if parts, err = pendingOutboxPartitions(ctx, db); err != nil {
+				slog.Error("Worker partitions error", "err", err)
+			}
+		}
+
+		found := false
+		for _, part := range parts {
+			for ctx.Err() == nil {
+				// A batch that has started runs to completion (bounded by its own
+				// timeout) instead of being rolled back halfway through shutdown.
+				var n int
+				err := cfg.transient.do(ctx, func() error {
+					var err error
+					n, err = w.ProcessPartition(context.WithoutCancel(ctx), part)
+					return err
+				})
+				if err != nil {
+					if err != sql.ErrNoRows {
+						slog.Error("Worker error", "err", err)
+					}
+					break
+				}
+				found = found || n > 0
+				if n < w.BatchSize {
+					break
+				}
+			}
+		}
+		if found {
+			poll.busy()
+		} else {
+			poll.idle()
+		}
+		timer.Reset(poll.next())
+	}
+}
+
+// boardStore is the binary's rank store for the worker: the store from
+// newBoardRedisStore, taking a batch's ops as one ApplyChanges pipeline. A
+// user trimmed off a capped board starts again from their ledger total, read
+// in the batch's transaction (seedTrimmedMembers).
+type boardStore struct {
+	*leaderboard.RedisStore
+}
+
+func (s boardStore) ApplyBatch(ctx context.Context, b *outbox.Batch) error {
+	changes := make([]*leaderboard.Change, len(b.Ops))
+	for i, op := range b.Ops {
+		c := &leaderboard.Change{SeasonID: op.SeasonID, UserID: op.UserID}
+		switch op.Kind {
+		case outbox.OpApply:
+			c.Kind, c.IDs, c.Deltas, c.At = leaderboard.ChangeApply, op.IDs(), op.Deltas(), op.RecordedAt()
+		case outbox.OpRemove:
+			c.Kind = leaderboard.ChangeRemove
+		case outbox.OpDrop:
+			c.Kind = leaderboard.ChangeDrop
+		}
+		changes[i] = c
+	}
+	seed := func(ctx context.Context, members []leaderboard.Member) (map[leaderboard.Member]leaderboard.Seed, error) {
+		return seedTrimmedMembers(ctx, b.Tx, members)
+	}
+	if err := s.ApplyChanges(ctx, changes, seed); err != nil {
+		return err
+	}
+	for i, op := range b.Ops {
+		op.Score, op.Err = changes[i].Score, changes[i].Err
+	}
+	return nil
+}
+
+// outboxHooks adds the binary's features to each batch. Users held by cheat
+// review stay off the board (their deltas are only in the ledger), active
+// boosts scale score_delta rows, and applied rows are exported to webhooks,
+// the event stream and subscriptions in the batch's transaction, so an event
+// is exported iff it reached the board.
+type outboxHooks struct {
+	rdb redis.UniversalClient
+	cfg outboxConfig
+}
+
+// hookBatch is what outboxHooks keeps for a batch.
+type hookBatch struct {
+	span    *span
+	boosts  map[string]activeBoost
+	hooks   map[string]struct{}
+	subs    []webhookSubscription
+	changed map[string]*seasonUpdate
+}
+
+func (h *outboxHooks) Claimed(ctx context.Context, b *outbox.Batch) (context.Context, error) {
+	// The batch links to the traces of the scores in it, which each get their
+	// queue wait and apply once it commits.
+	ctx, sp := startSpanAt(ctx, "outbox batch", spanKindConsumer, b.StartedAt)
+	sp.setAttr("outbox.items", len(b.Rows))
+	if b.Partition >= 0 {
+		sp.setAttr("outbox.partition", b.Partition)
+	}
+	for _, r := range b.Rows {
+		sp.addLink(r.Payload.Traceparent)
+	}
+	hb := &hookBatch{span: sp}
+	b.Hook = hb
+
+	var scoreSeasons, scoreUsers, seasons []string
+	for _, r := range b.Rows {
+		if r.Err != nil {
+			continue
+		}
+		if r.EventType == outbox.EventScoreDelta {
+			scoreSeasons = append(scoreSeasons, r.Payload.SeasonID)
+			scoreUsers = append(scoreUsers, r.Payload.UserID)
+		}
+		if !slices.Contains(seasons, r.Payload.SeasonID) {
+			seasons = append(seasons, r.Payload.SeasonID)
+		}
+	}
+	held, err := heldUsers(ctx, b.Tx, scoreSeasons, scoreUsers)
+	if err != nil {
+		return ctx, fmt.Errorf("db held users lookup failed: %w", err)
+	}
+	if hb.boosts, err = activeBoosts(ctx, b.Tx, scoreSeasons); err != nil {
+		return ctx, fmt.Errorf("db boosts lookup failed: %w", err)
+	}
+	if hb.hooks, err = webhookSeasons(ctx, b.Tx, scoreSeasons); err != nil {
+		return ctx, fmt.Errorf("db webhooks lookup failed: %w", err)
+	}
+	if hb.subs, err = activeSubscriptions(ctx, b.Tx, seasons); err != nil {
+		return ctx, fmt.Errorf("db webhook subscriptions lookup failed: %w", err)
+	}
+
+	for _, r := range b.Rows {
+		if r.Err != nil || r.EventType != outbox.EventScoreDelta {
+			continue
+		}
+		p := r.Payload
+		if _, ok := held[p.SeasonID+"\x00"+p.UserID]; ok {
+			r.Hold = true
+			continue
+		}
+		// Without an event id the ledger row can't be rewritten, so such rows are applied unboosted.
+		if bst, ok := hb.boosts[p.SeasonID]; ok && p.EventID != 0 {
+			r.Delta = boostedDelta(p.Delta, bst.multiplier)
+		}
+	}
+	return ctx, nil
+}
+
+func (h *outboxHooks) Applied(ctx context.Context, b *outbox.Batch) error {
+	hb := b.Hook.(*hookBatch)
+	hb.changed = make(map[string]*seasonUpdate)
+	var boosted []boostedEvent
+	var exports []webhookEvent
+	var streamed []streamEvent
+	var subEvents []subscriptionEvent
+	var topCands []topCandidate
+
+	for _, op := range b.Ops {
+		if op.Err != nil {
+			continue
+		}
+		u := hb.changed[op.SeasonID]
+		if u == nil {
+			u = &seasonUpdate{SeasonID: op.SeasonID}
+			hb.changed[op.SeasonID] = u
+		}
+		if op.Kind == outbox.OpDrop {
+			u.Dropped = true
+		} else if !slices.Contains(u.Users, op.UserID) {
+			u.Users = append(u.Users, op.UserID)
+		}
+		if op.Kind == outbox.OpDrop {
+			tenant, sid := h.cfg.tenants.splitSeason(op.SeasonID)
+			data := map[string]any{"seasonId": sid, "appliedAt": b.AppliedAt}
+			if tenant != "" {
+				data["tenantId"] = tenant
+			}
+			if h.cfg.stream {
+				streamed = append(streamed, streamEvent{eventType: op.EventType, seasonID: op.SeasonID, data: data})
+			}
+			subType := subEventSeasonDeleted
+			if op.EventType == outbox.EventSeasonArchived {
+				subType = subEventSeasonArchived
+			}
+			subEvents = append(subEvents, subscriptionEvent{eventType: subType, seasonID: op.SeasonID, data: data})
+		}
+		if op.Kind != outbox.OpApply {
+			continue
+		}
+
+		total := op.Total()
+		if total > 0 && len(topThresholds(hb.subs, op.SeasonID)) > 0 {
+			topCands = append(topCands, topCandidate{seasonID: op.SeasonID, userID: op.UserID, before: op.Score - float64(total), after: op.Score})
+		}
+		_, hooked := hb.hooks[op.SeasonID]
+		export := hooked || h.cfg.stream || subscriptionsWant(hb.subs, subEventScoreApplied, op.SeasonID)
+		// Each row's event gets the score as of that row: the final score
+		// minus the deltas of the rows after it.
+		rest := total
+		for _, r := range op.Rows {
+			rest -= r.Delta
+			if r.EventType != outbox.EventScoreDelta {
+				continue // corrections aren't exported
+			}
+			// Only applied boosts touch the ledger; a retried row is re-boosted from its raw payload delta.
+			if r.Delta != r.Payload.Delta {
+				boosted = append(boosted, boostedEvent{eventID: r.Payload.EventID, raw: r.Payload.Delta, boosted: r.Delta, boostID: hb.boosts[op.SeasonID].id})
+			}
+			if !export {
+				continue
+			}
+			ex := webhookEvent{EventID: r.Payload.EventID, SeasonID: op.SeasonID, UserID: op.UserID, Delta: r.Delta,
+				Score: op.Score - float64(rest), AppliedAt: b.AppliedAt}
+			if hooked {
+				exports = append(exports, ex)
+			}
+			out := ex.forTenant(h.cfg.tenants)
+			if h.cfg.stream {
+				streamed = append(streamed, streamEvent{eventType: "score_applied", seasonID: op.SeasonID, data: out})
+			}
+			subEvents = append(subEvents, subscriptionEvent{eventType: subEventScoreApplied, seasonID: op.SeasonID, data: out})
+		}
+	}
+
+	recordBoostedEvents(b, boosted)
+	queueWebhookEvents(b, exports, h.cfg.tenants)
+	queueStreamEvents(b, streamed)
+
+	// Top N entries are a notification, not part of the apply: on a Redis error they're skipped.
+	entered, err := topEnteredEvents(ctx, h.rdb, hb.subs, topCands, b.AppliedAt, h.cfg.tenants)
+	if err != nil {
+		slog.Error("Top N check error", "err", err)
+	}
+	queueSubscriptionEvents(b, hb.subs, append(subEvents, entered...))
+	return nil
+}
+
+func (h *outboxHooks) Done(ctx context.Context, b *outbox.Batch, err error) {
+	hb := b.Hook.(*hookBatch)
+	defer hb.span.finish(err)
+	if err != nil {
+		return
+	}
+	for _, op := range b.Ops {
+		for _, r := range op.Rows {
+			traceOutboxRow(r.Payload.Traceparent, r.EventType, r.ID, r.CreatedAt, b.ClaimedAt, b.CommittedAt, op.Err == nil, hb.span)
+			if op.Err != nil {
+				metrics.outboxProcessed.inc(r.EventType, "error")
+				continue
+			}
+			metrics.outboxProcessed.inc(r.EventType, "applied")
+			if r.EventType == outbox.EventScoreDelta {
+				metrics.outboxApplyLatency.observe(b.AppliedAt.Sub(r.CreatedAt).Seconds())
+			}
+		}
+	}
+	metrics.outboxBatchDuration.observe(time.Since(b.StartedAt).Seconds())
+	if err := publishSeasonUpdates(ctx, h.rdb, hb.changed); err != nil {
+		slog.Error("Season update publish error", "err", err)
+	}
+}
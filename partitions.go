@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// score_events is LIST-partitioned by season_id (migrations/). The submit path
+// only inserts the seasons row, so a new season's first events land in
+// score_events_default; the season-partitions job (worker mode) then gives
+// each season found there a partition of its own and moves its rows over,
+// ids and all. An admin can do the same ahead of the first event
+// (PUT /v1/admin/seasons/{sid}), in which case nothing has to move. Deleting
+// a season or pruning an archived one then drops that table instead of
+// deleting its rows in batches. Seasons that already had more than
+// partitionMoveMaxRows rows when they were found stay in the default
+// partition and are deleted in batches.
+//
+// Creating or dropping a partition takes an ACCESS EXCLUSIVE lock on
+// score_events, so submissions wait for it; lock_timeout keeps a busy table
+// from queueing writers behind it for long, and the row cap bounds how long
+// a move holds it.
+
+const (
+	partitionLockTimeout = "2s"
+	partitionMoveMaxRows = 10000
+)
+
+// seasonPartition names a season's partition; season IDs can be any text, so
+// the name comes from a hash.
+func seasonPartition(seasonID string) string {
+	return fmt.Sprintf("score_events_s_%x", md5.Sum([]byte(seasonID)))
+}
+
+var (
+	errPartitionBusy  = errors.New("score_events is busy")
+	errSeasonTooLarge = errors.New("season has too many events in the default partition to move")
+)
+
+// scoreEventColumns lists what a move copies; tenant_id is generated.
+const scoreEventColumns = `id, season_id, user_id, delta, raw_delta, boost_id, compacted, import_id, request_id, created_at`
+
+// createSeasonPartition creates the seasons row if it is missing and gives
+// the season its partition; see partitionSeason.
+func createSeasonPartition(ctx context.Context, db *sql.DB, seasonID string) (bool, error) {
+	if _, err := db.ExecContext(ctx, `INSERT INTO seasons (season_id) VALUES ($1) ON CONFLICT (season_id) DO NOTHING`, seasonID); err != nil {
+		return false, err
+	}
+	return partitionSeason(ctx, db, seasonID)
+}
+
+// partitionSeason gives a season its partition, moving any rows it has in
+// score_events_default, and reports whether it created one (false: the
+// season already had it). It fails with errSeasonTooLarge when there are more
+// than partitionMoveMaxRows rows to move, and with errPartitionBusy when the
+// lock can't be had within lock_timeout; the caller may retry that.
+func partitionSeason(ctx context.Context, db *sql.DB, seasonID string) (bool, error) {
+	name := seasonPartition(seasonID)
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, pq.QuoteIdentifier(name)).Scan(&exists); err != nil || exists {
+		return false, err
+	}
+	// counted before taking the lock, so a season too large to move doesn't
+	// hold up submissions while the move finds that out
+	var rows int64
+	if err := db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM (SELECT 1 FROM score_events_default WHERE season_id=$1 LIMIT $2) e
+`, seasonID, partitionMoveMaxRows+1).Scan(&rows); err != nil {
+		return false, err
+	}
+	if rows > partitionMoveMaxRows {
+		return false, errSeasonTooLarge
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SET LOCAL lock_timeout = '`+partitionLockTimeout+`'`); err != nil {
+		return false, err
+	}
+	// Taken up front rather than by the CREATE below, so no row for the season
+	// can reach the default partition between moving its rows out and
+	// creating the partition they go back into.
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE score_events IN ACCESS EXCLUSIVE MODE`); err != nil {
+		var pgErr interface{ SQLState() string }
+		if errors.As(err, &pgErr) && pgErr.SQLState() == "55P03" { // lock_not_available
+			return false, errPartitionBusy
+		}
+		return false, err
+	}
+	// another replica may have got here first
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, pq.QuoteIdentifier(name)).Scan(&exists); err != nil || exists {
+		return false, err
+	}
+
+	lit := pq.QuoteLiteral(seasonID)
+	stmts := []string{
+		`CREATE TEMP TABLE score_events_move ON COMMIT DROP AS
+		   SELECT ` + scoreEventColumns + ` FROM score_events_default WHERE season_id = ` + lit,
+		`DELETE FROM score_events_default WHERE season_id = ` + lit,
+		fmt.Sprintf(`CREATE TABLE %s PARTITION OF score_events FOR VALUES IN (%s)`, pq.QuoteIdentifier(name), lit),
+		`INSERT INTO score_events (` + scoreEventColumns + `) OVERRIDING SYSTEM VALUE
+		   SELECT ` + scoreEventColumns + ` FROM score_events_move`,
+	}
+	for _, q := range stmts {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// defaultPartitionSeasonsSQL lists the seasons with rows in
+// score_events_default, walking the (season_id, created_at) index one
+// season at a time instead of reading every row.
+const defaultPartitionSeasonsSQL = `
+WITH RECURSIVE s AS (
+  (SELECT season_id FROM score_events_default ORDER BY season_id LIMIT 1)
+  UNION ALL
+  SELECT (SELECT e.season_id FROM score_events_default e WHERE e.season_id > s.season_id ORDER BY e.season_id LIMIT 1)
+  FROM s WHERE s.season_id IS NOT NULL
+)
+SELECT season_id FROM s WHERE season_id IS NOT NULL
+`
+
+// runSeasonPartitioner moves new seasons out of score_events_default every
+// interval. Seasons found too large are remembered and not counted again
+// until the process restarts.
+func runSeasonPartitioner(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	tooLarge := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := partitionNewSeasons(ctx, db, tooLarge); err != nil && ctx.Err() == nil {
+				slog.Error("Season partition job error", "err", err)
+			}
+		}
+	}
+}
+
+// partitionNewSeasons gives every season in score_events_default except
+// those in tooLarge its partition, adding the ones it finds too large. It
+// returns how many it moved, stopping early when score_events is busy; the
+// rest are picked up next time.
+func partitionNewSeasons(ctx context.Context, db *sql.DB, tooLarge map[string]bool) (int, error) {
+	rows, err := db.QueryContext(ctx, defaultPartitionSeasonsSQL)
+	if err != nil {
+		return 0, err
+	}
+	var seasons []string
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !tooLarge[sid] {
+			seasons = append(seasons, sid)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, sid := range seasons {
+		created, err := partitionSeason(ctx, db, sid)
+		switch {
+		case errors.Is(err, errSeasonTooLarge):
+			tooLarge[sid] = true
+			slog.Info("Season stays in the default partition", "season_id", sid, "max_rows", partitionMoveMaxRows)
+			continue
+		case errors.Is(err, errPartitionBusy):
+			return moved, nil
+		case err != nil:
+			return moved, err
+		}
+		if created {
+			moved++
+			slog.Info("Moved season into its own partition", "season_id", sid)
+		}
+	}
+	return moved, nil
+}
+
+// dropSeasonPartition drops a season's partition inside tx if it has one and
+// all its rows have id <= cutoff (later rows belong to a season recreated
+// under the same ID). It reports whether it dropped the partition and how
+// many rows went with it. When the lock can't be had within lock_timeout it
+// drops nothing; tx is then aborted, and the caller deletes in batches.
+func dropSeasonPartition(ctx context.Context, tx *sql.Tx, seasonID string, cutoff int64) (dropped bool, rows int64, err error) {
+	name := pq.QuoteIdentifier(seasonPartition(seasonID))
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `
+	SELECT EXISTS (
+	  SELECT 1 FROM pg_inherits
+	  WHERE inhparent = to_regclass('score_events') AND inhrelid = to_regclass($1)
+	)
+`, name).Scan(&exists); err != nil || !exists {
+		return false, 0, err
+	}
+
+	// Counted before taking the lock, so submissions don't wait on the scan;
+	// rows that arrive meanwhile are past the cutoff and stop the drop below.
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+name+` WHERE id <= $1`, cutoff).Scan(&rows); err != nil {
+		return false, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `SET LOCAL lock_timeout = '`+partitionLockTimeout+`'`); err != nil {
+		return false, 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE score_events IN ACCESS EXCLUSIVE MODE`); err != nil {
+		var pgErr interface{ SQLState() string }
+		if errors.As(err, &pgErr) && pgErr.SQLState() == "55P03" { // lock_not_available
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+	var newer bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+name+` WHERE id > $1)`, cutoff).Scan(&newer); err != nil {
+		return false, 0, err
+	}
+	if newer {
+		return false, 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DROP TABLE `+name); err != nil {
+		return false, 0, err
+	}
+	return true, rows, nil
+}
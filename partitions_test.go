@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/ledger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lib/pq"
+)
+
+func TestNewSeasonMovesToItsOwnPartition(t *testing.T) {
+	db, _ := testStores(t)
+	ctx := context.Background()
+	sid := fmt.Sprintf("partition-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DROP TABLE IF EXISTS `+pq.QuoteIdentifier(seasonPartition(sid)))
+		db.ExecContext(ctx, `DELETE FROM score_events WHERE season_id=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM outbox WHERE payload->>'seasonId'=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM seasons WHERE season_id=$1`, sid)
+	})
+
+	pool, err := pgxpool.New(ctx, os.Getenv("TEST_DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	scores := ledger.NewPostgres(pool)
+	submit := func(user string) int64 {
+		t.Helper()
+		if _, err := scores.Season(ctx, sid); err != nil {
+			t.Fatal(err)
+		}
+		rec, err := scores.Record(ctx, ledger.Submission{SeasonID: sid, UserID: user, Delta: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.EventID
+	}
+	partitions := func() []string {
+		t.Helper()
+		rows, err := db.QueryContext(ctx, `SELECT DISTINCT tableoid::regclass::text FROM score_events WHERE season_id=$1`, sid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+
+	first := submit("alice")
+	submit("bob")
+	if got := partitions(); len(got) != 1 || got[0] != "score_events_default" {
+		t.Fatalf("before the job: rows in %v, want score_events_default", got)
+	}
+
+	// moves other tests' leftover seasons too, which is harmless
+	if _, err := partitionNewSeasons(ctx, db, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	submit("carol")
+
+	want := seasonPartition(sid)
+	if got := partitions(); len(got) != 1 || got[0] != want {
+		t.Fatalf("after the job: rows in %v, want %s", got, want)
+	}
+	var n int
+	var minID int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*), MIN(id) FROM score_events WHERE season_id=$1`, sid).Scan(&n, &minID); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || minID != first {
+		t.Fatalf("season has %d rows from id %d, want 3 from %d", n, minID, first)
+	}
+}
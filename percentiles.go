@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/redis/go-redis/v9"
+)
+
+type percentileBucket struct {
+	Bucket     int     `json:"bucket"`     // 1 = best
+	Percentile float64 `json:"percentile"` // "top N%" upper edge of the bucket
+	CutoffRank int64   `json:"cutoffRank"` // 1-based rank of the last member in the bucket
+	MinScore   float64 `json:"minScore"`   // score needed to be in this bucket or better
+}
+
+type percentilesResponse struct {
+	SeasonID   string             `json:"seasonId"`
+	Buckets    int                `json:"buckets"`
+	Total      int64              `json:"total"`
+	ComputedAt time.Time          `json:"computedAt"`
+	Items      []percentileBucket `json:"items"`
+}
+
+// percentileCache holds computed thresholds per (season, buckets) for ttl, so
+// the endpoint costs at most one ZCARD plus one pipeline per ttl per instance.
+type percentileCache struct {
+	ttl tunableDuration // PERCENTILES_CACHE_TTL, reloadable
+
+	mu      sync.Mutex
+	entries map[string]percentilesResponse
+}
+
+func newPercentileCache(ttl time.Duration) *percentileCache {
+	pc := &percentileCache{entries: make(map[string]percentilesResponse)}
+	pc.ttl.set(ttl)
+	return pc
+}
+
+func (pc *percentileCache) get(ctx context.Context, rdb redis.UniversalClient, seasonID string, buckets int) (percentilesResponse, error) {
+	cacheKey := fmt.Sprintf("%s\x00%d", seasonID, buckets)
+
+	pc.mu.Lock()
+	e, ok := pc.entries[cacheKey]
+	pc.mu.Unlock()
+	if ok && time.Since(e.ComputedAt) < pc.ttl.get() {
+		return e, nil
+	}
+
+	e, err := computePercentiles(ctx, rdb, seasonID, buckets)
+	if err != nil {
+		return percentilesResponse{}, err
+	}
+
+	pc.mu.Lock()
+	pc.entries[cacheKey] = e
+	// sweep stale entries so deleted/old seasons don't pile up
+	if len(pc.entries) > 10000 {
+		for k, v := range pc.entries {
+			if time.Since(v.ComputedAt) >= pc.ttl.get() {
+				delete(pc.entries, k)
+			}
+		}
+	}
+	pc.mu.Unlock()
+	return e, nil
+}
+
+// invalidate drops every cached bucket count for the season.
+func (pc *percentileCache) invalidate(seasonID string) {
+	prefix := seasonID + "\x00"
+	pc.mu.Lock()
+	for k := range pc.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(pc.entries, k)
+		}
+	}
+	pc.mu.Unlock()
+}
+
+func computePercentiles(ctx context.Context, rdb redis.UniversalClient, seasonID string, buckets int) (percentilesResponse, error) {
+	key := boardKey(seasonID)
+	resp := percentilesResponse{
+		SeasonID:   seasonID,
+		Buckets:    buckets,
+		ComputedAt: time.Now().UTC(),
+		Items:      []percentileBucket{},
+	}
+
+	total, err := rdb.ZCard(ctx, key).Result()
+	if err != nil {
+		return resp, err
+	}
+	resp.Total = total
+	if total == 0 {
+		return resp, nil
+	}
+
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.ZSliceCmd, buckets)
+	ranks := make([]int64, buckets)
+	for i := 1; i <= buckets; i++ {
+		// ceil(total*i/buckets) members are in the top i buckets
+		cutoff := (total*int64(i) + int64(buckets) - 1) / int64(buckets)
+		ranks[i-1] = cutoff
+		cmds[i-1] = pipe.ZRevRangeWithScores(ctx, key, cutoff-1, cutoff-1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return resp, err
+	}
+
+	for i, cmd := range cmds {
+		zs := cmd.Val()
+		if len(zs) == 0 {
+			// board shrank between ZCARD and the pipeline
+			continue
+		}
+		resp.Items = append(resp.Items, percentileBucket{
+			Bucket:     i + 1,
+			Percentile: 100 * float64(i+1) / float64(buckets),
+			CutoffRank: ranks[i],
+			MinScore:   leaderboard.Points(zs[0].Score),
+		})
+	}
+	return resp, nil
+}
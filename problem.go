@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Errors are RFC 7807 problem details with a stable machine-readable code;
+// clients should branch on code, never on detail. The detail is repeated as
+// "error", the body's only field before problem+json, for older clients.
+
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	writeProblemExt(w, status, code, detail, nil)
+}
+
+// writeProblemExt adds extension members (e.g. ids of work already done).
+func writeProblemExt(w http.ResponseWriter, status int, code, detail string, ext map[string]any) {
+	p := map[string]any{
+		"type":   "about:blank",
+		"title":  http.StatusText(status),
+		"status": status,
+		"code":   code,
+		"detail": detail,
+		"error":  detail,
+	}
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		p["requestId"] = id // set by requestIDHandler
+	}
+	for k, v := range ext {
+		p[k] = v
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Bulk rank export for season-end reward jobs: the request body is read as a
+// stream of user ids and answered chunk by chunk as NDJSON, so neither side
+// has to hold 100k entries in memory. Ranks are read per chunk; freeze the
+// season first if the whole export must be a single consistent snapshot.
+
+const (
+	maxRankExportUsers = 100000
+	rankExportChunk    = 1000
+)
+
+type rankExportItem struct {
+	UserID  string  `json:"userId"`
+	Found   bool    `json:"found"`
+	Rank    int64   `json:"rank,omitempty"` // 1-based; omitted when trimmed or not found
+	Score   float64 `json:"score"`
+	Trimmed bool    `json:"trimmed,omitempty"` // below the board cap, score comes from the ledger
+}
+
+var errTooManyUsers = fmt.Errorf("too many userIds (max %d)", maxRankExportUsers)
+
+// readUserIDs streams the ids of a {"userIds": [...]} body to fn in chunks.
+func readUserIDs(r io.Reader, fn func(ids []string) error) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return errors.New("body must be a json object")
+	}
+	if t, err := dec.Token(); err != nil || t != "userIds" {
+		return errors.New(`expected "userIds"`)
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return errors.New("userIds must be an array")
+	}
+
+	chunk := make([]string, 0, rankExportChunk)
+	total := 0
+	for dec.More() {
+		var id string
+		if err := dec.Decode(&id); err != nil {
+			return errors.New("userIds must be strings")
+		}
+		if total++; total > maxRankExportUsers {
+			return errTooManyUsers
+		}
+		chunk = append(chunk, id)
+		if len(chunk) == rankExportChunk {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim(']') {
+		return errors.New("userIds must be an array")
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('}') {
+		return errors.New("unexpected fields after userIds")
+	}
+	return nil
+}
+
+// lookupRanks resolves one chunk. Users missing from a capped board fall back
+// to their ledger total, like the single-user rank endpoint.
+func lookupRanks(ctx context.Context, reads *redisReads, db *sql.DB, seasonID string, ids []string, capped bool) ([]rankExportItem, error) {
+	members := make([]boardMember, len(ids))
+	for i, id := range ids {
+		members[i] = boardMember{seasonID: seasonID, userID: id}
+	}
+	out := make([]rankExportItem, len(ids))
+
+	err := reads.do(ctx, func(c redis.Cmdable) error {
+		found, err := readMembers(ctx, c, members)
+		if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			out[i] = rankExportItem{UserID: id, Found: found[i].found, Rank: found[i].rank, Score: found[i].score}
+		}
+		return nil
+	})
+	if err != nil || !capped {
+		return out, err
+	}
+
+	var missing []string
+	for _, it := range out {
+		if !it.Found {
+			missing = append(missing, it.UserID)
+		}
+	}
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+	SELECT user_id, SUM(delta)
+	FROM score_events
+	WHERE season_id=$1 AND user_id = ANY($2)
+	GROUP BY user_id
+`, seasonID, pq.Array(missing))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sums := make(map[string]int64, len(missing))
+	for rows.Next() {
+		var uid string
+		var sum int64
+		if err := rows.Scan(&uid, &sum); err != nil {
+			return nil, err
+		}
+		sums[uid] = sum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, it := range out {
+		if sum, ok := sums[it.UserID]; ok && !it.Found {
+			out[i].Found = true
+			out[i].Trimmed = true
+			out[i].Score = float64(sum)
+		}
+	}
+	return out, nil
+}
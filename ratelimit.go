@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Requests are rate limited per client with a token bucket per route class:
+// read (GET/HEAD), write (other methods) and admin (/v1/admin/). Each class
+// has RATE_LIMIT_{CLASS}_RPS tokens per second and bursts of
+// RATE_LIMIT_{CLASS}_BURST (default twice the rate); a class with no rate is
+// not limited. Buckets live in the process, so with several API instances
+// behind a balancer a client gets up to the limit on each one. The limits
+// can be changed by a config reload (reload.go).
+//
+// A client is the API key or token subject it authenticated as, otherwise
+// its IP: the peer address, or with TRUSTED_PROXIES=n the address n entries
+// from the right of X-Forwarded-For (clientIP in ipallow.go).
+
+const (
+	rateClassRead  = "read"
+	rateClassWrite = "write"
+	rateClassAdmin = "admin"
+
+	rateLimitSweepInterval = time.Minute
+)
+
+type rateLimit struct {
+	rate  float64 // tokens per second; 0 = unlimited
+	burst float64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	limits         atomic.Pointer[map[string]rateLimit] // by class; absent = unlimited
+	trustedProxies int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket // class + "\xff" + client
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	rl := &rateLimiter{
+		trustedProxies: trustedProxies(),
+		buckets:        make(map[string]*tokenBucket),
+		lastSweep:      time.Now(),
+	}
+	rl.setLimits(readRateLimits())
+	return rl
+}
+
+// readRateLimits reads the RATE_LIMIT_{CLASS}_* settings.
+func readRateLimits() map[string]rateLimit {
+	limits := make(map[string]rateLimit)
+	for _, class := range []string{rateClassRead, rateClassWrite, rateClassAdmin} {
+		name := "RATE_LIMIT_" + strings.ToUpper(class)
+		rate := envFloat64(name+"_RPS", 0)
+		burst := envFloat64(name+"_BURST", 2*rate)
+		if rate < 0 || burst < 0 || (rate > 0 && burst < 1) {
+			panic(fmt.Sprintf("invalid %s_RPS/%s_BURST", name, name))
+		}
+		if rate > 0 {
+			limits[class] = rateLimit{rate: rate, burst: burst}
+		}
+	}
+	return limits
+}
+
+// setLimits replaces the limits; existing buckets are capped at the new burst
+// as they are next used.
+func (rl *rateLimiter) setLimits(limits map[string]rateLimit) { rl.limits.Store(&limits) }
+
+func rateClass(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/admin/"):
+		return rateClassAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return rateClassRead
+	default:
+		return rateClassWrite
+	}
+}
+
+// clientKey identifies the caller for bucketing.
+func (rl *rateLimiter) clientKey(r *http.Request) string {
+	if p := requestPrincipal(r.Context()); p != nil {
+		return p.ident()
+	}
+	return "ip:" + clientIP(r, rl.trustedProxies)
+}
+
+// allow takes a token from the client's bucket for class, or reports how long
+// until one is available.
+func (rl *rateLimiter) allow(class, client string, now time.Time) (bool, time.Duration) {
+	lim, ok := (*rl.limits.Load())[class]
+	if !ok {
+		return true, 0
+	}
+	key := class + "\xff" + client
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.lastSweep) >= rateLimitSweepInterval {
+		rl.sweep(now)
+	}
+	b := rl.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: lim.burst, last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens = math.Min(lim.burst, b.tokens+now.Sub(b.last).Seconds()*lim.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / lim.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely; they would start over
+// full anyway. Called with mu held.
+func (rl *rateLimiter) sweep(now time.Time) {
+	rl.lastSweep = now
+	limits := *rl.limits.Load()
+	for key, b := range rl.buckets {
+		class, _, _ := strings.Cut(key, "\xff")
+		lim := limits[class] // unlimited now: burst 0, so the bucket goes
+		if b.tokens+now.Sub(b.last).Seconds()*lim.rate >= lim.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// middleware answers 429 with Retry-After once a client's bucket for the
+// route class is empty. Probes and /metrics are never limited.
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+		class := rateClass(r)
+		if _, limited := (*rl.limits.Load())[class]; !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := rl.allow(class, rl.clientKey(r), time.Now())
+		if !ok {
+			metrics.rateLimited.inc(class)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeProblem(w, http.StatusTooManyRequests, "rate_limited", "too many "+class+" requests, retry later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/outbox"
+)
+
+// The worker commits its claim (status='processing', the attempt counted)
+// before it applies a batch, and settles the rows in a second transaction. A
+// worker that dies in between (killed, OOM, a crash the batch itself causes)
+// leaves them processing; the reaper returns rows stuck longer than the
+// timeout to pending, or to failed once they have had OUTBOX_MAX_ATTEMPTS, so
+// a batch that keeps taking the worker down is parked instead of claimed
+// forever. Rows a live batch holds are locked and skipped.
+
+const reaperBatch = 1000
+
+func runOutboxReaper(ctx context.Context, db *sql.DB, timeout time.Duration, retry outbox.Retry) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := reapStuckOutbox(ctx, db, timeout, retry)
+			if err != nil {
+				slog.Error("Reaper error", "err", err)
+			} else if n > 0 {
+				slog.Warn("Reaper returned stuck outbox rows", "rows", n)
+			}
+		}
+	}
+}
+
+func reapStuckOutbox(ctx context.Context, db *sql.DB, timeout time.Duration, retry outbox.Retry) (int64, error) {
+	var total int64
+	for {
+		c, cancel := context.WithTimeout(ctx, 10*time.Second)
+		res, err := db.ExecContext(c, `
+	UPDATE outbox o
+	SET status=CASE WHEN o.attempts >= $2 THEN 'failed' ELSE 'pending' END,
+	    next_attempt_at=NULL,
+	    last_error='reaped: stuck in processing'
+	FROM (
+	  SELECT id FROM outbox
+	  WHERE status='processing'
+	    AND claimed_at < now() - make_interval(secs => $1)
+	  ORDER BY id
+	  FOR UPDATE SKIP LOCKED
+	  LIMIT $3
+	) stuck
+	WHERE o.id=stuck.id
+`, timeout.Seconds(), retry.MaxAttempts, reaperBatch)
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		if n < reaperBatch {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/disfordave/leaderboard-go/outbox"
+)
+
+// crashStore stops a batch mid-apply the way a killed worker would: nothing
+// after the store call runs, and no error is returned.
+type crashStore struct{ *leaderboard.MemoryStore }
+
+func (crashStore) Apply(ctx context.Context, seasonID, userID string, ids, deltas []int64, at time.Time) (float64, error) {
+	panic("worker killed mid-apply")
+}
+
+func TestKilledBatchCountsAttempt(t *testing.T) {
+	db, rdb := testStores(t)
+	ctx := context.Background()
+	sid := fmt.Sprintf("reaper-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		rdb.Del(ctx, boardKey(sid), appliedKey(sid))
+		db.ExecContext(ctx, `DELETE FROM outbox WHERE payload->>'seasonId'=$1`, sid)
+		db.ExecContext(ctx, `DELETE FROM score_events WHERE season_id=$1`, sid)
+	})
+	retry := outbox.Retry{MaxAttempts: 3, Base: time.Second, Max: time.Second}
+	row := func() (status string, attempts int) {
+		t.Helper()
+		if err := db.QueryRowContext(ctx,
+			`SELECT status, attempts FROM outbox WHERE payload->>'seasonId'=$1`, sid).Scan(&status, &attempts); err != nil {
+			t.Fatal(err)
+		}
+		return status, attempts
+	}
+
+	queueDelta(t, db, sid, "alice", 5)
+	w := outbox.NewWorker(db, crashStore{leaderboard.NewMemoryStore()})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("batch didn't reach the store")
+			}
+		}()
+		w.ProcessBatch(ctx)
+	}()
+	if status, attempts := row(); status != "processing" || attempts != 1 {
+		t.Fatalf("after the crash: %s with %d attempts, want processing with 1", status, attempts)
+	}
+
+	// the timeout is 0 here, so every stuck row is returned, other tests' too
+	if _, err := reapStuckOutbox(ctx, db, 0, retry); err != nil {
+		t.Fatal(err)
+	}
+	if status, attempts := row(); status != "pending" || attempts != 1 {
+		t.Fatalf("after the reaper: %s with %d attempts, want pending with 1", status, attempts)
+	}
+
+	drainOutbox(t, db, rdb)
+	if status, attempts := row(); status != "done" || attempts != 2 {
+		t.Fatalf("after the retry: %s with %d attempts, want done with 2", status, attempts)
+	}
+	if score := rdb.ZScore(ctx, boardKey(sid), "alice").Val(); score != 5 {
+		t.Fatalf("score = %v, want 5", score)
+	}
+}
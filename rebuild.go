@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Rebuilds recompute a board from score_events. While a rebuild holds the
+// season's exclusive advisory lock, the worker can't apply deltas for that
+// season (it takes the shared lock per batch), so every ledger event is either
+// in the rebuilt board or still pending and applied afterwards, never both.
+
+const rebuildZAddBatch = 1000
+
+func lockSeasonForRebuild(ctx context.Context, tx *sql.Tx, seasonID string) error {
+	_, err := tx.ExecContext(ctx,
+		`SELECT pg_advisory_xact_lock(hashtext('lb_rebuild'), hashtext($1))`, seasonID)
+	return err
+}
+
+// rebuildLeaderboard repopulates lb:{sid} from the ledger via a temp key and
+// RENAME, so readers never see a half-built board. Held users are left off.
+func rebuildLeaderboard(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID string, defaultMaxSize int64) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSeasonForRebuild(ctx, tx, seasonID); err != nil {
+		return 0, err
+	}
+	if err := markRebuild(ctx, tx, seasonID, ""); err != nil {
+		return 0, err
+	}
+
+	// Events whose outbox row is still pending haven't reached Redis (the worker applies them after
+	// us), and neither have rows parked as failed.
+	rows, err := tx.QueryContext(ctx, `
+	SELECT e.user_id, SUM(e.delta), MAX(e.created_at)
+	FROM score_events e
+	WHERE e.season_id=$1
+	  AND NOT EXISTS (
+	    SELECT 1 FROM outbox o
+	    WHERE o.event_type='score_delta' AND o.status IN ('pending', 'processing', 'failed')
+	      AND o.event_id = e.id
+	  )
+	  AND NOT EXISTS (
+	    SELECT 1 FROM report_targets t
+	    WHERE t.season_id=e.season_id AND t.user_id=e.user_id AND t.status='held'
+	  )
+	GROUP BY e.user_id
+`, seasonID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	key := boardKey(seasonID)
+	tmp := fmt.Sprintf("lbtmp:rebuild:%s", seasonTag(seasonID))
+	if err := rdb.Del(ctx, tmp).Err(); err != nil {
+		return 0, err
+	}
+
+	var members int64
+	batch := make([]redis.Z, 0, rebuildZAddBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := rdb.ZAdd(ctx, tmp, batch...).Err()
+		batch = batch[:0]
+		return err
+	}
+	for rows.Next() {
+		var uid string
+		var sum int64
+		var last time.Time
+		if err := rows.Scan(&uid, &sum, &last); err != nil {
+			return 0, err
+		}
+		batch = append(batch, redis.Z{Score: boardScore(sum, last), Member: uid})
+		members++
+		if len(batch) == rebuildZAddBatch {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	// The apply markers go with the old board (see leaderboard/keys.go).
+	if members == 0 {
+		// RENAME fails on a missing key; an empty ledger means an empty board.
+		if err := rdb.Del(ctx, key, appliedKey(seasonID)).Err(); err != nil {
+			return 0, err
+		}
+	} else if err := replaceBoard(ctx, rdb, tmp, key, seasonID); err != nil {
+		return 0, err
+	}
+	bumpBoardVersion(ctx, rdb, seasonID)
+
+	if err := trimLeaderboards(ctx, db, rdb, map[string]struct{}{seasonID: {}}, defaultMaxSize); err != nil {
+		slog.Error("Trim error", "err", err)
+	}
+
+	return members, tx.Commit()
+}
+
+// rebuildUserScore recomputes one user's score the same way and writes it with
+// ZADD. Returns held=true when the user is under a hold and was removed instead.
+func rebuildUserScore(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID, userID string, defaultMaxSize int64) (score float64, found, held bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, false, err
+	}
+	defer tx.Rollback()
+
+	score, found, held, err = rebuildUserScoreTx(ctx, tx, db, rdb, seasonID, userID, defaultMaxSize)
+	if err != nil {
+		return 0, false, false, err
+	}
+	return score, found, held, tx.Commit()
+}
+
+// rebuildUserScoreTx is rebuildUserScore in the caller's transaction, which
+// keeps the season's rebuild lock until it commits.
+func rebuildUserScoreTx(ctx context.Context, tx *sql.Tx, db *sql.DB, rdb redis.UniversalClient, seasonID, userID string, defaultMaxSize int64) (score float64, found, held bool, err error) {
+	if err := lockSeasonForRebuild(ctx, tx, seasonID); err != nil {
+		return 0, false, false, err
+	}
+	if err := markRebuild(ctx, tx, seasonID, userID); err != nil {
+		return 0, false, false, err
+	}
+
+	var sum sql.NullInt64
+	var last sql.NullTime
+	if err := tx.QueryRowContext(ctx, `
+	SELECT SUM(e.delta), MAX(e.created_at)
+	FROM score_events e
+	WHERE e.season_id=$1 AND e.user_id=$2
+	  AND NOT EXISTS (
+	    SELECT 1 FROM outbox o
+	    WHERE o.event_type='score_delta' AND o.status IN ('pending', 'processing', 'failed')
+	      AND o.event_id = e.id
+	  )
+`, seasonID, userID).Scan(&sum, &last); err != nil {
+		return 0, false, false, err
+	}
+	if err := tx.QueryRowContext(ctx, `
+	SELECT EXISTS (
+	  SELECT 1 FROM report_targets WHERE season_id=$1 AND user_id=$2 AND status='held'
+	)
+`, seasonID, userID).Scan(&held); err != nil {
+		return 0, false, false, err
+	}
+
+	key := boardKey(seasonID)
+	if !sum.Valid || held {
+		if err := rdb.ZRem(ctx, key, userID).Err(); err != nil {
+			return 0, false, false, err
+		}
+		bumpBoardVersion(ctx, rdb, seasonID)
+		return 0, sum.Valid, held, nil
+	}
+
+	score = float64(sum.Int64)
+	if err := rdb.ZAdd(ctx, key, redis.Z{Score: boardScore(sum.Int64, last.Time), Member: userID}).Err(); err != nil {
+		return 0, false, false, err
+	}
+	bumpBoardVersion(ctx, rdb, seasonID)
+	if err := trimLeaderboards(ctx, db, rdb, map[string]struct{}{seasonID: {}}, defaultMaxSize); err != nil {
+		slog.Error("Trim error", "err", err)
+	}
+	return score, true, false, nil
+}
+
+// replaceBoard renames tmp over the season's board and drops its apply markers in one step.
+func replaceBoard(ctx context.Context, rdb redis.UniversalClient, tmp, key, seasonID string) error {
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Rename(ctx, tmp, key)
+		pipe.Del(ctx, appliedKey(seasonID))
+		return nil
+	})
+	return err
+}
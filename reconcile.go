@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Reconciliation compares board scores with ledger totals. Each chunk of users
+// is checked under the season's rebuild lock, so the worker can't apply a delta
+// between reading the ledger and reading Redis; events still pending in the
+// outbox are excluded from the ledger side, as in rebuilds.
+//
+// Sample mode checks random board members (finds mismatches, extras and held
+// users still on the board). Full mode walks every ledger user (also finds
+// users missing from the board) and estimates extras from ZCARD.
+//
+// With auto-heal on, mismatched and missing users get a score_correction
+// outbox event (see corrections.go); extras and held users are only reported.
+//
+// Full mode lists ledger users from the read replica (POSTGRES_READ_DSN);
+// a user it hasn't seen yet is picked up by the next run. The chunk checks
+// themselves lock, so they run on the primary.
+
+const (
+	reconcileChunk        = 1000
+	reconcileMaxDriftRows = 1000 // per run; counts are always exact
+	reconcileRunsTTL      = 7 * 24 * time.Hour
+)
+
+type reconcileRun struct {
+	ID         int64         `json:"runId"`
+	SeasonID   string        `json:"seasonId"`
+	Mode       string        `json:"mode"` // sample/full
+	Checked    int64         `json:"checked"`
+	Mismatched int64         `json:"mismatched"`
+	Missing    int64         `json:"missing"`
+	Extra      int64         `json:"extra"`
+	Held       int64         `json:"held"`
+	Healed     int64         `json:"healed"` // corrections queued by auto-heal
+	MaxAbsDiff float64       `json:"maxAbsDiff"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Drift      []driftRecord `json:"drift,omitempty"`
+}
+
+type driftRecord struct {
+	UserID      string   `json:"userId"`
+	Kind        string   `json:"kind"` // mismatch/missing/extra/held
+	RedisScore  *float64 `json:"redisScore,omitempty"`
+	LedgerScore *int64   `json:"ledgerScore,omitempty"`
+}
+
+func (r *reconcileRun) drifted() bool {
+	return r.Mismatched+r.Missing+r.Extra+r.Held > 0
+}
+
+func (r *reconcileRun) add(d driftRecord) {
+	switch d.Kind {
+	case "mismatch":
+		r.Mismatched++
+		r.MaxAbsDiff = math.Max(r.MaxAbsDiff, math.Abs(*d.RedisScore-float64(*d.LedgerScore)))
+	case "missing":
+		r.Missing++
+	case "extra":
+		r.Extra++
+	case "held":
+		r.Held++
+	}
+	if len(r.Drift) < reconcileMaxDriftRows {
+		r.Drift = append(r.Drift, d)
+	}
+}
+
+func runReconcileJob(ctx context.Context, db, readDB *sql.DB, rdb redis.UniversalClient, interval time.Duration, sampleSize int, autoHeal bool, defaultMaxSize int64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := reconcileAll(ctx, db, readDB, rdb, sampleSize, autoHeal, defaultMaxSize); err != nil {
+				slog.Error("Reconcile error", "err", err)
+			}
+		}
+	}
+}
+
+// reconcileAll checks every board once. A session advisory lock keeps
+// instances from running the same pass concurrently.
+func reconcileAll(ctx context.Context, db, readDB *sql.DB, rdb redis.UniversalClient, sampleSize int, autoHeal bool, defaultMaxSize int64) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_lock(hashtext('lb_reconcile'))`).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('lb_reconcile'))`)
+
+	c, cancel := context.WithTimeout(ctx, time.Minute)
+	seasons, err := boardSeasons(c, db)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for _, sid := range seasons {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		run, err := reconcileSeason(ctx, db, readDB, rdb, sid, sampleSize, autoHeal, defaultMaxSize)
+		if err != nil {
+			return fmt.Errorf("season %s: %w", sid, err)
+		}
+		if run.drifted() {
+			slog.Warn("Reconcile found drift", "seasonId", sid, "mode", run.Mode, "checked", run.Checked,
+				"mismatched", run.Mismatched, "missing", run.Missing, "extra", run.Extra, "held", run.Held,
+				"healed", run.Healed, "maxAbsDiff", run.MaxAbsDiff)
+		}
+	}
+
+	c, cancel = context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err = db.ExecContext(c, `
+	DELETE FROM reconcile_runs WHERE started_at < now() - make_interval(secs => $1)
+`, reconcileRunsTTL.Seconds())
+	return err
+}
+
+func reconcileSeason(ctx context.Context, db, readDB *sql.DB, rdb redis.UniversalClient, seasonID string, sampleSize int, autoHeal bool, defaultMaxSize int64) (*reconcileRun, error) {
+	run := &reconcileRun{SeasonID: seasonID, Mode: "full", StartedAt: time.Now().UTC()}
+	if sampleSize > 0 {
+		run.Mode = "sample"
+	}
+	key := boardKey(seasonID)
+
+	c, cancel := context.WithTimeout(ctx, 5*time.Second)
+	maxSize, err := seasonMaxSize(c, db, seasonID, defaultMaxSize)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	if sampleSize > 0 {
+		c, cancel := context.WithTimeout(ctx, 5*time.Second)
+		users, err := rdb.ZRandMember(c, key, sampleSize).Result()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(users); i += reconcileChunk {
+			if _, err := reconcileChunkUsers(ctx, db, rdb, seasonID, users[i:min(i+reconcileChunk, len(users))], maxSize, autoHeal, run); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		var onBoard int64
+		after := ""
+		for {
+			c, cancel := context.WithTimeout(ctx, 30*time.Second)
+			users, err := nextLedgerUsers(c, readDB, seasonID, after)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+			if len(users) == 0 {
+				break
+			}
+			n, err := reconcileChunkUsers(ctx, db, rdb, seasonID, users, maxSize, autoHeal, run)
+			if err != nil {
+				return nil, err
+			}
+			onBoard += n
+			after = users[len(users)-1]
+		}
+		// Board members outside the ledger aren't visited; count them from ZCARD.
+		c, cancel := context.WithTimeout(ctx, 5*time.Second)
+		card, err := rdb.ZCard(c, key).Result()
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if extra := card - onBoard; extra > 0 {
+			run.Extra += extra
+		}
+	}
+
+	run.FinishedAt = time.Now().UTC()
+	c, cancel = context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return run, saveReconcileRun(c, db, run)
+}
+
+func nextLedgerUsers(ctx context.Context, db *sql.DB, seasonID, after string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT DISTINCT user_id
+	FROM score_events
+	WHERE season_id=$1 AND user_id > $2
+	ORDER BY user_id
+	LIMIT $3
+`, seasonID, after, reconcileChunk)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// reconcileChunkUsers compares one chunk of users, adds any drift to run and
+// returns how many of them are on the board.
+func reconcileChunkUsers(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID string, users []string, maxSize int64, autoHeal bool, run *reconcileRun) (int64, error) {
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSeasonForRebuild(c, tx, seasonID); err != nil {
+		return 0, err
+	}
+
+	ledger := make(map[string]int64, len(users))
+	rows, err := tx.QueryContext(c, `
+	SELECT e.user_id, SUM(e.delta)
+	FROM score_events e
+	WHERE e.season_id=$1 AND e.user_id = ANY($2)
+	  AND NOT EXISTS (
+	    SELECT 1 FROM outbox o
+	    WHERE o.event_type='score_delta' AND o.status IN ('pending', 'processing', 'failed')
+	      AND o.event_id = e.id
+	  )
+	GROUP BY e.user_id
+`, seasonID, pq.Array(users))
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var uid string
+		var sum int64
+		if err := rows.Scan(&uid, &sum); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ledger[uid] = sum
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	held, err := heldUsers(c, tx, []string{seasonID}, users)
+	if err != nil {
+		return 0, err
+	}
+	queued, err := pendingCorrections(c, tx, seasonID, users)
+	if err != nil {
+		return 0, err
+	}
+
+	key := boardKey(seasonID)
+	pipe := rdb.Pipeline()
+	scores := make([]*redis.FloatCmd, len(users))
+	for i, u := range users {
+		scores[i] = pipe.ZScore(c, key, u)
+	}
+	var lowest *redis.ZSliceCmd
+	if maxSize > 0 {
+		lowest = pipe.ZRangeWithScores(c, key, 0, 0)
+	}
+	if _, err := pipe.Exec(c); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	var found int64
+	var fixes []scoreCorrection
+	for i, u := range users {
+		run.Checked++
+		redisScore, rerr := scores[i].Result()
+		redisScore = leaderboard.Points(redisScore)
+		onBoard := rerr == nil
+		if onBoard {
+			found++
+		}
+		sum, inLedger := ledger[u]
+		_, isHeld := held[seasonID+"\x00"+u]
+		// a correction already queued will land; judge the board as if it had
+		pending, fixing := queued[u]
+		redisScore += float64(pending)
+
+		switch {
+		case isHeld:
+			if onBoard {
+				run.add(driftRecord{UserID: u, Kind: "held", RedisScore: &redisScore})
+			}
+		case onBoard && !inLedger:
+			run.add(driftRecord{UserID: u, Kind: "extra", RedisScore: &redisScore})
+		case !onBoard && inLedger:
+			if fixing {
+				continue
+			}
+			// Capped boards legitimately drop low scorers; only flag users that should have made the cut.
+			if maxSize > 0 {
+				if zs := lowest.Val(); len(zs) == 0 || float64(sum) <= leaderboard.Points(zs[0].Score) {
+					continue
+				}
+			}
+			run.add(driftRecord{UserID: u, Kind: "missing", LedgerScore: &sum})
+			fixes = append(fixes, newScoreCorrection(seasonID, u, 0, sum))
+		case onBoard && redisScore != float64(sum):
+			run.add(driftRecord{UserID: u, Kind: "mismatch", RedisScore: &redisScore, LedgerScore: &sum})
+			fixes = append(fixes, newScoreCorrection(seasonID, u, redisScore, sum))
+		}
+	}
+
+	if autoHeal && len(fixes) > 0 {
+		n, err := queueCorrections(c, tx, fixes)
+		if err != nil {
+			return 0, err
+		}
+		run.Healed += n
+	}
+	return found, tx.Commit()
+}
+
+func saveReconcileRun(ctx context.Context, db *sql.DB, run *reconcileRun) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRowContext(ctx, `
+	INSERT INTO reconcile_runs (season_id, mode, checked, mismatched, missing, extra, held, healed, max_abs_diff, started_at, finished_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id
+`, run.SeasonID, run.Mode, run.Checked, run.Mismatched, run.Missing, run.Extra, run.Held, run.Healed,
+		run.MaxAbsDiff, run.StartedAt, run.FinishedAt).Scan(&run.ID); err != nil {
+		return err
+	}
+
+	if len(run.Drift) > 0 {
+		users := make([]string, len(run.Drift))
+		kinds := make([]string, len(run.Drift))
+		redisScores := make([]sql.NullFloat64, len(run.Drift))
+		ledgerScores := make([]sql.NullInt64, len(run.Drift))
+		for i, d := range run.Drift {
+			users[i], kinds[i] = d.UserID, d.Kind
+			if d.RedisScore != nil {
+				redisScores[i] = sql.NullFloat64{Float64: *d.RedisScore, Valid: true}
+			}
+			if d.LedgerScore != nil {
+				ledgerScores[i] = sql.NullInt64{Int64: *d.LedgerScore, Valid: true}
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `
+	INSERT INTO reconcile_drift (run_id, user_id, kind, redis_score, ledger_score)
+	SELECT $1, u, k, r, l
+	FROM unnest($2::text[], $3::text[], $4::float8[], $5::bigint[]) AS v(u, k, r, l)
+	ON CONFLICT DO NOTHING
+`, run.ID, pq.Array(users), pq.Array(kinds), pq.Array(redisScores), pq.Array(ledgerScores)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// latestReconcileRuns returns the most recent run per season (optionally one season).
+func latestReconcileRuns(ctx context.Context, db *sql.DB, seasonID string) ([]reconcileRun, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT DISTINCT ON (season_id)
+	  id, season_id, mode, checked, mismatched, missing, extra, held, healed, max_abs_diff, started_at, finished_at
+	FROM reconcile_runs
+	WHERE $1 = '' OR season_id = $1
+	ORDER BY season_id, id DESC
+`, seasonID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []reconcileRun{}
+	for rows.Next() {
+		var r reconcileRun
+		if err := rows.Scan(&r.ID, &r.SeasonID, &r.Mode, &r.Checked, &r.Mismatched, &r.Missing,
+			&r.Extra, &r.Held, &r.Healed, &r.MaxAbsDiff, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func reconcileDrift(ctx context.Context, db *sql.DB, runID int64) ([]driftRecord, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT user_id, kind, redis_score, ledger_score
+	FROM reconcile_drift
+	WHERE run_id=$1
+	ORDER BY kind, user_id
+`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []driftRecord{}
+	for rows.Next() {
+		var d driftRecord
+		var rs sql.NullFloat64
+		var ls sql.NullInt64
+		if err := rows.Scan(&d.UserID, &d.Kind, &rs, &ls); err != nil {
+			return nil, err
+		}
+		if rs.Valid {
+			d.RedisScore = &rs.Float64
+		}
+		if ls.Valid {
+			d.LedgerScore = &ls.Int64
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// pendingCorrections sums queued score_correction deltas per user.
+func pendingCorrections(ctx context.Context, tx *sql.Tx, seasonID string, users []string) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT payload->>'userId', SUM((payload->>'delta')::bigint)
+	FROM outbox
+	WHERE event_type='score_correction' AND status IN ('pending', 'processing')
+	  AND payload->>'seasonId'=$1 AND payload->>'userId' = ANY($2)
+	GROUP BY 1
+`, seasonID, pq.Array(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]int64)
+	for rows.Next() {
+		var uid string
+		var sum int64
+		if err := rows.Scan(&uid, &sum); err != nil {
+			return nil, err
+		}
+		out[uid] = sum
+	}
+	return out, rows.Err()
+}
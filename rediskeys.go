@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/redis/go-redis/v9"
+)
+
+// Season keys are hash-tagged by season ID so a season's keys share a Redis
+// Cluster slot; the naming lives in package leaderboard (keys.go) and these
+// wrap it. Per-user submit counters stay untagged to spread a busy season's
+// writes across the cluster.
+//
+// Boards under the old untagged names (lb:sid) are not read any more; they
+// are rebuilt from the ledger under the new names when first needed (see
+// warm.go), and the old keys can then be deleted.
+
+func seasonTag(seasonID string) string { return leaderboard.SeasonTag(seasonID) }
+
+func boardKey(seasonID string) string { return leaderboard.BoardKey(seasonID) }
+
+// appliedKey holds the worker's apply markers (see leaderboard/keys.go).
+func appliedKey(seasonID string) string { return leaderboard.AppliedKey(seasonID) }
+
+// boardSeason is the season ID of a board key, or "" for any other key.
+func boardSeason(key string) string { return leaderboard.BoardSeason(key) }
+
+// scanKeys calls fn with each key matching the glob until fn returns false;
+// on a cluster it scans every master.
+func scanKeys(ctx context.Context, rdb redis.UniversalClient, match string, fn func(key string) bool) error {
+	var mu sync.Mutex
+	stopped := false
+	scan := func(ctx context.Context, c redis.Cmdable) error {
+		iter := c.Scan(ctx, 0, match, 1000).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			if !stopped {
+				stopped = !fn(iter.Val())
+			}
+			stop := stopped
+			mu.Unlock()
+			if stop {
+				return nil
+			}
+		}
+		return iter.Err()
+	}
+	if cc, ok := rdb.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *redis.Client) error {
+			return scan(ctx, c)
+		})
+	}
+	return scan(ctx, rdb)
+}
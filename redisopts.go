@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Connection settings shared by the primary and the read replicas:
+//
+//   - REDIS_USERNAME, REDIS_PASSWORD: AUTH (ACL user, or password only)
+//   - REDIS_DB: database index
+//   - REDIS_POOL_SIZE, REDIS_DIAL_TIMEOUT, REDIS_READ_TIMEOUT, REDIS_WRITE_TIMEOUT
+//   - REDIS_TLS=true: connect over TLS, verifying the server against the
+//     system roots or REDIS_TLS_CA_FILE. REDIS_TLS_SERVER_NAME overrides the
+//     name checked (default: the host being dialled), and
+//     REDIS_TLS_CERT_FILE/REDIS_TLS_KEY_FILE present a client certificate.
+//
+// Zero timeouts and pool size keep go-redis's defaults.
+//
+// With REDIS_SENTINEL_MASTER (the master name) and REDIS_SENTINEL_ADDRS
+// (comma-separated) the primary is whichever server the sentinels report,
+// and the client reconnects to the new one after a failover; REDIS_ADDR must
+// then be unset. REDIS_SENTINEL_USERNAME/REDIS_SENTINEL_PASSWORD authenticate
+// to the sentinels, and REDIS_TLS applies to them as well.
+//
+// With REDIS_CLUSTER_ADDRS (comma-separated, any subset of the nodes) the
+// client talks to a Redis Cluster and follows its slot map; REDIS_ADDR,
+// Sentinel, REDIS_DB and REDIS_REPLICA_ADDRS don't apply. Keys are
+// hash-tagged by season (rediskeys.go) so each season's keys share a slot.
+
+// newRedisOptions reads the REDIS_* connection settings for a server at addr.
+func newRedisOptions(addr string) *redis.Options {
+	poolSize := envInt64("REDIS_POOL_SIZE", 0)
+	if poolSize < 0 {
+		panic("invalid REDIS_POOL_SIZE")
+	}
+	db := envInt64("REDIS_DB", 0)
+	if db < 0 {
+		panic("invalid REDIS_DB")
+	}
+	opts := &redis.Options{
+		Addr:         addr,
+		Username:     getenv("REDIS_USERNAME"),
+		Password:     getenv("REDIS_PASSWORD"),
+		DB:           int(db),
+		PoolSize:     int(poolSize),
+		DialTimeout:  envDuration("REDIS_DIAL_TIMEOUT", 0),
+		ReadTimeout:  envDuration("REDIS_READ_TIMEOUT", 0),
+		WriteTimeout: envDuration("REDIS_WRITE_TIMEOUT", 0),
+	}
+	if opts.DialTimeout < 0 || opts.ReadTimeout < 0 || opts.WriteTimeout < 0 {
+		panic("invalid REDIS_*_TIMEOUT")
+	}
+	if opts.Username != "" && opts.Password == "" {
+		panic("REDIS_USERNAME needs REDIS_PASSWORD")
+	}
+	opts.TLSConfig = newRedisTLS()
+	return opts
+}
+
+// newRedisFailoverOptions reads the REDIS_SENTINEL_* settings on top of the
+// connection settings above.
+func newRedisFailoverOptions(master string) *redis.FailoverOptions {
+	addrs := splitAddrs(getenv("REDIS_SENTINEL_ADDRS"))
+	if len(addrs) == 0 {
+		panic("REDIS_SENTINEL_MASTER needs REDIS_SENTINEL_ADDRS")
+	}
+	if getenv("REDIS_ADDR") != "" {
+		panic("set either REDIS_ADDR or REDIS_SENTINEL_MASTER, not both")
+	}
+	opts := newRedisOptions("")
+	return &redis.FailoverOptions{
+		MasterName:       master,
+		SentinelAddrs:    addrs,
+		SentinelUsername: getenv("REDIS_SENTINEL_USERNAME"),
+		SentinelPassword: getenv("REDIS_SENTINEL_PASSWORD"),
+		Username:         opts.Username,
+		Password:         opts.Password,
+		DB:               opts.DB,
+		PoolSize:         opts.PoolSize,
+		DialTimeout:      opts.DialTimeout,
+		ReadTimeout:      opts.ReadTimeout,
+		WriteTimeout:     opts.WriteTimeout,
+		TLSConfig:        opts.TLSConfig,
+	}
+}
+
+// newRedisClusterOptions reads the cluster seed nodes on top of the
+// connection settings above.
+func newRedisClusterOptions(addrs []string) *redis.ClusterOptions {
+	if getenv("REDIS_ADDR") != "" || getenv("REDIS_SENTINEL_MASTER") != "" {
+		panic("set only one of REDIS_ADDR, REDIS_SENTINEL_MASTER and REDIS_CLUSTER_ADDRS")
+	}
+	if getenv("REDIS_REPLICA_ADDRS") != "" {
+		panic("REDIS_REPLICA_ADDRS doesn't apply to a cluster")
+	}
+	opts := newRedisOptions("")
+	if opts.DB != 0 {
+		panic("REDIS_DB must be 0 with REDIS_CLUSTER_ADDRS")
+	}
+	return &redis.ClusterOptions{
+		Addrs:        addrs,
+		Username:     opts.Username,
+		Password:     opts.Password,
+		PoolSize:     opts.PoolSize,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		TLSConfig:    opts.TLSConfig,
+	}
+}
+
+// splitAddrs splits a comma-separated address list, dropping blanks.
+func splitAddrs(v string) []string {
+	var addrs []string
+	for _, a := range strings.Split(v, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// newRedisTLS is nil unless REDIS_TLS is on.
+func newRedisTLS() *tls.Config {
+	caFile := getenv("REDIS_TLS_CA_FILE")
+	certFile, keyFile := getenv("REDIS_TLS_CERT_FILE"), getenv("REDIS_TLS_KEY_FILE")
+	if !envBool("REDIS_TLS", false) {
+		if caFile != "" || certFile != "" || keyFile != "" {
+			panic("REDIS_TLS_* files need REDIS_TLS=true")
+		}
+		return nil
+	}
+	cfg := &tls.Config{
+		ServerName: getenv("REDIS_TLS_SERVER_NAME"),
+		MinVersion: tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			panic(fmt.Sprintf("invalid REDIS_TLS_CA_FILE: %v", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			panic("invalid REDIS_TLS_CA_FILE: no certificates")
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			panic(fmt.Sprintf("invalid REDIS_TLS_CERT_FILE/REDIS_TLS_KEY_FILE: %v", err))
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Some settings can change without a restart, so SSE and WebSocket clients
+// stay connected: on SIGHUP, or when the config file's modification time
+// changes (checked every CONFIG_WATCH_INTERVAL, off by default), the file is
+// read again and these are applied:
+//
+//   - RATE_LIMIT_{READ,WRITE,ADMIN}_{RPS,BURST}
+//   - OUTBOX_BATCH_SIZE, OUTBOX_POLL_INTERVAL, OUTBOX_POLL_MAX_INTERVAL
+//   - TOP_CACHE_TTL, PERCENTILES_CACHE_TTL, API_KEY_CACHE_TTL, JWKS_CACHE_TTL
+//
+// Everything else is read once and needs a restart. A process can't see
+// changes to its own environment, so a reload only picks up the file and
+// -set flags, and environment variables still override it. If any value is
+// invalid the whole reload is rejected and the running values stay.
+
+// tunableDuration is a duration a config reload can change while it is in use.
+type tunableDuration struct{ v atomic.Int64 }
+
+func (t *tunableDuration) get() time.Duration  { return time.Duration(t.v.Load()) }
+func (t *tunableDuration) set(d time.Duration) { t.v.Store(int64(d)) }
+
+type configReloader struct {
+	mu       sync.Mutex
+	prepares []func() func() // each reads and checks its settings, then returns how to apply them
+}
+
+// add registers settings to reload: prepare reads them (panicking on an
+// invalid value, like the env helpers) and returns a func that applies them.
+func (cr *configReloader) add(prepare func() func()) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.prepares = append(cr.prepares, prepare)
+}
+
+// reload reads the config file again and applies the reloadable settings,
+// all or none.
+func (cr *configReloader) reload() (err error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	config.mu.Lock()
+	path, oldFile, oldReads := config.path, config.file, maps.Clone(config.reads)
+	config.mu.Unlock()
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			config.mu.Lock()
+			config.file, config.reads = oldFile, oldReads
+			config.mu.Unlock()
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+
+	applies := make([]func(), 0, len(cr.prepares))
+	for _, prepare := range cr.prepares {
+		applies = append(applies, prepare())
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
+// run reloads on SIGHUP and, with watch > 0, when the config file changes.
+func (cr *configReloader) run(ctx context.Context, watch time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	config.mu.Lock()
+	path := config.path
+	config.mu.Unlock()
+	var tick <-chan time.Time
+	var modTime time.Time
+	if watch > 0 && path != "" {
+		t := time.NewTicker(watch)
+		defer t.Stop()
+		tick = t.C
+		if fi, err := os.Stat(path); err == nil {
+			modTime = fi.ModTime()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = fi.ModTime()
+		}
+		if err := cr.reload(); err != nil {
+			slog.Error("Config reload rejected", "err", err)
+			continue
+		}
+		slog.Info("Config reloaded")
+		config.logEffective()
+	}
+}
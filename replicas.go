@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Replica reads: top/rank/around may be served from Redis replicas. Each
+// instance writes a heartbeat timestamp to the primary and reads it back from
+// every replica; a replica is eligible while its heartbeat lag is within
+// maxLag. Among eligible replicas the one with the lowest round trip wins, and
+// any replica error or miss is retried on the primary.
+
+const (
+	replicaHeartbeatKey      = "lbctl:heartbeat"
+	replicaHeartbeatInterval = 250 * time.Millisecond
+)
+
+type redisReplica struct {
+	addr   string
+	client *redis.Client
+
+	ok    atomic.Bool
+	rttNs atomic.Int64
+	lagNs atomic.Int64
+}
+
+type redisReads struct {
+	primary  redis.UniversalClient
+	replicas []*redisReplica
+	maxLag   time.Duration
+	retry    transientRetry
+}
+
+// newRedisReads returns a router for the given comma-separated replica
+// addresses, which connect like the primary (redisopts.go); with none
+// configured every read goes to the primary.
+func newRedisReads(primary redis.UniversalClient, addrs string, maxLag time.Duration, retry transientRetry) *redisReads {
+	rr := &redisReads{primary: primary, maxLag: maxLag, retry: retry}
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		client := redis.NewClient(newRedisOptions(a))
+		client.AddHook(redisMetricsHook{})
+		client.AddHook(redisTraceHook{})
+		rr.replicas = append(rr.replicas, &redisReplica{addr: a, client: client})
+	}
+	return rr
+}
+
+// pick returns the lowest-latency replica within the staleness bound, or nil.
+func (rr *redisReads) pick() *redis.Client {
+	var best *redisReplica
+	for _, r := range rr.replicas {
+		if !r.ok.Load() {
+			continue
+		}
+		if best == nil || r.rttNs.Load() < best.rttNs.Load() {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.client
+}
+
+// do runs fn against a replica when one is eligible, falling back to the
+// primary on any error (redis.Nil included: a lagging replica may simply not
+// have the member yet). Transient primary errors are retried (retry.go).
+func (rr *redisReads) do(ctx context.Context, fn func(c redis.Cmdable) error) error {
+	return rr.retry.do(ctx, func() error {
+		if c := rr.pick(); c != nil {
+			if err := fn(c); err == nil {
+				return nil
+			}
+		}
+		return fn(rr.primary)
+	})
+}
+
+func (rr *redisReads) run(ctx context.Context) {
+	if len(rr.replicas) == 0 {
+		return
+	}
+	ticker := time.NewTicker(replicaHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c, cancel := context.WithTimeout(ctx, replicaHeartbeatInterval)
+			err := rr.primary.Set(c, replicaHeartbeatKey, time.Now().UnixNano(), time.Minute).Err()
+			if err != nil && !errors.Is(err, errRedisUnavailable) {
+				slog.Error("Replica heartbeat error", "err", err)
+			}
+			for _, r := range rr.replicas {
+				rr.probe(c, r)
+			}
+			cancel()
+		}
+	}
+}
+
+func (rr *redisReads) probe(ctx context.Context, r *redisReplica) {
+	start := time.Now()
+	v, err := r.client.Get(ctx, replicaHeartbeatKey).Result()
+	rtt := time.Since(start)
+	if err != nil {
+		if r.ok.Swap(false) {
+			slog.Warn("Replica disabled", "replica", r.addr, "err", err)
+		}
+		return
+	}
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		r.ok.Store(false)
+		return
+	}
+	// Heartbeats come from every instance, so clock skew between them shows up
+	// as lag; keep clocks in sync or leave headroom in maxLag.
+	lag := max(time.Since(time.Unix(0, ns)), 0)
+
+	r.rttNs.Store(int64(rtt))
+	r.lagNs.Store(int64(lag))
+	ok := lag <= rr.maxLag
+	if r.ok.Swap(ok) != ok {
+		if ok {
+			slog.Info("Replica enabled", "replica", r.addr, "lag", lag.String())
+		} else {
+			slog.Warn("Replica disabled", "replica", r.addr, "lag", lag.String())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Cheat reports aggregate per (season, target user) in report_targets. A held
+// target is removed from the ZSET and the worker stops applying its deltas;
+// the ledger keeps every event, so releasing the hold restores the exact score.
+
+type reportRequest struct {
+	TargetUserID string `json:"targetUserId"`
+	ReporterID   string `json:"reporterId"`
+	Reason       string `json:"reason"`
+}
+
+type reportTarget struct {
+	SeasonID        string     `json:"seasonId"`
+	UserID          string     `json:"userId"`
+	ReportCount     int64      `json:"reportCount"`
+	Status          string     `json:"status"` // open/held/dismissed
+	FirstReportedAt time.Time  `json:"firstReportedAt"`
+	LastReportedAt  time.Time  `json:"lastReportedAt"`
+	HeldAt          *time.Time `json:"heldAt,omitempty"`
+}
+
+// recordReport stores one report and returns the updated target aggregate.
+// A reporter counts once per target; repeats are accepted but not counted.
+func recordReport(ctx context.Context, db *sql.DB, seasonID string, req reportRequest, score float64, rank int64) (*reportTarget, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	INSERT INTO reports (season_id, target_user_id, reporter_id, reason, score_at_report, rank_at_report)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+	ON CONFLICT (season_id, target_user_id, reporter_id) DO NOTHING
+`, seasonID, req.TargetUserID, req.ReporterID, req.Reason, score, rank)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := res.RowsAffected()
+
+	var t reportTarget
+	var heldAt sql.NullTime
+	if err := tx.QueryRowContext(ctx, `
+	INSERT INTO report_targets (season_id, user_id, report_count)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (season_id, user_id) DO UPDATE SET
+	  report_count=report_targets.report_count+EXCLUDED.report_count,
+	  last_reported_at=now()
+	RETURNING season_id, user_id, report_count, status, first_reported_at, last_reported_at, held_at
+`, seasonID, req.TargetUserID, n).Scan(&t.SeasonID, &t.UserID, &t.ReportCount, &t.Status,
+		&t.FirstReportedAt, &t.LastReportedAt, &heldAt); err != nil {
+		return nil, err
+	}
+	if heldAt.Valid {
+		t.HeldAt = &heldAt.Time
+	}
+	return &t, tx.Commit()
+}
+
+func listReportTargets(ctx context.Context, db *sql.DB, seasonID, status string, limit int) ([]reportTarget, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT season_id, user_id, report_count, status, first_reported_at, last_reported_at, held_at
+	FROM report_targets
+	WHERE season_id=$1 AND status=$2
+	ORDER BY report_count DESC, last_reported_at DESC
+	LIMIT $3
+`, seasonID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []reportTarget{}
+	for rows.Next() {
+		var t reportTarget
+		var heldAt sql.NullTime
+		if err := rows.Scan(&t.SeasonID, &t.UserID, &t.ReportCount, &t.Status,
+			&t.FirstReportedAt, &t.LastReportedAt, &heldAt); err != nil {
+			return nil, err
+		}
+		if heldAt.Valid {
+			t.HeldAt = &heldAt.Time
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// holdFromBoard marks the target held and removes it from the ZSET.
+func holdFromBoard(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID, userID string) error {
+	if _, err := db.ExecContext(ctx, `
+	INSERT INTO report_targets (season_id, user_id, report_count, status, held_at)
+	VALUES ($1, $2, 0, 'held', now())
+	ON CONFLICT (season_id, user_id) DO UPDATE SET status='held', held_at=now()
+`, seasonID, userID); err != nil {
+		return err
+	}
+	// Deltas already in flight may re-add the member before the worker sees the
+	// hold; those are removed again on the next apply for this user.
+	if err := rdb.ZRem(ctx, boardKey(seasonID), userID).Err(); err != nil {
+		return err
+	}
+	bumpBoardVersion(ctx, rdb, seasonID)
+	return nil
+}
+
+// releaseFromBoard clears a hold (or dismisses an open target) and, if the user
+// was held, puts them back on the board with their ledger total. A target that
+// was never held is still on the board and is left alone.
+func releaseFromBoard(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID, userID, status string, defaultMaxSize int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasHeld bool
+	if err := tx.QueryRowContext(ctx, `
+	UPDATE report_targets t SET status=$3, held_at=NULL
+	FROM (
+	  SELECT status FROM report_targets WHERE season_id=$1 AND user_id=$2 FOR UPDATE
+	) old
+	WHERE t.season_id=$1 AND t.user_id=$2
+	RETURNING old.status = 'held'
+`, seasonID, userID, status).Scan(&wasHeld); err != nil {
+		return err
+	}
+	// The same recompute as a user rebuild, under the season's rebuild lock and
+	// leaving out deltas the worker hasn't applied yet; the hold stays if it fails.
+	if wasHeld {
+		if _, _, _, err := rebuildUserScoreTx(ctx, tx, db, rdb, seasonID, userID, defaultMaxSize); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// heldUsers returns the held (season, user) pairs among the given candidates,
+// keyed as season + "\x00" + user.
+func heldUsers(ctx context.Context, tx *sql.Tx, seasons, users []string) (map[string]struct{}, error) {
+	held := make(map[string]struct{})
+	if len(users) == 0 {
+		return held, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT season_id, user_id
+	FROM report_targets
+	WHERE status='held' AND season_id = ANY($1) AND user_id = ANY($2)
+`, pq.Array(seasons), pq.Array(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid, uid string
+		if err := rows.Scan(&sid, &uid); err != nil {
+			return nil, err
+		}
+		held[sid+"\x00"+uid] = struct{}{}
+	}
+	return held, rows.Err()
+}
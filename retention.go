@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Season expiry: expire_after_days after ends_at a season is either archived
+// (ZSET dropped via a season_archived outbox event, writes closed, ledger kept)
+// or deleted outright through a regular season delete job.
+
+// Event retention: once a season has been over for event_retention_days, its
+// raw score_events are compacted into one row per user (compacted=TRUE) holding
+// the user's total. SUM(delta) per user is unchanged, so anything reading the
+// ledger keeps working; only per-event history is lost.
+
+const retentionUserBatch = 1000
+
+type expiringSeason struct {
+	SeasonID        string    `json:"seasonId"`
+	EndsAt          time.Time `json:"endsAt"`
+	ExpireAfterDays int       `json:"expireAfterDays"`
+	Action          string    `json:"action"` // archive/delete
+}
+
+type retentionReport struct {
+	SeasonID           string    `json:"seasonId"`
+	EndsAt             time.Time `json:"endsAt"`
+	EventRetentionDays int       `json:"eventRetentionDays"`
+	Events             int64     `json:"events"` // raw rows that would be compacted
+	Users              int64     `json:"users"`  // compacted rows that would replace them
+}
+
+// dueRetentionSeasons lists seasons whose raw events are past their retention window.
+func dueRetentionSeasons(ctx context.Context, db *sql.DB) ([]retentionReport, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT season_id, ends_at, event_retention_days
+	FROM seasons
+	WHERE ends_at IS NOT NULL AND event_retention_days IS NOT NULL
+	  AND ends_at + make_interval(days => event_retention_days) < now()
+	ORDER BY ends_at
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []retentionReport
+	for rows.Next() {
+		var r retentionReport
+		if err := rows.Scan(&r.SeasonID, &r.EndsAt, &r.EventRetentionDays); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// retentionDryRun reports what the next retention pass would compact.
+func retentionDryRun(ctx context.Context, db *sql.DB) ([]retentionReport, error) {
+	due, err := dueRetentionSeasons(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]retentionReport, 0, len(due))
+	for _, r := range due {
+		if err := db.QueryRowContext(ctx, `
+	SELECT COUNT(*), COUNT(DISTINCT user_id)
+	FROM score_events e
+	WHERE season_id=$1 AND NOT compacted
+	  AND NOT EXISTS (
+	    SELECT 1 FROM outbox o
+	    WHERE o.status IN ('pending', 'processing', 'failed') AND o.event_id = e.id
+	  )
+`, r.SeasonID).Scan(&r.Events, &r.Users); err != nil {
+			return nil, err
+		}
+		if r.Events > 0 {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// dueExpiringSeasons lists seasons past ends_at + expire_after_days that haven't been expired yet.
+func dueExpiringSeasons(ctx context.Context, db *sql.DB) ([]expiringSeason, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT season_id, ends_at, expire_after_days, expire_action
+	FROM seasons
+	WHERE ends_at IS NOT NULL AND expire_after_days IS NOT NULL AND expired_at IS NULL
+	  AND ends_at + make_interval(days => expire_after_days) < now()
+	ORDER BY ends_at
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []expiringSeason{}
+	for rows.Next() {
+		var e expiringSeason
+		if err := rows.Scan(&e.SeasonID, &e.EndsAt, &e.ExpireAfterDays, &e.Action); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func expireSeasons(ctx context.Context, db *sql.DB, rdb redis.UniversalClient) error {
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	due, err := dueExpiringSeasons(c, db)
+	if err != nil {
+		return err
+	}
+	for _, e := range due {
+		if err := expireSeason(c, db, rdb, e); err != nil {
+			return fmt.Errorf("season %s: %w", e.SeasonID, err)
+		}
+		slog.Info("Season expired", "seasonId", e.SeasonID, "action", e.Action)
+	}
+	return nil
+}
+
+// expireSeason claims the season by setting expired_at, so only one instance acts on it.
+func expireSeason(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, e expiringSeason) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	UPDATE seasons SET expired_at=now(), updated_at=now()
+	WHERE season_id=$1 AND expired_at IS NULL
+`, e.SeasonID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil // another instance got it
+	}
+
+	payload, _ := json.Marshal(map[string]any{"seasonId": e.SeasonID})
+	switch e.Action {
+	case "delete":
+		redisMembers, err := rdb.ZCard(ctx, boardKey(e.SeasonID)).Result()
+		if err != nil {
+			return err
+		}
+		if _, err := enqueueSeasonDelete(ctx, tx, e.SeasonID, payload, redisMembers); err != nil {
+			return err
+		}
+	default: // archive
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE seasons SET status='archived' WHERE season_id=$1`, e.SeasonID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+  INSERT INTO outbox (event_type, payload, status, request_id)
+  VALUES ('season_archived', $1, 'pending', NULLIF($2,''))
+`, payload, requestID(ctx)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func runRetentionJob(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, interval time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if dryRun {
+				c, cancel := context.WithTimeout(ctx, time.Minute)
+				report, err := retentionDryRun(c, db)
+				cancel()
+				if err != nil {
+					slog.Error("Retention dry-run error", "err", err)
+					continue
+				}
+				for _, r := range report {
+					slog.Info("Retention dry-run", "seasonId", r.SeasonID, "events", r.Events, "users", r.Users)
+				}
+				c, cancel = context.WithTimeout(ctx, 10*time.Second)
+				expiring, err := dueExpiringSeasons(c, db)
+				cancel()
+				if err != nil {
+					slog.Error("Expiry dry-run error", "err", err)
+					continue
+				}
+				for _, e := range expiring {
+					slog.Info("Expiry dry-run", "seasonId", e.SeasonID, "action", e.Action)
+				}
+				continue
+			}
+
+			if err := applyRetention(ctx, db); err != nil {
+				slog.Error("Retention error", "err", err)
+			}
+			if err := expireSeasons(ctx, db, rdb); err != nil {
+				slog.Error("Expiry error", "err", err)
+			}
+		}
+	}
+}
+
+func applyRetention(ctx context.Context, db *sql.DB) error {
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	due, err := dueRetentionSeasons(c, db)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		n, err := compactSeasonEvents(ctx, db, r.SeasonID)
+		if err != nil {
+			return fmt.Errorf("season %s: %w", r.SeasonID, err)
+		}
+		if n > 0 {
+			slog.Info("Retention compacted events", "seasonId", r.SeasonID, "users", n)
+		}
+	}
+	return nil
+}
+
+// compactSeasonEvents folds a season's raw events into per-user totals, a batch
+// of users at a time. Each batch is a single DELETE ... RETURNING feeding the
+// INSERT, so concurrent runs on other instances can't double-count a row.
+// Events whose outbox row is still pending, processing or failed stay raw:
+// rebuilds tell unapplied events apart by id, which a total doesn't keep, and
+// the worker or a requeue would add them on top of it. A later run folds them
+// in once applied. A total keeps the time of the latest event it replaces,
+// which rebuilds use to order tied scores by when they were reached.
+func compactSeasonEvents(ctx context.Context, db *sql.DB, seasonID string) (int64, error) {
+	var cutoff int64
+	c, cancel := context.WithTimeout(ctx, 5*time.Second)
+	err := db.QueryRowContext(c, `SELECT COALESCE(MAX(id), 0) FROM score_events`).Scan(&cutoff)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	after := ""
+	for {
+		c, cancel := context.WithTimeout(ctx, 30*time.Second)
+		users, err := nextRetentionUsers(c, db, seasonID, cutoff, after)
+		if err != nil {
+			cancel()
+			return total, err
+		}
+		if len(users) == 0 {
+			cancel()
+			return total, nil
+		}
+
+		res, err := db.ExecContext(c, `
+	WITH moved AS (
+	  DELETE FROM score_events
+	  WHERE season_id=$1 AND user_id = ANY($2) AND NOT compacted AND id <= $3
+	    AND NOT EXISTS (
+	      SELECT 1 FROM outbox o
+	      WHERE o.status IN ('pending', 'processing', 'failed') AND o.event_id = score_events.id
+	    )
+	  RETURNING user_id, delta, created_at
+	)
+	INSERT INTO score_events (season_id, user_id, delta, compacted, created_at)
+	SELECT $1, user_id, SUM(delta), TRUE, MAX(created_at)
+	FROM moved
+	GROUP BY user_id
+`, seasonID, pq.Array(users), cutoff)
+		cancel()
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+		after = users[len(users)-1]
+	}
+}
+
+func nextRetentionUsers(ctx context.Context, db *sql.DB, seasonID string, cutoff int64, after string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT DISTINCT user_id
+	FROM score_events
+	WHERE season_id=$1 AND NOT compacted AND id <= $2 AND user_id > $3
+	ORDER BY user_id
+	LIMIT $4
+`, seasonID, cutoff, after, retentionUserBatch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
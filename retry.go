@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Transient errors are retried a few times, with exponential backoff and full
+// jitter, before they reach a client as a 500 or the worker's error log: a
+// dropped or refused connection, a timeout, Redis in the middle of a failover
+// (LOADING, READONLY, MASTERDOWN, TRYAGAIN, CLUSTERDOWN), and Postgres
+// connection failures, serialization failures and deadlocks. Anything else,
+// an open Redis circuit breaker (breaker.go) and the caller's own deadline
+// included, is returned at once. RETRY_ATTEMPTS counts tries (1 turns retries
+// off); RETRY_BASE_DELAY doubles per try up to RETRY_MAX_DELAY.
+
+type transientRetry struct {
+	attempts int
+	base     time.Duration
+	max      time.Duration
+}
+
+func newTransientRetry() transientRetry {
+	t := transientRetry{
+		attempts: int(envInt64("RETRY_ATTEMPTS", 3)),
+		base:     envDuration("RETRY_BASE_DELAY", 20*time.Millisecond),
+		max:      envDuration("RETRY_MAX_DELAY", 500*time.Millisecond),
+	}
+	if t.attempts < 1 || t.base <= 0 || t.max < t.base {
+		panic("invalid RETRY_ATTEMPTS/RETRY_BASE_DELAY/RETRY_MAX_DELAY")
+	}
+	return t
+}
+
+// do calls fn until it succeeds, fails with an error that isn't transient,
+// runs out of attempts, or ctx is done; it returns fn's last error.
+func (t transientRetry) do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= t.attempts || !transientError(err) {
+			return err
+		}
+		wait := t.max
+		if shift := attempt - 1; shift < 32 && t.base<<shift < t.max {
+			wait = t.base << shift
+		}
+		timer := time.NewTimer(rand.N(wait) + 1)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Postgres SQLSTATEs worth another try; class 08 (connection exception) is
+// matched as a whole.
+var transientSQLStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+var transientRedisReplies = []string{"LOADING", "READONLY", "MASTERDOWN", "TRYAGAIN", "CLUSTERDOWN"}
+
+func transientError(err error) bool {
+	if err == nil || err == redis.Nil || errors.Is(err, errRedisUnavailable) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		code := pgErr.SQLState()
+		return strings.HasPrefix(code, "08") || transientSQLStates[code]
+	}
+	var reply redis.Error
+	if errors.As(err, &reply) {
+		for _, prefix := range transientRedisReplies {
+			if strings.HasPrefix(reply.Error(), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var safe interface{ SafeToRetry() bool }
+	if errors.As(err, &safe) && safe.SafeToRetry() {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
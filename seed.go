@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/ledger"
+)
+
+// The seed command fills seasons with generated submissions for staging and
+// demos. Rows are written like POST /scores writes them: a score_events row
+// and a pending score_delta outbox row each, so the worker builds the boards
+// (and sends webhooks and stream events) as it would for real traffic.
+//
+// Users are user1..userN. Each has a skill (log-normal, the same in every
+// season) scaling the size of their deltas and an activity (log-normal)
+// scaling how often they submit, so a few users submit a lot and score high
+// while most have a handful of events. Events are spread over the span before
+// now, oldest first. The same seed always generates the same data.
+
+const seedChunkSize = 5000 // rows per statement
+
+type seedOptions struct {
+	users      int
+	events     float64 // mean submissions per user per season
+	span       time.Duration
+	seed       int64
+	allowExist bool // seed seasons that already have events
+}
+
+type seedEvent struct {
+	userID    string
+	delta     int64
+	createdAt time.Time
+}
+
+type seedResult struct {
+	Events int64
+	Users  int64
+}
+
+type seedUser struct {
+	skill, activity float64
+}
+
+func seedUsers(n int, seed int64) []seedUser {
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	users := make([]seedUser, n)
+	for i := range users {
+		users[i] = seedUser{
+			skill:    math.Exp(rng.NormFloat64() * 0.75),
+			activity: math.Exp(rng.NormFloat64()),
+		}
+	}
+	return users
+}
+
+// seedEvents generates a season's events in created_at order.
+func seedEvents(seasonID string, users []seedUser, opts seedOptions, now time.Time) []seedEvent {
+	h := fnv.New64a()
+	h.Write([]byte(seasonID))
+	rng := rand.New(rand.NewPCG(uint64(opts.seed), h.Sum64()))
+
+	var evs []seedEvent
+	for i, u := range users {
+		// geometric with mean events*activity
+		mean := opts.events * u.activity
+		n := int(math.Log(1-rng.Float64()) / math.Log(mean/(mean+1)))
+		n = min(n, int(50*opts.events))
+		for range n {
+			delta := int64(math.Round(100 * u.skill * math.Exp(rng.NormFloat64()*0.5)))
+			evs = append(evs, seedEvent{
+				userID:    fmt.Sprintf("user%d", i+1),
+				delta:     max(delta, 1),
+				createdAt: now.Add(-time.Duration(rng.Int64N(int64(opts.span)))),
+			})
+		}
+	}
+	sort.Slice(evs, func(a, b int) bool { return evs[a].createdAt.Before(evs[b].createdAt) })
+	return evs
+}
+
+// seedSeason writes a season's generated events and their outbox rows in one
+// transaction. Frozen and archived seasons are refused, as are seasons that
+// already have events unless opts.allowExist.
+func seedSeason(ctx context.Context, db *sql.DB, seasonID string, users []seedUser, opts seedOptions) (*seedResult, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// as in import: the row has to exist for FOR SHARE to hold off a freeze
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO seasons (season_id) VALUES ($1) ON CONFLICT (season_id) DO NOTHING`, seasonID); err != nil {
+		return nil, err
+	}
+	var status string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT status FROM seasons WHERE season_id=$1 FOR SHARE`, seasonID).Scan(&status); err != nil {
+		return nil, err
+	}
+	if ledger.Closed(status) {
+		return nil, fmt.Errorf("season is %s", status)
+	}
+	if !opts.allowExist {
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM score_events WHERE season_id=$1)`, seasonID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("season already has events (use -append to add to them)")
+		}
+	}
+
+	evs := seedEvents(seasonID, users, opts, time.Now())
+	res := &seedResult{Events: int64(len(evs))}
+	seen := make(map[string]struct{})
+	var wb writeBatch
+	for start := 0; start < len(evs); start += seedChunkSize {
+		chunk := evs[start:min(start+seedChunkSize, len(evs))]
+		uids := make([]string, len(chunk))
+		deltas := make([]int64, len(chunk))
+		ats := make([]time.Time, len(chunk))
+		for i, ev := range chunk {
+			uids[i], deltas[i], ats[i] = ev.userID, ev.delta, ev.createdAt
+			seen[ev.userID] = struct{}{}
+		}
+		// the same rows ledger.Postgres writes, ordered so ids follow created_at
+		wb.queue("seed insert", `
+	WITH ev AS (
+	  INSERT INTO score_events (season_id, user_id, delta, created_at)
+	  SELECT $1::text, u, d, t FROM unnest($2::text[], $3::bigint[], $4::timestamptz[]) WITH ORDINALITY AS x(u, d, t, n)
+	  ORDER BY n
+	  RETURNING id, user_id, delta
+	)
+	INSERT INTO outbox (event_type, payload, status, event_id)
+	SELECT 'score_delta', jsonb_build_object('seasonId', $1::text, 'userId', user_id, 'delta', delta, 'eventId', id), 'pending', id
+	FROM ev ORDER BY id
+`, seasonID, uids, deltas, ats)
+	}
+	if err := wb.send(ctx, conn); err != nil {
+		return nil, err
+	}
+	res.Users = int64(len(seen))
+	return res, tx.Commit()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Snapshots copy each board into Postgres on a schedule, so a board can be
+// recovered to a point in time even when Redis persistence is off or lost.
+// The ZSET is first COPY'd to a scratch key (atomic, so the snapshot is a
+// consistent cut) and then read from there page by page. Restores go the other
+// way through a temp key and RENAME, under the same lock as a rebuild.
+
+const snapshotPage = 1000
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+type leaderboardSnapshot struct {
+	ID       int64     `json:"snapshotId"`
+	SeasonID string    `json:"seasonId"`
+	Members  int64     `json:"members"`
+	TakenAt  time.Time `json:"takenAt"`
+}
+
+func runSnapshotJob(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := snapshotAll(ctx, db, rdb, keep); err != nil {
+				slog.Error("Snapshot error", "err", err)
+			}
+		}
+	}
+}
+
+// snapshotAll snapshots every board once and prunes all but the newest keep
+// snapshots per season. Like reconciliation, one instance runs it at a time.
+func snapshotAll(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, keep int) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_lock(hashtext('lb_snapshot'))`).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('lb_snapshot'))`)
+
+	c, cancel := context.WithTimeout(ctx, time.Minute)
+	seasons, err := boardSeasons(c, db)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for _, sid := range seasons {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		snap, err := snapshotSeason(ctx, db, rdb, sid)
+		if err != nil {
+			return fmt.Errorf("season %s: %w", sid, err)
+		}
+		if snap == nil {
+			continue // board not in Redis; nothing worth keeping
+		}
+
+		c, cancel := context.WithTimeout(ctx, 30*time.Second)
+		_, err = db.ExecContext(c, `
+	DELETE FROM leaderboard_snapshots
+	WHERE season_id=$1 AND id NOT IN (
+	  SELECT id FROM leaderboard_snapshots WHERE season_id=$1 ORDER BY id DESC LIMIT $2
+	)
+`, sid, keep)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("season %s: prune: %w", sid, err)
+		}
+	}
+	return nil
+}
+
+// snapshotSeason stores the current board; it returns nil when the board
+// doesn't exist in Redis.
+func snapshotSeason(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID string) (*leaderboardSnapshot, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	key := boardKey(seasonID)
+	tmp := fmt.Sprintf("lbtmp:snapshot:%s", seasonTag(seasonID))
+	copied, err := rdb.Copy(ctx, key, tmp, 0, true).Result()
+	if err != nil {
+		return nil, err
+	}
+	if copied == 0 {
+		return nil, nil
+	}
+	defer rdb.Del(context.Background(), tmp)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	snap := &leaderboardSnapshot{SeasonID: seasonID}
+	if err := tx.QueryRowContext(ctx, `
+	INSERT INTO leaderboard_snapshots (season_id) VALUES ($1)
+	RETURNING id, taken_at
+`, seasonID).Scan(&snap.ID, &snap.TakenAt); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, snapshotPage)
+	scores := make([]float64, 0, snapshotPage)
+	for start := int64(0); ; start += snapshotPage {
+		zs, err := rdb.ZRangeWithScores(ctx, tmp, start, start+snapshotPage-1).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(zs) == 0 {
+			break
+		}
+		ids, scores = ids[:0], scores[:0]
+		for _, z := range zs {
+			uid, ok := z.Member.(string)
+			if !ok {
+				uid = fmt.Sprint(z.Member)
+			}
+			ids = append(ids, uid)
+			scores = append(scores, z.Score)
+		}
+		if _, err := tx.ExecContext(ctx, `
+	INSERT INTO leaderboard_snapshot_members (snapshot_id, user_id, score)
+	SELECT $1, u, s FROM unnest($2::text[], $3::float8[]) AS t(u, s)
+`, snap.ID, pq.Array(ids), pq.Array(scores)); err != nil {
+			return nil, err
+		}
+		snap.Members += int64(len(zs))
+		if len(zs) < snapshotPage {
+			break
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	UPDATE leaderboard_snapshots SET members=$2 WHERE id=$1
+`, snap.ID, snap.Members); err != nil {
+		return nil, err
+	}
+	return snap, tx.Commit()
+}
+
+// restoreSnapshot replaces lb:{sid} with the members of one of its snapshots.
+// Deltas applied after the snapshot was taken are not replayed; a rebuild from
+// the ledger is the way to get those back.
+func restoreSnapshot(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, seasonID string, snapshotID, defaultMaxSize int64) (*leaderboardSnapshot, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockSeasonForRebuild(ctx, tx, seasonID); err != nil {
+		return nil, err
+	}
+	// queued corrections were computed against the board being replaced
+	if err := markRebuild(ctx, tx, seasonID, ""); err != nil {
+		return nil, err
+	}
+
+	snap := &leaderboardSnapshot{ID: snapshotID, SeasonID: seasonID}
+	err = tx.QueryRowContext(ctx, `
+	SELECT members, taken_at FROM leaderboard_snapshots WHERE id=$1 AND season_id=$2
+`, snapshotID, seasonID).Scan(&snap.Members, &snap.TakenAt)
+	if err == sql.ErrNoRows {
+		return nil, errSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+	SELECT user_id, score FROM leaderboard_snapshot_members WHERE snapshot_id=$1
+`, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	key := boardKey(seasonID)
+	tmp := fmt.Sprintf("lbtmp:restore:%s", seasonTag(seasonID))
+	if err := rdb.Del(ctx, tmp).Err(); err != nil {
+		return nil, err
+	}
+
+	var members int64
+	batch := make([]redis.Z, 0, rebuildZAddBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := rdb.ZAdd(ctx, tmp, batch...).Err()
+		batch = batch[:0]
+		return err
+	}
+	for rows.Next() {
+		var z redis.Z
+		var uid string
+		if err := rows.Scan(&uid, &z.Score); err != nil {
+			return nil, err
+		}
+		z.Member = uid
+		batch = append(batch, z)
+		members++
+		if len(batch) == rebuildZAddBatch {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if members == 0 {
+		if err := rdb.Del(ctx, key, appliedKey(seasonID)).Err(); err != nil {
+			return nil, err
+		}
+	} else if err := replaceBoard(ctx, rdb, tmp, key, seasonID); err != nil {
+		return nil, err
+	}
+	bumpBoardVersion(ctx, rdb, seasonID)
+
+	// the season's cap may have shrunk since the snapshot
+	if err := trimLeaderboards(ctx, db, rdb, map[string]struct{}{seasonID: {}}, defaultMaxSize); err != nil {
+		slog.Error("Trim error", "err", err)
+	}
+
+	snap.Members = members
+	return snap, tx.Commit()
+}
+
+func listSnapshots(ctx context.Context, db *sql.DB, seasonID string, limit int) ([]leaderboardSnapshot, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT id, season_id, members, taken_at
+	FROM leaderboard_snapshots
+	WHERE season_id=$1
+	ORDER BY id DESC
+	LIMIT $2
+`, seasonID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []leaderboardSnapshot{}
+	for rows.Next() {
+		var s leaderboardSnapshot
+		if err := rows.Scan(&s.ID, &s.SeasonID, &s.Members, &s.TakenAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
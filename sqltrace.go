@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/redis/go-redis/v9"
+)
+
+// SQL and Redis calls made inside a traced operation get client spans. The
+// SQL side wraps the pgx driver ("pgx-traced"); queries are recorded when the
+// driver runs them, so a span covers the round trip but not reading the rows.
+
+func init() {
+	sql.Register("pgx-traced", tracedDriver{stdlib.GetDefaultDriver()})
+}
+
+type tracedDriver struct {
+	driver.Driver
+}
+
+func (d tracedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{c}, nil
+}
+
+// tracedConn forwards the optional interfaces database/sql looks for, so
+// wrapping doesn't change how the pool or pgx behave.
+type tracedConn struct {
+	driver.Conn
+}
+
+// Unwrap gives the outbox worker the pgx connection for its pipelined writes.
+func (c *tracedConn) Unwrap() driver.Conn { return c.Conn }
+
+func startSQLSpan(ctx context.Context, op, query string) *span {
+	_, sp := startChildSpan(ctx, op, spanKindClient)
+	if sp != nil {
+		query = strings.Join(strings.Fields(query), " ")
+		if len(query) > maxSQLAttrLen {
+			query = query[:maxSQLAttrLen]
+		}
+		sp.setAttr("db.system", "postgresql")
+		sp.setAttr("db.statement", query)
+	}
+	return sp
+}
+
+// sqlSpanErr leaves out driver.ErrSkip, which only means database/sql takes another route.
+func sqlSpanErr(err error) error {
+	if err == driver.ErrSkip {
+		return nil
+	}
+	return err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	sp := startSQLSpan(ctx, "sql query", query)
+	rows, err := q.QueryContext(ctx, query, args)
+	sp.finish(sqlSpanErr(err))
+	return rows, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	sp := startSQLSpan(ctx, "sql exec", query)
+	res, err := e.ExecContext(ctx, query, args)
+	sp.finish(sqlSpanErr(err))
+	return res, err
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// pgxTracer gives queries on the native pgx pool (ingest.go) the same spans.
+type pgxTracer struct{}
+
+type pgxSpanKey struct{}
+
+func (pgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if sp := startSQLSpan(ctx, "sql query", data.SQL); sp != nil {
+		return context.WithValue(ctx, pgxSpanKey{}, sp)
+	}
+	return ctx
+}
+
+func (pgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if sp, ok := ctx.Value(pgxSpanKey{}).(*span); ok {
+		sp.finish(data.Err)
+	}
+}
+
+// pgxConn unwraps the driver connection handed to (*sql.Conn).Raw.
+func pgxConn(dc any) *stdlib.Conn {
+	if t, ok := dc.(*tracedConn); ok {
+		dc = t.Conn
+	}
+	return dc.(*stdlib.Conn)
+}
+
+// redisTraceHook records commands and pipelines made inside a traced operation.
+type redisTraceHook struct{}
+
+func (redisTraceHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (redisTraceHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		_, sp := startChildSpan(ctx, "redis "+cmd.Name(), spanKindClient)
+		sp.setAttr("db.system", "redis")
+		err := next(ctx, cmd)
+		sp.finish(redisSpanErr(err))
+		return err
+	}
+}
+
+func (redisTraceHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		_, sp := startChildSpan(ctx, "redis pipeline", spanKindClient)
+		sp.setAttr("db.system", "redis")
+		sp.setAttr("db.redis.commands", len(cmds))
+		err := next(ctx, cmds)
+		sp.finish(redisSpanErr(err))
+		return err
+	}
+}
+
+func redisSpanErr(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
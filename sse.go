@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+	"github.com/redis/go-redis/v9"
+)
+
+// topStreams backs GET /v1/seasons/{sid}/leaderboard/stream. Viewers of the
+// same (season, limit) share one feed that re-reads the top N when the season
+// is updated (at most once per interval, and every topFeedIdlePoll in case an
+// update message was lost) and fans the payload out only when it changed, so
+// Redis load follows the number of distinct boards being watched rather than
+// the number of viewers. A feed stops when its last viewer leaves.
+type topStreams struct {
+	ctx        context.Context // closes every stream on shutdown
+	reads      *redisReads
+	db         *sql.DB
+	collations *seasonCollations
+	updates    *seasonUpdates
+	tenants    *tenancy // payloads show a tenant's season by the id it uses
+	interval   time.Duration
+
+	mu    sync.Mutex
+	feeds map[topFeedKey]*topFeed
+}
+
+type topFeedKey struct {
+	seasonID string
+	limit    int
+}
+
+type topFeed struct {
+	subs  map[chan []byte]struct{}
+	last  []byte // latest payload, sent to viewers as they join
+	dirty atomic.Bool
+}
+
+const topFeedIdlePoll = 5 * time.Second
+
+func newTopStreams(ctx context.Context, reads *redisReads, db *sql.DB, collations *seasonCollations, updates *seasonUpdates, tenants *tenancy, interval time.Duration) *topStreams {
+	return &topStreams{
+		ctx:        ctx,
+		reads:      reads,
+		db:         db,
+		collations: collations,
+		updates:    updates,
+		tenants:    tenants,
+		interval:   interval,
+		feeds:      make(map[topFeedKey]*topFeed),
+	}
+}
+
+// subscribe returns a channel that receives each changed payload. Only the
+// newest payload is kept for a slow viewer; older ones are dropped.
+func (ts *topStreams) subscribe(seasonID string, limit int) (<-chan []byte, func()) {
+	key := topFeedKey{seasonID, limit}
+	ch := make(chan []byte, 1)
+
+	ts.mu.Lock()
+	f := ts.feeds[key]
+	if f == nil {
+		f = &topFeed{subs: make(map[chan []byte]struct{})}
+		ts.feeds[key] = f
+		go ts.run(key, f)
+	} else if f.last != nil {
+		ch <- f.last
+	}
+	f.subs[ch] = struct{}{}
+	ts.mu.Unlock()
+
+	return ch, func() {
+		ts.mu.Lock()
+		delete(f.subs, ch)
+		ts.mu.Unlock()
+	}
+}
+
+func (ts *topStreams) run(key topFeedKey, f *topFeed) {
+	stop := ts.updates.listen(key.seasonID, func() { f.dirty.Store(true) })
+	defer stop()
+	ticker := time.NewTicker(ts.interval)
+	defer ticker.Stop()
+
+	var lastRead time.Time
+	for {
+		var payload []byte
+		var err error
+		read := f.dirty.Swap(false) || time.Since(lastRead) >= topFeedIdlePoll
+		if read {
+			lastRead = time.Now()
+			if payload, err = ts.read(key); err != nil && !errors.Is(err, errRedisUnavailable) {
+				slog.Error("Top stream read error", "seasonId", key.seasonID, "err", err)
+			}
+		}
+
+		ts.mu.Lock()
+		if len(f.subs) == 0 {
+			delete(ts.feeds, key)
+			ts.mu.Unlock()
+			return
+		}
+		if read && err == nil && !bytes.Equal(payload, f.last) {
+			f.last = payload
+			for ch := range f.subs {
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- payload
+			}
+		}
+		ts.mu.Unlock()
+
+		select {
+		case <-ts.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ts *topStreams) read(key topFeedKey) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ts.ctx, 300*time.Millisecond)
+	defer cancel()
+
+	var zs []redis.Z
+	err := ts.reads.do(ctx, func(c redis.Cmdable) error {
+		var err error
+		zs, err = c.ZRevRangeWithScores(ctx, boardKey(key.seasonID), 0, int64(key.limit-1)).Result()
+		return err
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if tag, ok, err := ts.collations.lookup(ctx, ts.db, key.seasonID); err == nil && ok && !tieBreakFirst() {
+		if err := ts.reads.do(ctx, func(c redis.Cmdable) error {
+			return collatePage(ctx, c, boardKey(key.seasonID), zs, 0, tag)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]leaderboardItem, 0, len(zs))
+	for _, z := range zs {
+		uid, ok := z.Member.(string)
+		if !ok {
+			uid = fmt.Sprint(z.Member)
+		}
+		items = append(items, leaderboardItem{UserID: uid, Score: leaderboard.Points(z.Score)})
+	}
+	_, sid := ts.tenants.splitSeason(key.seasonID)
+	return json.Marshal(topResponse{SeasonID: sid, Items: items})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/outbox"
+	"github.com/lib/pq"
+)
+
+// Event stream: when a publisher is configured, the worker queues a
+// stream_events row for every applied score event and board drop in the same
+// transaction that marks the outbox rows done, so a row exists iff the change
+// reached the board. The publisher sends rows in id order, one instance at a
+// time, and only marks them published once the broker has acknowledged them:
+// delivery is at-least-once, and consumers should dedupe on id. Unpublished
+// rows are retried with backoff forever rather than skipped, to keep order.
+
+const (
+	streamBatchSize  = 500
+	streamMaxBackoff = time.Minute
+	streamDoneTTL    = 24 * time.Hour
+)
+
+// streamMessage is what goes out on the bus.
+type streamMessage struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"` // score_applied/season_deleted/season_archived
+	TenantID  string          `json:"tenantId,omitempty"`
+	SeasonID  string          `json:"seasonId"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// streamPublisher sends a batch to a broker and returns once every message is
+// acknowledged; a returned error means the whole batch is retried.
+type streamPublisher interface {
+	name() string
+	publish(ctx context.Context, msgs []streamMessage) error
+}
+
+type streamEvent struct {
+	eventType string
+	seasonID  string
+	data      any
+}
+
+func queueStreamEvents(b *outbox.Batch, evs []streamEvent) {
+	if len(evs) == 0 {
+		return
+	}
+	types := make([]string, len(evs))
+	sids := make([]string, len(evs))
+	payloads := make([]string, len(evs))
+	for i, e := range evs {
+		body, _ := json.Marshal(e.data)
+		types[i], sids[i], payloads[i] = e.eventType, e.seasonID, string(body)
+	}
+	b.Queue("stream queue", `
+	INSERT INTO stream_events (event_type, season_id, payload)
+	SELECT t, s, p::jsonb FROM unnest($1::text[], $2::text[], $3::text[]) AS v(t, s, p)
+`, types, sids, payloads)
+}
+
+func runStreamPublisher(ctx context.Context, db *sql.DB, pub streamPublisher, tenants *tenancy) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	lastCleanup := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := publishStream(ctx, db, pub, tenants); err != nil {
+				slog.Error("Stream publish error", "publisher", pub.name(), "err", err)
+			}
+			if time.Since(lastCleanup) >= time.Minute {
+				lastCleanup = time.Now()
+				c, cancel := context.WithTimeout(ctx, 10*time.Second)
+				_, err := db.ExecContext(c, `
+	DELETE FROM stream_events
+	WHERE status='published' AND published_at < now() - make_interval(secs => $1)
+`, streamDoneTTL.Seconds())
+				cancel()
+				if err != nil {
+					slog.Error("Stream cleanup error", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// publishStream drains due rows batch by batch while holding lb_stream, so
+// only one instance publishes and order is kept.
+func publishStream(ctx context.Context, db *sql.DB, pub streamPublisher, tenants *tenancy) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_lock(hashtext('lb_stream'))`).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext('lb_stream'))`)
+
+	for ctx.Err() == nil {
+		n, err := publishStreamBatch(ctx, conn, pub, tenants)
+		if err != nil || n < streamBatchSize {
+			return err
+		}
+	}
+	return nil
+}
+
+func publishStreamBatch(ctx context.Context, conn *sql.Conn, pub streamPublisher, tenants *tenancy) (int, error) {
+	c, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// The oldest unpublished row gates the rest: nothing is sent past a row
+	// that is still backing off.
+	rows, err := conn.QueryContext(c, `
+	SELECT id, event_type, season_id, payload, created_at
+	FROM stream_events
+	WHERE status='pending'
+	  AND (SELECT next_attempt_at FROM stream_events WHERE status='pending' ORDER BY id LIMIT 1) <= now()
+	ORDER BY id
+	LIMIT $1
+`, streamBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var msgs []streamMessage
+	ids := make([]int64, 0, streamBatchSize)
+	for rows.Next() {
+		var m streamMessage
+		var payload []byte
+		if err := rows.Scan(&m.ID, &m.Type, &m.SeasonID, &payload, &m.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		m.TenantID, m.SeasonID = tenants.splitSeason(m.SeasonID)
+		m.Data = payload
+		msgs = append(msgs, m)
+		ids = append(ids, m.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	if pubErr := pub.publish(c, msgs); pubErr != nil {
+		// 2^attempts seconds, capped, on the head row only (it gates the rest)
+		_, err := conn.ExecContext(c, `
+	UPDATE stream_events
+	SET attempts=attempts+1, last_error=$2,
+	    next_attempt_at=now() + LEAST(make_interval(secs => power(2, attempts+1)), make_interval(secs => $3))
+	WHERE id=$1
+`, ids[0], pubErr.Error(), streamMaxBackoff.Seconds())
+		if err != nil {
+			return 0, err
+		}
+		return 0, pubErr
+	}
+
+	_, err = conn.ExecContext(c, `
+	UPDATE stream_events
+	SET status='published', published_at=now(), last_error=NULL
+	WHERE id = ANY($1)
+`, pq.Array(ids))
+	return len(msgs), err
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stub mode (--stub) serves the read API from generated in-memory boards so
+// frontend work doesn't need Redis or Postgres. Every season gets its own board
+// derived from the seed and the season id, so the same request always returns
+// the same payload.
+
+type stubOptions struct {
+	enabled bool
+	size    int
+	seed    int64
+}
+
+type stubBoards struct {
+	size  int
+	seed  int64
+	stamp boardStamp // boards never change: version 1 since startup
+
+	mu     sync.Mutex
+	boards map[string]*stubBoard
+}
+
+type stubBoard struct {
+	items []leaderboardItem // descending, ties ordered like ZREVRANGE (member descending)
+	index map[string]int64  // userId -> 0-based rank
+}
+
+func (sb *stubBoards) get(seasonID string) *stubBoard {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if b, ok := sb.boards[seasonID]; ok {
+		return b
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(seasonID))
+	rng := rand.New(rand.NewPCG(uint64(sb.seed), h.Sum64()))
+
+	items := make([]leaderboardItem, sb.size)
+	for i := range items {
+		items[i] = leaderboardItem{
+			UserID: fmt.Sprintf("user%d", i+1),
+			Score:  float64(rng.IntN(100000)),
+		}
+	}
+	sort.Slice(items, func(a, b int) bool {
+		if items[a].Score != items[b].Score {
+			return items[a].Score > items[b].Score
+		}
+		return items[a].UserID > items[b].UserID
+	})
+
+	b := &stubBoard{items: items, index: make(map[string]int64, len(items))}
+	for i, it := range items {
+		b.index[it.UserID] = int64(i)
+	}
+	sb.boards[seasonID] = b
+	return b
+}
+
+func newStubMux(size int, seed int64) *http.ServeMux {
+	sb := &stubBoards{
+		size:   size,
+		seed:   seed,
+		stamp:  boardStamp{version: 1, modified: time.Now().UTC().Truncate(time.Second)},
+		boards: make(map[string]*stubBoard),
+	}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"service": "leaderboard-go",
+			"stub":    true,
+			"versions": []map[string]any{
+				{"version": "v1", "path": "/v1", "status": "stable"},
+			},
+		})
+	})
+
+	mux.HandleFunc("GET /favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":   "ready",
+			"redis":    "stub",
+			"postgres": "stub",
+			"schema":   "stub",
+		})
+	})
+
+	mux.HandleFunc("GET /openapi.json", serveOpenAPI)
+	mux.HandleFunc("GET /metrics", metricsHandler(nil, nil))
+
+	mux.HandleFunc("GET /v1/capabilities", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, capabilitiesResponse{
+			Versions: []string{"v1"},
+			Features: map[string]bool{"stub": true},
+		})
+	})
+
+	// Writes are accepted so clients can exercise the flow, but boards never change.
+	mux.HandleFunc("POST /v1/seasons/{sid}/scores", func(w http.ResponseWriter, r *http.Request) {
+		var req scoreUpdateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.UserID == "" {
+			writeProblem(w, http.StatusBadRequest, "invalid_json", "invalid json body")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"seasonId": r.PathValue("sid"),
+			"userId":   req.UserID,
+			"queued":   true,
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/top", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		limit := 10
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var parsed int
+			if _, err := fmt.Sscanf(v, "%d", &parsed); err != nil || parsed <= 0 || parsed > 1000 {
+				writeProblem(w, http.StatusBadRequest, "invalid_limit", "limit must be 1..1000")
+				return
+			}
+			limit = parsed
+		}
+
+		format := negotiateFormat(r, true)
+		if notModified(w, r, sb.stamp, format, "no-cache") {
+			return
+		}
+		b := sb.get(seasonID)
+		limit = min(limit, len(b.items))
+		setValidators(w, sb.stamp, format, "no-cache")
+		writeRead(w, r, http.StatusOK, topResponse{
+			SeasonID: seasonID,
+			Items:    append([]leaderboardItem{}, b.items[:limit]...),
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/rank", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, http.StatusBadRequest, "missing_user_id", "userId is required")
+			return
+		}
+
+		b := sb.get(seasonID)
+		rank0, ok := b.index[userID]
+		if !ok {
+			writeProblem(w, http.StatusNotFound, "user_not_found", "user not found in leaderboard")
+			return
+		}
+		format := negotiateFormat(r, true)
+		if notModified(w, r, sb.stamp, format, "no-cache") {
+			return
+		}
+		setValidators(w, sb.stamp, format, "no-cache")
+		writeRead(w, r, http.StatusOK, rankResponse{
+			SeasonID: seasonID,
+			UserID:   userID,
+			Rank:     rank0 + 1,
+			Score:    b.items[rank0].Score,
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/around", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, http.StatusBadRequest, "missing_user_id", "userId is required")
+			return
+		}
+		rng := int64(5)
+		if v := r.URL.Query().Get("range"); v != "" {
+			var parsed int64
+			if _, err := fmt.Sscanf(v, "%d", &parsed); err != nil || parsed < 0 || parsed > 100 {
+				writeProblem(w, http.StatusBadRequest, "invalid_range", "range must be 0..100")
+				return
+			}
+			rng = parsed
+		}
+
+		b := sb.get(seasonID)
+		myRank0, ok := b.index[userID]
+		if !ok {
+			writeProblem(w, http.StatusNotFound, "user_not_found", "user not found in leaderboard")
+			return
+		}
+		start := max(myRank0-rng, 0)
+		end := min(myRank0+rng, int64(len(b.items)-1))
+
+		items := make([]aroundItem, 0, end-start+1)
+		for i := start; i <= end; i++ {
+			items = append(items, aroundItem{
+				Rank:   i + 1,
+				UserID: b.items[i].UserID,
+				Score:  b.items[i].Score,
+			})
+		}
+		writeRead(w, r, http.StatusOK, aroundResponse{
+			SeasonID: seasonID,
+			UserID:   userID,
+			Range:    rng,
+			Items:    items,
+		})
+	})
+
+	mux.HandleFunc("GET /v1/seasons/{sid}/leaderboard/percentiles", func(w http.ResponseWriter, r *http.Request) {
+		seasonID := r.PathValue("sid")
+		buckets := 10
+		if v := r.URL.Query().Get("buckets"); v != "" {
+			var parsed int
+			if _, err := fmt.Sscanf(v, "%d", &parsed); err != nil || parsed <= 0 || parsed > 100 {
+				writeProblem(w, http.StatusBadRequest, "invalid_buckets", "buckets must be 1..100")
+				return
+			}
+			buckets = parsed
+		}
+
+		b := sb.get(seasonID)
+		total := int64(len(b.items))
+		resp := percentilesResponse{
+			SeasonID:   seasonID,
+			Buckets:    buckets,
+			Total:      total,
+			ComputedAt: time.Now().UTC(),
+			Items:      []percentileBucket{},
+		}
+		for i := 1; i <= buckets && total > 0; i++ {
+			cutoff := (total*int64(i) + int64(buckets) - 1) / int64(buckets)
+			resp.Items = append(resp.Items, percentileBucket{
+				Bucket:     i,
+				Percentile: 100 * float64(i) / float64(buckets),
+				CutoffRank: cutoff,
+				MinScore:   b.items[cutoff-1].Score,
+			})
+		}
+		writeRead(w, r, http.StatusOK, resp)
+	})
+
+	return mux
+}
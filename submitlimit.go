@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Score submissions are capped per user and season per minute, whatever the
+// client's transport limits: a first anti-spam line in the write path. The
+// cap is seasons.submit_limit_per_minute, or SCORE_SUBMIT_LIMIT_PER_MINUTE
+// when that is NULL; 0 means no cap. Attempts are counted in fixed
+// one-minute windows in Redis, shared by all API instances, and the check
+// fails open when Redis is unavailable, like the kill switch.
+
+func submitCountKey(seasonID, userID string, window int64) string {
+	return fmt.Sprintf("lbctl:submits:%s:%s:%d", seasonID, userID, window)
+}
+
+// takeSubmitSlot counts one attempt and reports whether it is within limit,
+// and if not, how many seconds remain in the window.
+func takeSubmitSlot(ctx context.Context, rdb redis.UniversalClient, seasonID, userID string, limit int64, now time.Time) (bool, int, error) {
+	window := now.Unix() / 60
+	key := submitCountKey(seasonID, userID, window)
+
+	pipe := rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 2*time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true, 0, err
+	}
+	if incr.Val() <= limit {
+		return true, 0, nil
+	}
+	return false, int((window+1)*60 - now.Unix()), nil
+}
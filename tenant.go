@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// One deployment can serve several games (tenants), listed in TENANTS
+// (comma-separated ids; unset = no tenancy). A request names its tenant with
+// a /t/{tenant} path prefix or an X-Tenant-ID header; without either it is in
+// the default tenant, which is the data from before tenancy and the operator's
+// own. An API key or token bound to a tenant (api_keys.tenant_id,
+// JWT_TENANT_CLAIM) is always in that tenant and gets 403 tenant_mismatch for
+// another one.
+//
+// A tenant's season s1 is stored as season "{tenant}:s1": Redis keys become
+// lb:{tenant}:s1, and since every query already filters by season, tenant
+// data is isolated everywhere without each query knowing about tenants.
+// score_events and outbox carry a tenant_id generated from that season id.
+// Season ids may not contain ':' while tenancy is on, and routes that span
+// seasons (outbox, webhooks, reconcile and retention reports, API keys, ...)
+// are for the default tenant only. Responses show a tenant's seasons by the
+// ids it uses (unqualifySeason); webhook and stream events carry those and
+// the tenant (splitSeason). The default tenant sees stored ids as they are.
+
+var tenantIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+type tenancy struct {
+	tenants map[string]bool
+}
+
+// newTenancy reads TENANTS; it returns nil when tenancy is off.
+func newTenancy() *tenancy {
+	v := strings.TrimSpace(getenv("TENANTS"))
+	if v == "" {
+		return nil
+	}
+	t := &tenancy{tenants: make(map[string]bool)}
+	for _, id := range strings.Split(v, ",") {
+		id = strings.TrimSpace(id)
+		if !tenantIDRe.MatchString(id) {
+			panic(fmt.Sprintf("invalid TENANTS entry %q", id))
+		}
+		t.tenants[id] = true
+	}
+	return t
+}
+
+func (t *tenancy) known(id string) bool { return t != nil && t.tenants[id] }
+
+// tenantSeason is the stored season id of tenant's season sid.
+func tenantSeason(tenant, sid string) string {
+	if tenant == "" {
+		return sid
+	}
+	return tenant + ":" + sid
+}
+
+type tenantKey struct{}
+
+// requestTenant returns the request's tenant ("" for the default tenant) and
+// whether tenancy is on.
+func requestTenant(ctx context.Context) (string, bool) {
+	t, ok := ctx.Value(tenantKey{}).(string)
+	return t, ok
+}
+
+// qualifySeason maps a season id from the request (path, query or body) to
+// the stored one; false means the id is not valid while tenancy is on.
+func qualifySeason(ctx context.Context, sid string) (string, bool) {
+	tenant, on := requestTenant(ctx)
+	if !on {
+		return sid, true
+	}
+	if strings.Contains(sid, ":") {
+		return "", false
+	}
+	return tenantSeason(tenant, sid), true
+}
+
+// unqualifySeason is the reverse of qualifySeason: the season id the
+// request's tenant knows a stored season id by.
+func unqualifySeason(ctx context.Context, stored string) string {
+	if tenant, _ := requestTenant(ctx); tenant != "" {
+		return strings.TrimPrefix(stored, tenant+":")
+	}
+	return stored
+}
+
+// splitSeason splits a stored season id into its tenant ("" for the default
+// tenant) and the id that tenant uses, for output that isn't an answer to a
+// request (webhooks, event streams). With tenancy off (t nil) ids are whole.
+func (t *tenancy) splitSeason(stored string) (tenant, sid string) {
+	if prefix, rest, ok := strings.Cut(stored, ":"); ok && t.known(prefix) {
+		return prefix, rest
+	}
+	return "", stored
+}
+
+type requestedTenantKey struct{}
+
+// stripPrefix takes a /t/{tenant} prefix off the path, so routing,
+// authentication exemptions and the admin allowlist see the plain route.
+func (t *tenancy) stripPrefix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.EscapedPath(), "/t/")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, path, _ := strings.Cut(rest, "/")
+		r = withPath(r, "/"+path)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestedTenantKey{}, id)))
+	})
+}
+
+// middleware settles the request's tenant and rewrites the {sid} path segment
+// to the stored season id. It runs after authentication, for the binding of
+// keys to tenants. With tenancy off (t nil) it only turns away keys bound to
+// a tenant, which would otherwise land in the default one.
+func (t *tenancy) middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t == nil {
+			if p := requestPrincipal(r.Context()); p != nil && p.tenant != "" {
+				writeProblem(w, http.StatusNotFound, "unknown_tenant", "unknown tenant")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant, _ := r.Context().Value(requestedTenantKey{}).(string)
+		if tenant == "" {
+			tenant = r.Header.Get("X-Tenant-ID")
+		}
+		if p := requestPrincipal(r.Context()); p != nil && p.tenant != "" {
+			if tenant != "" && tenant != p.tenant {
+				writeProblem(w, http.StatusForbidden, "tenant_mismatch", "credentials belong to another tenant")
+				return
+			}
+			tenant = p.tenant
+		}
+		if tenant != "" && !t.known(tenant) {
+			writeProblem(w, http.StatusNotFound, "unknown_tenant", "unknown tenant")
+			return
+		}
+
+		_, pattern := mux.Handler(r)
+		if tenant != "" && pattern != "" && requiredScope(pattern) == scopeAdmin && !strings.Contains(pattern, "/seasons/{sid}") {
+			writeProblem(w, http.StatusForbidden, "tenant_forbidden", "this route is for the default tenant only")
+			return
+		}
+		ctx := context.WithValue(r.Context(), tenantKey{}, tenant)
+
+		escaped := r.URL.EscapedPath()
+		for _, prefix := range []string{"/v1/seasons/", "/v1/admin/seasons/"} {
+			rest, ok := strings.CutPrefix(escaped, prefix)
+			if !ok {
+				continue
+			}
+			seg, tail, slash := strings.Cut(rest, "/")
+			sid, err := url.PathUnescape(seg)
+			if err != nil || strings.Contains(sid, ":") {
+				writeProblem(w, http.StatusBadRequest, "invalid_season_id", "season id must not contain ':'")
+				return
+			}
+			if tenant != "" {
+				path := prefix + url.PathEscape(tenantSeason(tenant, sid))
+				if slash {
+					path += "/" + tail
+				}
+				r = withPath(r, path)
+			}
+			break
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withPath returns a shallow copy of r with the escaped path replaced.
+func withPath(r *http.Request, escaped string) *http.Request {
+	u := *r.URL
+	u.RawPath = escaped
+	if p, err := url.PathUnescape(escaped); err == nil {
+		u.Path = p
+	}
+	r2 := r.WithContext(r.Context())
+	r2.URL = &u
+	r2.RequestURI = u.RequestURI()
+	return r2
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+)
+
+// TIE_BREAK=first ranks users with equal points by who reached them first,
+// instead of by user ID: board scores carry a tie fraction from the time of
+// the user's latest ledger event (leaderboard/tiebreak.go). Everything that
+// writes a board goes through boardScore or a leaderboard.RedisStore with
+// TieBreak set, and everything that returns a score reads it with
+// leaderboard.Points. Boards written under the other setting keep their old
+// order until rebuilt, so switching takes a `rebuild -all`.
+var tieBreakFirst = sync.OnceValue(func() bool {
+	switch v := getenv("TIE_BREAK"); v {
+	case "", "none":
+		return false
+	case "first":
+		return true
+	default:
+		panic("invalid TIE_BREAK " + v + " (none or first)")
+	}
+})
+
+// boardScore is the board score of points last changed at t.
+func boardScore(points int64, t time.Time) float64 {
+	if tieBreakFirst() {
+		return leaderboard.TieScore(points, t)
+	}
+	return float64(points)
+}
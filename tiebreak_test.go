@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/leaderboard"
+)
+
+func TestRedisStoreTieBreak(t *testing.T) {
+	_, rdb := testStores(t)
+	ctx := context.Background()
+	early := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+
+	for _, window := range []time.Duration{0, time.Minute} {
+		sid := fmt.Sprintf("tie-test-%d", time.Now().UnixNano())
+		t.Cleanup(func() { rdb.Del(ctx, boardKey(sid), appliedKey(sid)) })
+		store := leaderboard.NewRedisStore(rdb, leaderboard.RedisOptions{DedupWindow: window, TieBreak: true})
+
+		// bob applies first but reached 10 later than carol
+		for i, op := range []struct {
+			user string
+			at   time.Time
+		}{{"bob", late}, {"carol", early}} {
+			score, err := store.Apply(ctx, sid, op.user, []int64{int64(i + 1)}, []int64{10}, op.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score != 10 {
+				t.Fatalf("window %v: %s's score = %v, want 10 points", window, op.user, score)
+			}
+		}
+
+		top, err := store.Top(ctx, sid, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(top) != 2 || top[0].UserID != "carol" || top[1].UserID != "bob" || top[0].Score != 10 {
+			t.Fatalf("window %v: top = %v, want carol then bob on 10", window, top)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// topCache keeps recent top-N pages per instance for ttl, so a hot board read
+// by many viewers costs one Redis read per ttl instead of one per request.
+// Entries are dropped when the season is updated (see seasonUpdates), and the
+// least recently used page goes first once size is reached.
+type topCache struct {
+	ttl  tunableDuration // TOP_CACHE_TTL, reloadable
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // of *topCacheEntry, most recent first
+	entries map[topCacheKey]*list.Element
+	gens    map[string]uint64 // per season, bumped on invalidate
+}
+
+type topCacheKey struct {
+	seasonID string
+	limit    int
+}
+
+type topCacheEntry struct {
+	key     topCacheKey
+	items   []leaderboardItem // shared; never modified after put
+	stamp   boardStamp
+	fetched time.Time
+}
+
+// newTopCache returns a cache of up to size pages; ttl <= 0 disables it.
+func newTopCache(ttl time.Duration, size int) *topCache {
+	tc := &topCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[topCacheKey]*list.Element),
+		gens:    make(map[string]uint64),
+	}
+	tc.ttl.set(ttl)
+	return tc
+}
+
+func (tc *topCache) get(seasonID string, limit int) ([]leaderboardItem, boardStamp, bool) {
+	if tc.ttl.get() <= 0 {
+		return nil, boardStamp{}, false
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	el, ok := tc.entries[topCacheKey{seasonID, limit}]
+	if !ok {
+		return nil, boardStamp{}, false
+	}
+	e := el.Value.(*topCacheEntry)
+	if time.Since(e.fetched) >= tc.ttl.get() {
+		tc.lru.Remove(el)
+		delete(tc.entries, e.key)
+		return nil, boardStamp{}, false
+	}
+	tc.lru.MoveToFront(el)
+	return e.items, e.stamp, true
+}
+
+// generation is read before the Redis read and passed to put, so a page read
+// before an invalidation isn't stored after it.
+func (tc *topCache) generation(seasonID string) uint64 {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.gens[seasonID]
+}
+
+func (tc *topCache) put(seasonID string, limit int, gen uint64, items []leaderboardItem, stamp boardStamp) {
+	if tc.ttl.get() <= 0 {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.gens[seasonID] != gen {
+		return
+	}
+	key := topCacheKey{seasonID, limit}
+	e := &topCacheEntry{key: key, items: items, stamp: stamp, fetched: time.Now()}
+	if el, ok := tc.entries[key]; ok {
+		el.Value = e
+		tc.lru.MoveToFront(el)
+		return
+	}
+	tc.entries[key] = tc.lru.PushFront(e)
+	for tc.lru.Len() > tc.size {
+		oldest := tc.lru.Back()
+		tc.lru.Remove(oldest)
+		delete(tc.entries, oldest.Value.(*topCacheEntry).key)
+	}
+}
+
+// invalidate drops every cached page for the season.
+func (tc *topCache) invalidate(seasonID string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.gens[seasonID]++
+	for key, el := range tc.entries {
+		if key.seasonID == seasonID {
+			tc.lru.Remove(el)
+			delete(tc.entries, key)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing follows OpenTelemetry without its SDK: W3C traceparent headers are
+// continued (or a trace started) for every request, spans are recorded for
+// the handler, the SQL and Redis calls it makes and the outbox worker, and
+// finished spans are sent in batches to an OTLP/HTTP collector as JSON. A
+// score's traceparent rides in its outbox payload, so the worker adds the
+// queue wait and the apply to the trace of the request that submitted it.
+//
+// It is off unless OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set. New traces are sampled at
+// OTEL_TRACES_SAMPLER_ARG (0..1, default 1); continued traces follow the
+// caller's sampled flag.
+
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+	spanKindConsumer = 5
+
+	maxQueuedSpans  = 4096
+	spanExportBatch = 512
+	maxSQLAttrLen   = 1024
+)
+
+// tracer is nil when tracing is off; every helper below is then a no-op.
+var tracer *spanExporter
+
+// traceContext identifies a span as a W3C traceparent does.
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type traceKey struct{}
+
+// parseTraceparent reads a version-00 header (and the 00 fields of later
+// versions, as the spec asks). All-zero ids are invalid.
+func parseTraceparent(s string) (traceContext, bool) {
+	var tc traceContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, false
+	}
+	if s[:2] == "ff" || (s[:2] == "00" && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return tc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(tc.traceID[:], []byte(s[3:35])); err != nil {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.spanID[:], []byte(s[36:52])); err != nil {
+		return tc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return tc, false
+	}
+	if tc.traceID == [16]byte{} || tc.spanID == [8]byte{} {
+		return tc, false
+	}
+	tc.sampled = flags[0]&1 == 1
+	return tc, true
+}
+
+func (tc traceContext) traceparent() string {
+	flags := "00"
+	if tc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(tc.traceID[:]) + "-" + hex.EncodeToString(tc.spanID[:]) + "-" + flags
+}
+
+// withTraceparent makes a remote span (from a header or an outbox payload) the parent of spans started from ctx.
+func withTraceparent(ctx context.Context, traceparent string) context.Context {
+	if tc, ok := parseTraceparent(traceparent); ok {
+		return context.WithValue(ctx, traceKey{}, tc)
+	}
+	return ctx
+}
+
+// traceparent returns the header value for the current span, or "" when it isn't recorded.
+func traceparent(ctx context.Context) string {
+	if tc, ok := ctx.Value(traceKey{}).(traceContext); ok && tc.sampled {
+		return tc.traceparent()
+	}
+	return ""
+}
+
+type spanAttr struct {
+	key   string
+	value any
+}
+
+// span is one recorded operation. A nil *span (tracing off, not sampled) is
+// valid and ignores everything.
+type span struct {
+	tc       traceContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	links    []traceContext
+	errMsg   string
+}
+
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	return startSpanAt(ctx, name, kind, time.Now())
+}
+
+// startSpanAt starts a child of the span in ctx, or a new sampled-or-not
+// trace when there is none.
+func startSpanAt(ctx context.Context, name string, kind int, at time.Time) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	sp := &span{name: name, kind: kind, start: at}
+	if parent, ok := ctx.Value(traceKey{}).(traceContext); ok {
+		if !parent.sampled {
+			return ctx, nil
+		}
+		sp.tc.traceID = parent.traceID
+		sp.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(sp.tc.traceID[:])
+		if !tracer.sample(sp.tc.traceID) {
+			return context.WithValue(ctx, traceKey{}, sp.tc), nil
+		}
+	}
+	_, _ = rand.Read(sp.tc.spanID[:])
+	sp.tc.sampled = true
+	return context.WithValue(ctx, traceKey{}, sp.tc), sp
+}
+
+// startChildSpan is for calls (SQL, Redis) that are only worth a span inside
+// a traced operation; without a parent it records nothing.
+func startChildSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	if _, ok := ctx.Value(traceKey{}).(traceContext); !ok {
+		return ctx, nil
+	}
+	return startSpan(ctx, name, kind)
+}
+
+func (sp *span) setAttr(key string, value any) {
+	if sp != nil {
+		sp.attrs = append(sp.attrs, spanAttr{key, value})
+	}
+}
+
+func (sp *span) addLink(traceparent string) {
+	if sp == nil {
+		return
+	}
+	if tc, ok := parseTraceparent(traceparent); ok {
+		sp.links = append(sp.links, tc)
+	}
+}
+
+func (sp *span) setError(msg string) {
+	if sp != nil {
+		sp.errMsg = msg
+	}
+}
+
+// finish ends the span now, marking it failed if err is non-nil, and queues it for export.
+func (sp *span) finish(err error) {
+	sp.finishAt(time.Now(), err)
+}
+
+func (sp *span) finishAt(at time.Time, err error) {
+	if sp == nil {
+		return
+	}
+	if err != nil {
+		sp.errMsg = err.Error()
+	}
+	sp.end = at
+	tracer.enqueue(sp)
+}
+
+// spanExporter buffers finished spans and posts them to the collector every
+// few seconds. When the collector can't keep up, spans are dropped rather
+// than held: tracing must not grow the process without bound.
+type spanExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	ratio    float64
+	client   *http.Client
+
+	mu      sync.Mutex
+	queue   []*span
+	dropped int
+}
+
+// setupTracing reads the OTEL_* settings; it leaves tracer nil when no endpoint is set.
+func setupTracing() {
+	endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	service := getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "leaderboard-go"
+	}
+	ratio := envFloat64("OTEL_TRACES_SAMPLER_ARG", 1)
+	if ratio < 0 || ratio > 1 {
+		panic("invalid OTEL_TRACES_SAMPLER_ARG (0..1)")
+	}
+	headers := map[string]string{}
+	for _, kv := range strings.Split(getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	tracer = &spanExporter{
+		endpoint: endpoint,
+		headers:  headers,
+		service:  service,
+		ratio:    ratio,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	slog.Info("Tracing enabled", "endpoint", endpoint, "service", service, "ratio", ratio)
+}
+
+// sample decides on the low 8 bytes of the trace id, as OTel's ratio sampler does.
+func (e *spanExporter) sample(traceID [16]byte) bool {
+	if e.ratio >= 1 {
+		return true
+	}
+	return binary.BigEndian.Uint64(traceID[8:]) < uint64(e.ratio*math.MaxUint64)
+}
+
+func (e *spanExporter) enqueue(sp *span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, sp)
+}
+
+// run exports every 5s until ctx is cancelled, then sends what is left.
+func (e *spanExporter) run(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			e.flush(c)
+			cancel()
+			return nil
+		case <-ticker.C:
+			e.flush(ctx)
+		}
+	}
+}
+
+func (e *spanExporter) flush(ctx context.Context) {
+	e.mu.Lock()
+	spans, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("Trace spans dropped, export queue full", "dropped", dropped)
+	}
+	for len(spans) > 0 {
+		n := min(len(spans), spanExportBatch)
+		if err := e.export(ctx, spans[:n]); err != nil {
+			slog.Error("Trace export error", "err", err, "spans", len(spans))
+			return
+		}
+		spans = spans[n:]
+	}
+}
+
+// OTLP/HTTP JSON (opentelemetry-proto, JSON mapping): ids are hex, 64-bit
+// integers are decimal strings.
+type otlpAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 = error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes,omitempty"`
+	Links             []otlpLink  `json:"links,omitempty"`
+	Status            *otlpStatus `json:"status,omitempty"`
+}
+
+func otlpValue(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+}
+
+func (e *spanExporter) export(ctx context.Context, spans []*span) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, sp := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(sp.tc.traceID[:]),
+			SpanID:            hex.EncodeToString(sp.tc.spanID[:]),
+			Name:              sp.name,
+			Kind:              sp.kind,
+			StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sp.end.UnixNano(), 10),
+		}
+		if sp.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+		}
+		for _, a := range sp.attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: a.key, Value: otlpValue(a.value)})
+		}
+		for _, l := range sp.links {
+			o.Links = append(o.Links, otlpLink{TraceID: hex.EncodeToString(l.traceID[:]), SpanID: hex.EncodeToString(l.spanID[:])})
+		}
+		if sp.errMsg != "" {
+			o.Status = &otlpStatus{Code: 2, Message: sp.errMsg}
+		}
+		out = append(out, o)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttr{{Key: "service.name", Value: otlpValue(e.service)}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "leaderboard-go"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// traceOutboxRow adds a settled outbox row to the trace of the request that
+// queued it: the wait from insert to claim, then the apply up to the batch
+// commit, linked to the batch span.
+func traceOutboxRow(traceparent, eventType string, id int64, queued, claimed, committed time.Time, ok bool, batch *span) {
+	if tracer == nil || traceparent == "" {
+		return
+	}
+	ctx := withTraceparent(context.Background(), traceparent)
+	_, wait := startSpanAt(ctx, "outbox queued", spanKindInternal, queued)
+	wait.setAttr("outbox.id", id)
+	wait.finishAt(claimed, nil)
+
+	_, apply := startSpanAt(ctx, "outbox apply "+eventType, spanKindConsumer, claimed)
+	apply.setAttr("outbox.id", id)
+	if apply != nil && batch != nil {
+		apply.links = append(apply.links, batch.tc)
+	}
+	if !ok {
+		apply.setError("apply failed")
+	}
+	apply.finishAt(committed, nil)
+}
+
+// traceHandler records a server span per request, continuing the caller's
+// traceparent. The route comes from recordRoute.
+func traceHandler(next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withTraceparent(r.Context(), r.Header.Get("traceparent"))
+		ctx, sp := startSpan(ctx, r.Method, spanKindServer)
+		r = r.WithContext(ctx)
+		if sp == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r, ri := withRouteInfo(r)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		status := sw.status
+		switch {
+		case status == 0 && r.Header.Get("Upgrade") != "":
+			status = http.StatusSwitchingProtocols
+		case status == 0:
+			status = http.StatusOK
+		}
+		if _, route, ok := strings.Cut(ri.pattern, " "); ok {
+			sp.name = r.Method + " " + route
+			sp.setAttr("http.route", route)
+		}
+		sp.setAttr("http.request.method", r.Method)
+		sp.setAttr("url.path", r.URL.Path)
+		sp.setAttr("http.response.status_code", status)
+		if id := requestID(ctx); id != "" {
+			sp.setAttr("request_id", id)
+		}
+		if ri.seasonID != "" {
+			sp.setAttr("seasonId", ri.seasonID)
+		}
+		if status >= 500 {
+			sp.setError(http.StatusText(status))
+		}
+		sp.finish(nil)
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// boardWarmer rebuilds boards whose ZSET is gone (Redis flush, failover to an
+// empty replica) while the ledger still has rows. Reads that hit a missing
+// board get a 503 with Retry-After instead of a silently empty leaderboard.
+
+const (
+	warmRebuildTimeout = 2 * time.Minute
+	warmMissCooldown   = 30 * time.Second // don't re-check a season more often than this
+)
+
+type boardWarmer struct {
+	db             *sql.DB
+	rdb            redis.UniversalClient
+	defaultMaxSize int64
+	lazy           bool // rebuild on read misses
+
+	mu       sync.Mutex
+	inflight map[string]struct{}
+	checked  map[string]time.Time
+}
+
+func newBoardWarmer(db *sql.DB, rdb redis.UniversalClient, defaultMaxSize int64, lazy bool) *boardWarmer {
+	return &boardWarmer{
+		db:             db,
+		rdb:            rdb,
+		defaultMaxSize: defaultMaxSize,
+		lazy:           lazy,
+		inflight:       make(map[string]struct{}),
+		checked:        make(map[string]time.Time),
+	}
+}
+
+// needsRebuild reports whether the season has ledger rows that should be on a
+// board. Archived seasons and seasons being deleted are expected to be empty;
+// a delete job that gave up (failed) no longer counts, so the rows it left
+// come back on the board instead of the season being skipped for good.
+func needsRebuild(ctx context.Context, db *sql.DB, seasonID string) (bool, error) {
+	var ok bool
+	err := db.QueryRowContext(ctx, `
+	SELECT EXISTS (SELECT 1 FROM score_events WHERE season_id=$1)
+	  AND NOT EXISTS (SELECT 1 FROM seasons WHERE season_id=$1 AND status='archived')
+	  AND NOT EXISTS (
+	    SELECT 1 FROM season_delete_jobs
+	    WHERE season_id=$1 AND status IN ('pending', 'running')
+	  )
+`, seasonID).Scan(&ok)
+	return ok, err
+}
+
+// onMiss is called by read paths that found no board. It returns true while a
+// rebuild for the season is running (starting one if needed). Errors count as
+// "not rebuilding" so reads fail open to the empty board.
+func (bw *boardWarmer) onMiss(ctx context.Context, seasonID string) bool {
+	if !bw.lazy {
+		return false
+	}
+	busy, err := bw.checkMiss(ctx, seasonID)
+	if err != nil {
+		slog.Error("Warm miss check error", "seasonId", seasonID, "err", err)
+	}
+	return busy
+}
+
+// onUserMiss is onMiss for reads that only know a member is absent.
+func (bw *boardWarmer) onUserMiss(ctx context.Context, seasonID string) bool {
+	if !bw.lazy {
+		return false
+	}
+	n, err := bw.rdb.Exists(ctx, boardKey(seasonID)).Result()
+	if err != nil || n > 0 {
+		return false
+	}
+	return bw.onMiss(ctx, seasonID)
+}
+
+func (bw *boardWarmer) checkMiss(ctx context.Context, seasonID string) (bool, error) {
+	bw.mu.Lock()
+	if _, ok := bw.inflight[seasonID]; ok {
+		bw.mu.Unlock()
+		return true, nil
+	}
+	if t, ok := bw.checked[seasonID]; ok && time.Since(t) < warmMissCooldown {
+		bw.mu.Unlock()
+		return false, nil
+	}
+	bw.mu.Unlock()
+
+	need, err := needsRebuild(ctx, bw.db, seasonID)
+	if err != nil {
+		return false, err
+	}
+
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if !need {
+		bw.markChecked(seasonID)
+		return false, nil
+	}
+	if _, ok := bw.inflight[seasonID]; !ok {
+		bw.inflight[seasonID] = struct{}{}
+		go bw.rebuild(context.Background(), seasonID)
+	}
+	return true, nil
+}
+
+// markChecked must be called with mu held.
+func (bw *boardWarmer) markChecked(seasonID string) {
+	bw.checked[seasonID] = time.Now()
+	// sweep expired entries so one-off season ids don't pile up
+	if len(bw.checked) > 10000 {
+		for k, t := range bw.checked {
+			if time.Since(t) >= warmMissCooldown {
+				delete(bw.checked, k)
+			}
+		}
+	}
+}
+
+func (bw *boardWarmer) rebuild(ctx context.Context, seasonID string) {
+	defer func() {
+		bw.mu.Lock()
+		delete(bw.inflight, seasonID)
+		bw.markChecked(seasonID)
+		bw.mu.Unlock()
+	}()
+
+	c, cancel := context.WithTimeout(ctx, warmRebuildTimeout)
+	defer cancel()
+	members, err := rebuildLeaderboard(c, bw.db, bw.rdb, seasonID, bw.defaultMaxSize)
+	if err != nil {
+		slog.Error("Warm rebuild error", "seasonId", seasonID, "err", err)
+		return
+	}
+	slog.Info("Warm rebuilt season", "seasonId", seasonID, "members", members)
+}
+
+// boardSeasons lists seasons with ledger rows that are expected to have a
+// board, i.e. not archived and not being deleted.
+func boardSeasons(ctx context.Context, db *sql.DB) ([]string, error) {
+	// loose index scan over score_events(season_id, ...) instead of a full DISTINCT
+	rows, err := db.QueryContext(ctx, `
+	WITH RECURSIVE s AS (
+	  (SELECT season_id FROM score_events ORDER BY season_id LIMIT 1)
+	  UNION ALL
+	  SELECT (SELECT e.season_id FROM score_events e WHERE e.season_id > s.season_id ORDER BY e.season_id LIMIT 1)
+	  FROM s WHERE s.season_id IS NOT NULL
+	)
+	SELECT s.season_id FROM s
+	WHERE s.season_id IS NOT NULL
+	  AND NOT EXISTS (SELECT 1 FROM seasons x WHERE x.season_id=s.season_id AND x.status='archived')
+	  AND NOT EXISTS (
+	    SELECT 1 FROM season_delete_jobs j
+	    WHERE j.season_id=s.season_id AND j.status IN ('pending', 'running')
+	  )
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seasons []string
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, sid)
+	}
+	return seasons, rows.Err()
+}
+
+// warmAll rebuilds every season that has ledger rows but no board, one at a time.
+func (bw *boardWarmer) warmAll(ctx context.Context) error {
+	c, cancel := context.WithTimeout(ctx, time.Minute)
+	seasons, err := boardSeasons(c, bw.db)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if len(seasons) == 0 {
+		return nil
+	}
+
+	c, cancel = context.WithTimeout(ctx, 10*time.Second)
+	pipe := bw.rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(seasons))
+	for i, sid := range seasons {
+		cmds[i] = pipe.Exists(c, boardKey(sid))
+	}
+	_, err = pipe.Exec(c)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for i, sid := range seasons {
+		if cmds[i].Val() > 0 {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		bw.mu.Lock()
+		_, busy := bw.inflight[sid]
+		if !busy {
+			bw.inflight[sid] = struct{}{}
+		}
+		bw.mu.Unlock()
+		if !busy {
+			bw.rebuild(ctx, sid)
+		}
+	}
+	return nil
+}
+
+func writeRebuilding(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "2")
+	writeProblem(w, http.StatusServiceUnavailable, "board_rebuilding", "leaderboard is being rebuilt")
+}
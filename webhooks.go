@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/outbox"
+	"github.com/lib/pq"
+)
+
+// Per-season webhooks mirror applied score events to a game's own endpoint.
+// The worker queues one webhook_deliveries row per applied event in the same
+// transaction that marks the outbox row done, so an event is exported iff it
+// reached the board. Delivery is at-least-once and may reorder on retries;
+// receivers should dedupe on eventId.
+
+const (
+	webhookBatchSize   = 100
+	webhookMaxAttempts = 10
+	webhookMaxBackoff  = time.Hour
+	webhookDoneTTL     = 24 * time.Hour
+)
+
+type seasonWebhook struct {
+	SeasonID  string    `json:"seasonId"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // only echoed back when generated by the server
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Pending   int64     `json:"pending"`
+	Failed    int64     `json:"failed"`
+}
+
+// webhookEvent is one applied delta as delivered to the receiver.
+type webhookEvent struct {
+	EventID   int64     `json:"eventId"`
+	TenantID  string    `json:"tenantId,omitempty"`
+	SeasonID  string    `json:"seasonId"`
+	UserID    string    `json:"userId"`
+	Delta     int64     `json:"delta"` // as applied (after boosts)
+	Score     float64   `json:"score"` // board score right after this delta
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// forTenant is ev as receivers see it: the season by the id its tenant uses.
+func (ev webhookEvent) forTenant(t *tenancy) webhookEvent {
+	ev.TenantID, ev.SeasonID = t.splitSeason(ev.SeasonID)
+	return ev
+}
+
+// internalIP reports whether ip is off limits to a tenant's webhook: loopback,
+// private, link-local or unspecified.
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// checkTenantWebhookHost rejects a tenant's webhook host that is or resolves
+// to an internal address. DNS can change after this, so deliveries check the
+// address again when they connect (denyInternalDial).
+func checkTenantWebhookHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("url host %s does not resolve", host)
+	}
+	for _, a := range addrs {
+		if internalIP(a.IP) {
+			return fmt.Errorf("url host %s is an internal address", host)
+		}
+	}
+	return nil
+}
+
+// denyInternalDial is the dialer Control of the client that delivers tenants'
+// webhooks.
+func denyInternalDial(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+		return fmt.Errorf("webhook address %s is internal", host)
+	}
+	return nil
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func putSeasonWebhook(ctx context.Context, db *sql.DB, wh *seasonWebhook) error {
+	return db.QueryRowContext(ctx, `
+	INSERT INTO season_webhooks (season_id, url, secret)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (season_id) DO UPDATE SET url=EXCLUDED.url, secret=EXCLUDED.secret, updated_at=now()
+	RETURNING created_at, updated_at
+`, wh.SeasonID, wh.URL, wh.Secret).Scan(&wh.CreatedAt, &wh.UpdatedAt)
+}
+
+func getSeasonWebhook(ctx context.Context, db *sql.DB, seasonID string) (*seasonWebhook, error) {
+	wh := seasonWebhook{SeasonID: seasonID}
+	if err := db.QueryRowContext(ctx, `
+	SELECT w.url, w.created_at, w.updated_at,
+	  (SELECT COUNT(*) FROM webhook_deliveries d
+	   WHERE d.season_id=w.season_id AND d.subscription_id IS NULL AND d.status='pending'),
+	  (SELECT COUNT(*) FROM webhook_deliveries d
+	   WHERE d.season_id=w.season_id AND d.subscription_id IS NULL AND d.status='failed')
+	FROM season_webhooks w
+	WHERE w.season_id=$1
+`, seasonID).Scan(&wh.URL, &wh.CreatedAt, &wh.UpdatedAt, &wh.Pending, &wh.Failed); err != nil {
+		return nil, err
+	}
+	return &wh, nil
+}
+
+// deleteSeasonWebhook removes the endpoint; queued deliveries are dropped with it.
+func deleteSeasonWebhook(ctx context.Context, db *sql.DB, seasonID string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM season_webhooks WHERE season_id=$1`, seasonID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM webhook_deliveries WHERE season_id=$1 AND subscription_id IS NULL AND status='pending'`, seasonID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// webhookSeasons returns which of the given seasons have a webhook registered.
+func webhookSeasons(ctx context.Context, tx *sql.Tx, seasons []string) (map[string]struct{}, error) {
+	out := make(map[string]struct{})
+	if len(seasons) == 0 {
+		return out, nil
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT season_id FROM season_webhooks WHERE season_id = ANY($1)`, pq.Array(seasons))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		out[sid] = struct{}{}
+	}
+	return out, rows.Err()
+}
+
+func queueWebhookEvents(b *outbox.Batch, evs []webhookEvent, tenants *tenancy) {
+	if len(evs) == 0 {
+		return
+	}
+	sids := make([]string, len(evs))
+	payloads := make([]string, len(evs))
+	for i, e := range evs {
+		body, _ := json.Marshal(e.forTenant(tenants))
+		sids[i], payloads[i] = e.SeasonID, string(body)
+	}
+	b.Queue("webhook queue", `
+	INSERT INTO webhook_deliveries (season_id, payload)
+	SELECT s, p::jsonb FROM unnest($1::text[], $2::text[]) AS v(s, p)
+`, sids, payloads)
+}
+
+// signWebhook returns hex(HMAC-SHA256(secret, timestamp + "." + body)).
+func signWebhook(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSender delivers the queued events. Tenants' season webhooks go
+// through tenantClient, which won't connect to internal addresses.
+type webhookSender struct {
+	tenants      *tenancy
+	client       *http.Client
+	tenantClient *http.Client
+}
+
+func runWebhookDeliveries(ctx context.Context, db *sql.DB, tenants *tenancy) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: denyInternalDial}
+	ws := webhookSender{
+		tenants: tenants,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		tenantClient: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{DialContext: dialer.DialContext}, // no proxy: the dialer sees the receiver's address
+		},
+	}
+	lastCleanup := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ws.deliver(ctx, db); err != nil {
+				slog.Error("Webhook delivery error", "err", err)
+			}
+			if time.Since(lastCleanup) >= time.Minute {
+				lastCleanup = time.Now()
+				c, cancel := context.WithTimeout(ctx, 10*time.Second)
+				_, err := db.ExecContext(c, `
+	DELETE FROM webhook_deliveries
+	WHERE status='done' AND delivered_at < now() - make_interval(secs => $1)
+`, webhookDoneTTL.Seconds())
+				cancel()
+				if err != nil {
+					slog.Error("Webhook cleanup error", "err", err)
+				}
+			}
+		}
+	}
+}
+
+func (ws webhookSender) deliver(ctx context.Context, db *sql.DB) error {
+	// Season webhook rows have no subscription; subscription rows are batched per subscription.
+	c, cancel := context.WithTimeout(ctx, 2*time.Second)
+	rows, err := db.QueryContext(c, `
+	SELECT DISTINCT season_id, COALESCE(subscription_id, 0)
+	FROM webhook_deliveries
+	WHERE status='pending' AND next_attempt_at <= now()
+	LIMIT 100
+`)
+	if err != nil {
+		cancel()
+		return err
+	}
+	var targets []webhookTarget
+	for rows.Next() {
+		var t webhookTarget
+		if err := rows.Scan(&t.seasonID, &t.subscriptionID); err != nil {
+			rows.Close()
+			cancel()
+			return err
+		}
+		if t.subscriptionID != 0 {
+			t.seasonID = ""
+		}
+		if !slices.Contains(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+	rows.Close()
+	cancel()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := ws.deliverBatch(ctx, db, t); err != nil {
+			if t.subscriptionID != 0 {
+				return fmt.Errorf("subscription %d: %w", t.subscriptionID, err)
+			}
+			return fmt.Errorf("season %s: %w", t.seasonID, err)
+		}
+	}
+	return nil
+}
+
+// webhookTarget is one delivery queue: a season's webhook, or a subscription.
+type webhookTarget struct {
+	seasonID       string
+	subscriptionID int64
+}
+
+// deliverBatch sends one batch for a target. Rows stay locked for the
+// duration of the request so other instances skip them.
+func (ws webhookSender) deliverBatch(ctx context.Context, db *sql.DB, t webhookTarget) error {
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(c, `
+	SELECT id, payload
+	FROM webhook_deliveries
+	WHERE status='pending' AND next_attempt_at <= now()
+	  AND CASE WHEN $2 = 0 THEN season_id=$1 AND subscription_id IS NULL ELSE subscription_id=$2 END
+	ORDER BY id
+	FOR UPDATE SKIP LOCKED
+	LIMIT $3
+`, t.seasonID, t.subscriptionID, webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	var events []json.RawMessage
+	for rows.Next() {
+		var id int64
+		var p []byte
+		if err := rows.Scan(&id, &p); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		events = append(events, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var url, secret string
+	var body []byte
+	client := ws.client
+	if t.subscriptionID != 0 {
+		err = tx.QueryRowContext(c,
+			`SELECT url, secret FROM webhook_subscriptions WHERE id=$1`, t.subscriptionID).Scan(&url, &secret)
+		body, _ = json.Marshal(map[string]any{"subscriptionId": t.subscriptionID, "events": events})
+	} else {
+		err = tx.QueryRowContext(c,
+			`SELECT url, secret FROM season_webhooks WHERE season_id=$1`, t.seasonID).Scan(&url, &secret)
+		tenant, sid := ws.tenants.splitSeason(t.seasonID)
+		msg := map[string]any{"seasonId": sid, "events": events}
+		if tenant != "" {
+			msg["tenantId"] = tenant
+			client = ws.tenantClient
+		}
+		body, _ = json.Marshal(msg)
+	}
+	if err == sql.ErrNoRows {
+		// webhook removed while these were queued
+		if _, err := tx.ExecContext(c, `
+	UPDATE webhook_deliveries SET status='failed', last_error='webhook removed' WHERE id = ANY($1)
+`, pq.Array(ids)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	if sendErr := postWebhook(c, client, url, secret, body); sendErr != nil {
+		// 2^attempts seconds, capped; rows out of attempts are parked as failed
+		if _, err := tx.ExecContext(c, `
+	UPDATE webhook_deliveries
+	SET attempts=attempts+1,
+	    last_error=$2,
+	    status=CASE WHEN attempts+1 >= $3 THEN 'failed' ELSE 'pending' END,
+	    next_attempt_at=now() + LEAST(make_interval(secs => power(2, attempts+1)), make_interval(secs => $4))
+	WHERE id = ANY($1)
+`, pq.Array(ids), sendErr.Error(), webhookMaxAttempts, webhookMaxBackoff.Seconds()); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if _, err := tx.ExecContext(c, `
+	UPDATE webhook_deliveries
+	SET status='done', attempts=attempts+1, last_error=NULL, delivered_at=now()
+	WHERE id = ANY($1)
+`, pq.Array(ids)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func postWebhook(ctx context.Context, client *http.Client, url, secret string, body []byte) error {
+	ts := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Leaderboard-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Leaderboard-Signature", "sha256="+signWebhook(secret, ts, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
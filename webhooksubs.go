@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/disfordave/leaderboard-go/outbox"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Webhook subscriptions are registered endpoints that pick which events they
+// get, across all seasons or just one. They share webhook_deliveries (rows
+// carry subscription_id) and the delivery job with per-season webhooks, and
+// are queued by the worker in the same transaction that applies the event.
+// A batch is POSTed as {"subscriptionId", "events": [{"type", "data"}]},
+// signed like season webhooks.
+
+const (
+	subEventScoreApplied   = "score.applied"
+	subEventTopEntered     = "rank.top_entered"
+	subEventSeasonArchived = "season.archived"
+	subEventSeasonDeleted  = "season.deleted"
+
+	defaultSubscriptionTopN = 10
+	maxSubscriptionTopN     = 1000
+)
+
+var subscriptionEventTypes = []string{subEventScoreApplied, subEventTopEntered, subEventSeasonArchived, subEventSeasonDeleted}
+
+type webhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"` // only echoed back when generated by the server
+	Events    []string  `json:"events"`
+	SeasonID  *string   `json:"seasonId"` // nil: every season
+	TopN      int64     `json:"topN"`     // threshold for rank.top_entered
+	CreatedAt time.Time `json:"createdAt"`
+	Pending   int64     `json:"pending"`
+	Failed    int64     `json:"failed"`
+}
+
+func (s *webhookSubscription) wants(eventType, seasonID string) bool {
+	return (s.SeasonID == nil || *s.SeasonID == seasonID) && slices.Contains(s.Events, eventType)
+}
+
+// topEnteredEvent is sent when an applied delta moves a user into a
+// subscription's top N. The old position is taken from the board right after
+// the apply, so it's approximate under concurrent writes.
+type topEnteredEvent struct {
+	TenantID  string    `json:"tenantId,omitempty"`
+	SeasonID  string    `json:"seasonId"`
+	UserID    string    `json:"userId"`
+	Rank      int64     `json:"rank"` // 1-based
+	Score     float64   `json:"score"`
+	TopN      int64     `json:"topN"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// subscriptionEvent is one event to fan out to matching subscriptions.
+type subscriptionEvent struct {
+	eventType string
+	seasonID  string
+	topN      int64 // rank.top_entered: only subscriptions with this threshold
+	data      any
+}
+
+func createWebhookSubscription(ctx context.Context, db *sql.DB, s *webhookSubscription) error {
+	return db.QueryRowContext(ctx, `
+	INSERT INTO webhook_subscriptions (url, secret, events, season_id, top_n)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at
+`, s.URL, s.Secret, pq.Array(s.Events), s.SeasonID, s.TopN).Scan(&s.ID, &s.CreatedAt)
+}
+
+func listWebhookSubscriptions(ctx context.Context, db *sql.DB) ([]webhookSubscription, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT s.id, s.url, s.events, s.season_id, s.top_n, s.created_at,
+	  (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.subscription_id=s.id AND d.status='pending'),
+	  (SELECT COUNT(*) FROM webhook_deliveries d WHERE d.subscription_id=s.id AND d.status='failed')
+	FROM webhook_subscriptions s
+	ORDER BY s.id
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []webhookSubscription{}
+	for rows.Next() {
+		var s webhookSubscription
+		var sid sql.NullString
+		if err := rows.Scan(&s.ID, &s.URL, pq.Array(&s.Events), &sid, &s.TopN, &s.CreatedAt, &s.Pending, &s.Failed); err != nil {
+			return nil, err
+		}
+		if sid.Valid {
+			s.SeasonID = &sid.String
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// deleteWebhookSubscription removes the subscription; its deliveries go with it (ON DELETE CASCADE).
+func deleteWebhookSubscription(ctx context.Context, db *sql.DB, id int64) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// activeSubscriptions returns the subscriptions that could match events in the
+// given seasons. The table is small, so the worker reads it once per batch.
+func activeSubscriptions(ctx context.Context, tx *sql.Tx, seasons []string) ([]webhookSubscription, error) {
+	if len(seasons) == 0 {
+		return nil, nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+	SELECT id, events, season_id, top_n
+	FROM webhook_subscriptions
+	WHERE season_id IS NULL OR season_id = ANY($1)
+`, pq.Array(seasons))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []webhookSubscription
+	for rows.Next() {
+		var s webhookSubscription
+		var sid sql.NullString
+		if err := rows.Scan(&s.ID, pq.Array(&s.Events), &sid, &s.TopN); err != nil {
+			return nil, err
+		}
+		if sid.Valid {
+			s.SeasonID = &sid.String
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// subscriptionsWant reports whether any subscription takes eventType for the season.
+func subscriptionsWant(subs []webhookSubscription, eventType, seasonID string) bool {
+	for i := range subs {
+		if subs[i].wants(eventType, seasonID) {
+			return true
+		}
+	}
+	return false
+}
+
+// topThresholds returns the distinct top N values subscribed to for the season.
+func topThresholds(subs []webhookSubscription, seasonID string) []int64 {
+	var out []int64
+	for i := range subs {
+		if subs[i].wants(subEventTopEntered, seasonID) && !slices.Contains(out, subs[i].TopN) {
+			out = append(out, subs[i].TopN)
+		}
+	}
+	return out
+}
+
+func queueSubscriptionEvents(b *outbox.Batch, subs []webhookSubscription, evs []subscriptionEvent) {
+	var subIDs []int64
+	var sids, payloads []string
+	for _, e := range evs {
+		var payload []byte
+		for i := range subs {
+			s := &subs[i]
+			if !s.wants(e.eventType, e.seasonID) || (e.topN != 0 && s.TopN != e.topN) {
+				continue
+			}
+			if payload == nil {
+				payload, _ = json.Marshal(map[string]any{"type": e.eventType, "data": e.data})
+			}
+			subIDs = append(subIDs, s.ID)
+			sids = append(sids, e.seasonID)
+			payloads = append(payloads, string(payload))
+		}
+	}
+	if len(subIDs) == 0 {
+		return
+	}
+	b.Queue("webhook subscription queue", `
+	INSERT INTO webhook_deliveries (subscription_id, season_id, payload)
+	SELECT i, s, p::jsonb FROM unnest($1::bigint[], $2::text[], $3::text[]) AS v(i, s, p)
+`, subIDs, sids, payloads)
+}
+
+// topCandidate is a user whose score went up in this batch.
+type topCandidate struct {
+	seasonID, userID string
+	before, after    float64
+}
+
+// topEnteredEvents checks, after the apply, which candidates moved into a
+// subscribed top N. A score of 0 before the batch is taken as not being on the
+// board yet.
+func topEnteredEvents(ctx context.Context, rdb redis.UniversalClient, subs []webhookSubscription, cands []topCandidate, appliedAt time.Time, tenants *tenancy) ([]subscriptionEvent, error) {
+	if len(cands) == 0 {
+		return nil, nil
+	}
+	pipe := rdb.Pipeline()
+	ranks := make([]*redis.IntCmd, len(cands))
+	above := make([]*redis.IntCmd, len(cands))
+	for i, c := range cands {
+		key := boardKey(c.seasonID)
+		ranks[i] = pipe.ZRevRank(ctx, key, c.userID)
+		above[i] = pipe.ZCount(ctx, key, "("+strconv.FormatFloat(c.before, 'f', -1, 64), "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var out []subscriptionEvent
+	for i, c := range cands {
+		rank, err := ranks[i].Result()
+		if err != nil {
+			continue // dropped or trimmed since
+		}
+		// everyone above the old score, less the user themselves
+		oldRank := above[i].Val() - 1
+		for _, n := range topThresholds(subs, c.seasonID) {
+			if rank < n && (oldRank >= n || c.before == 0) {
+				ev := topEnteredEvent{UserID: c.userID, Rank: rank + 1, Score: c.after, TopN: n, AppliedAt: appliedAt}
+				ev.TenantID, ev.SeasonID = tenants.splitSeason(c.seasonID)
+				out = append(out, subscriptionEvent{eventType: subEventTopEntered, seasonID: c.seasonID, topN: n, data: ev})
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal RFC 6455 server side, enough for small JSON messages: unfragmented
+// text frames from the client, text frames from the server, and the control
+// frames. Like the NATS publisher it keeps the service on the standard library.
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	wsMaxMessage   = 4096
+	wsWriteTimeout = 10 * time.Second
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errWSClosed = errors.New("websocket closed")
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	wmu sync.Mutex // the reader answers pings while the session writes
+}
+
+// upgradeWebSocket completes the handshake. On failure it has already written
+// an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, bool) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeProblem(w, http.StatusBadRequest, "websocket_upgrade_required", "websocket upgrade required")
+		return nil, false
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeProblem(w, http.StatusUpgradeRequired, "unsupported_websocket_version", "unsupported websocket version")
+		return nil, false
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		writeProblem(w, http.StatusBadRequest, "websocket_upgrade_required", "invalid Sec-WebSocket-Key")
+		return nil, false
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeProblem(w, http.StatusInternalServerError, "streaming_unsupported", "websocket unsupported")
+		return nil, false
+	}
+	// drop the server's read/write timeouts; the session sets its own
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	return &wsConn{conn: conn, r: brw.Reader}, true
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op // FIN, never fragmented
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) writeText(payload []byte) error { return c.writeFrame(wsOpText, payload) }
+
+func (c *wsConn) ping() error { return c.writeFrame(wsOpPing, nil) }
+
+// close sends a close frame with the status code and drops the connection.
+func (c *wsConn) close(code uint16) {
+	_ = c.writeFrame(wsOpClose, binary.BigEndian.AppendUint16(nil, code))
+	c.conn.Close()
+}
+
+// readMessage returns the next text message, answering pings along the way.
+// Each frame must arrive within idle; the session's pings keep a live client
+// sending pongs.
+func (c *wsConn) readMessage(idle time.Duration) ([]byte, error) {
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(idle))
+
+		var h [2]byte
+		if _, err := io.ReadFull(c.r, h[:]); err != nil {
+			return nil, err
+		}
+		fin, op := h[0]&0x80 != 0, h[0]&0x0F
+		if h[1]&0x80 == 0 {
+			return nil, errors.New("websocket: unmasked client frame")
+		}
+		n := uint64(h[1] & 0x7F)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.r, b[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.r, b[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		if n > wsMaxMessage {
+			return nil, errors.New("websocket: message too large")
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case wsOpText:
+			if !fin {
+				return nil, errors.New("websocket: fragmented messages are not supported")
+			}
+			return payload, nil
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			return nil, errWSClosed
+		default:
+			return nil, errors.New("websocket: unsupported frame")
+		}
+	}
+}